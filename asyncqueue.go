@@ -0,0 +1,95 @@
+package fuddle
+
+import (
+	"sync"
+)
+
+// asyncQueueSize is the number of pending notifications an asyncQueue buffers
+// before coalescing further notifications.
+const asyncQueueSize = 16
+
+// asyncQueue delivers the notifications of a single subscriber on its own
+// goroutine, so a slow subscriber doesn't delay notifying other subscribers
+// (see WithAsyncNotify).
+//
+// Once the queue is full, further notifications are coalesced into the last
+// pending notification rather than blocking. Since notifications other than
+// deltas are passed the latest state of the registry, the last pending
+// notification is replaced, and deltas are merged into the net changes.
+type asyncQueue struct {
+	// pending contains the notifications waiting to be delivered.
+	pending []notification
+	// closed is true once the queue is closed, after which any pending
+	// notifications are discarded.
+	closed bool
+
+	// cond is signalled when a notification is pushed or the queue is
+	// closed.
+	cond *sync.Cond
+	// mu protects the above fields.
+	mu sync.Mutex
+}
+
+func newAsyncQueue() *asyncQueue {
+	q := &asyncQueue{}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// Push queues the notification to be delivered. Push never blocks.
+func (q *asyncQueue) Push(n notification) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return
+	}
+
+	if len(q.pending) < asyncQueueSize {
+		q.pending = append(q.pending, n)
+		q.cond.Signal()
+		return
+	}
+
+	last := &q.pending[len(q.pending)-1]
+	if last.delta != nil && n.delta != nil {
+		merged := last.delta.merge(*n.delta)
+		*last = deltaNotification(n.sub, merged)
+		return
+	}
+	*last = n
+}
+
+// Run delivers the pending notifications in order until the queue is closed.
+func (q *asyncQueue) Run() {
+	for {
+		q.mu.Lock()
+		for len(q.pending) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if q.closed {
+			q.mu.Unlock()
+			return
+		}
+		n := q.pending[0]
+		q.pending = q.pending[1:]
+		q.mu.Unlock()
+
+		if n.sub.unsubscribed.Load() {
+			continue
+		}
+		n.notify()
+	}
+}
+
+// Close stops delivering notifications and discards any pending
+// notifications. Close doesn't wait for a notification being delivered to
+// return.
+func (q *asyncQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	q.pending = nil
+	q.cond.Broadcast()
+}
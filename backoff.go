@@ -0,0 +1,77 @@
+package fuddle
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"google.golang.org/grpc/backoff"
+)
+
+// Backoff configures the delay between reconnect attempts, and between
+// register attempts when WithConfirmRegister is used.
+//
+// Any zero fields use the default value.
+type Backoff struct {
+	// Initial is the delay before the first reconnect attempt.
+	Initial time.Duration
+	// Max is the upper bound on the delay between attempts.
+	Max time.Duration
+	// Multiplier is the factor the delay is multiplied by after each failed
+	// attempt.
+	Multiplier float64
+	// Jitter is the fraction of the delay to randomize by, such as 0.2 will
+	// randomize the delay by up to +/-20%.
+	Jitter float64
+}
+
+func defaultBackoff() Backoff {
+	return Backoff{
+		Initial:    time.Millisecond * 100,
+		Max:        time.Second * 30,
+		Multiplier: 1.6,
+		Jitter:     0.2,
+	}
+}
+
+// withDefaults returns the backoff with any zero fields set to the default.
+func (b Backoff) withDefaults() Backoff {
+	defaults := defaultBackoff()
+	if b.Initial == 0 {
+		b.Initial = defaults.Initial
+	}
+	if b.Max == 0 {
+		b.Max = defaults.Max
+	}
+	if b.Multiplier == 0 {
+		b.Multiplier = defaults.Multiplier
+	}
+	if b.Jitter == 0 {
+		b.Jitter = defaults.Jitter
+	}
+	return b
+}
+
+// grpcConfig returns the gRPC backoff config, which gRPC uses between
+// connection attempts.
+func (b Backoff) grpcConfig() backoff.Config {
+	b = b.withDefaults()
+	return backoff.Config{
+		BaseDelay:  b.Initial,
+		Multiplier: b.Multiplier,
+		Jitter:     b.Jitter,
+		MaxDelay:   b.Max,
+	}
+}
+
+// delay returns the delay before the retry following the given number of
+// failed attempts, randomized by the jitter.
+func (b Backoff) delay(attempts int) time.Duration {
+	b = b.withDefaults()
+	d := float64(b.Initial) * math.Pow(b.Multiplier, float64(attempts))
+	if d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+	d *= 1 + b.Jitter*(rand.Float64()*2-1)
+	return time.Duration(d)
+}
@@ -0,0 +1,51 @@
+package fuddle
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/backoff"
+)
+
+func TestBackoff_GRPCConfig(t *testing.T) {
+	assert.Equal(t, backoff.Config{
+		BaseDelay:  time.Second,
+		Multiplier: 2,
+		Jitter:     0.1,
+		MaxDelay:   time.Minute,
+	}, Backoff{
+		Initial:    time.Second,
+		Max:        time.Minute,
+		Multiplier: 2,
+		Jitter:     0.1,
+	}.grpcConfig())
+}
+
+func TestBackoff_GRPCConfigDefaults(t *testing.T) {
+	// Zero fields must use the defaults rather than disabling the backoff.
+	assert.Equal(t, backoff.Config{
+		BaseDelay:  time.Second,
+		Multiplier: 1.6,
+		Jitter:     0.2,
+		MaxDelay:   time.Second * 30,
+	}, Backoff{
+		Initial: time.Second,
+	}.grpcConfig())
+}
+
+func TestBackoff_Delay(t *testing.T) {
+	b := Backoff{
+		Initial:    time.Second,
+		Max:        time.Second * 10,
+		Multiplier: 2,
+		// Use a tiny jitter since zero uses the default.
+		Jitter: 0.0001,
+	}
+	assert.InDelta(t, time.Second, b.delay(0), float64(time.Millisecond))
+	assert.InDelta(t, time.Second*2, b.delay(1), float64(time.Millisecond))
+	assert.InDelta(t, time.Second*8, b.delay(3), float64(time.Millisecond))
+	// The delay must be capped at the max.
+	assert.InDelta(t, time.Second*10, b.delay(4), float64(time.Millisecond))
+	assert.InDelta(t, time.Second*10, b.delay(100), float64(time.Millisecond))
+}
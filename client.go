@@ -0,0 +1,29 @@
+package fuddle
+
+import (
+	"context"
+)
+
+// Client is the interface implemented by Fuddle.
+//
+// Code using the client can depend on Client rather than *Fuddle, so tests
+// can inject a fake client instead of connecting to a Fuddle cluster, such as
+// fuddlefake.Client.
+type Client interface {
+	// Members returns the known members in the registry sorted by ID. See
+	// Fuddle.Members.
+	Members(opts ...MembersOption) []Member
+	// Subscribe subscribes to updates when the registry changes, and returns
+	// a function to unsubscribe. See Fuddle.Subscribe.
+	Subscribe(cb func(), opts ...SubscribeOption) func()
+	// Register registers an additional local member. See Fuddle.Register.
+	Register(ctx context.Context, member Member) (*LocalNode, error)
+	// ConnState returns the last known connection state. See
+	// Fuddle.ConnState.
+	ConnState() ConnState
+	// Close unregisters the local members and closes the client. See
+	// Fuddle.Close.
+	Close()
+}
+
+var _ Client = (*Fuddle)(nil)
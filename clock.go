@@ -0,0 +1,46 @@
+package fuddle
+
+import (
+	"time"
+)
+
+// clock abstracts time so timing sensitive behaviour, such as sending
+// heartbeats, can be tested with a fake clock.
+type clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) ticker
+	After(d time.Duration) <-chan time.Time
+}
+
+// ticker is a time.Ticker returned by a clock.
+type ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// realClock is a clock using the system time.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTicker(d time.Duration) ticker {
+	return &realTicker{ticker: time.NewTicker(d)}
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+type realTicker struct {
+	ticker *time.Ticker
+}
+
+func (t *realTicker) C() <-chan time.Time {
+	return t.ticker.C
+}
+
+func (t *realTicker) Stop() {
+	t.ticker.Stop()
+}
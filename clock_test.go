@@ -0,0 +1,185 @@
+package fuddle
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFakeClock_Ticker(t *testing.T) {
+	clock := newFakeClock()
+	ticker := clock.NewTicker(time.Second)
+
+	clock.Advance(time.Millisecond * 500)
+	assertNoTick(t, ticker.C())
+
+	clock.Advance(time.Millisecond * 500)
+	assertTick(t, ticker.C())
+	assertNoTick(t, ticker.C())
+
+	// Like time.Ticker, ticks are dropped if the receiver falls behind.
+	clock.Advance(time.Second * 3)
+	assertTick(t, ticker.C())
+	assertNoTick(t, ticker.C())
+
+	ticker.Stop()
+	clock.Advance(time.Second)
+	assertNoTick(t, ticker.C())
+}
+
+func TestFakeClock_After(t *testing.T) {
+	clock := newFakeClock()
+	start := clock.Now()
+	ch := clock.After(time.Second)
+
+	clock.Advance(time.Millisecond * 999)
+	assertNoTick(t, ch)
+
+	clock.Advance(time.Millisecond)
+	assertTick(t, ch)
+	assert.Equal(t, start.Add(time.Second), clock.Now())
+}
+
+func assertTick(t *testing.T, ch <-chan time.Time) {
+	t.Helper()
+
+	select {
+	case <-ch:
+	default:
+		t.Fatal("expected tick")
+	}
+}
+
+func assertNoTick(t *testing.T, ch <-chan time.Time) {
+	t.Helper()
+
+	select {
+	case <-ch:
+		t.Fatal("unexpected tick")
+	default:
+	}
+}
+
+// fakeClock is a clock that only advances when Advance is called.
+type fakeClock struct {
+	now     time.Time
+	tickers map[*fakeTicker]struct{}
+	timers  []*fakeTimer
+
+	// mu protects the above fields.
+	mu sync.Mutex
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{
+		now:     time.Unix(0, 0),
+		tickers: make(map[*fakeTicker]struct{}),
+	}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) ticker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &fakeTicker{
+		c:      make(chan time.Time, 1),
+		period: d,
+		next:   c.now.Add(d),
+		clock:  c,
+	}
+	c.tickers[t] = struct{}{}
+	return t
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &fakeTimer{
+		c:        make(chan time.Time, 1),
+		deadline: c.now.Add(d),
+	}
+	c.timers = append(c.timers, t)
+	return t.c
+}
+
+// Advance moves the clock forward by d, firing any tickers and timers that
+// are due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+
+	for t := range c.tickers {
+		if t.next.After(c.now) {
+			continue
+		}
+		select {
+		case t.c <- c.now:
+		default:
+			// Drop the tick if the receiver hasn't received the last tick.
+		}
+		for !t.next.After(c.now) {
+			t.next = t.next.Add(t.period)
+		}
+	}
+
+	var timers []*fakeTimer
+	for _, t := range c.timers {
+		if t.deadline.After(c.now) {
+			timers = append(timers, t)
+			continue
+		}
+		t.c <- c.now
+	}
+	c.timers = timers
+}
+
+// NumTimers returns the number of timers that haven't fired.
+func (c *fakeClock) NumTimers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.timers)
+}
+
+// NumTickers returns the number of active tickers.
+func (c *fakeClock) NumTickers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.tickers)
+}
+
+type fakeTicker struct {
+	c      chan time.Time
+	period time.Duration
+	next   time.Time
+	clock  *fakeClock
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTicker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	delete(t.clock.tickers, t)
+}
+
+type fakeTimer struct {
+	c        chan time.Time
+	deadline time.Time
+}
@@ -0,0 +1,280 @@
+package fuddle
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/fuddle-io/fuddle-go/internal/wildcard"
+)
+
+// CompiledFilter is a filter whose patterns have been parsed once, so
+// matching many members is cheaper than Filter.Match, which parses the
+// patterns on every match. Use Filter.Compile or AnyFilter.Compile to create
+// a CompiledFilter.
+//
+// CompiledFilter implements MemberFilter, so can be passed to WithFilter and
+// Fuddle.SubscribeFilter. The filter is a snapshot, so modifying the Filter
+// after compiling it doesn't affect the CompiledFilter.
+type CompiledFilter struct {
+	matchAll bool
+	// filters contains the service filters of each compiled filter, where
+	// a member matches if it matches any of the filters.
+	filters [][]compiledServiceFilter
+}
+
+// Compile returns the compiled filter. Returns an error if the filter is
+// invalid (see Filter.Validate).
+func (f *Filter) Compile() (*CompiledFilter, error) {
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+	if f.IsMatchAll() {
+		return &CompiledFilter{matchAll: true}, nil
+	}
+	return &CompiledFilter{
+		filters: [][]compiledServiceFilter{compileServiceFilters(f)},
+	}, nil
+}
+
+// Compile returns the compiled filter. Returns an error if any of the filters
+// are invalid.
+func (f AnyFilter) Compile() (*CompiledFilter, error) {
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+	if f.IsMatchAll() {
+		return &CompiledFilter{matchAll: true}, nil
+	}
+
+	compiled := &CompiledFilter{}
+	for _, filter := range f {
+		compiled.filters = append(compiled.filters, compileServiceFilters(filter))
+	}
+	return compiled, nil
+}
+
+// Match returns whether the given member matches the filter.
+func (f *CompiledFilter) Match(member Member) bool {
+	if f.IsMatchAll() {
+		return true
+	}
+
+	for _, serviceFilters := range f.filters {
+		for i := range serviceFilters {
+			if serviceFilters[i].match(member) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsMatchAll returns whether the filter matches all members.
+func (f *CompiledFilter) IsMatchAll() bool {
+	return f == nil || f.matchAll
+}
+
+// Validate always returns nil, since the filter is validated when compiled.
+func (f *CompiledFilter) Validate() error {
+	return nil
+}
+
+// compileMemberFilter returns the compiled filter if the filter can be
+// compiled, otherwise returns the filter unchanged.
+func compileMemberFilter(filter MemberFilter) (MemberFilter, error) {
+	switch f := filter.(type) {
+	case *Filter:
+		return f.Compile()
+	case AnyFilter:
+		return f.Compile()
+	default:
+		if err := validateFilter(filter); err != nil {
+			return nil, err
+		}
+		return filter, nil
+	}
+}
+
+type compiledServiceFilter struct {
+	service wildcard.Pattern
+
+	status        compiledPatterns
+	revision      compiledPatterns
+	startedAfter  int64
+	startedBefore int64
+	region        compiledPatterns
+	zone          compiledPatterns
+	metadata      []compiledMetadataFilter
+	attributes    []compiledMetadataFilter
+}
+
+func compileServiceFilters(f *Filter) []compiledServiceFilter {
+	var compiled []compiledServiceFilter
+	for service, serviceFilter := range *f {
+		ci := serviceFilter.CaseInsensitive
+
+		c := compiledServiceFilter{
+			service:       wildcard.Compile(service),
+			status:        compilePatterns(serviceFilter.Status, ci),
+			revision:      compilePatterns(serviceFilter.Revision, ci),
+			startedAfter:  serviceFilter.StartedAfter,
+			startedBefore: serviceFilter.StartedBefore,
+			region:        compilePatterns(serviceFilter.Locality.Region, ci),
+			zone:          compilePatterns(serviceFilter.Locality.AvailabilityZone, ci),
+		}
+		c.metadata = compileMetadataFilter(serviceFilter.Metadata, ci)
+		c.attributes = compileMetadataFilter(serviceFilter.Attributes, ci)
+		compiled = append(compiled, c)
+	}
+	return compiled
+}
+
+func (f *compiledServiceFilter) match(member Member) bool {
+	if !f.service.Match(member.Service) {
+		return false
+	}
+	if f.startedAfter != 0 && member.Started < f.startedAfter {
+		return false
+	}
+	if f.startedBefore != 0 && member.Started > f.startedBefore {
+		return false
+	}
+	if !f.status.match(member.Status) ||
+		!f.revision.match(member.Revision) ||
+		!f.region.match(member.Locality.Region) ||
+		!f.zone.match(member.Locality.AvailabilityZone) {
+		return false
+	}
+	for i := range f.metadata {
+		if !f.metadata[i].match(member.Metadata) {
+			return false
+		}
+	}
+	for i := range f.attributes {
+		if !f.attributes[i].match(member.Attributes) {
+			return false
+		}
+	}
+	return true
+}
+
+type compiledMetadataFilter struct {
+	key         string
+	wildcardKey bool
+	keyPattern  wildcard.Pattern
+	values      compiledPatterns
+}
+
+// compileMetadataFilter compiles a metadata filter, which is used to filter
+// both the members metadata and attributes.
+func compileMetadataFilter(f MetadataFilter, caseInsensitive bool) []compiledMetadataFilter {
+	var compiled []compiledMetadataFilter
+	for key, values := range f {
+		keyPattern := wildcard.Compile(key)
+		literal, ok := keyPattern.Literal()
+		compiled = append(compiled, compiledMetadataFilter{
+			key:         literal,
+			wildcardKey: !ok,
+			keyPattern:  keyPattern,
+			values:      compilePatterns(values, caseInsensitive),
+		})
+	}
+	return compiled
+}
+
+func (f *compiledMetadataFilter) match(metadata map[string]string) bool {
+	if !f.wildcardKey {
+		v, ok := metadata[f.key]
+		return ok && f.values.match(v)
+	}
+
+	for k, v := range metadata {
+		if f.keyPattern.Match(k) && f.values.match(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// compiledPatterns matches a value if it matches any of the patterns, or if
+// there are no patterns.
+type compiledPatterns struct {
+	patterns        []compiledPattern
+	caseInsensitive bool
+}
+
+func compilePatterns(patterns []string, caseInsensitive bool) compiledPatterns {
+	compiled := compiledPatterns{caseInsensitive: caseInsensitive}
+	for _, p := range patterns {
+		compiled.patterns = append(compiled.patterns, compilePattern(p, caseInsensitive))
+	}
+	return compiled
+}
+
+func (p *compiledPatterns) match(s string) bool {
+	if len(p.patterns) == 0 {
+		return true
+	}
+
+	// Only lower the value if it is compared with a wildcard pattern.
+	lower := ""
+	lowered := false
+	for i := range p.patterns {
+		pattern := &p.patterns[i]
+		if pattern.invalid {
+			continue
+		}
+		if pattern.re != nil {
+			if pattern.re.MatchString(s) {
+				return true
+			}
+			continue
+		}
+
+		if p.caseInsensitive {
+			if !lowered {
+				lower = strings.ToLower(s)
+				lowered = true
+			}
+			if pattern.wildcard.Match(lower) {
+				return true
+			}
+			continue
+		}
+		if pattern.wildcard.Match(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// compiledPattern is either a regular expression, if the pattern has the
+// 're:' prefix, or a wildcard pattern.
+type compiledPattern struct {
+	re *regexp.Regexp
+	// invalid is true if the regular expression doesn't compile, in which
+	// case the pattern never matches like matchPattern.
+	invalid  bool
+	wildcard wildcard.Pattern
+}
+
+func compilePattern(pattern string, caseInsensitive bool) compiledPattern {
+	if strings.HasPrefix(pattern, regexPrefix) {
+		expr := strings.TrimPrefix(pattern, regexPrefix)
+		if caseInsensitive {
+			expr = "(?i)" + expr
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return compiledPattern{invalid: true}
+		}
+		return compiledPattern{re: re}
+	}
+
+	if caseInsensitive {
+		pattern = strings.ToLower(pattern)
+	}
+	return compiledPattern{wildcard: wildcard.Compile(pattern)}
+}
+
+var _ MemberFilter = &CompiledFilter{}
@@ -1,8 +1,47 @@
 package fuddle
 
+import (
+	"strings"
+
+	"google.golang.org/grpc/status"
+)
+
 type ConnState string
 
 const (
 	StateConnected    ConnState = "connected"
 	StateDisconnected ConnState = "disconnected"
 )
+
+// DisconnectReason is the cause of the client disconnecting from a Fuddle
+// node, where it can be detected.
+type DisconnectReason string
+
+const (
+	// DisconnectReasonConnectionLost means the connection to the node was
+	// lost, such as the node being unreachable.
+	DisconnectReasonConnectionLost DisconnectReason = "connection_lost"
+	// DisconnectReasonGoAway means the node sent a GOAWAY, such as when the
+	// node is shutting down for a rolling restart.
+	DisconnectReasonGoAway DisconnectReason = "goaway"
+	// DisconnectReasonStreamClosed means the node closed the update stream
+	// while the connection was still open.
+	DisconnectReasonStreamClosed DisconnectReason = "stream_closed"
+)
+
+// disconnectReason returns the reason for disconnecting given the error that
+// closed the update stream, or nil if the stream wasn't closed before the
+// client disconnected.
+func disconnectReason(streamErr error) DisconnectReason {
+	if streamErr == nil {
+		return DisconnectReasonConnectionLost
+	}
+
+	// gRPC doesn't expose whether a GOAWAY was received, though includes it
+	// in the error message of streams closed due to the GOAWAY.
+	msg := strings.ToLower(status.Convert(streamErr).Message())
+	if strings.Contains(msg, "goaway") || strings.Contains(msg, "draining") {
+		return DisconnectReasonGoAway
+	}
+	return DisconnectReasonStreamClosed
+}
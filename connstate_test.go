@@ -0,0 +1,20 @@
+package fuddle
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestDisconnectReason(t *testing.T) {
+	assert.Equal(t, DisconnectReasonConnectionLost, disconnectReason(nil))
+	assert.Equal(t, DisconnectReasonStreamClosed, disconnectReason(
+		status.Error(codes.Unavailable, "server shutting down"),
+	))
+	assert.Equal(t, DisconnectReasonGoAway, disconnectReason(status.Error(
+		codes.Unavailable,
+		"closing transport due to: EOF, received prior goaway: code: NO_ERROR",
+	)))
+}
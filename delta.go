@@ -0,0 +1,96 @@
+package fuddle
+
+// MemberUpdate describes an update to a member.
+type MemberUpdate struct {
+	Old Member
+	New Member
+}
+
+// Delta describes the changes to the registry since the last notification.
+type Delta struct {
+	// Joined contains the members that joined the registry.
+	Joined []Member
+	// Left contains the members that left the registry.
+	Left []Member
+	// Updated contains the members whose state was updated.
+	Updated []MemberUpdate
+}
+
+func (d *Delta) empty() bool {
+	return len(d.Joined) == 0 && len(d.Left) == 0 && len(d.Updated) == 0
+}
+
+// copy returns a deep copy of the delta so it can be passed to multiple
+// subscribers.
+func (d *Delta) copy() Delta {
+	var cp Delta
+	for _, m := range d.Joined {
+		cp.Joined = append(cp.Joined, m.Copy())
+	}
+	for _, m := range d.Left {
+		cp.Left = append(cp.Left, m.Copy())
+	}
+	for _, u := range d.Updated {
+		cp.Updated = append(cp.Updated, MemberUpdate{
+			Old: u.Old.Copy(),
+			New: u.New.Copy(),
+		})
+	}
+	return cp
+}
+
+// merge returns the net changes of the delta followed by the next delta. Such
+// as a member that joined in the delta and left in the next delta is omitted,
+// and a member updated in both is included as a single update from the
+// original state to the final state.
+func (d *Delta) merge(next Delta) Delta {
+	type change struct {
+		old        Member
+		oldPresent bool
+		new        Member
+		newPresent bool
+	}
+
+	changes := make(map[string]*change)
+	// order contains the IDs of the changed members in the order they were
+	// first changed.
+	var order []string
+	apply := func(id string, old Member, oldPresent bool, new Member, newPresent bool) {
+		c, ok := changes[id]
+		if !ok {
+			c = &change{old: old, oldPresent: oldPresent}
+			changes[id] = c
+			order = append(order, id)
+		}
+		c.new = new
+		c.newPresent = newPresent
+	}
+	for _, delta := range []*Delta{d, &next} {
+		for _, m := range delta.Joined {
+			apply(m.ID, Member{}, false, m, true)
+		}
+		for _, m := range delta.Left {
+			apply(m.ID, m, true, Member{}, false)
+		}
+		for _, u := range delta.Updated {
+			apply(u.New.ID, u.Old, true, u.New, true)
+		}
+	}
+
+	var merged Delta
+	for _, id := range order {
+		c := changes[id]
+		switch {
+		case !c.oldPresent && c.newPresent:
+			merged.Joined = append(merged.Joined, c.new)
+		case c.oldPresent && !c.newPresent:
+			merged.Left = append(merged.Left, c.old)
+		case c.oldPresent && c.newPresent && !c.old.Equal(c.new):
+			merged.Updated = append(merged.Updated, MemberUpdate{
+				Old: c.old,
+				New: c.new,
+			})
+		}
+	}
+	return merged
+}
@@ -0,0 +1,88 @@
+package fuddle
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDelta_Merge(t *testing.T) {
+	member := func(id string, status string) Member {
+		return Member{
+			ID:      id,
+			Service: "orders",
+			Status:  status,
+		}
+	}
+
+	tests := []struct {
+		name     string
+		delta    Delta
+		next     Delta
+		expected Delta
+	}{
+		{
+			name:  "joined then left",
+			delta: Delta{Joined: []Member{member("a", "up")}},
+			next:  Delta{Left: []Member{member("a", "up")}},
+		},
+		{
+			name:  "left then joined",
+			delta: Delta{Left: []Member{member("a", "up")}},
+			next:  Delta{Joined: []Member{member("a", "starting")}},
+			expected: Delta{Updated: []MemberUpdate{
+				{Old: member("a", "up"), New: member("a", "starting")},
+			}},
+		},
+		{
+			name:  "left then joined unchanged",
+			delta: Delta{Left: []Member{member("a", "up")}},
+			next:  Delta{Joined: []Member{member("a", "up")}},
+		},
+		{
+			name:  "joined then updated",
+			delta: Delta{Joined: []Member{member("a", "starting")}},
+			next: Delta{Updated: []MemberUpdate{
+				{Old: member("a", "starting"), New: member("a", "up")},
+			}},
+			expected: Delta{Joined: []Member{member("a", "up")}},
+		},
+		{
+			name: "updated then updated",
+			delta: Delta{Updated: []MemberUpdate{
+				{Old: member("a", "starting"), New: member("a", "up")},
+			}},
+			next: Delta{Updated: []MemberUpdate{
+				{Old: member("a", "up"), New: member("a", "draining")},
+			}},
+			expected: Delta{Updated: []MemberUpdate{
+				{Old: member("a", "starting"), New: member("a", "draining")},
+			}},
+		},
+		{
+			name: "updated then left",
+			delta: Delta{Updated: []MemberUpdate{
+				{Old: member("a", "starting"), New: member("a", "up")},
+			}},
+			next:     Delta{Left: []Member{member("a", "up")}},
+			expected: Delta{Left: []Member{member("a", "starting")}},
+		},
+		{
+			name:  "different members",
+			delta: Delta{Joined: []Member{member("a", "up")}},
+			next: Delta{
+				Joined: []Member{member("b", "up")},
+				Left:   []Member{member("c", "up")},
+			},
+			expected: Delta{
+				Joined: []Member{member("a", "up"), member("b", "up")},
+				Left:   []Member{member("c", "up")},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.delta.merge(tt.next))
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package fuddle
+
+import (
+	"sort"
+
+	"go.uber.org/zap"
+)
+
+// fuddleAddrPrefix is the prefix of the metadata keys of Fuddle node members
+// containing the address clients connect to, in the 'addr.rpc.ip' and
+// 'addr.rpc.port' keys.
+const fuddleAddrPrefix = "addr.rpc"
+
+// discoverFuddleNodes subscribes to the Fuddle node members in the registry
+// and adds their addresses to the resolver addresses, so the client can fail
+// over to nodes that weren't in the seeds.
+func (f *Fuddle) discoverFuddleNodes() {
+	filter := Filter{
+		f.fuddleServiceName: {},
+	}
+	f.registry.subscribe(&subscriber{
+		MembersCallback: func(members []Member) {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+
+			f.discovered = fuddleNodeAddrs(members)
+			f.updateResolverAddrsLocked()
+		},
+		Filter: &filter,
+	})
+}
+
+// watchBackupSeeds adds the backup seeds to the resolver addresses if the
+// client is still disconnected after backupSeedsAfter, where disconnects is
+// the number of disconnects when the client last disconnected. If the client
+// reconnects and disconnects again in the meantime, the later disconnect
+// restarts the wait.
+func (f *Fuddle) watchBackupSeeds(disconnects int) {
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+
+		select {
+		case <-f.clock.After(f.backupSeedsAfter):
+		case <-f.ctx.Done():
+			return
+		}
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		if f.connected || f.disconnects != disconnects || f.useBackupSeeds {
+			return
+		}
+
+		f.logger.Warn(
+			"unable to connect to seeds; adding backup seeds",
+			zap.Strings("backup-seeds", f.backupSeeds),
+			zap.Duration("threshold", f.backupSeedsAfter),
+		)
+
+		f.useBackupSeeds = true
+		f.updateResolverAddrsLocked()
+	}()
+}
+
+// updateResolverAddrsLocked updates the resolver with the seed, backup seed
+// (if enabled) and discovered addresses, excluding the address of a node that
+// closed the update stream and the addresses of nodes that failed the
+// readiness probe, if the addresses have changed.
+//
+// Assumes the mutex is locked.
+func (f *Fuddle) updateResolverAddrsLocked() {
+	addrs := append(append([]string{}, f.seeds...), f.discovered...)
+	if f.useBackupSeeds {
+		addrs = append(addrs, f.backupSeeds...)
+	}
+	addrs = uniqueSorted(addrs)
+	if f.excludedAddr != "" {
+		addrs = excludeAddr(addrs, f.excludedAddr)
+	}
+	for _, addr := range f.unreadyAddrs {
+		addrs = excludeAddr(addrs, addr)
+	}
+	if equalStrings(addrs, f.resolverAddrs) {
+		return
+	}
+	f.resolverAddrs = addrs
+
+	f.logger.Info("update resolver addrs", zap.Strings("addrs", addrs))
+
+	// Since we use a 'first pick' load balancer, shuffle the addrs so
+	// multiple clients with the same addrs don't all try the same node.
+	addrs = append([]string{}, addrs...)
+	shuffleStrings(addrs)
+	f.staticResolver.UpdateAddrs(addrs)
+}
+
+// excludeAddr returns the addresses without the given address, unless that
+// would leave no addresses.
+func excludeAddr(addrs []string, addr string) []string {
+	var excluded []string
+	for _, a := range addrs {
+		if a != addr {
+			excluded = append(excluded, a)
+		}
+	}
+	if len(excluded) == 0 {
+		return addrs
+	}
+	return excluded
+}
+
+// fuddleNodeAddrs returns the addresses of the given Fuddle node members,
+// ignoring members that don't include an address.
+func fuddleNodeAddrs(members []Member) []string {
+	var addrs []string
+	for _, m := range members {
+		addr, ok := m.Address(fuddleAddrPrefix)
+		if !ok {
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+func uniqueSorted(s []string) []string {
+	set := make(map[string]interface{})
+	for _, v := range s {
+		set[v] = struct{}{}
+	}
+	var unique []string
+	for v := range set {
+		unique = append(unique, v)
+	}
+	sort.Strings(unique)
+	return unique
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
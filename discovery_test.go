@@ -0,0 +1,174 @@
+package fuddle
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	rpc "github.com/fuddle-io/fuddle-rpc/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiscovery_FuddleNodeAddrs(t *testing.T) {
+	members := []Member{
+		{
+			ID: "fuddle-1",
+			Metadata: map[string]string{
+				"addr.rpc.ip":   "10.26.104.1",
+				"addr.rpc.port": "8220",
+			},
+		},
+		// Ignore members without an address.
+		{
+			ID: "fuddle-2",
+			Metadata: map[string]string{
+				"addr.rpc.ip": "10.26.104.2",
+			},
+		},
+		{
+			ID: "fuddle-3",
+		},
+	}
+	assert.Equal(t, []string{"10.26.104.1:8220"}, fuddleNodeAddrs(members))
+}
+
+func TestDiscovery_UpdatesResolver(t *testing.T) {
+	server1 := newTestServer(t)
+	server2 := newTestServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f, err := Connect(
+		ctx,
+		fromRPC(randomMember("local")),
+		[]string{server1.addr},
+		WithFuddleServiceName("my-fuddle"),
+		WithReconnectBackoff(Backoff{
+			Initial: time.Millisecond * 10,
+			Max:     time.Millisecond * 10,
+		}),
+	)
+	require.NoError(t, err)
+	defer f.Close()
+
+	ip, port, err := net.SplitHostPort(server2.addr)
+	require.NoError(t, err)
+
+	// Ignore members in other services.
+	other := randomMember("other")
+	other.Metadata = map[string]string{
+		"addr.rpc.ip":   "10.26.104.1",
+		"addr.rpc.port": "8220",
+	}
+	f.registry.RemoteUpdate(&rpc.Member2{
+		State:    other,
+		Liveness: rpc.Liveness_UP,
+	})
+
+	fuddleNode := randomMember("fuddle-2")
+	fuddleNode.Service = "my-fuddle"
+	fuddleNode.Metadata = map[string]string{
+		"addr.rpc.ip":   ip,
+		"addr.rpc.port": port,
+	}
+	f.registry.RemoteUpdate(&rpc.Member2{
+		State:    fuddleNode,
+		Liveness: rpc.Liveness_UP,
+	})
+
+	f.mu.Lock()
+	assert.ElementsMatch(t, []string{server1.addr, server2.addr}, f.resolverAddrs)
+	f.mu.Unlock()
+
+	// Once the seed stops, the client should fail over to the discovered
+	// node.
+	server1.Stop()
+
+	assert.Eventually(t, func() bool {
+		return len(receivedIDs(server2, rpc.ClientUpdateType_CLIENT_REGISTER)) == 1
+	}, time.Second*5, time.Millisecond*10)
+}
+
+func TestDiscovery_BackupSeedsPrimaryDown(t *testing.T) {
+	// Get an address with nothing listening to simulate the primary seed
+	// being down.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	primaryAddr := ln.Addr().String()
+	ln.Close()
+
+	backup := newTestServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f, err := Connect(
+		ctx,
+		fromRPC(randomMember("local")),
+		[]string{primaryAddr},
+		WithBackupSeeds([]string{backup.addr}),
+		WithBackupSeedsThreshold(time.Millisecond*100),
+		WithReconnectBackoff(Backoff{
+			Initial: time.Millisecond * 10,
+			Max:     time.Millisecond * 10,
+		}),
+	)
+	require.NoError(t, err)
+	defer f.Close()
+
+	f.mu.Lock()
+	assert.ElementsMatch(t, []string{primaryAddr, backup.addr}, f.resolverAddrs)
+	f.mu.Unlock()
+
+	assert.Eventually(t, func() bool {
+		return len(receivedIDs(backup, rpc.ClientUpdateType_CLIENT_REGISTER)) == 1
+	}, time.Second*5, time.Millisecond*10)
+}
+
+func TestDiscovery_BackupSeedsAfterDisconnect(t *testing.T) {
+	primary := newTestServer(t)
+	backup := newTestServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f, err := Connect(
+		ctx,
+		fromRPC(randomMember("local")),
+		[]string{primary.addr},
+		WithBackupSeeds([]string{backup.addr}),
+		WithBackupSeedsThreshold(time.Millisecond*500),
+		WithReconnectBackoff(Backoff{
+			Initial: time.Millisecond * 10,
+			Max:     time.Millisecond * 10,
+		}),
+	)
+	require.NoError(t, err)
+	defer f.Close()
+
+	resolverAddrs := func() []string {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return f.resolverAddrs
+	}
+
+	// While connected to the primary seed the backup isn't used.
+	assert.Equal(t, []string{primary.addr}, resolverAddrs())
+
+	primary.Stop()
+	require.Eventually(t, func() bool {
+		return f.ConnState() == StateDisconnected
+	}, time.Second, time.Millisecond)
+
+	// The backup seeds must not be added before the threshold.
+	time.Sleep(time.Millisecond * 100)
+	assert.Equal(t, []string{primary.addr}, resolverAddrs())
+
+	assert.Eventually(t, func() bool {
+		return len(receivedIDs(backup, rpc.ClientUpdateType_CLIENT_REGISTER)) == 1
+	}, time.Second*5, time.Millisecond*10)
+	assert.ElementsMatch(t, []string{primary.addr, backup.addr}, resolverAddrs())
+}
@@ -0,0 +1,26 @@
+package fuddle
+
+import (
+	"errors"
+)
+
+var (
+	// ErrNotConnected is returned when the client isn't connected to a
+	// Fuddle node, such as when the client is closed or the connection
+	// fails while sending an update.
+	ErrNotConnected = errors.New("not connected")
+
+	// ErrUnregisterFailed is returned when a local member couldn't be
+	// unregistered from the connected node. The member is still removed
+	// from the local registry, and will expire once the node stops
+	// receiving heartbeats.
+	ErrUnregisterFailed = errors.New("unregister failed")
+
+	// ErrUpdateRejected is returned when an update to a local member is
+	// rejected, such as registering a member whose ID is already registered
+	// or updating a member that has been unregistered.
+	ErrUpdateRejected = errors.New("update rejected")
+
+	// ErrNoSeeds is returned when no seed addresses are given.
+	ErrNoSeeds = errors.New("no seed addresses")
+)
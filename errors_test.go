@@ -0,0 +1,80 @@
+package fuddle
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrors_ConnectNoSeeds(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	_, err := Connect(ctx, fromRPC(randomMember("local")), nil)
+	assert.ErrorIs(t, err, ErrNoSeeds)
+}
+
+func TestErrors_UpdateSeedsNoSeeds(t *testing.T) {
+	server := newTestServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f, err := Connect(ctx, fromRPC(randomMember("local")), []string{server.addr})
+	require.NoError(t, err)
+	defer f.Close()
+
+	assert.ErrorIs(t, f.UpdateSeeds(nil), ErrNoSeeds)
+}
+
+func TestErrors_RegisterClosed(t *testing.T) {
+	f := NewOffline(nil)
+	f.Close()
+
+	_, err := f.Register(context.Background(), fromRPC(randomMember("local")))
+	assert.ErrorIs(t, err, ErrNotConnected)
+}
+
+func TestErrors_WaitForReadyClosed(t *testing.T) {
+	f := NewOffline(nil)
+	f.Close()
+
+	assert.ErrorIs(t, f.WaitForReady(context.Background()), ErrNotConnected)
+}
+
+func TestErrors_RegisterDuplicate(t *testing.T) {
+	f := NewOffline(nil)
+	defer f.Close()
+
+	_, err := f.Register(context.Background(), fromRPC(randomMember("local")))
+	require.NoError(t, err)
+	_, err = f.Register(context.Background(), fromRPC(randomMember("local")))
+	assert.ErrorIs(t, err, ErrUpdateRejected)
+}
+
+func TestErrors_UpdateMetadataUnregistered(t *testing.T) {
+	f := NewOffline(nil)
+	defer f.Close()
+
+	node, err := f.Register(context.Background(), fromRPC(randomMember("local")))
+	require.NoError(t, err)
+	require.NoError(t, node.Unregister())
+
+	assert.ErrorIs(t, node.UpdateMetadata(map[string]string{"foo": "1"}), ErrUpdateRejected)
+}
+
+func TestErrors_UnregisterDisconnected(t *testing.T) {
+	server := newTestServer(t)
+	f, node := connectWithLocalNode(t, server, nil)
+	defer f.Close()
+
+	server.Stop()
+	require.Eventually(t, func() bool {
+		return f.ConnState() == StateDisconnected
+	}, time.Second, time.Millisecond)
+
+	assert.ErrorIs(t, node.Unregister(), ErrUnregisterFailed)
+}
@@ -0,0 +1,421 @@
+package fuddle
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/fuddle-io/fuddle-go/internal/wildcard"
+)
+
+// MemberFilter matches members, which is implemented by both *Filter and
+// AnyFilter.
+type MemberFilter interface {
+	// Match returns whether the given member matches the filter.
+	Match(member Member) bool
+	// IsMatchAll returns whether the filter matches all members.
+	IsMatchAll() bool
+	// Validate returns an error if the filter is invalid.
+	Validate() error
+}
+
+// AnyFilter combines multiple filters, where a member matches if it matches
+// any of the filters (a logical OR).
+//
+// Within a single Filter, a member must match at least one of the service
+// filters whose service matches the member, and must match all clauses of
+// that service filter (a logical AND). Since each service name has a single
+// service filter, a Filter can't express alternatives for the same service,
+// such as 'orders in us-east-1 OR orders with status active', or overlapping
+// wildcard services. AnyFilter combines such filters.
+//
+// Note an empty AnyFilter matches no members, and nil filters in the list
+// match all members.
+type AnyFilter []*Filter
+
+// Match returns whether the given member matches any of the filters.
+func (f AnyFilter) Match(member Member) bool {
+	for _, filter := range f {
+		if filter.Match(member) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsMatchAll returns whether any of the filters match all members.
+func (f AnyFilter) IsMatchAll() bool {
+	for _, filter := range f {
+		if filter.IsMatchAll() {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate returns an error if any of the filters are invalid.
+func (f AnyFilter) Validate() error {
+	for _, filter := range f {
+		if err := filter.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isMatchAll returns whether the filter matches all members, including if the
+// filter is nil.
+func isMatchAll(f MemberFilter) bool {
+	return f == nil || f.IsMatchAll()
+}
+
+// validateFilter returns an error if the filter is invalid. A nil filter is
+// valid.
+func validateFilter(f MemberFilter) error {
+	if f == nil {
+		return nil
+	}
+	return f.Validate()
+}
+
+// Filter specifies a member filter.
+//
+// This maps a service name (which may include wildcards) to a filter for
+// members of that service. A member matches the filter if it matches any of
+// the service filters whose service name matches the members service.
+//
+// Wildcard patterns may contain '*', which matches zero or more characters,
+// and '?', which matches a single character. A backslash escapes a character
+// to match it literally, such as 'build\*' matching only 'build*'.
+//
+// Note an empty filter matches no members, whereas a nil filter (either a nil
+// pointer or a nil map) matches all members. To make the intent clear, use
+// MatchAll and MatchNone rather than relying on nil and empty filters.
+type Filter map[string]ServiceFilter
+
+// MatchAll returns a filter that matches all members.
+func MatchAll() *Filter {
+	return &Filter{"*": {}}
+}
+
+// MatchNone returns a filter that matches no members.
+func MatchNone() *Filter {
+	return &Filter{}
+}
+
+// IsMatchAll returns whether the filter matches all members, which is if the
+// filter is nil or contains a '*' service with no other clauses. This lets
+// callers skip evaluating the filter for each member.
+func (f *Filter) IsMatchAll() bool {
+	if f == nil || *f == nil {
+		return true
+	}
+	serviceFilter, ok := (*f)["*"]
+	return ok && serviceFilter.isMatchAll()
+}
+
+// Match returns whether the given member matches the filter. Since regular
+// expressions are compiled on each call, use Compile to match the filter
+// against many members.
+func (f *Filter) Match(member Member) bool {
+	if f.IsMatchAll() {
+		return true
+	}
+
+	for service, serviceFilter := range *f {
+		if !wildcard.Match(service, member.Service) {
+			continue
+		}
+		if serviceFilter.Match(member) {
+			return true
+		}
+	}
+	return false
+}
+
+// services returns a filter that matches all members in the services the
+// filter selects, ignoring the other clauses of each service filter. A nil
+// filter is returned as nil, which matches all members.
+func (f *Filter) services() *Filter {
+	if f == nil || *f == nil {
+		return nil
+	}
+	services := make(Filter, len(*f))
+	for service := range *f {
+		services[service] = ServiceFilter{}
+	}
+	return &services
+}
+
+// ServiceFilter specifies a filter for the members of a service. A member
+// must match all clauses of the filter to match.
+type ServiceFilter struct {
+	// Status contains a list of statuses (which may include wildcards or
+	// regular expressions like LocalityFilter) where the members status must
+	// match at least one. A nil or empty list matches all members.
+	Status []string
+	// Revision contains a list of revisions (which may include wildcards or
+	// regular expressions), such as 'v5.1.*', where the members revision
+	// must match at least one. A nil or empty list matches all members.
+	Revision []string
+	// StartedAfter and StartedBefore match members whose started timestamp
+	// is within the range, inclusive. A zero bound is unbounded.
+	StartedAfter  int64
+	StartedBefore int64
+	Locality      LocalityFilter
+	Metadata      MetadataFilter
+	// Attributes filters on the members attributes, using the same matching
+	// as Metadata.
+	Attributes MetadataFilter
+
+	// CaseInsensitive matches the status, revision, locality, metadata and
+	// attribute values ignoring case. Note metadata and attribute keys are
+	// still case sensitive.
+	CaseInsensitive bool
+}
+
+// isMatchAll returns whether the filter has no clauses so matches all
+// members.
+func (f *ServiceFilter) isMatchAll() bool {
+	return len(f.Status) == 0 &&
+		len(f.Revision) == 0 &&
+		f.StartedAfter == 0 &&
+		f.StartedBefore == 0 &&
+		len(f.Locality.Region) == 0 &&
+		len(f.Locality.AvailabilityZone) == 0 &&
+		len(f.Metadata) == 0 &&
+		len(f.Attributes) == 0
+}
+
+// Match returns whether the given member matches the filter.
+func (f *ServiceFilter) Match(member Member) bool {
+	if f == nil {
+		return true
+	}
+	return matchAny(f.Status, member.Status, f.CaseInsensitive) &&
+		matchAny(f.Revision, member.Revision, f.CaseInsensitive) &&
+		f.matchStarted(member.Started) &&
+		f.Locality.match(member, f.CaseInsensitive) &&
+		f.Metadata.match(member.Metadata, f.CaseInsensitive) &&
+		f.Attributes.match(member.Attributes, f.CaseInsensitive)
+}
+
+// matchStarted returns whether the started timestamp is within the started
+// range.
+func (f *ServiceFilter) matchStarted(started int64) bool {
+	if f.StartedAfter != 0 && started < f.StartedAfter {
+		return false
+	}
+	if f.StartedBefore != 0 && started > f.StartedBefore {
+		return false
+	}
+	return true
+}
+
+// LocalityFilter specifies a filter on the members locality.
+//
+// Each field contains a list of values (which may include wildcards) where
+// the member must match at least one. A nil or empty list matches all members.
+//
+// Values with a 're:' prefix are regular expressions instead, such as
+// 're:^us-(east|west)-1$'. Note the expression isn't anchored unless it
+// includes '^' and '$'.
+type LocalityFilter struct {
+	Region           []string
+	AvailabilityZone []string
+}
+
+// Match returns whether the given member matches the filter.
+func (f *LocalityFilter) Match(member Member) bool {
+	return f.match(member, false)
+}
+
+func (f *LocalityFilter) match(member Member, caseInsensitive bool) bool {
+	if f == nil {
+		return true
+	}
+	return matchAny(f.Region, member.Locality.Region, caseInsensitive) &&
+		matchAny(f.AvailabilityZone, member.Locality.AvailabilityZone, caseInsensitive)
+}
+
+// MetadataFilter specifies a filter on the members metadata.
+//
+// This maps a metadata key to a list of values (which may include wildcards).
+// The member must contain every key in the filter, and the members value
+// for each key must match at least one of the filter values. An empty list
+// of values matches any value. As with LocalityFilter, values with a 're:'
+// prefix are regular expressions.
+//
+// Keys may also include wildcards, such as 'shard.*.weight', where the member
+// matches if the value of any key matching the pattern matches the values.
+type MetadataFilter map[string][]string
+
+// Match returns whether the given members metadata matches the filter.
+func (f *MetadataFilter) Match(member Member) bool {
+	return f.match(member.Metadata, false)
+}
+
+// match returns whether the given metadata matches the filter, which is either
+// the members metadata or attributes.
+func (f *MetadataFilter) match(metadata map[string]string, caseInsensitive bool) bool {
+	if f == nil {
+		return true
+	}
+
+	for key, values := range *f {
+		// Only scan the members metadata for wildcard keys, otherwise look
+		// up the key directly.
+		literal, ok := wildcard.Compile(key).Literal()
+		if !ok {
+			if !matchWildcardKey(key, values, metadata, caseInsensitive) {
+				return false
+			}
+			continue
+		}
+
+		v, ok := metadata[literal]
+		if !ok {
+			return false
+		}
+		if !matchAny(values, v, caseInsensitive) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchWildcardKey returns whether any of the metadata keys match the key
+// pattern where the value for that key also matches the values.
+func matchWildcardKey(pattern string, values []string, metadata map[string]string, caseInsensitive bool) bool {
+	for k, v := range metadata {
+		if !wildcard.Match(pattern, k) {
+			continue
+		}
+		if matchAny(values, v, caseInsensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate returns an error if the filter is invalid, which is when it
+// contains an empty service name, an empty metadata or attribute key, a
+// regular expression that doesn't compile, or an empty started range. An
+// invalid filter would otherwise silently match nothing.
+func (f *Filter) Validate() error {
+	if f == nil {
+		return nil
+	}
+
+	for service, serviceFilter := range *f {
+		if service == "" {
+			return fmt.Errorf("filter: empty service")
+		}
+		if err := validatePatterns(serviceFilter.Status); err != nil {
+			return fmt.Errorf("filter: %s: status: %w", service, err)
+		}
+		if err := validatePatterns(serviceFilter.Revision); err != nil {
+			return fmt.Errorf("filter: %s: revision: %w", service, err)
+		}
+		if serviceFilter.StartedAfter != 0 && serviceFilter.StartedBefore != 0 &&
+			serviceFilter.StartedAfter > serviceFilter.StartedBefore {
+			return fmt.Errorf("filter: %s: started after is after started before", service)
+		}
+		if err := validatePatterns(serviceFilter.Locality.Region); err != nil {
+			return fmt.Errorf("filter: %s: region: %w", service, err)
+		}
+		if err := validatePatterns(serviceFilter.Locality.AvailabilityZone); err != nil {
+			return fmt.Errorf("filter: %s: availability zone: %w", service, err)
+		}
+		for key, values := range serviceFilter.Metadata {
+			if key == "" {
+				return fmt.Errorf("filter: %s: metadata: empty key", service)
+			}
+			if err := validatePatterns(values); err != nil {
+				return fmt.Errorf("filter: %s: metadata: %s: %w", service, key, err)
+			}
+		}
+		for key, values := range serviceFilter.Attributes {
+			if key == "" {
+				return fmt.Errorf("filter: %s: attributes: empty key", service)
+			}
+			if err := validatePatterns(values); err != nil {
+				return fmt.Errorf("filter: %s: attributes: %s: %w", service, key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// WildcardMatch returns whether s matches the wildcard pattern, using the same
+// matching as filters. This is the canonical wildcard matcher, so filtering
+// members client side using WildcardMatch is consistent with Filter.
+//
+// The pattern must match the whole string. '*' matches zero or more
+// characters, '?' matches a single character, and a backslash escapes a
+// character to match it literally. Unlike filter values, patterns with a
+// 're:' prefix aren't regular expressions.
+func WildcardMatch(pattern string, s string) bool {
+	return wildcard.Match(pattern, s)
+}
+
+// regexPrefix is the prefix of filter values that are regular expressions
+// rather than wildcard patterns.
+const regexPrefix = "re:"
+
+// validatePatterns returns an error if any of the patterns are invalid.
+func validatePatterns(patterns []string) error {
+	for _, p := range patterns {
+		if !strings.HasPrefix(p, regexPrefix) {
+			continue
+		}
+		if _, err := regexp.Compile(strings.TrimPrefix(p, regexPrefix)); err != nil {
+			return fmt.Errorf("invalid regex: %w", err)
+		}
+	}
+	return nil
+}
+
+// matchAny returns whether s matches any of the given patterns, or true if
+// there are no patterns. If caseInsensitive is true, the patterns and s are
+// compared ignoring case.
+func matchAny(patterns []string, s string, caseInsensitive bool) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if matchPattern(p, s, caseInsensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPattern returns whether s matches the pattern, which is either a
+// regular expression if it has the 're:' prefix, otherwise a wildcard
+// pattern. Invalid regular expressions never match.
+//
+// Regular expressions are compiled on each call, so filters matched against
+// many members should be compiled first (see Filter.Compile).
+func matchPattern(pattern string, s string, caseInsensitive bool) bool {
+	if strings.HasPrefix(pattern, regexPrefix) {
+		expr := strings.TrimPrefix(pattern, regexPrefix)
+		if caseInsensitive {
+			expr = "(?i)" + expr
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return false
+		}
+		return re.MatchString(s)
+	}
+
+	if caseInsensitive {
+		pattern = strings.ToLower(pattern)
+		s = strings.ToLower(s)
+	}
+	return wildcard.Match(pattern, s)
+}
+
+var _ MemberFilter = &Filter{}
+var _ MemberFilter = AnyFilter{}
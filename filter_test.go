@@ -0,0 +1,963 @@
+package fuddle
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/fuddle-io/fuddle-go/internal/wildcard"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilter_Match(t *testing.T) {
+	member := Member{
+		ID:      "orders-1",
+		Service: "orders",
+		Locality: Locality{
+			Region:           "us-east-1",
+			AvailabilityZone: "us-east-1-b",
+		},
+		Metadata: map[string]string{
+			"status":   "active",
+			"protocol": "3",
+		},
+	}
+
+	tests := []struct {
+		name   string
+		filter *Filter
+		match  bool
+	}{
+		{
+			name:   "nil filter",
+			filter: nil,
+			match:  true,
+		},
+		{
+			name:   "nil map filter",
+			filter: new(Filter),
+			match:  true,
+		},
+		{
+			name:   "empty filter",
+			filter: &Filter{},
+			match:  false,
+		},
+		{
+			name:   "service match",
+			filter: &Filter{"orders": {}},
+			match:  true,
+		},
+		{
+			name:   "service wildcard match",
+			filter: &Filter{"ord*": {}},
+			match:  true,
+		},
+		{
+			name:   "service mismatch",
+			filter: &Filter{"payments": {}},
+			match:  false,
+		},
+		{
+			name: "locality match",
+			filter: &Filter{"orders": {
+				Locality: LocalityFilter{
+					Region:           []string{"eu-west-1", "us-east-1"},
+					AvailabilityZone: []string{"us-east-1-*"},
+				},
+			}},
+			match: true,
+		},
+		{
+			name: "locality mismatch",
+			filter: &Filter{"orders": {
+				Locality: LocalityFilter{
+					Region: []string{"eu-west-*"},
+				},
+			}},
+			match: false,
+		},
+		{
+			name: "metadata match",
+			filter: &Filter{"orders": {
+				Metadata: MetadataFilter{
+					"status":   []string{"active"},
+					"protocol": []string{},
+				},
+			}},
+			match: true,
+		},
+		{
+			name: "metadata value mismatch",
+			filter: &Filter{"orders": {
+				Metadata: MetadataFilter{
+					"status": []string{"booting"},
+				},
+			}},
+			match: false,
+		},
+		{
+			name: "metadata missing key",
+			filter: &Filter{"orders": {
+				Metadata: MetadataFilter{
+					"foo": []string{},
+				},
+			}},
+			match: false,
+		},
+		{
+			name: "any service matches",
+			filter: &Filter{
+				"payments": {},
+				"orders": {
+					Metadata: MetadataFilter{
+						"status": []string{"act*"},
+					},
+				},
+			},
+			match: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertFilterMatch(t, tt.match, tt.filter, member)
+		})
+	}
+}
+
+func TestFilter_MatchCaseInsensitive(t *testing.T) {
+	member := Member{
+		ID:      "orders-1",
+		Service: "orders",
+		Locality: Locality{
+			Region:           "US-East-1",
+			AvailabilityZone: "us-east-1-B",
+		},
+		Metadata: map[string]string{
+			"status": "Active",
+		},
+	}
+
+	tests := []struct {
+		name            string
+		serviceFilter   ServiceFilter
+		caseSensitive   bool
+		caseInsensitive bool
+	}{
+		{
+			name: "region",
+			serviceFilter: ServiceFilter{
+				Locality: LocalityFilter{Region: []string{"us-east-1"}},
+			},
+			caseSensitive:   false,
+			caseInsensitive: true,
+		},
+		{
+			name: "availability zone wildcard",
+			serviceFilter: ServiceFilter{
+				Locality: LocalityFilter{AvailabilityZone: []string{"US-EAST-*-b"}},
+			},
+			caseSensitive:   false,
+			caseInsensitive: true,
+		},
+		{
+			name: "metadata value",
+			serviceFilter: ServiceFilter{
+				Metadata: MetadataFilter{"status": {"active"}},
+			},
+			caseSensitive:   false,
+			caseInsensitive: true,
+		},
+		{
+			name: "metadata key",
+			serviceFilter: ServiceFilter{
+				Metadata: MetadataFilter{"STATUS": {"Active"}},
+			},
+			caseSensitive:   false,
+			caseInsensitive: false,
+		},
+		{
+			name: "exact case",
+			serviceFilter: ServiceFilter{
+				Locality: LocalityFilter{Region: []string{"US-East-1"}},
+				Metadata: MetadataFilter{"status": {"Active"}},
+			},
+			caseSensitive:   true,
+			caseInsensitive: true,
+		},
+		{
+			name: "mismatch",
+			serviceFilter: ServiceFilter{
+				Locality: LocalityFilter{Region: []string{"us-west-1"}},
+			},
+			caseSensitive:   false,
+			caseInsensitive: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter := Filter{"orders": tt.serviceFilter}
+			assertFilterMatch(t, tt.caseSensitive, &filter, member)
+
+			tt.serviceFilter.CaseInsensitive = true
+			filter = Filter{"orders": tt.serviceFilter}
+			assertFilterMatch(t, tt.caseInsensitive, &filter, member)
+		})
+	}
+}
+
+func TestFilter_MatchRegex(t *testing.T) {
+	member := Member{
+		ID:      "orders-1",
+		Service: "orders",
+		Locality: Locality{
+			Region:           "us-east-1",
+			AvailabilityZone: "us-east-1-b",
+		},
+		Metadata: map[string]string{
+			"protocol": "3",
+		},
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		match  bool
+	}{
+		{
+			name: "alternation",
+			filter: Filter{"orders": {
+				Locality: LocalityFilter{Region: []string{"re:^us-(east|west)-1$"}},
+			}},
+			match: true,
+		},
+		{
+			name: "character class",
+			filter: Filter{"orders": {
+				Metadata: MetadataFilter{"protocol": {"re:^[2-4]$"}},
+			}},
+			match: true,
+		},
+		{
+			name: "anchored mismatch",
+			filter: Filter{"orders": {
+				Locality: LocalityFilter{AvailabilityZone: []string{"re:^east"}},
+			}},
+			match: false,
+		},
+		{
+			name: "unanchored match",
+			filter: Filter{"orders": {
+				Locality: LocalityFilter{AvailabilityZone: []string{"re:east"}},
+			}},
+			match: true,
+		},
+		{
+			name: "case insensitive",
+			filter: Filter{"orders": {
+				Locality:        LocalityFilter{Region: []string{"re:^US-EAST-1$"}},
+				CaseInsensitive: true,
+			}},
+			match: true,
+		},
+		{
+			name: "invalid regex",
+			filter: Filter{"orders": {
+				Locality: LocalityFilter{Region: []string{"re:us-(east"}},
+			}},
+			match: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertFilterMatch(t, tt.match, &tt.filter, member)
+		})
+	}
+}
+
+func TestFilter_ValidateRegex(t *testing.T) {
+	valid := Filter{"orders": {
+		Locality: LocalityFilter{
+			Region:           []string{"re:^us-(east|west)-1$"},
+			AvailabilityZone: []string{"us-*"},
+		},
+		Metadata: MetadataFilter{"protocol": {"re:^[2-4]$"}},
+	}}
+	assert.NoError(t, valid.Validate())
+
+	invalid := []Filter{
+		{"orders": {
+			Locality: LocalityFilter{Region: []string{"re:us-(east"}},
+		}},
+		{"orders": {
+			Locality: LocalityFilter{AvailabilityZone: []string{"re:[a-"}},
+		}},
+		{"orders": {
+			Metadata: MetadataFilter{"protocol": {"re:*"}},
+		}},
+	}
+	for _, f := range invalid {
+		assert.Error(t, f.Validate())
+	}
+}
+
+func TestFilter_Validate(t *testing.T) {
+	valid := Filter{
+		"orders": {
+			Locality: LocalityFilter{
+				Region: []string{"us-east-*"},
+			},
+			Metadata: MetadataFilter{
+				"status": {"active"},
+			},
+		},
+		"*": {},
+	}
+	assert.NoError(t, valid.Validate())
+
+	var nilFilter *Filter
+	assert.NoError(t, nilFilter.Validate())
+
+	tests := []struct {
+		name   string
+		filter Filter
+	}{
+		{
+			name:   "empty service",
+			filter: Filter{"": {}},
+		},
+		{
+			name: "empty metadata key",
+			filter: Filter{"orders": {
+				Metadata: MetadataFilter{"": {"active"}},
+			}},
+		},
+		{
+			name: "invalid region regex",
+			filter: Filter{"orders": {
+				Locality: LocalityFilter{Region: []string{"re:(us"}},
+			}},
+		},
+		{
+			name: "invalid revision regex",
+			filter: Filter{"orders": {
+				Revision: []string{"re:(v5"},
+			}},
+		},
+		{
+			name: "empty started range",
+			filter: Filter{"orders": {
+				StartedAfter:  2000,
+				StartedBefore: 1000,
+			}},
+		},
+		{
+			name: "invalid status regex",
+			filter: Filter{"orders": {
+				Status: []string{"re:(active"},
+			}},
+		},
+		{
+			name: "invalid metadata regex",
+			filter: Filter{"orders": {
+				Metadata: MetadataFilter{"status": {"re:[a-"}},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Error(t, tt.filter.Validate())
+		})
+	}
+}
+
+func TestFilter_MatchMetadataWildcardKey(t *testing.T) {
+	member := Member{
+		ID:      "orders-1",
+		Service: "orders",
+		Metadata: map[string]string{
+			"status":         "active",
+			"shard.0.weight": "10",
+			"shard.1.weight": "20",
+			"build*":         "yes",
+		},
+	}
+
+	tests := []struct {
+		name     string
+		metadata MetadataFilter
+		match    bool
+	}{
+		{
+			name:     "wildcard key any value",
+			metadata: MetadataFilter{"shard.*.weight": {}},
+			match:    true,
+		},
+		{
+			name:     "wildcard key first match",
+			metadata: MetadataFilter{"shard.*.weight": {"10"}},
+			match:    true,
+		},
+		{
+			// Multiple member keys match the filter key, where only the
+			// second has a matching value.
+			name:     "wildcard key second match",
+			metadata: MetadataFilter{"shard.*.weight": {"20"}},
+			match:    true,
+		},
+		{
+			name:     "wildcard key value mismatch",
+			metadata: MetadataFilter{"shard.*.weight": {"30"}},
+			match:    false,
+		},
+		{
+			name:     "wildcard key mismatch",
+			metadata: MetadataFilter{"replica.*.weight": {}},
+			match:    false,
+		},
+		{
+			name: "literal and wildcard keys",
+			metadata: MetadataFilter{
+				"status":         {"active"},
+				"shard.*.weight": {"2*"},
+			},
+			match: true,
+		},
+		{
+			name:     "single character wildcard key",
+			metadata: MetadataFilter{"shard.?.weight": {"20"}},
+			match:    true,
+		},
+		{
+			name:     "single character wildcard key mismatch",
+			metadata: MetadataFilter{"shard.??.weight": {}},
+			match:    false,
+		},
+		{
+			name:     "escaped wildcard key",
+			metadata: MetadataFilter{`build\*`: {"yes"}},
+			match:    true,
+		},
+		{
+			name:     "escaped wildcard key mismatch",
+			metadata: MetadataFilter{`shard\*`: {}},
+			match:    false,
+		},
+		{
+			name: "literal mismatch with wildcard match",
+			metadata: MetadataFilter{
+				"status":         {"inactive"},
+				"shard.*.weight": {"20"},
+			},
+			match: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter := Filter{"orders": {Metadata: tt.metadata}}
+			assertFilterMatch(t, tt.match, &filter, member)
+		})
+	}
+}
+
+func TestFilter_MatchAllAndMatchNone(t *testing.T) {
+	members := []Member{
+		{ID: "orders-1", Service: "orders"},
+		{
+			ID:      "payments-1",
+			Service: "payments",
+			Locality: Locality{
+				Region:           "us-east-1",
+				AvailabilityZone: "us-east-1-b",
+			},
+			Metadata: map[string]string{"status": "active"},
+		},
+		{ID: "empty"},
+	}
+	for _, m := range members {
+		assert.True(t, MatchAll().Match(m))
+		assert.False(t, MatchNone().Match(m))
+	}
+
+	assert.True(t, MatchAll().IsMatchAll())
+	assert.False(t, MatchNone().IsMatchAll())
+}
+
+func TestFilter_IsMatchAll(t *testing.T) {
+	var nilFilter *Filter
+	assert.True(t, nilFilter.IsMatchAll())
+	assert.True(t, new(Filter).IsMatchAll())
+	assert.True(t, (&Filter{"*": {}, "orders": {}}).IsMatchAll())
+
+	assert.False(t, (&Filter{}).IsMatchAll())
+	assert.False(t, (&Filter{"orders": {}}).IsMatchAll())
+	assert.False(t, (&Filter{"*": {
+		Locality: LocalityFilter{Region: []string{"us-east-1"}},
+	}}).IsMatchAll())
+	assert.False(t, (&Filter{"*": {
+		Metadata: MetadataFilter{"status": {}},
+	}}).IsMatchAll())
+	assert.False(t, (&Filter{"*": {
+		Status: []string{"active"},
+	}}).IsMatchAll())
+	assert.False(t, (&Filter{"*": {
+		Revision: []string{"v5.*"},
+	}}).IsMatchAll())
+	assert.False(t, (&Filter{"*": {
+		StartedAfter: 1000,
+	}}).IsMatchAll())
+}
+
+func TestFilter_MatchRevision(t *testing.T) {
+	member := Member{
+		ID:       "orders-1",
+		Service:  "orders",
+		Revision: "v5.1.3",
+	}
+
+	tests := []struct {
+		revision []string
+		match    bool
+	}{
+		{revision: nil, match: true},
+		{revision: []string{"v5.1.3"}, match: true},
+		{revision: []string{"v5.1.*"}, match: true},
+		{revision: []string{"v5.*"}, match: true},
+		{revision: []string{"v5.0.*", "v5.1.*"}, match: true},
+		{revision: []string{"re:^v5\\.1\\.[0-9]+$"}, match: true},
+		{revision: []string{"v5.1"}, match: false},
+		{revision: []string{"v5.2.*"}, match: false},
+		{revision: []string{"v4.*"}, match: false},
+	}
+	for _, tt := range tests {
+		filter := &Filter{"orders": {Revision: tt.revision}}
+		assertFilterMatch(t, tt.match, filter, member, tt.revision)
+	}
+}
+
+func TestFilter_MatchAttributes(t *testing.T) {
+	member := Member{
+		ID:       "orders-1",
+		Service:  "orders",
+		Metadata: map[string]string{"status": "active"},
+		Attributes: map[string]string{
+			"rack":           "r12",
+			"hardware-class": "c5",
+		},
+	}
+
+	tests := []struct {
+		attributes MetadataFilter
+		match      bool
+	}{
+		{attributes: nil, match: true},
+		{attributes: MetadataFilter{"rack": {"r12"}}, match: true},
+		{attributes: MetadataFilter{"rack": {"r1*"}}, match: true},
+		{attributes: MetadataFilter{"rack": nil}, match: true},
+		{attributes: MetadataFilter{"rack": {"re:^r[0-9]+$"}}, match: true},
+		{attributes: MetadataFilter{"hardware-*": {"c5"}}, match: true},
+		{attributes: MetadataFilter{"rack": {"r12"}, "hardware-class": {"c5"}}, match: true},
+		{attributes: MetadataFilter{"rack": {"r13"}}, match: false},
+		{attributes: MetadataFilter{"rack": {"r12"}, "hardware-class": {"c6"}}, match: false},
+		{attributes: MetadataFilter{"zone": nil}, match: false},
+		// Attributes don't match metadata and the other way round.
+		{attributes: MetadataFilter{"status": nil}, match: false},
+	}
+	for _, tt := range tests {
+		filter := &Filter{"orders": {Attributes: tt.attributes}}
+		assertFilterMatch(t, tt.match, filter, member, tt.attributes)
+	}
+
+	assertFilterMatch(t, false, &Filter{"orders": {
+		Metadata: MetadataFilter{"rack": nil},
+	}}, member)
+	assertFilterMatch(t, true, &Filter{"orders": {
+		Attributes:      MetadataFilter{"rack": {"R12"}},
+		CaseInsensitive: true,
+	}}, member)
+
+	assert.Error(t, (&Filter{"orders": {
+		Attributes: MetadataFilter{"": nil},
+	}}).Validate())
+	assert.False(t, (&Filter{"*": {
+		Attributes: MetadataFilter{"rack": nil},
+	}}).IsMatchAll())
+}
+
+func TestWildcardMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+	}{
+		{pattern: "", s: ""},
+		{pattern: "", s: "a"},
+		{pattern: "*", s: "orders"},
+		{pattern: "order*", s: "orders"},
+		{pattern: "order*", s: "storage"},
+		{pattern: "us-*-1", s: "us-east-1"},
+		{pattern: "host-?", s: "host-1"},
+		{pattern: "host-?", s: "host-12"},
+		{pattern: `build\*`, s: "build*"},
+		{pattern: `build\*`, s: "builds"},
+		{pattern: "a**c", s: "abc"},
+		{pattern: "re:^orders$", s: "orders"},
+	}
+	for _, tt := range tests {
+		assert.Equal(
+			t,
+			wildcard.Match(tt.pattern, tt.s),
+			WildcardMatch(tt.pattern, tt.s),
+			"pattern %q, s %q", tt.pattern, tt.s,
+		)
+	}
+
+	// Filters use the same matching as WildcardMatch.
+	for _, tt := range tests {
+		// Filter values with the 're:' prefix are regular expressions.
+		if strings.HasPrefix(tt.pattern, regexPrefix) {
+			continue
+		}
+		filter := &Filter{"orders": {Status: []string{tt.pattern}}}
+		assert.Equal(
+			t,
+			WildcardMatch(tt.pattern, tt.s),
+			filter.Match(Member{Service: "orders", Status: tt.s}),
+			"pattern %q, s %q", tt.pattern, tt.s,
+		)
+	}
+}
+
+func TestFilter_MatchStarted(t *testing.T) {
+	member := Member{
+		ID:      "orders-1",
+		Service: "orders",
+		Started: 1000,
+	}
+
+	tests := []struct {
+		name   string
+		after  int64
+		before int64
+		match  bool
+	}{
+		{name: "unbounded", after: 0, before: 0, match: true},
+		{name: "after lower boundary", after: 1000, before: 0, match: true},
+		{name: "after below", after: 999, before: 0, match: true},
+		{name: "after above", after: 1001, before: 0, match: false},
+		{name: "before upper boundary", after: 0, before: 1000, match: true},
+		{name: "before above", after: 0, before: 1001, match: true},
+		{name: "before below", after: 0, before: 999, match: false},
+		{name: "range", after: 500, before: 1500, match: true},
+		{name: "single point range", after: 1000, before: 1000, match: true},
+		{name: "range before", after: 1001, before: 1500, match: false},
+		{name: "range after", after: 500, before: 999, match: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter := &Filter{"orders": {
+				StartedAfter:  tt.after,
+				StartedBefore: tt.before,
+			}}
+			assertFilterMatch(t, tt.match, filter, member)
+		})
+	}
+
+	// Combined with a revision, both clauses must match.
+	member.Revision = "v5.1.3"
+	assert.True(t, (&Filter{"orders": {
+		Revision:     []string{"v5.1.*"},
+		StartedAfter: 1000,
+	}}).Match(member))
+	assert.False(t, (&Filter{"orders": {
+		Revision:     []string{"v5.2.*"},
+		StartedAfter: 1000,
+	}}).Match(member))
+}
+
+func TestFilter_MatchStatus(t *testing.T) {
+	member := Member{
+		ID:      "orders-1",
+		Status:  "active",
+		Service: "orders",
+		Locality: Locality{
+			Region:           "us-east-1",
+			AvailabilityZone: "us-east-1-b",
+		},
+		Metadata: map[string]string{
+			"protocol": "3",
+		},
+	}
+
+	tests := []struct {
+		name   string
+		filter *Filter
+		match  bool
+	}{
+		{
+			name:   "nil status",
+			filter: &Filter{"orders": {Status: nil}},
+			match:  true,
+		},
+		{
+			name: "status match",
+			filter: &Filter{"orders": {
+				Status: []string{"booting", "active"},
+			}},
+			match: true,
+		},
+		{
+			name: "status wildcard match",
+			filter: &Filter{"orders": {
+				Status: []string{"act*"},
+			}},
+			match: true,
+		},
+		{
+			name: "status regex match",
+			filter: &Filter{"orders": {
+				Status: []string{"re:^(active|draining)$"},
+			}},
+			match: true,
+		},
+		{
+			name: "status mismatch",
+			filter: &Filter{"orders": {
+				Status: []string{"booting", "draining"},
+			}},
+			match: false,
+		},
+		{
+			name: "status case insensitive match",
+			filter: &Filter{"orders": {
+				Status:          []string{"ACTIVE"},
+				CaseInsensitive: true,
+			}},
+			match: true,
+		},
+		{
+			name: "status, locality and metadata match",
+			filter: &Filter{"orders": {
+				Status: []string{"active"},
+				Locality: LocalityFilter{
+					Region: []string{"us-east-*"},
+				},
+				Metadata: MetadataFilter{
+					"protocol": {"3"},
+				},
+			}},
+			match: true,
+		},
+		{
+			name: "status match locality mismatch",
+			filter: &Filter{"orders": {
+				Status: []string{"active"},
+				Locality: LocalityFilter{
+					Region: []string{"eu-west-2"},
+				},
+			}},
+			match: false,
+		},
+		{
+			name: "status mismatch metadata match",
+			filter: &Filter{"orders": {
+				Status: []string{"booting"},
+				Metadata: MetadataFilter{
+					"protocol": {"3"},
+				},
+			}},
+			match: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertFilterMatch(t, tt.match, tt.filter, member)
+		})
+	}
+}
+
+func TestAnyFilter_Match(t *testing.T) {
+	filter := AnyFilter{
+		{"orders": {
+			Locality: LocalityFilter{Region: []string{"us-east-1"}},
+		}},
+		{"orders": {
+			Metadata: MetadataFilter{"status": {"active"}},
+		}},
+	}
+
+	tests := []struct {
+		name   string
+		member Member
+		match  bool
+	}{
+		{
+			name: "first filter",
+			member: Member{
+				Service:  "orders",
+				Locality: Locality{Region: "us-east-1"},
+			},
+			match: true,
+		},
+		{
+			name: "second filter",
+			member: Member{
+				Service:  "orders",
+				Locality: Locality{Region: "eu-west-1"},
+				Metadata: map[string]string{"status": "active"},
+			},
+			match: true,
+		},
+		{
+			name: "neither filter",
+			member: Member{
+				Service:  "orders",
+				Locality: Locality{Region: "eu-west-1"},
+			},
+			match: false,
+		},
+		{
+			name: "other service",
+			member: Member{
+				Service:  "payments",
+				Locality: Locality{Region: "us-east-1"},
+			},
+			match: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertFilterMatch(t, tt.match, filter, tt.member)
+		})
+	}
+
+	assert.False(t, filter.IsMatchAll())
+	assert.NoError(t, filter.Validate())
+}
+
+func TestAnyFilter_Empty(t *testing.T) {
+	assert.False(t, AnyFilter{}.Match(Member{Service: "orders"}))
+	assert.False(t, AnyFilter{}.IsMatchAll())
+
+	assert.True(t, AnyFilter{nil}.Match(Member{Service: "orders"}))
+	assert.True(t, AnyFilter{MatchNone(), MatchAll()}.IsMatchAll())
+
+	assert.Error(t, AnyFilter{MatchAll(), {"": {}}}.Validate())
+}
+
+func TestFilter_Compile(t *testing.T) {
+	filter := &Filter{"orders": {
+		Metadata: MetadataFilter{"status": {"active"}},
+	}}
+	compiled, err := filter.Compile()
+	require.NoError(t, err)
+
+	// The compiled filter is a snapshot of the filter.
+	(*filter)["orders"] = ServiceFilter{}
+	assert.True(t, compiled.Match(Member{
+		Service:  "orders",
+		Metadata: map[string]string{"status": "active"},
+	}))
+	assert.False(t, compiled.Match(Member{Service: "orders"}))
+
+	assert.True(t, mustCompile(t, MatchAll()).IsMatchAll())
+	assert.False(t, mustCompile(t, MatchNone()).Match(Member{Service: "orders"}))
+
+	_, err = (&Filter{"": {}}).Compile()
+	assert.Error(t, err)
+	_, err = AnyFilter{{"orders": {Status: []string{"re:[a-"}}}}.Compile()
+	assert.Error(t, err)
+}
+
+// assertFilterMatch asserts both the filter and the compiled filter match
+// the member, if the filter is valid.
+func assertFilterMatch(t *testing.T, match bool, filter MemberFilter, member Member, msgAndArgs ...interface{}) {
+	t.Helper()
+
+	assert.Equal(t, match, filter.Match(member), msgAndArgs...)
+
+	if filter.Validate() != nil {
+		return
+	}
+	compiled, err := compileMemberFilter(filter)
+	require.NoError(t, err)
+	assert.Equal(t, match, compiled.Match(member), msgAndArgs...)
+}
+
+func BenchmarkFilter_Match(b *testing.B) {
+	filter := benchmarkFilter()
+	members := benchmarkFilterMembers(10000)
+
+	b.ResetTimer()
+	for i := 0; i != b.N; i++ {
+		for _, m := range members {
+			filter.Match(m)
+		}
+	}
+}
+
+func BenchmarkCompiledFilter_Match(b *testing.B) {
+	compiled, err := benchmarkFilter().Compile()
+	require.NoError(b, err)
+	members := benchmarkFilterMembers(10000)
+
+	b.ResetTimer()
+	for i := 0; i != b.N; i++ {
+		for _, m := range members {
+			compiled.Match(m)
+		}
+	}
+}
+
+func benchmarkFilter() *Filter {
+	return &Filter{
+		"order*": {
+			Status: []string{"active", "draining"},
+			Locality: LocalityFilter{
+				Region:           []string{"us-*-1", "eu-west-*"},
+				AvailabilityZone: []string{"re:^[a-z]+-[a-z]+-[0-9][a-c]$"},
+			},
+			Metadata: MetadataFilter{
+				"protocol":       {"v2*"},
+				"shard.*.weight": {"1*"},
+			},
+			CaseInsensitive: true,
+		},
+		"payments": {
+			Revision: []string{"v5.*"},
+		},
+	}
+}
+
+func benchmarkFilterMembers(n int) []Member {
+	regions := []string{"us-east-1", "us-west-1", "eu-west-2", "ap-south-1"}
+	members := make([]Member, 0, n)
+	for i := 0; i != n; i++ {
+		region := regions[i%len(regions)]
+		members = append(members, Member{
+			ID:      fmt.Sprintf("member-%d", i),
+			Service: []string{"orders", "payments", "frontend"}[i%3],
+			Status:  []string{"active", "booting"}[i%2],
+			Locality: Locality{
+				Region:           region,
+				AvailabilityZone: region + "a",
+			},
+			Revision: "v5.1.0",
+			Metadata: map[string]string{
+				"protocol":           "v2.1",
+				"shard.1.weight":     "10",
+				fmt.Sprint("key", i): "value",
+			},
+		})
+	}
+	return members
+}
+
+func mustCompile(t *testing.T, filter *Filter) *CompiledFilter {
+	t.Helper()
+
+	compiled, err := filter.Compile()
+	require.NoError(t, err)
+	return compiled
+}
@@ -2,37 +2,176 @@ package fuddle
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"math/rand"
 	"net"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/fuddle-io/fuddle-go/internal/resolvers"
 	rpc "github.com/fuddle-io/fuddle-rpc/go"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/atomic"
 	"go.uber.org/zap"
+	"golang.org/x/net/proxy"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/grpclog"
 	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/status"
 )
 
+// defaultCloseTimeout is the maximum time Close waits for the local members
+// to be unregistered.
+const defaultCloseTimeout = time.Second * 5
+
+// clientIDMetadataKey is the gRPC metadata key containing the client ID.
+const clientIDMetadataKey = "fuddle-client-id"
+
 // Fuddle is a client for Fuddle registry. It streams updates to build a local
-// eventually consistent view of the cluster, and registers its own local
-// member.
+// eventually consistent view of the cluster, and registers its local
+// members.
 type Fuddle struct {
+	connectTimeout        time.Duration
 	connectAttemptTimeout time.Duration
 	keepAlivePingInterval time.Duration
 	keepAlivePingTimeout  time.Duration
-	heartbeatInterval     time.Duration
+	// keepAliveParams overrides the keepalive ping interval and timeout if
+	// set.
+	keepAliveParams      *keepalive.ClientParameters
+	heartbeatInterval    time.Duration
+	heartbeatMaxFailures int
+	reconnectBackoff     Backoff
+
+	tls       bool
+	tlsConfig *tls.Config
+
+	dialOptions []grpc.DialOption
+
+	// proxyDialer connects to Fuddle nodes through a proxy if set.
+	proxyDialer proxy.Dialer
+
+	maxRecvMsgSize int
+	maxSendMsgSize int
+
+	compression string
+
+	loadBalancingPolicy string
+
+	srvName   string
+	srvLookup resolvers.LookupSRVFunc
+
+	clock clock
+
+	fuddleServiceName string
+
+	backupSeeds      []string
+	backupSeedsAfter time.Duration
+
+	ownerOnly bool
+
+	stalenessThreshold time.Duration
+
+	metadataBatchWindow time.Duration
+
+	confirmRegister bool
+
+	autoTimestamp bool
+
+	readinessProbe bool
+
+	clientID string
 
 	onConnectionStateChange func(state ConnState)
+	onDisconnected          func(reason DisconnectReason, err error)
+	onHeartbeatError        func(err error)
+	onAllSeedsFailed        func()
+
+	// connState is the last known connection state.
+	connState *atomic.String
+	// peerAddr is the resolver address of the last successful connection
+	// attempt, as passed to the dialer.
+	peerAddr *atomic.String
+	// connectedAddr is the resolver address of the connected node, or empty
+	// if the client is disconnected.
+	connectedAddr *atomic.String
+	// lastReceived is the time in nanoseconds the client last received an
+	// update from the connected node, or subscribed to updates.
+	lastReceived *atomic.Int64
 
 	registry *registry
 
+	// localNodes contains the members registered by the client, keyed by
+	// member ID.
+	localNodes map[string]*LocalNode
+	// connected is true once the local nodes have been registered on the
+	// current connection.
+	connected bool
+	// disconnects is incremented each time the client disconnects.
+	disconnects int
+	// failedAddrs contains the addresses that failed to connect since the
+	// client disconnected, or nil while connected, used to detect when all
+	// addresses are unreachable.
+	failedAddrs map[string]struct{}
+	// allSeedsFailed is true once onAllSeedsFailed is called, until the
+	// client reconnects.
+	allSeedsFailed bool
+	// useBackupSeeds is true once the client has been unable to connect to
+	// the primary seeds for longer than backupSeedsAfter.
+	useBackupSeeds bool
+	// ready is closed once connected, and replaced with a new channel when
+	// disconnected.
+	ready chan struct{}
+
+	// seeds contains the seed addresses, and discovered contains the
+	// addresses of Fuddle nodes discovered in the registry.
+	seeds      []string
+	discovered []string
+	// resolverAddrs contains the addresses last passed to the resolver.
+	resolverAddrs []string
+	// excludedAddr is the resolver address of a node that closed the update
+	// stream, which is excluded from the resolver addresses so the client
+	// reconnects to another node.
+	excludedAddr string
+	// unreadyAddrs contains the addresses of nodes that failed the readiness
+	// probe while connecting, which are excluded from the resolver addresses
+	// until Connect returns.
+	unreadyAddrs []string
+	// streamErr is the error that last closed the update stream on the
+	// current connection, or nil if the stream wasn't closed.
+	streamErr error
+	// streamCloses is the number of times the update stream was closed on
+	// the current connection.
+	streamCloses int
+	// connects is incremented each time the client connects.
+	connects int
+	// connCtx is cancelled when the client disconnects, to stop the
+	// goroutines for the current connection such as streaming updates.
+	connCtx    context.Context
+	connCancel func()
+	// connWG waits for the goroutines for the current connection to exit.
+	connWG *sync.WaitGroup
+
+	// mu protects the above fields.
+	mu sync.Mutex
+
+	// staticResolver is the resolver for the seed addresses, or nil if
+	// using the SRV resolver.
+	staticResolver *resolvers.StaticResolverBuilder
+	// srvResolver is the SRV resolver, or nil if using the static resolver.
+	srvResolver *resolvers.SRVResolverBuilder
+
 	conn        *grpc.ClientConn
 	readClient  rpc.ClientReadRegistryClient
 	writeClient rpc.ClientWriteRegistryClient
@@ -42,104 +181,999 @@ type Fuddle struct {
 	wg     sync.WaitGroup
 	closed *atomic.Bool
 
+	// synced is closed once the client has received its first update from
+	// the connected node.
+	synced     chan struct{}
+	syncedOnce sync.Once
+
+	tracerProvider trace.TracerProvider
+	tracer         trace.Tracer
+
+	stats               *stats
+	metrics             Metrics
 	logger              *zap.Logger
 	grpcLoggerVerbosity int
 }
 
 // Connect connects to the registry and registers the given member.
 //
-// addrs is a list of seed addresses of known Fuddle nodes.
+// addrs is a list of seed addresses of known Fuddle nodes, which is ignored
+// if WithSRVResolver is used.
+//
+// If the member is the zero Member, the client connects as an observer, which
+// streams updates from the registry without registering a member, such as for
+// dashboards and controllers. An observer has no local members, so sends no
+// heartbeats and Members only includes the remote members. Members may still
+// be registered later using Fuddle.Register.
 func Connect(ctx context.Context, member Member, addrs []string, opts ...Option) (*Fuddle, error) {
 	options := defaultOptions()
 	for _, o := range opts {
 		o.apply(options)
 	}
+	if err := options.validate(); err != nil {
+		return nil, fmt.Errorf("fuddle: %w", err)
+	}
+
+	observer := member.Equal(Member{})
+	if !observer {
+		if err := member.Validate(); err != nil {
+			return nil, fmt.Errorf("fuddle: %w", err)
+		}
+		if options.autoTimestamp {
+			stampStarted(&member, options.clock)
+		}
+	}
+
+	var f *Fuddle
+	if observer {
+		f = newObserver(options)
+	} else {
+		f = newFuddle(member, options)
+	}
+	if err := f.connect(ctx, addrs); err != nil {
+		// Stop any background goroutines started while connecting.
+		f.cancel()
+		return nil, fmt.Errorf("fuddle: %w", err)
+	}
+
+	if f.confirmRegister && !observer {
+		if err := f.confirmLocalMember(ctx, member.ID); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("fuddle: %w", err)
+		}
+	}
+
+	return f, nil
+}
+
+// Ping checks the given member is valid and a Fuddle node is reachable,
+// without registering the member or streaming updates. This is useful to
+// check the configuration when a service starts, such as the seed addresses
+// and TLS options.
+//
+// Ping accepts the same arguments as Connect, then dials the registry and
+// sends a request to look up the member, and closes the connection.
+func Ping(ctx context.Context, member Member, addrs []string, opts ...Option) error {
+	options := defaultOptions()
+	for _, o := range opts {
+		o.apply(options)
+	}
+	if err := options.validate(); err != nil {
+		return fmt.Errorf("fuddle: ping: %w", err)
+	}
+	if err := member.Validate(); err != nil {
+		return fmt.Errorf("fuddle: ping: %w", err)
+	}
+
+	f := newFuddle(member, options)
+	defer f.cancel()
+
+	if err := f.dial(ctx, addrs); err != nil {
+		return fmt.Errorf("fuddle: ping: %w", err)
+	}
+	defer f.conn.Close()
+
+	if err := f.probe(ctx, member.ID); err != nil {
+		return fmt.Errorf("fuddle: ping: %w", err)
+	}
+	return nil
+}
+
+// NewOffline returns a client that isn't connected to Fuddle, whose registry
+// contains the members in the given snapshot (see Fuddle.Snapshot). This is
+// useful for testing, such as to replay a snapshot captured in production.
+//
+// Note the client has no local members and never connects, though members
+// may still be registered locally.
+func NewOffline(snapshot []Member, opts ...Option) *Fuddle {
+	options := defaultOptions()
+	for _, o := range opts {
+		o.apply(options)
+	}
+
+	f := newFuddleWithRegistry(
+		newSnapshotRegistry(snapshot, options.metrics, options.logger),
+		options,
+	)
+	// The registry is already populated from the snapshot.
+	f.setSynced()
+	return f
+}
+
+// stampStarted sets the members started timestamp to the current time if the
+// timestamp is zero.
+func stampStarted(member *Member, clock clock) {
+	if member.Started == 0 {
+		member.Started = clock.Now().UnixMilli()
+	}
+}
+
+func newFuddle(member Member, options *options) *Fuddle {
+	f := newFuddleWithRegistry(
+		newRegistry(member, options.metrics, options.logger),
+		options,
+	)
+	f.localNodes[member.ID] = newLocalNode(member.ID, f)
+	return f
+}
+
+// newObserver returns a client without a local member.
+func newObserver(options *options) *Fuddle {
+	return newFuddleWithRegistry(
+		newSnapshotRegistry(nil, options.metrics, options.logger),
+		options,
+	)
+}
+
+func newFuddleWithRegistry(registry *registry, options *options) *Fuddle {
+	registry.onIDConflict = options.onIDConflict
+	registry.coalesce = options.notifyCoalesce
+	registry.slowThreshold = options.slowSubscriberThreshold
+	registry.async = options.asyncNotify
+	registry.clock = options.clock
 
 	cancelCtx, cancel := context.WithCancel(context.Background())
-	f := &Fuddle{
+	return &Fuddle{
+		connectTimeout:        options.connectTimeout,
 		connectAttemptTimeout: options.connectAttemptTimeout,
 		keepAlivePingInterval: options.keepAlivePingInterval,
 		keepAlivePingTimeout:  options.keepAlivePingTimeout,
+		keepAliveParams:       options.keepAliveParams,
 		heartbeatInterval:     options.heartbeatInterval,
+		heartbeatMaxFailures:  options.heartbeatMaxFailures,
+		reconnectBackoff:      options.reconnectBackoff,
+
+		tls:       options.tls,
+		tlsConfig: options.tlsConfig,
+
+		dialOptions: options.dialOptions,
+
+		proxyDialer: options.proxyDialer,
+
+		maxRecvMsgSize: options.maxRecvMsgSize,
+		maxSendMsgSize: options.maxSendMsgSize,
+
+		compression: options.compression,
+
+		loadBalancingPolicy: options.loadBalancingPolicy,
+
+		srvName:   options.srvName,
+		srvLookup: options.srvLookup,
+
+		clock: options.clock,
+
+		fuddleServiceName: options.fuddleServiceName,
+
+		backupSeeds:      options.backupSeeds,
+		backupSeedsAfter: options.backupSeedsAfter,
+
+		ownerOnly: options.ownerOnly,
+
+		stalenessThreshold: options.stalenessThreshold,
+
+		metadataBatchWindow: options.metadataBatchWindow,
+
+		confirmRegister: options.confirmRegister,
+
+		autoTimestamp: options.autoTimestamp,
+
+		readinessProbe: options.readinessProbe,
+
+		clientID: options.clientID,
 
 		onConnectionStateChange: options.onConnectionStateChange,
+		onDisconnected:          options.onDisconnected,
+		onHeartbeatError:        options.onHeartbeatError,
+		onAllSeedsFailed:        options.onAllSeedsFailed,
+
+		connState: atomic.NewString(string(StateDisconnected)),
+		peerAddr:  atomic.NewString(""),
 
-		registry: newRegistry(member, options.logger),
+		connectedAddr: atomic.NewString(""),
+
+		lastReceived: atomic.NewInt64(0),
+
+		registry:   registry,
+		localNodes: make(map[string]*LocalNode),
+		ready:      make(chan struct{}),
 
 		ctx:    cancelCtx,
 		cancel: cancel,
 		closed: atomic.NewBool(false),
 
+		synced: make(chan struct{}),
+
+		stats: newStats(),
+
+		tracerProvider: options.tracerProvider,
+		tracer:         options.tracerProvider.Tracer(tracerName),
+
+		metrics:             options.metrics,
 		logger:              options.logger,
 		grpcLoggerVerbosity: options.grpcLoggerVerbosity,
 	}
-	if err := f.connect(ctx, addrs); err != nil {
-		return nil, fmt.Errorf("fuddle: %w", err)
+}
+
+// Register registers an additional local member, which shares the clients
+// connection. The member is unregistered when either LocalNode.Unregister is
+// called or the client is closed.
+//
+// If the client is disconnected, the member is added to the local registry
+// and registered once the client reconnects.
+func (f *Fuddle) Register(ctx context.Context, member Member) (_ *LocalNode, err error) {
+	ctx, span := f.startSpan(ctx, "fuddle.Register", member.ID)
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("fuddle: register: %w", err)
+	}
+	if f.closed.Load() {
+		return nil, fmt.Errorf("fuddle: register: %w: client closed", ErrNotConnected)
+	}
+	if err := member.Validate(); err != nil {
+		return nil, fmt.Errorf("fuddle: register: %w", err)
+	}
+	if f.autoTimestamp {
+		stampStarted(&member, f.clock)
 	}
 
-	return f, nil
+	// Add the member to the registry before locking, since adding the member
+	// notifies subscribers which may call back into the client.
+	if err := f.registry.AddLocalMember(member); err != nil {
+		return nil, fmt.Errorf("fuddle: register: %w", err)
+	}
+
+	node := newLocalNode(member.ID, f)
+
+	f.mu.Lock()
+	if f.connected {
+		if err := node.register(ctx); err != nil {
+			f.mu.Unlock()
+
+			f.registry.RemoveLocalMember(member.ID)
+
+			f.logger.Warn(
+				"failed to register",
+				zap.String("id", member.ID),
+				zap.Error(err),
+			)
+			return nil, fmt.Errorf("fuddle: register: %w", err)
+		}
+	}
+	f.localNodes[member.ID] = node
+	connected := f.connected
+	f.mu.Unlock()
+
+	if f.confirmRegister && connected {
+		if err := node.confirmRegistered(ctx); err != nil {
+			//nolint
+			node.Unregister()
+			return nil, fmt.Errorf("fuddle: register: %w", err)
+		}
+	}
+
+	return node, nil
+}
+
+// Unregister unregisters the local member with the given ID, the same as
+// LocalNode.Unregister, such as when the LocalNode returned by Register is
+// no longer available. The member passed to Connect may also be
+// unregistered.
+//
+// Returns an error wrapping ErrUpdateRejected if there is no local member
+// with the given ID, such as the ID of a remote member, or an error wrapping
+// ErrUnregisterFailed if the unregister couldn't be sent to the connected
+// node, though the member is still removed from the registry.
+func (f *Fuddle) Unregister(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("fuddle: unregister: %w", err)
+	}
+
+	f.mu.Lock()
+	node, ok := f.localNodes[id]
+	f.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("fuddle: unregister: %w: not a local member: %s", ErrUpdateRejected, id)
+	}
+	return node.Unregister()
+}
+
+// Members returns the known members in the registry sorted by ID. By default
+// this includes all members, though the members may be filtered using
+// WithFilter. An invalid filter (see Filter.Validate) is logged as a warning,
+// since it may silently match fewer members than expected.
+func (f *Fuddle) Members(opts ...MembersOption) []Member {
+	return f.registry.Members(opts...)
 }
 
-// Members returns all known members in the registry.
-func (f *Fuddle) Members() []Member {
+// MembersContext returns the known members in the registry, the same as
+// Members, though records a span and returns an error if the context is
+// cancelled.
+func (f *Fuddle) MembersContext(ctx context.Context, opts ...MembersOption) (_ []Member, err error) {
+	_, span := f.tracer.Start(ctx, "fuddle.Members")
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("fuddle: members: %w", err)
+	}
+	members := f.registry.Members(opts...)
+	span.SetAttributes(attribute.Int("fuddle.members.count", len(members)))
+	return members, nil
+}
+
+// Count returns the number of known members in the registry, which may be
+// filtered using WithFilter the same as Members. This is cheaper than
+// counting the members returned by Members, since the members aren't copied.
+func (f *Fuddle) Count(opts ...MembersOption) int {
+	return f.registry.Count(opts...)
+}
+
+// Query returns the members matching the filter sorted by ID, along with the
+// total number of members in the services the filter selects, ignoring the
+// other clauses of each service filter. Such as a filter for 'orders' members
+// in region 'us-east-1' returns the matching members along with the total
+// number of 'orders' members in any region. A nil filter matches all members,
+// so total is the number of members in the registry.
+//
+// Unlike calling Members and Count separately, the matched members and total
+// are taken from the same snapshot of the registry, so are consistent even
+// when members are updated concurrently.
+func (f *Fuddle) Query(filter *Filter) (matched []Member, total int) {
+	return f.registry.Query(filter)
+}
+
+// Snapshot returns a copy of all members in the registry sorted by ID, which
+// is the same as Members with no filter. The snapshot may be passed to
+// NewOffline to replay the registry without connecting.
+func (f *Fuddle) Snapshot() []Member {
 	return f.registry.Members()
 }
 
+// Member returns the member with the given ID, including the local members.
+// Returns false if the member isn't in the registry.
+func (f *Fuddle) Member(id string) (Member, bool) {
+	return f.registry.Member(id)
+}
+
+// MembersByService returns the known members in the given service sorted by
+// ID. This is faster than using WithFilter to filter by service, since the
+// registry is indexed by service.
+func (f *Fuddle) MembersByService(service string) []Member {
+	return f.registry.MembersByService(service)
+}
+
+// Services returns the sorted names of the distinct services of the known
+// members, including the local members. This is cheaper than GroupByService
+// when only the service names are needed, since the members aren't copied.
+func (f *Fuddle) Services() []string {
+	return f.registry.Services()
+}
+
+// Localities returns the distinct localities of the known members, including
+// the local members, sorted by region then availability zone. Members without
+// a locality are included as the zero Locality, which sorts first.
+func (f *Fuddle) Localities() []Locality {
+	return f.registry.Localities()
+}
+
+// GroupByService returns a copy of the known members grouped by service,
+// including the local members. The members in each service are sorted by ID.
+func (f *Fuddle) GroupByService() map[string][]Member {
+	return f.registry.GroupByService()
+}
+
+// GroupByLocality returns a copy of the known members grouped by locality,
+// including the local members. The members in each locality are sorted by
+// ID.
+func (f *Fuddle) GroupByLocality() map[Locality][]Member {
+	return f.registry.GroupByLocality()
+}
+
 // Subscribe subscribes to updates when the registry changes. This also fires
 // the callback immediately after subscribing to bootstrap (which avoids having
-// to first call Fuddoe.Members).
-func (f *Fuddle) Subscribe(cb func()) func() {
-	return f.registry.Subscribe(cb)
+// to first call Fuddoe.Members), unless WithoutBootstrap is used.
+//
+// Unless WithAsyncNotify is used, callbacks are called one at a time. When an
+// update notifies multiple subscribers, including those added with
+// SubscribeMember and the other Subscribe variants, they are called in the
+// order they subscribed, and all are called before any subscriber is notified
+// of a later update. Though if notifications are coalesced (see
+// WithNotifyCoalesce), a deferred notification is delivered at the end of the
+// coalesce window instead.
+func (f *Fuddle) Subscribe(cb func(), opts ...SubscribeOption) func() {
+	return f.registry.Subscribe(cb, opts...)
+}
+
+// SubscribeContext subscribes to updates when the registry changes, the same
+// as Subscribe, though also unsubscribes when the given context is cancelled.
+// The returned function may also be used to unsubscribe early, and is safe to
+// call multiple times.
+//
+// If the context is already cancelled the callback is never called. A span is
+// recorded for subscribing, which includes the bootstrap callback.
+func (f *Fuddle) SubscribeContext(ctx context.Context, cb func(), opts ...SubscribeOption) func() {
+	_, span := f.tracer.Start(ctx, "fuddle.Subscribe")
+	if err := ctx.Err(); err != nil {
+		endSpan(span, err)
+		return func() {}
+	}
+	unsubscribe := f.registry.Subscribe(cb, opts...)
+	span.End()
+
+	done := make(chan struct{})
+	var once sync.Once
+	unsub := func() {
+		// Unsubscribe synchronously so the callback won't fire once unsub
+		// returns.
+		once.Do(func() {
+			unsubscribe()
+			close(done)
+		})
+	}
+
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+
+		// Also wait for the client to close to avoid leaking the goroutine
+		// if the context is never cancelled.
+		select {
+		case <-ctx.Done():
+		case <-f.ctx.Done():
+		case <-done:
+		}
+		unsub()
+	}()
+
+	return unsub
+}
+
+// SubscribeFilter subscribes to updates when the set of members matching the
+// filter changes, so changes to members that don't match the filter are
+// ignored. Like Subscribe, this also fires the callback immediately after
+// subscribing to bootstrap, unless WithoutBootstrap is used.
+//
+// The filter is compiled once when subscribing (see Filter.Compile), so later
+// changes to the filter are ignored. Returns an error if the filter is invalid
+// (see Filter.Validate).
+func (f *Fuddle) SubscribeFilter(filter MemberFilter, cb func(), opts ...SubscribeOption) (func(), error) {
+	compiled, err := compileMemberFilter(filter)
+	if err != nil {
+		return nil, fmt.Errorf("fuddle: subscribe: %w", err)
+	}
+	return f.registry.SubscribeFilter(compiled, cb, opts...), nil
+}
+
+// SubscribeMembers subscribes to updates when the registry changes, where the
+// callback is passed a snapshot of the registry members at the time of the
+// notification. This avoids having to call Fuddle.Members in the callback,
+// which may include later updates. Like Subscribe, this also fires the
+// callback immediately after subscribing to bootstrap, unless
+// WithoutBootstrap is used.
+func (f *Fuddle) SubscribeMembers(cb func(members []Member), opts ...SubscribeOption) func() {
+	return f.registry.SubscribeMembers(cb, opts...)
+}
+
+// SubscribeDelta subscribes to updates when the registry changes, where the
+// callback is passed the members that joined, left or were updated since the
+// last notification. This also fires the callback immediately after
+// subscribing to bootstrap, with all existing members as joined, unless
+// WithoutBootstrap is used.
+func (f *Fuddle) SubscribeDelta(cb func(delta Delta), opts ...SubscribeOption) func() {
+	return f.registry.SubscribeDelta(cb, opts...)
+}
+
+// SubscribeMember subscribes to changes to the member with the given ID, such
+// as to watch a leader. The callback is passed the member and whether it is
+// in the registry, where present is false once the member leaves. Unlike
+// Subscribe, the callback is only fired when that member joins, leaves or is
+// updated, so changes to other members are ignored.
+//
+// Like Subscribe, this also fires the callback immediately after subscribing
+// to bootstrap, with present false if the member isn't in the registry,
+// unless WithoutBootstrap is used.
+func (f *Fuddle) SubscribeMember(id string, cb func(member Member, present bool), opts ...SubscribeOption) func() {
+	return f.registry.SubscribeMember(id, cb, opts...)
+}
+
+// WaitForMembers blocks until the members matching the filter satisfy the
+// predicate, such as waiting for a service to have at least 3 members, and
+// returns the matching members sorted by ID. A nil filter matches all members.
+//
+// The predicate is evaluated with the members in the registry when called,
+// then each time the registry changes. Returns an error if the filter is
+// invalid, or the context is cancelled or client closed before the predicate
+// is satisfied.
+func (f *Fuddle) WaitForMembers(ctx context.Context, filter MemberFilter, predicate func(members []Member) bool) ([]Member, error) {
+	filter, err := compileMemberFilter(filter)
+	if err != nil {
+		return nil, fmt.Errorf("fuddle: wait for members: %w", err)
+	}
+
+	satisfied := make(chan []Member, 1)
+	// done is only accessed by the subscriber callback, which is never
+	// called concurrently.
+	done := false
+	unsubscribe := f.registry.SubscribeMembers(func(members []Member) {
+		if done {
+			return
+		}
+
+		var matched []Member
+		for _, m := range members {
+			if isMatchAll(filter) || filter.Match(m) {
+				matched = append(matched, m)
+			}
+		}
+		if predicate(matched) {
+			done = true
+			satisfied <- matched
+		}
+	})
+	defer unsubscribe()
+
+	select {
+	case members := <-satisfied:
+		return members, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("fuddle: wait for members: %w", ctx.Err())
+	case <-f.ctx.Done():
+		return nil, fmt.Errorf("fuddle: wait for members: %w: client closed", ErrNotConnected)
+	}
+}
+
+// UpdateSeeds replaces the seed addresses of known Fuddle nodes without
+// reconnecting. Any Fuddle nodes discovered in the registry are kept. If the
+// client is disconnected, it will try the new addresses when reconnecting.
+//
+// Returns an error if addrs is empty or the client is using WithSRVResolver.
+func (f *Fuddle) UpdateSeeds(addrs []string) error {
+	if len(addrs) == 0 {
+		return fmt.Errorf("fuddle: update seeds: %w", ErrNoSeeds)
+	}
+	if f.staticResolver == nil {
+		return fmt.Errorf("fuddle: update seeds: using srv resolver")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.seeds = append([]string{}, addrs...)
+	f.updateResolverAddrsLocked()
+	return nil
+}
+
+// WaitForReady blocks until the client is connected and has registered its
+// local members, such as to wait for the client to reconnect. Returns
+// immediately if the client is already connected.
+//
+// Returns an error if the context is cancelled or the client is closed.
+func (f *Fuddle) WaitForReady(ctx context.Context) error {
+	f.mu.Lock()
+	ready := f.ready
+	f.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("fuddle: wait for ready: %w", ctx.Err())
+	case <-f.ctx.Done():
+		return fmt.Errorf("fuddle: wait for ready: %w: client closed", ErrNotConnected)
+	}
+}
+
+// SyncedChan returns a channel that is closed once the client has synced the
+// registry from the connected node, so Members no longer returns a partial
+// view of the cluster.
+//
+// Since the update stream has no marker for the end of the initial registry
+// snapshot, the client is considered synced once it receives its first
+// update. The connected node streams the members it knows about before any
+// later updates, though members streamed after the first update may still be
+// missing when the channel is closed. The channel isn't reset if the client
+// reconnects.
+//
+// An offline client is synced once created.
+func (f *Fuddle) SyncedChan() <-chan struct{} {
+	return f.synced
+}
+
+// WaitForInitialSync blocks until the client has synced the registry from the
+// connected node (see SyncedChan), such as to wait until Members includes the
+// other members in the cluster before proceeding. Returns immediately if the
+// client is already synced.
+//
+// Returns an error if the context is cancelled or the client is closed.
+func (f *Fuddle) WaitForInitialSync(ctx context.Context) error {
+	select {
+	case <-f.synced:
+		return nil
+	default:
+	}
+
+	select {
+	case <-f.synced:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("fuddle: wait for initial sync: %w", ctx.Err())
+	case <-f.ctx.Done():
+		return fmt.Errorf("fuddle: wait for initial sync: %w: client closed", ErrNotConnected)
+	}
+}
+
+// Stats returns a snapshot of the client counters.
+func (f *Fuddle) Stats() Stats {
+	return Stats{
+		ConnectAttempts: f.stats.connectAttempts.Load(),
+		Reconnects:      f.stats.reconnects.Load(),
+		UpdatesReceived: f.stats.updatesReceived.Load(),
+		HeartbeatsSent:  f.stats.heartbeatsSent.Load(),
+		HeartbeatErrors: f.stats.heartbeatErrors.Load(),
+		CurrentMembers:  int64(f.registry.Count()),
+	}
+}
+
+// Healthy returns whether the client is connected and, if configured with
+// WithStalenessThreshold, has received an update from the connected node
+// within the threshold. This is useful for liveness probes, since the update
+// stream may stall even though the connection is ready.
+func (f *Fuddle) Healthy() bool {
+	if f.ConnState() != StateConnected {
+		return false
+	}
+	if f.stalenessThreshold == 0 {
+		return true
+	}
+
+	lastReceived := time.Unix(0, f.lastReceived.Load())
+	return f.clock.Now().Sub(lastReceived) <= f.stalenessThreshold
+}
+
+// ClientID returns the ID of the client instance (see WithClientID).
+func (f *Fuddle) ClientID() string {
+	return f.clientID
+}
+
+// ConnectedAddr returns the address of the Fuddle node the client is
+// connected to, or an empty string if the client is disconnected.
+//
+// The address is the seed or discovered address the client dialed, as passed
+// to the resolver, rather than the resolved IP address. For example, if the
+// seed is a hostname the hostname is returned, and when using WithProxyDialer
+// the node address is returned rather than the proxy address.
+func (f *Fuddle) ConnectedAddr() string {
+	return f.connectedAddr.Load()
 }
 
+// ConnState returns the last known connection state.
+//
+// The client starts in StateDisconnected, and is StateConnected once Connect
+// returns successfully.
+func (f *Fuddle) ConnState() ConnState {
+	return ConnState(f.connState.Load())
+}
+
+// Close unregisters the local members and closes the client, waiting up to
+// defaultCloseTimeout for the members to be unregistered. Close is safe to
+// call multiple times.
 func (f *Fuddle) Close() {
-	f.closed.Store(true)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultCloseTimeout)
+	defer cancel()
+
+	//nolint
+	f.CloseWithContext(ctx)
+}
+
+// CloseWithContext unregisters the local members and closes the client.
+//
+// If the members aren't unregistered before the context is cancelled, such as
+// if the connection is unresponsive, the connection is closed without waiting
+// and an error is returned. The members will then expire once the connected
+// node stops receiving heartbeats.
+//
+// Closing a client that is already closed is a no-op.
+func (f *Fuddle) CloseWithContext(ctx context.Context) error {
+	if f.closed.Swap(true) {
+		return nil
+	}
 	f.cancel()
+
 	// Note must wait for all goroutines to stop before closing the connection
 	// since we unregister before exiting.
-	f.wg.Wait()
-	f.conn.Close()
+	done := make(chan struct{})
+	go func() {
+		f.wg.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		f.logger.Warn("close timed out; closing without unregistering")
+		err = fmt.Errorf("fuddle: close: %w", ctx.Err())
+	}
+
+	// The connection is nil if the client is offline.
+	if f.conn != nil {
+		f.conn.Close()
+	}
+	f.connectedAddr.Store("")
+	f.registry.Close()
+	return err
 }
 
+// connect dials the registry, then starts monitoring the connection to
+// stream updates and register the local members.
 func (f *Fuddle) connect(ctx context.Context, addrs []string) error {
+	if err := f.dial(ctx, addrs); err != nil {
+		return err
+	}
+
+	if f.readinessProbe {
+		if err := f.probeReady(ctx); err != nil {
+			f.conn.Close()
+			return err
+		}
+	}
+
+	// Since the dial blocks until the connection is ready, we're connected
+	// once it returns, so set the state before returning rather than waiting
+	// for monitorConnection.
+	f.connectedAddr.Store(f.peerAddr.Load())
+	f.connState.Store(string(StateConnected))
+	f.metrics.ConnState(StateConnected)
+
+	if f.staticResolver != nil && f.fuddleServiceName != "" {
+		f.discoverFuddleNodes()
+	}
+
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		f.monitorConnection()
+	}()
+
+	return nil
+}
+
+// probe sends a request to the connected node to check it is serving the
+// registry, by looking up the member with the given ID. The member isn't
+// expected to exist, so not found still confirms the node is serving.
+func (f *Fuddle) probe(ctx context.Context, id string) error {
+	if _, err := f.readClient.Member(ctx, &rpc.MemberRequest{
+		Id: id,
+	}); err != nil && status.Code(err) != codes.NotFound {
+		return err
+	}
+	return nil
+}
+
+// probeReady probes the connected node, and if the probe fails, excludes the
+// nodes resolver address (see peerAddr) from the resolver so the client connects to another node,
+// until a node passes the probe. Returns an error if the probe fails and
+// there are no other addresses to try.
+func (f *Fuddle) probeReady(ctx context.Context) error {
+	defer func() {
+		// Once connected, the unready nodes may be used again when
+		// reconnecting, since they may since be ready.
+		f.mu.Lock()
+		if len(f.unreadyAddrs) > 0 {
+			f.unreadyAddrs = nil
+			f.updateResolverAddrsLocked()
+		}
+		f.mu.Unlock()
+	}()
+
+	for {
+		addr := f.peerAddr.Load()
+
+		probeCtx, cancel := context.WithTimeout(ctx, f.connectAttemptTimeout)
+		err := f.probe(probeCtx, f.clientID)
+		cancel()
+		if err == nil {
+			return nil
+		}
+
+		f.logger.Warn(
+			"readiness probe failed",
+			zap.String("addr", addr),
+			zap.Error(err),
+		)
+
+		f.mu.Lock()
+		if f.staticResolver == nil || !f.canExcludeAddrLocked(addr) {
+			f.mu.Unlock()
+			return fmt.Errorf("connect: readiness probe: %s: %w: %w", addr, ErrNotConnected, err)
+		}
+		f.unreadyAddrs = append(f.unreadyAddrs, addr)
+		f.updateResolverAddrsLocked()
+		f.mu.Unlock()
+
+		if err := f.waitForReconnect(ctx, addr); err != nil {
+			return fmt.Errorf("connect: readiness probe: %w", err)
+		}
+	}
+}
+
+// waitForReconnect blocks until the connection is ready to a node other than
+// the node with the given address.
+func (f *Fuddle) waitForReconnect(ctx context.Context, addr string) error {
+	for {
+		state := f.conn.GetState()
+		if state == connectivity.Ready && f.peerAddr.Load() != addr {
+			return nil
+		}
+		if state == connectivity.Idle {
+			f.conn.Connect()
+		}
+		if !f.conn.WaitForStateChange(ctx, state) {
+			return ctx.Err()
+		}
+	}
+}
+
+// clientIDUnaryInterceptor adds the client ID to the outgoing metadata.
+func (f *Fuddle) clientIDUnaryInterceptor(
+	ctx context.Context,
+	method string,
+	req, reply interface{},
+	cc *grpc.ClientConn,
+	invoker grpc.UnaryInvoker,
+	opts ...grpc.CallOption,
+) error {
+	ctx = metadata.AppendToOutgoingContext(ctx, clientIDMetadataKey, f.clientID)
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+// clientIDStreamInterceptor adds the client ID to the outgoing metadata.
+func (f *Fuddle) clientIDStreamInterceptor(
+	ctx context.Context,
+	desc *grpc.StreamDesc,
+	cc *grpc.ClientConn,
+	method string,
+	streamer grpc.Streamer,
+	opts ...grpc.CallOption,
+) (grpc.ClientStream, error) {
+	ctx = metadata.AppendToOutgoingContext(ctx, clientIDMetadataKey, f.clientID)
+	return streamer(ctx, desc, cc, method, opts...)
+}
+
+// dial opens a connection to the registry, blocking until the connection is
+// ready.
+func (f *Fuddle) dial(ctx context.Context, addrs []string) error {
 	if f.grpcLoggerVerbosity > 0 {
 		grpclog.SetLoggerV2(grpclog.NewLoggerV2WithVerbosity(
 			os.Stderr, os.Stderr, os.Stderr, f.grpcLoggerVerbosity,
 		))
 	}
 
-	if len(addrs) == 0 {
-		f.logger.Error("failed to connect: no seed addresses")
-		return fmt.Errorf("connect: no seeds addresses")
-	}
+	var resolverBuilder resolver.Builder
+	if f.srvName != "" {
+		f.logger.Info("connecting", zap.String("srv", f.srvName))
 
-	// Since we use a 'first pick' load balancer, shuffle the addrs so multiple
-	// clients with the same addrs don't all try the same node.
-	shuffleStrings(addrs)
+		f.srvResolver = resolvers.NewSRVResolverBuilder(f.srvName, f.srvLookup)
+		resolverBuilder = f.srvResolver
+	} else {
+		if len(addrs) == 0 {
+			f.logger.Error("failed to connect: no seed addresses")
+			return fmt.Errorf("connect: %w", ErrNoSeeds)
+		}
 
-	f.logger.Info("connecting", zap.Strings("addrs", addrs))
+		f.mu.Lock()
+		// Copy the seeds since addrs is shuffled.
+		f.seeds = append([]string{}, addrs...)
+		f.resolverAddrs = uniqueSorted(addrs)
+		f.mu.Unlock()
 
-	// Send keep alive pings to detect unresponsive connections and trigger
-	// a reconnect.
-	keepAliveParams := keepalive.ClientParameters{
-		Time:                f.keepAlivePingInterval,
-		Timeout:             f.keepAlivePingTimeout,
-		PermitWithoutStream: true,
+		// Since we use a 'first pick' load balancer, shuffle the addrs so
+		// multiple clients with the same addrs don't all try the same node.
+		shuffleStrings(addrs)
+
+		f.logger.Info("connecting", zap.Strings("addrs", addrs))
+
+		f.staticResolver = resolvers.NewStaticResolverBuilder(addrs)
+		resolverBuilder = f.staticResolver
+
+		if len(f.backupSeeds) > 0 {
+			f.watchBackupSeeds(0)
+		}
 	}
-	conn, err := grpc.DialContext(
-		ctx,
-		// Use the static resolver which uses the configured seed addresses.
-		"static:///fuddle",
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-		grpc.WithResolvers(resolvers.NewStaticResolverBuilder(addrs)),
+
+	if f.connectTimeout > 0 {
+		var cancel func()
+		ctx, cancel = context.WithTimeout(ctx, f.connectTimeout)
+		defer cancel()
+	}
+
+	// Add the user dial options first so the options the client depends on
+	// take precedence.
+	dialOpts := append([]grpc.DialOption{}, f.dialOptions...)
+	dialOpts = append(
+		dialOpts,
+		grpc.WithTransportCredentials(f.transportCredentials()),
+		grpc.WithResolvers(resolverBuilder),
 		// Add a custom dialer so we can set a per connection attempt timeout.
 		grpc.WithContextDialer(f.dialerWithTimeout),
 		// Block until the connection succeeds so we can fail the initial
 		// connection.
 		grpc.WithBlock(),
-		grpc.WithKeepaliveParams(keepAliveParams),
+		// Include the last connection error if the dial fails, such as a
+		// TLS handshake failure, rather than only the context error.
+		grpc.WithReturnConnectionError(),
+		// Send keep alive pings to detect unresponsive connections and
+		// trigger a reconnect.
+		grpc.WithKeepaliveParams(f.keepAliveClientParams()),
+		// Propagate the trace context to the connected node. Use chained
+		// interceptors so user interceptors aren't overridden.
+		grpc.WithChainUnaryInterceptor(otelgrpc.UnaryClientInterceptor(
+			otelgrpc.WithTracerProvider(f.tracerProvider),
+		)),
+		grpc.WithChainStreamInterceptor(otelgrpc.StreamClientInterceptor(
+			otelgrpc.WithTracerProvider(f.tracerProvider),
+		)),
+		// Identify the client in every request.
+		grpc.WithChainUnaryInterceptor(f.clientIDUnaryInterceptor),
+		grpc.WithChainStreamInterceptor(f.clientIDStreamInterceptor),
+		// Backoff between connection attempts to avoid reconnecting in a
+		// tight loop when the cluster is unreachable.
+		grpc.WithConnectParams(grpc.ConnectParams{
+			Backoff:           f.reconnectBackoff.grpcConfig(),
+			MinConnectTimeout: f.connectAttemptTimeout,
+		}),
+	)
+	if f.loadBalancingPolicy != "" {
+		dialOpts = append(
+			dialOpts, grpc.WithDefaultServiceConfig(f.serviceConfig()),
+		)
+	}
+	if callOpts := f.callOptions(); len(callOpts) > 0 {
+		dialOpts = append(dialOpts, grpc.WithDefaultCallOptions(callOpts...))
+	}
+	conn, err := grpc.DialContext(
+		ctx,
+		// Use either the SRV resolver or the static resolver which uses the
+		// configured seed addresses.
+		resolverBuilder.Scheme()+":///fuddle",
+		dialOpts...,
 	)
 	if err != nil {
+		// Since we use WithReturnConnectionError, gRPC includes the context
+		// error in the message but doesn't wrap it, so wrap the context error
+		// to support errors.Is.
+		if ctxErr := ctx.Err(); ctxErr != nil && !errors.Is(err, ctxErr) {
+			err = fmt.Errorf(
+				"%w: %s",
+				ctxErr, strings.TrimPrefix(err.Error(), ctxErr.Error()+": "),
+			)
+		}
+
 		f.logger.Error(
 			"failed to connect",
 			zap.Strings("seeds", addrs),
@@ -152,21 +1186,41 @@ func (f *Fuddle) connect(ctx context.Context, addrs []string) error {
 	f.readClient = rpc.NewClientReadRegistryClient(conn)
 	f.writeClient = rpc.NewClientWriteRegistryClient(conn)
 
-	f.wg.Add(1)
-	go func() {
-		defer f.wg.Done()
-		f.monitorConnection()
-	}()
-
 	return nil
 }
 
+func (f *Fuddle) transportCredentials() credentials.TransportCredentials {
+	if !f.tls {
+		return insecure.NewCredentials()
+	}
+
+	config := f.tlsConfig
+	if config == nil {
+		config = &tls.Config{}
+	}
+	return credentials.NewTLS(config)
+}
+
 // monitorConnection detects disconnects and reconnects.
 func (f *Fuddle) monitorConnection() {
+	reconnect := false
+	// lastAttempts is the number of connection attempts when the client
+	// last connected.
+	lastAttempts := int64(0)
 	for {
 		s := f.conn.GetState()
 		if s == connectivity.Ready {
-			f.onConnected()
+			// The first time we're ready is the initial connection rather
+			// than a reconnect.
+			if reconnect {
+				f.stats.reconnects.Inc()
+				f.metrics.Reconnect()
+			}
+			reconnect = true
+
+			attempts := f.stats.connectAttempts.Load()
+			f.onConnected(attempts - lastAttempts)
+			lastAttempts = attempts
 		} else {
 			f.conn.Connect()
 		}
@@ -184,119 +1238,436 @@ func (f *Fuddle) monitorConnection() {
 	}
 }
 
-func (f *Fuddle) onConnected() {
-	f.logger.Info("connected")
+// onConnected is called when the client connects, where attempts is the
+// number of connection attempts since the client was last connected.
+func (f *Fuddle) onConnected(attempts int64) {
+	f.logger.Info(
+		"connected",
+		zap.String("addr", f.peerAddr.Load()),
+		zap.Int64("reconnect", f.stats.reconnects.Load()),
+		zap.Int64("attempts", attempts),
+	)
+
+	f.mu.Lock()
+	f.connects++
+	f.streamErr = nil
+	f.streamCloses = 0
+	f.failedAddrs = nil
+	f.allSeedsFailed = false
+	prevWG := f.resetConnContextLocked()
+	// Once connected to another node, the node that closed the update
+	// stream can be used again.
+	if f.excludedAddr != "" {
+		f.excludedAddr = ""
+		f.updateResolverAddrsLocked()
+	}
+	f.mu.Unlock()
+
+	f.connectedAddr.Store(f.peerAddr.Load())
+	f.connState.Store(string(StateConnected))
+	f.metrics.ConnState(StateConnected)
 
 	if f.onConnectionStateChange != nil {
 		f.onConnectionStateChange(StateConnected)
 	}
 
-	f.setupStreamUpdates()
-	f.setupStreamRegister()
+	// Wait for the goroutines from the previous connection to exit before
+	// subscribing again, so rapid reconnects don't stack streams.
+	if prevWG != nil {
+		prevWG.Wait()
+	}
+
+	f.mu.Lock()
+	ctx, wg := f.connCtx, f.connWG
+	f.mu.Unlock()
+
+	f.setupStreamUpdates(ctx, wg)
+	f.registerLocalNodes()
+}
+
+// resetConnContextLocked cancels the context for the previous connection and
+// creates a new context for the current connection. Returns the wait group for
+// the previous connections goroutines, or nil if there was no previous
+// connection.
+//
+// Assumes the mutex is locked.
+func (f *Fuddle) resetConnContextLocked() *sync.WaitGroup {
+	prevWG := f.connWG
+	if f.connCancel != nil {
+		f.connCancel()
+	}
+	f.connCtx, f.connCancel = context.WithCancel(f.ctx)
+	f.connWG = &sync.WaitGroup{}
+	return prevWG
 }
 
 func (f *Fuddle) onDisconnect() {
-	f.logger.Info("disconnected")
+	f.logger.Info("disconnected", zap.String("addr", f.peerAddr.Load()))
+
+	f.mu.Lock()
+	if f.connected {
+		f.ready = make(chan struct{})
+	}
+	f.connected = false
+	f.disconnects++
+	f.failedAddrs = make(map[string]struct{})
+	disconnects := f.disconnects
+	streamErr := f.streamErr
+	f.streamErr = nil
+	// Stop the goroutines for the connection. The connection may be closed
+	// gracefully, so its streams would otherwise stay open.
+	if f.connCancel != nil {
+		f.connCancel()
+	}
+	f.mu.Unlock()
+
+	if f.staticResolver != nil && len(f.backupSeeds) > 0 {
+		f.watchBackupSeeds(disconnects)
+	}
+
+	f.connectedAddr.Store("")
+	f.connState.Store(string(StateDisconnected))
+	f.metrics.ConnState(StateDisconnected)
 
 	if f.onConnectionStateChange != nil {
 		f.onConnectionStateChange(StateDisconnected)
 	}
+	if f.onDisconnected != nil {
+		f.onDisconnected(disconnectReason(streamErr), streamErr)
+	}
 }
 
-func (f *Fuddle) setupStreamUpdates() {
-	subscription, err := f.readClient.Updates(
-		f.ctx,
-		&rpc.SubscribeRequest{
-			KnownMembers: f.registry.KnownVersions(),
-			// Receive all updates from the connected node..
-			OwnerOnly: false,
-		},
-	)
-	if err != nil {
-		// If we can't subscribe, this will typically mean we've disconnected
-		// so will retry once reconnected.
-		f.logger.Warn("failed to subscribe", zap.Error(err))
+// onStreamClosed is called when the update stream is closed while the client
+// is open. If the node closed the stream, such as when shutting down, the
+// connection may still be open without receiving updates, so the client
+// reconnects to another node if possible, otherwise subscribes again.
+func (f *Fuddle) onStreamClosed(ctx context.Context, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Ignore streams closed by the connection being replaced. Since the
+	// connection context is cancelled with the mutex locked, the stream is
+	// still for the current connection if its context isn't cancelled.
+	if ctx.Err() != nil {
+		return
+	}
+
+	f.streamErr = err
+	f.streamCloses++
+
+	// If the connection was closed, the client will reconnect as usual.
+	if f.conn.GetState() != connectivity.Ready {
 		return
 	}
 
+	// Exclude the node by the address the dialer was given rather than the
+	// resolved address, which must match the resolver addresses.
+	addr := f.connectedAddr.Load()
+	if f.staticResolver != nil && f.canExcludeAddrLocked(addr) {
+		f.logger.Info(
+			"update stream closed; reconnecting to another node",
+			zap.String("addr", addr),
+			zap.Error(err),
+		)
+
+		// Removing the connected address from the resolver closes the
+		// connection and connects to another address.
+		f.excludedAddr = addr
+		f.updateResolverAddrsLocked()
+		f.watchExcludedAddr(f.connects)
+		return
+	}
+
+	// Otherwise re-resolve the addresses, such as to lookup the SRV record
+	// again, and subscribe again after a backoff.
+	if f.srvResolver != nil {
+		f.srvResolver.ResolveNow()
+	}
+	f.resubscribe(f.reconnectBackoff.delay(f.streamCloses - 1))
+}
+
+// canExcludeAddrLocked returns whether the given address can be excluded from
+// the resolver addresses, which requires the resolver to include the address
+// and at least one other address.
+//
+// Assumes the mutex is locked.
+func (f *Fuddle) canExcludeAddrLocked(addr string) bool {
+	if len(f.resolverAddrs) < 2 {
+		return false
+	}
+	for _, a := range f.resolverAddrs {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// watchExcludedAddr adds the excluded address back to the resolver addresses
+// if the client hasn't reconnected within the connect attempt timeout, such as
+// if the other nodes are unreachable, where connects is the number of connects
+// when the address was excluded.
+func (f *Fuddle) watchExcludedAddr(connects int) {
 	f.wg.Add(1)
 	go func() {
 		defer f.wg.Done()
-		f.streamUpdates(subscription)
+
+		select {
+		case <-f.clock.After(f.connectAttemptTimeout):
+		case <-f.ctx.Done():
+			return
+		}
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		if f.connects != connects || f.excludedAddr == "" {
+			return
+		}
+		f.excludedAddr = ""
+		f.updateResolverAddrsLocked()
 	}()
 }
 
-func (f *Fuddle) setupStreamRegister() {
-	stream, err := f.writeClient.Register(
-		// Use background since f.ctx will be cancelled before we've sent
-		// unregister.
-		context.Background(),
+// resubscribe subscribes to updates again after the given delay, if the
+// client is still on the same connection.
+//
+// Assumes the mutex is locked.
+func (f *Fuddle) resubscribe(delay time.Duration) {
+	ctx, wg := f.connCtx, f.connWG
+
+	f.wg.Add(1)
+	wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		defer wg.Done()
+
+		// If the client reconnected, the context is cancelled and the
+		// client has already subscribed on the new connection.
+		select {
+		case <-f.clock.After(delay):
+		case <-ctx.Done():
+			return
+		}
+
+		if f.conn.GetState() != connectivity.Ready {
+			return
+		}
+		f.setupStreamUpdates(ctx, wg)
+	}()
+}
+
+// setupStreamUpdates subscribes to updates on the connection with the given
+// context, where wg tracks the connections goroutines.
+func (f *Fuddle) setupStreamUpdates(ctx context.Context, wg *sync.WaitGroup) {
+	knownVersions := f.registry.KnownVersions()
+	f.logger.Debug(
+		"subscribing",
+		zap.Stringers("known-members", sortedKnownVersions(knownVersions)),
+	)
+
+	subscription, err := f.readClient.Updates(
+		ctx,
+		&rpc.SubscribeRequest{
+			KnownMembers: knownVersions,
+			// Unless configured with WithOwnerOnly, receive updates for all
+			// members from the connected node.
+			OwnerOnly: f.ownerOnly,
+		},
 	)
 	if err != nil {
 		// If we can't subscribe, this will typically mean we've disconnected
 		// so will retry once reconnected.
-		f.logger.Warn("failed to stream register", zap.Error(err))
-		return
-	}
-
-	if err := stream.Send(&rpc.ClientUpdate{
-		UpdateType: rpc.ClientUpdateType_CLIENT_REGISTER,
-		Member:     f.registry.LocalRPCMember(),
-	}); err != nil {
-		f.logger.Warn("failed to send register", zap.Error(err))
+		f.logger.Warn("failed to subscribe", zap.Error(err))
 		return
 	}
+	// Subscribing counts as receiving from the node, so the client isn't
+	// unhealthy after reconnecting before the first update.
+	f.lastReceived.Store(f.clock.Now().UnixNano())
 
 	f.wg.Add(1)
+	wg.Add(1)
 	go func() {
 		defer f.wg.Done()
-		f.streamHeartbeats(stream)
+		defer wg.Done()
+		f.streamUpdates(ctx, subscription)
 	}()
 }
 
-func (f *Fuddle) streamUpdates(stream rpc.ClientReadRegistry_UpdatesClient) {
+// confirmLocalMember waits for the member passed to Connect to be registered
+// after connecting, then confirms the connected node has registered it.
+func (f *Fuddle) confirmLocalMember(ctx context.Context, id string) error {
+	// The member is registered asynchronously once the connection is
+	// ready.
+	if err := f.WaitForReady(ctx); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	node := f.localNodes[id]
+	f.mu.Unlock()
+
+	return node.confirmRegistered(ctx)
+}
+
+// registerLocalNodes registers the local nodes on the current connection.
+func (f *Fuddle) registerLocalNodes() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, node := range f.localNodes {
+		if err := node.register(context.Background()); err != nil {
+			// If we can't register, this will typically mean we've
+			// disconnected so will retry once reconnected.
+			f.logger.Warn(
+				"failed to register",
+				zap.String("id", node.ID()),
+				zap.Error(err),
+			)
+		}
+	}
+	if !f.connected {
+		close(f.ready)
+	}
+	f.connected = true
+}
+
+func (f *Fuddle) streamUpdates(ctx context.Context, stream rpc.ClientReadRegistry_UpdatesClient) {
 	for {
 		update, err := stream.Recv()
 		if err != nil {
-			// Avoid redundent logs if we've closed.
-			if f.closed.Load() {
+			// Avoid redundent logs if we've closed or the connection was
+			// replaced.
+			if f.closed.Load() || ctx.Err() != nil {
 				return
 			}
 			f.logger.Warn("subscribe error", zap.Error(err))
+			f.onStreamClosed(ctx, err)
 			return
 		}
 
+		f.lastReceived.Store(f.clock.Now().UnixNano())
+		f.stats.updatesReceived.Inc()
+		f.metrics.UpdateReceived()
 		f.registry.RemoteUpdate(update)
+
+		f.setSynced()
 	}
 }
 
-func (f *Fuddle) streamHeartbeats(stream rpc.ClientWriteRegistry_RegisterClient) {
-	ticker := time.NewTicker(f.heartbeatInterval)
-	defer ticker.Stop()
+// setSynced marks the client as synced. setSynced is safe to call multiple
+// times.
+func (f *Fuddle) setSynced() {
+	f.syncedOnce.Do(func() {
+		close(f.synced)
+	})
+}
 
-	for {
-		select {
-		case <-f.ctx.Done():
-			if err := stream.Send(&rpc.ClientUpdate{
-				UpdateType: rpc.ClientUpdateType_CLIENT_UNREGISTER,
-				Member:     f.registry.LocalRPCMember(),
-			}); err != nil {
-				f.logger.Warn("unregister error", zap.Error(err))
-			}
+// serviceConfig returns the gRPC service config using the configured load
+// balancing policy.
+func (f *Fuddle) serviceConfig() string {
+	return fmt.Sprintf(
+		`{"loadBalancingConfig": [{"%s": {}}]}`, f.loadBalancingPolicy,
+	)
+}
+
+// callOptions returns the default call options for all RPCs.
+func (f *Fuddle) callOptions() []grpc.CallOption {
+	var opts []grpc.CallOption
+	if f.maxRecvMsgSize > 0 {
+		opts = append(opts, grpc.MaxCallRecvMsgSize(f.maxRecvMsgSize))
+	}
+	if f.maxSendMsgSize > 0 {
+		opts = append(opts, grpc.MaxCallSendMsgSize(f.maxSendMsgSize))
+	}
+	if f.compression != "" {
+		opts = append(opts, grpc.UseCompressor(f.compression))
+	}
+	return opts
+}
+
+// keepAliveClientParams returns the gRPC keepalive parameters, using either
+// the parameters configured with WithKeepAliveParameters or the configured
+// ping interval and timeout.
+func (f *Fuddle) keepAliveClientParams() keepalive.ClientParameters {
+	if f.keepAliveParams != nil {
+		return *f.keepAliveParams
+	}
+	return keepalive.ClientParameters{
+		Time:                f.keepAlivePingInterval,
+		Timeout:             f.keepAlivePingTimeout,
+		PermitWithoutStream: true,
+	}
+}
+
+func (f *Fuddle) dialerWithTimeout(ctx context.Context, addr string) (net.Conn, error) {
+	f.stats.connectAttempts.Inc()
+
+	var conn net.Conn
+	var err error
+	if f.proxyDialer != nil {
+		conn, err = f.dialProxy(ctx, addr)
+	} else {
+		dialer := &net.Dialer{
+			Timeout: f.connectAttemptTimeout,
+		}
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		f.onDialFailed(addr)
+		return nil, err
+	}
+	// Record the resolver address rather than conn.RemoteAddr(), since the
+	// remote address is the proxy when using WithProxyDialer, and seeds may
+	// be hostnames rather than IPs.
+	f.peerAddr.Store(addr)
+	return conn, nil
+}
+
+// onDialFailed records that connecting to the given address failed, and calls
+// onAllSeedsFailed if every resolver address has failed since the client
+// disconnected.
+func (f *Fuddle) onDialFailed(addr string) {
+	// Ignore failures from closing the client.
+	if f.onAllSeedsFailed == nil || f.ctx.Err() != nil {
+		return
+	}
+
+	f.mu.Lock()
+	// Only track failures once disconnected, since Connect returns an error
+	// if the initial connection fails.
+	if f.failedAddrs == nil || f.allSeedsFailed || f.staticResolver == nil {
+		f.mu.Unlock()
+		return
+	}
+	f.failedAddrs[addr] = struct{}{}
+	for _, a := range f.resolverAddrs {
+		if _, ok := f.failedAddrs[a]; !ok {
+			f.mu.Unlock()
 			return
-		case <-ticker.C:
-			if err := stream.Send(&rpc.ClientUpdate{
-				UpdateType: rpc.ClientUpdateType_CLIENT_HEARTBEAT,
-			}); err != nil {
-				return
-			}
 		}
 	}
+	f.allSeedsFailed = true
+	addrs := f.resolverAddrs
+	f.mu.Unlock()
+
+	f.logger.Warn("all addresses unreachable", zap.Strings("addrs", addrs))
+
+	f.onAllSeedsFailed()
 }
 
-func (f *Fuddle) dialerWithTimeout(ctx context.Context, addr string) (net.Conn, error) {
-	dialer := &net.Dialer{
-		Timeout: f.connectAttemptTimeout,
+// dialProxy connects to the given address through the proxy dialer, with the
+// same per-attempt timeout as connecting directly.
+func (f *Fuddle) dialProxy(ctx context.Context, addr string) (net.Conn, error) {
+	dialer, ok := f.proxyDialer.(proxy.ContextDialer)
+	if !ok {
+		return f.proxyDialer.Dial("tcp", addr)
+	}
+
+	if f.connectAttemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.connectAttemptTimeout)
+		defer cancel()
 	}
 	return dialer.DialContext(ctx, "tcp", addr)
 }
@@ -0,0 +1,2145 @@
+package fuddle
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fuddle-io/fuddle-go/fuddletest"
+	rpc "github.com/fuddle-io/fuddle-rpc/go"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"golang.org/x/net/proxy"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+)
+
+func TestFuddle_SubscribeContextCancel(t *testing.T) {
+	f := newFuddle(fromRPC(randomMember("local")), defaultOptions())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	unsub := f.SubscribeContext(ctx, func() {})
+	assert.Equal(t, 1, numSubscribers(f.registry))
+
+	cancel()
+	assert.Eventually(t, func() bool {
+		return numSubscribers(f.registry) == 0
+	}, time.Second, time.Millisecond)
+
+	// Unsubscribing after cancel should be a no-op.
+	unsub()
+	unsub()
+
+	f.cancel()
+	f.wg.Wait()
+}
+
+func TestFuddle_SubscribeContextClientClosed(t *testing.T) {
+	f := newFuddle(fromRPC(randomMember("local")), defaultOptions())
+
+	f.SubscribeContext(context.Background(), func() {})
+	assert.Equal(t, 1, numSubscribers(f.registry))
+
+	// Closing the client should stop the subscription goroutine even though
+	// the context is never cancelled.
+	f.cancel()
+	f.wg.Wait()
+
+	assert.Equal(t, 0, numSubscribers(f.registry))
+}
+
+func TestFuddle_SubscribeContextAlreadyCancelled(t *testing.T) {
+	f := newFuddle(fromRPC(randomMember("local")), defaultOptions())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// The callback must not be called, not even to bootstrap.
+	unsub := f.SubscribeContext(ctx, func() {
+		t.Fatal("callback called")
+	})
+	unsub()
+	assert.Equal(t, 0, numSubscribers(f.registry))
+}
+
+func TestFuddle_MembersContext(t *testing.T) {
+	f := newFuddle(fromRPC(randomMember("local")), defaultOptions())
+
+	members, err := f.MembersContext(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, f.Members(), members)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = f.MembersContext(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestFuddle_WaitForMembersCountReached(t *testing.T) {
+	f := NewOffline(nil)
+	defer f.Close()
+
+	filter := &Filter{"orders": {}}
+
+	go func() {
+		for i := 0; i != 3; i++ {
+			m := randomMember(fmt.Sprintf("orders-%d", i))
+			m.Service = "orders"
+			f.registry.RemoteUpdate(&rpc.Member2{
+				State:    m,
+				Liveness: rpc.Liveness_UP,
+			})
+			// Members in other services must not be counted.
+			f.registry.RemoteUpdate(&rpc.Member2{
+				State:    randomMember(""),
+				Liveness: rpc.Liveness_UP,
+			})
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	members, err := f.WaitForMembers(ctx, filter, func(members []Member) bool {
+		return len(members) >= 3
+	})
+	require.NoError(t, err)
+	require.Len(t, members, 3)
+	for _, m := range members {
+		assert.Equal(t, "orders", m.Service)
+	}
+
+	// The subscriber must be removed once WaitForMembers returns.
+	assert.Equal(t, 0, numSubscribers(f.registry))
+}
+
+func TestFuddle_WaitForMembersBootstrap(t *testing.T) {
+	f := NewOffline([]Member{fromRPC(randomMember("remote"))})
+	defer f.Close()
+
+	members, err := f.WaitForMembers(context.Background(), nil, func(members []Member) bool {
+		return len(members) == 1
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "remote", members[0].ID)
+}
+
+func TestFuddle_WaitForMembersTimeout(t *testing.T) {
+	f := NewOffline(nil)
+	defer f.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+
+	_, err := f.WaitForMembers(ctx, nil, func(members []Member) bool {
+		return len(members) >= 1
+	})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 0, numSubscribers(f.registry))
+}
+
+func TestFuddle_ConnState(t *testing.T) {
+	server := newTestServer(t)
+
+	f := newFuddle(fromRPC(randomMember("local")), defaultOptions())
+	assert.Equal(t, StateDisconnected, f.ConnState())
+
+	require.NoError(t, f.connect(context.Background(), []string{server.addr}))
+	defer f.Close()
+
+	// Must be connected as soon as connect returns.
+	assert.Equal(t, StateConnected, f.ConnState())
+
+	server.Stop()
+
+	assert.Eventually(t, func() bool {
+		return f.ConnState() == StateDisconnected
+	}, time.Second, time.Millisecond)
+}
+
+func TestFuddle_ConnectProxy(t *testing.T) {
+	server := newTestServer(t)
+	socks := newSOCKSProxy(t)
+
+	dialer, err := proxy.SOCKS5("tcp", socks.addr, nil, proxy.Direct)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f, err := Connect(
+		ctx,
+		fromRPC(randomMember("local")),
+		[]string{server.addr},
+		WithProxyDialer(dialer),
+	)
+	require.NoError(t, err)
+	defer f.Close()
+
+	assert.Contains(t, socks.Targets(), server.addr)
+	// The connected address is the server, not the proxy.
+	assert.Equal(t, server.addr, f.ConnectedAddr())
+
+	// The member is registered through the proxy.
+	assert.Eventually(t, func() bool {
+		return len(server.Received()) > 0
+	}, time.Second, time.Millisecond)
+}
+
+func TestFuddle_ConnectProxyUnreachable(t *testing.T) {
+	server := newTestServer(t)
+
+	// Use the address of a closed listener so the proxy is unreachable.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	proxyAddr := ln.Addr().String()
+	ln.Close()
+
+	dialer, err := proxy.SOCKS5("tcp", proxyAddr, nil, proxy.Direct)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*500)
+	defer cancel()
+
+	// The server is reachable directly, but must not be connected to
+	// without the proxy.
+	_, err = Connect(
+		ctx,
+		fromRPC(randomMember("local")),
+		[]string{server.addr},
+		WithProxyDialer(dialer),
+	)
+	assert.Error(t, err)
+	assert.Empty(t, server.ClientIDs())
+}
+
+// newUnreadyServer returns the address of a gRPC server that accepts
+// connections but doesn't serve the Fuddle registry.
+func newUnreadyServer(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := grpc.NewServer()
+	go func() {
+		//nolint
+		server.Serve(ln)
+	}()
+	t.Cleanup(server.Stop)
+
+	return ln.Addr().String()
+}
+
+func TestFuddle_ConnectReadinessProbe(t *testing.T) {
+	unready := newUnreadyServer(t)
+	server := newTestServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	// Since the seeds are shuffled, repeat so the client connects to the
+	// unready server first at least some of the time.
+	for i := 0; i != 5; i++ {
+		f, err := Connect(
+			ctx,
+			fromRPC(randomMember("local")),
+			[]string{unready, server.addr},
+			WithReadinessProbe(),
+		)
+		require.NoError(t, err)
+
+		assert.Equal(t, server.addr, f.ConnectedAddr())
+
+		f.Close()
+	}
+}
+
+func TestFuddle_ConnectReadinessProbeHostname(t *testing.T) {
+	// Use hostname seeds, which differ from the resolved IP addresses, to
+	// check unready nodes are excluded by their seed address.
+	unready := strings.Replace(newUnreadyServer(t), "127.0.0.1", "localhost", 1)
+	server := newTestServer(t)
+	serverAddr := strings.Replace(server.addr, "127.0.0.1", "localhost", 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	for i := 0; i != 5; i++ {
+		f, err := Connect(
+			ctx,
+			fromRPC(randomMember("local")),
+			[]string{unready, serverAddr},
+			WithReadinessProbe(),
+		)
+		require.NoError(t, err)
+
+		assert.Equal(t, serverAddr, f.ConnectedAddr())
+
+		f.Close()
+	}
+}
+
+func TestFuddle_ConnectReadinessProbeFailed(t *testing.T) {
+	unready := newUnreadyServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	_, err := Connect(
+		ctx,
+		fromRPC(randomMember("local")),
+		[]string{unready},
+		WithReadinessProbe(),
+	)
+	assert.ErrorIs(t, err, ErrNotConnected)
+	assert.Equal(t, codes.Unimplemented, statusCode(err))
+}
+
+func TestFuddle_OnAllSeedsFailed(t *testing.T) {
+	server1 := newTestServer(t)
+	server2 := newTestServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	calls := atomic.NewInt64(0)
+	f, err := Connect(
+		ctx,
+		fromRPC(randomMember("local")),
+		[]string{server1.addr, server2.addr},
+		WithReconnectBackoff(Backoff{
+			Initial:    time.Millisecond * 10,
+			Max:        time.Millisecond * 50,
+			Multiplier: 2,
+		}),
+		WithOnAllSeedsFailed(func() {
+			calls.Inc()
+		}),
+	)
+	require.NoError(t, err)
+	defer f.Close()
+
+	// Stopping the connected node must not call the callback, since the
+	// client reconnects to the other node.
+	connected, other := server1, server2
+	if f.ConnectedAddr() == server2.addr {
+		connected, other = server2, server1
+	}
+	connected.Stop()
+
+	assert.Eventually(t, func() bool {
+		return f.ConnectedAddr() == other.addr
+	}, time.Second*2, time.Millisecond*10)
+	assert.Equal(t, int64(0), calls.Load())
+
+	// Once all nodes are unreachable the callback must be called once.
+	other.Stop()
+
+	assert.Eventually(t, func() bool {
+		return calls.Load() == 1
+	}, time.Second*2, time.Millisecond*10)
+
+	<-time.After(time.Millisecond * 200)
+	assert.Equal(t, int64(1), calls.Load())
+}
+
+func TestFuddle_ReconnectBackoff(t *testing.T) {
+	server := newTestServer(t)
+
+	f, err := Connect(
+		context.Background(),
+		fromRPC(randomMember("local")),
+		[]string{server.addr},
+		WithReconnectBackoff(Backoff{
+			Initial:    time.Millisecond * 250,
+			Max:        time.Millisecond * 250,
+			Multiplier: 1,
+		}),
+	)
+	require.NoError(t, err)
+	defer f.Close()
+
+	server.Stop()
+
+	// Replace the server with a listener that counts connection attempts
+	// and closes them immediately.
+	ln, err := net.Listen("tcp", server.addr)
+	require.NoError(t, err)
+	defer ln.Close()
+
+	attempts := atomic.NewInt64(0)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			attempts.Inc()
+			conn.Close()
+		}
+	}()
+
+	<-time.After(time.Second)
+
+	// With a 250ms backoff there should only be a few attempts in a second,
+	// rather than reconnecting in a tight loop.
+	assert.LessOrEqual(t, attempts.Load(), int64(6))
+	assert.GreaterOrEqual(t, attempts.Load(), int64(1))
+}
+
+func TestFuddle_SubscribeContextUnsubscribe(t *testing.T) {
+	f := newFuddle(fromRPC(randomMember("local")), defaultOptions())
+
+	unsub := f.SubscribeContext(context.Background(), func() {})
+	unsub()
+	// The subscriber must be removed once unsub returns.
+	assert.Equal(t, 0, numSubscribers(f.registry))
+
+	f.cancel()
+	f.wg.Wait()
+}
+
+func TestFuddle_ConnectTLS(t *testing.T) {
+	cert, pool := selfSignedCert(t)
+	server := newTestServer(t, grpc.Creds(credentials.NewServerTLSFromCert(&cert)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	// Note the config doesn't set ServerName, so the certificate must be
+	// verified against the seed host.
+	f, err := Connect(
+		ctx,
+		fromRPC(randomMember("local")),
+		[]string{server.addr},
+		WithTLS(&tls.Config{RootCAs: pool}),
+	)
+	require.NoError(t, err)
+	f.Close()
+}
+
+func TestFuddle_ConnectTLSUntrustedCert(t *testing.T) {
+	cert, _ := selfSignedCert(t)
+	server := newTestServer(t, grpc.Creds(credentials.NewServerTLSFromCert(&cert)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// The self signed certificate isn't trusted by the default config.
+	_, err := Connect(
+		ctx,
+		fromRPC(randomMember("local")),
+		[]string{server.addr},
+		WithTLS(nil),
+	)
+	// gRPC doesn't wrap the connection error, so check the message to verify
+	// the dial failed due to certificate verification.
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "x509: certificate signed by unknown authority")
+}
+
+func TestFuddle_DialOptions(t *testing.T) {
+	server := newTestServer(t)
+
+	var mu sync.Mutex
+	var methods []string
+	interceptor := func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		mu.Lock()
+		methods = append(methods, method)
+		mu.Unlock()
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f, err := Connect(
+		ctx,
+		fromRPC(randomMember("local")),
+		[]string{server.addr},
+		WithDialOptions(grpc.WithStreamInterceptor(interceptor)),
+	)
+	require.NoError(t, err)
+	defer f.Close()
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		for _, m := range methods {
+			if m == "/registry.ClientWriteRegistry/Register" {
+				return true
+			}
+		}
+		return false
+	}, time.Second, time.Millisecond)
+}
+
+func TestFuddle_LoadBalancingPolicyRoundRobin(t *testing.T) {
+	server1 := newTestServer(t)
+	server2 := newTestServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f, err := Connect(
+		ctx,
+		fromRPC(randomMember("local")),
+		[]string{server1.addr, server2.addr},
+		WithLoadBalancingPolicy("round_robin"),
+	)
+	require.NoError(t, err)
+	defer f.Close()
+
+	// Register members until both servers have received a register stream,
+	// which would never happen with 'pick_first'.
+	assert.Eventually(t, func() bool {
+		_, err := f.Register(ctx, fromRPC(randomMember("")))
+		require.NoError(t, err)
+
+		return len(server1.Received()) > 0 && len(server2.Received()) > 0
+	}, time.Second, time.Millisecond*10)
+}
+
+func TestFuddle_OwnerOnly(t *testing.T) {
+	for _, ownerOnly := range []bool{false, true} {
+		server := newTestServer(t)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		opts := []Option{}
+		if ownerOnly {
+			opts = append(opts, WithOwnerOnly(true))
+		}
+		f, err := Connect(ctx, fromRPC(randomMember("local")), []string{server.addr}, opts...)
+		require.NoError(t, err)
+		defer f.Close()
+
+		require.Eventually(t, func() bool {
+			return len(server.SubscribeRequests()) == 1
+		}, time.Second, time.Millisecond)
+		assert.Equal(t, ownerOnly, server.SubscribeRequests()[0].OwnerOnly)
+	}
+}
+
+func TestFuddle_OnIDConflict(t *testing.T) {
+	server := newTestServer(t)
+	// Stream a member with the same ID as the local member, as if another
+	// client registered the same ID.
+	conflicting := randomMember("local")
+	server.AddMember(&rpc.Member2{
+		State:    conflicting,
+		Liveness: rpc.Liveness_UP,
+		Version: &rpc.Version2{
+			OwnerId:   "remote",
+			Timestamp: &rpc.MonotonicTimestamp{Timestamp: 10},
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	conflicts := make(chan Member, 1)
+	f, err := Connect(
+		ctx,
+		fromRPC(randomMember("local")),
+		[]string{server.addr},
+		WithOnIDConflict(func(local Member, remote Member) {
+			conflicts <- remote
+		}),
+	)
+	require.NoError(t, err)
+	defer f.Close()
+
+	select {
+	case remote := <-conflicts:
+		assert.Equal(t, fromRPC(conflicting), remote)
+	case <-time.After(time.Second):
+		t.Fatal("id conflict callback not called")
+	}
+}
+
+func TestFuddle_Ping(t *testing.T) {
+	server := newTestServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	require.NoError(t, Ping(ctx, fromRPC(randomMember("local")), []string{server.addr}))
+
+	// Ping must not register the member or subscribe to updates.
+	time.Sleep(time.Millisecond * 50)
+	assert.Empty(t, server.Received())
+	assert.Empty(t, server.SubscribeRequests())
+}
+
+func TestFuddle_PingUnreachable(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+
+	err := Ping(ctx, fromRPC(randomMember("local")), []string{"127.0.0.1:1"})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestFuddle_PingInvalidMember(t *testing.T) {
+	server := newTestServer(t)
+
+	err := Ping(context.Background(), Member{}, []string{server.addr})
+	assert.Error(t, err)
+	assert.Empty(t, server.Received())
+}
+
+func TestFuddle_ConnectTimeout(t *testing.T) {
+	// Get an address with no listener so all connection attempts fail.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	ln.Close()
+
+	start := time.Now()
+	_, err = Connect(
+		context.Background(),
+		fromRPC(randomMember("local")),
+		[]string{addr},
+		WithConnectTimeout(time.Millisecond*200),
+	)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestFuddle_ConnectCancelled(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	ln.Close()
+
+	// Cancelling the context must still fail the connect even with a longer
+	// connect timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+
+	start := time.Now()
+	_, err = Connect(
+		ctx,
+		fromRPC(randomMember("local")),
+		[]string{addr},
+		WithConnectTimeout(time.Minute),
+	)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestFuddle_Register(t *testing.T) {
+	server := newTestServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f, err := Connect(ctx, fromRPC(randomMember("local-1")), []string{server.addr})
+	require.NoError(t, err)
+
+	_, err = f.Register(ctx, fromRPC(randomMember("local-2")))
+	require.NoError(t, err)
+	_, err = f.Register(ctx, fromRPC(randomMember("local-3")))
+	require.NoError(t, err)
+
+	// Registering the same member twice should fail.
+	_, err = f.Register(ctx, fromRPC(randomMember("local-2")))
+	assert.Error(t, err)
+
+	assert.Equal(t, 3, len(f.Members()))
+	assert.Eventually(t, func() bool {
+		return len(receivedIDs(server, rpc.ClientUpdateType_CLIENT_REGISTER)) == 3
+	}, time.Second, time.Millisecond)
+
+	f.Close()
+
+	assert.Eventually(t, func() bool {
+		return len(receivedIDs(server, rpc.ClientUpdateType_CLIENT_UNREGISTER)) == 3
+	}, time.Second, time.Millisecond)
+	assert.ElementsMatch(
+		t,
+		[]string{"local-1", "local-2", "local-3"},
+		receivedIDs(server, rpc.ClientUpdateType_CLIENT_UNREGISTER),
+	)
+}
+
+func TestFuddle_RegisterAutoTimestamp(t *testing.T) {
+	clock := newFakeClock()
+	clock.Advance(time.Hour)
+
+	f := NewOffline(nil, WithAutoTimestamp(), withClock(clock))
+	defer f.Close()
+
+	unset := fromRPC(randomMember("local-1"))
+	unset.Started = 0
+	_, err := f.Register(context.Background(), unset)
+	require.NoError(t, err)
+
+	set := fromRPC(randomMember("local-2"))
+	set.Started = 1234
+	_, err = f.Register(context.Background(), set)
+	require.NoError(t, err)
+
+	// Only the unset timestamp is stamped.
+	m, ok := f.Member("local-1")
+	require.True(t, ok)
+	assert.Equal(t, time.Hour.Milliseconds(), m.Started)
+	m, ok = f.Member("local-2")
+	require.True(t, ok)
+	assert.Equal(t, int64(1234), m.Started)
+}
+
+func TestFuddle_RegisterAutoTimestampDisabled(t *testing.T) {
+	clock := newFakeClock()
+	clock.Advance(time.Hour)
+
+	f := NewOffline(nil, withClock(clock))
+	defer f.Close()
+
+	member := fromRPC(randomMember("local"))
+	member.Started = 0
+	_, err := f.Register(context.Background(), member)
+	require.NoError(t, err)
+
+	m, ok := f.Member("local")
+	require.True(t, ok)
+	assert.Equal(t, int64(0), m.Started)
+}
+
+func TestFuddle_ConnectAutoTimestamp(t *testing.T) {
+	server := newTestServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	clock := newFakeClock()
+	clock.Advance(time.Hour)
+
+	member := fromRPC(randomMember("local"))
+	member.Started = 0
+	f, err := Connect(
+		ctx, member, []string{server.addr}, WithAutoTimestamp(), withClock(clock),
+	)
+	require.NoError(t, err)
+	defer f.Close()
+
+	m, ok := f.Member("local")
+	require.True(t, ok)
+	assert.Equal(t, time.Hour.Milliseconds(), m.Started)
+
+	// The stamped timestamp is registered with the connected node.
+	assert.Eventually(t, func() bool {
+		for _, update := range server.Received() {
+			if update.UpdateType == rpc.ClientUpdateType_CLIENT_REGISTER {
+				return update.Member.Started == time.Hour.Milliseconds()
+			}
+		}
+		return false
+	}, time.Second, time.Millisecond)
+}
+
+func TestFuddle_ConnectObserver(t *testing.T) {
+	server := newTestServer(t)
+
+	remote := randomMember("remote")
+	server.AddMember(&rpc.Member2{
+		State:    remote,
+		Liveness: rpc.Liveness_UP,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f, err := Connect(
+		ctx,
+		Member{},
+		[]string{server.addr},
+		WithHeartbeatInterval(time.Millisecond*10),
+		WithConfirmRegister(true),
+	)
+	require.NoError(t, err)
+	defer f.Close()
+
+	// Remote members are still received.
+	assert.Eventually(t, func() bool {
+		_, ok := f.Member("remote")
+		return ok
+	}, time.Second, time.Millisecond)
+
+	// Wait for a few heartbeat intervals to check no register or heartbeat
+	// updates are sent.
+	<-time.After(time.Millisecond * 100)
+
+	assert.Empty(t, server.Received())
+	assert.Equal(t, int64(0), f.Stats().HeartbeatsSent)
+	// Only the update stream is opened, not the register stream.
+	assert.Equal(t, 1, len(server.ClientIDs()))
+
+	// Members excludes a local member.
+	members := f.Members()
+	require.Equal(t, 1, len(members))
+	assert.Equal(t, "remote", members[0].ID)
+}
+
+func TestFuddle_RegisterUnregister(t *testing.T) {
+	server := newTestServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f, err := Connect(ctx, fromRPC(randomMember("local-1")), []string{server.addr})
+	require.NoError(t, err)
+	defer f.Close()
+
+	node, err := f.Register(ctx, fromRPC(randomMember("local-2")))
+	require.NoError(t, err)
+	assert.Equal(t, "local-2", node.ID())
+
+	assert.Eventually(t, func() bool {
+		return len(receivedIDs(server, rpc.ClientUpdateType_CLIENT_REGISTER)) == 2
+	}, time.Second, time.Millisecond)
+
+	node.Unregister()
+	// Unregistering again should be a no-op.
+	node.Unregister()
+
+	assert.Equal(t, 1, len(f.Members()))
+	assert.Eventually(t, func() bool {
+		return len(receivedIDs(server, rpc.ClientUpdateType_CLIENT_UNREGISTER)) == 1
+	}, time.Second, time.Millisecond)
+	assert.Equal(
+		t,
+		[]string{"local-2"},
+		receivedIDs(server, rpc.ClientUpdateType_CLIENT_UNREGISTER),
+	)
+
+	// The member can be registered again once unregistered.
+	_, err = f.Register(ctx, fromRPC(randomMember("local-2")))
+	require.NoError(t, err)
+}
+
+func TestFuddle_UnregisterByID(t *testing.T) {
+	server := newTestServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f, err := Connect(ctx, fromRPC(randomMember("local-1")), []string{server.addr})
+	require.NoError(t, err)
+	defer f.Close()
+
+	_, err = f.Register(ctx, fromRPC(randomMember("local-2")))
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return len(receivedIDs(server, rpc.ClientUpdateType_CLIENT_REGISTER)) == 2
+	}, time.Second, time.Millisecond)
+
+	// Unregister without the LocalNode handle.
+	require.NoError(t, f.Unregister(ctx, "local-2"))
+
+	_, ok := f.Member("local-2")
+	assert.False(t, ok)
+	assert.Eventually(t, func() bool {
+		return len(receivedIDs(server, rpc.ClientUpdateType_CLIENT_UNREGISTER)) == 1
+	}, time.Second, time.Millisecond)
+	assert.Equal(
+		t,
+		[]string{"local-2"},
+		receivedIDs(server, rpc.ClientUpdateType_CLIENT_UNREGISTER),
+	)
+
+	// Once unregistered the member is no longer local.
+	assert.ErrorIs(t, f.Unregister(ctx, "local-2"), ErrUpdateRejected)
+}
+
+func TestFuddle_UnregisterByIDRemoteMember(t *testing.T) {
+	server := newTestServer(t)
+	server.AddMember(&rpc.Member2{
+		State:    randomMember("remote"),
+		Liveness: rpc.Liveness_UP,
+		Version: &rpc.Version2{
+			OwnerId:   "remote",
+			Timestamp: &rpc.MonotonicTimestamp{Timestamp: 10},
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f, err := Connect(ctx, fromRPC(randomMember("local")), []string{server.addr})
+	require.NoError(t, err)
+	defer f.Close()
+
+	require.Eventually(t, func() bool {
+		_, ok := f.Member("remote")
+		return ok
+	}, time.Second, time.Millisecond)
+
+	// Remote members must not be unregistered.
+	assert.ErrorIs(t, f.Unregister(ctx, "remote"), ErrUpdateRejected)
+	_, ok := f.Member("remote")
+	assert.True(t, ok)
+
+	assert.ErrorIs(t, f.Unregister(ctx, "unknown"), ErrUpdateRejected)
+	assert.Empty(t, receivedIDs(server, rpc.ClientUpdateType_CLIENT_UNREGISTER))
+}
+
+func TestFuddle_ConnectSRVResolver(t *testing.T) {
+	server := newTestServer(t)
+
+	host, portStr, err := net.SplitHostPort(server.addr)
+	require.NoError(t, err)
+	port, err := strconv.Atoi(portStr)
+	require.NoError(t, err)
+
+	options := defaultOptions()
+	WithSRVResolver("_fuddle._tcp.example.com").apply(options)
+	options.srvLookup = func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		assert.Equal(t, "_fuddle._tcp.example.com", name)
+		return "", []*net.SRV{
+			{Target: host, Port: uint16(port)},
+		}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f := newFuddle(fromRPC(randomMember("local")), options)
+	// The seed addresses are ignored when using the SRV resolver.
+	require.NoError(t, f.connect(ctx, nil))
+	defer f.Close()
+
+	assert.Eventually(t, func() bool {
+		return len(receivedIDs(server, rpc.ClientUpdateType_CLIENT_REGISTER)) == 1
+	}, time.Second, time.Millisecond)
+}
+
+func TestFuddle_UpdateSeeds(t *testing.T) {
+	server1 := newTestServer(t)
+	server2 := newTestServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f, err := Connect(
+		ctx,
+		fromRPC(randomMember("local")),
+		[]string{server1.addr},
+		WithReconnectBackoff(Backoff{
+			Initial: time.Millisecond * 10,
+			Max:     time.Millisecond * 10,
+		}),
+	)
+	require.NoError(t, err)
+	defer f.Close()
+
+	assert.Error(t, f.UpdateSeeds(nil))
+	require.NoError(t, f.UpdateSeeds([]string{server2.addr}))
+
+	// Once the first server stops, the client should reconnect to the
+	// updated seed.
+	server1.Stop()
+
+	assert.Eventually(t, func() bool {
+		return len(receivedIDs(server2, rpc.ClientUpdateType_CLIENT_REGISTER)) == 1
+	}, time.Second*5, time.Millisecond*10)
+}
+
+func TestFuddle_Metrics(t *testing.T) {
+	server1 := newTestServer(t)
+	server2 := newTestServer(t)
+
+	metrics := newTestMetrics()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f, err := Connect(
+		ctx,
+		fromRPC(randomMember("local")),
+		[]string{server1.addr},
+		WithMetrics(metrics),
+		WithHeartbeatInterval(time.Millisecond*10),
+		WithReconnectBackoff(Backoff{
+			Initial: time.Millisecond * 10,
+			Max:     time.Millisecond * 10,
+		}),
+	)
+	require.NoError(t, err)
+	defer f.Close()
+
+	assert.Equal(t, int64(1), metrics.members.Load())
+	assert.Equal(t, string(StateConnected), metrics.connState.Load())
+
+	f.registry.RemoteUpdate(&rpc.Member2{
+		State:    randomMember("remote"),
+		Liveness: rpc.Liveness_UP,
+	})
+	assert.Equal(t, int64(2), metrics.members.Load())
+
+	// Stopping the server should reconnect to the other server.
+	require.NoError(t, f.UpdateSeeds([]string{server1.addr, server2.addr}))
+	server1.Stop()
+
+	assert.Eventually(t, func() bool {
+		return metrics.reconnects.Load() == 1
+	}, time.Second*5, time.Millisecond*10)
+	assert.Equal(t, string(StateConnected), metrics.connState.Load())
+}
+
+func TestFuddle_Stats(t *testing.T) {
+	servers := []*testServer{
+		newTestServer(t), newTestServer(t), newTestServer(t),
+	}
+	// Each server streams a remote member when the client subscribes.
+	for i, server := range servers {
+		server.AddMember(&rpc.Member2{
+			State:    randomMember(fmt.Sprintf("remote-%d", i)),
+			Liveness: rpc.Liveness_UP,
+			Version: &rpc.Version2{
+				OwnerId:   "remote",
+				Timestamp: &rpc.MonotonicTimestamp{Timestamp: 10},
+			},
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f, err := Connect(
+		ctx,
+		fromRPC(randomMember("local")),
+		[]string{servers[0].addr},
+		WithHeartbeatInterval(time.Millisecond*10),
+		WithReconnectBackoff(Backoff{
+			Initial: time.Millisecond * 10,
+			Max:     time.Millisecond * 10,
+		}),
+	)
+	require.NoError(t, err)
+	defer f.Close()
+
+	assert.Eventually(t, func() bool {
+		stats := f.Stats()
+		return stats.UpdatesReceived == 1 && stats.HeartbeatsSent > 0
+	}, time.Second*5, time.Millisecond*10)
+	assert.Equal(t, int64(1), f.Stats().ConnectAttempts)
+	assert.Equal(t, int64(0), f.Stats().Reconnects)
+
+	// Stopping each server should reconnect to the next server.
+	for i := 1; i != len(servers); i++ {
+		require.NoError(t, f.UpdateSeeds([]string{servers[i].addr}))
+		servers[i-1].Stop()
+
+		assert.Eventually(t, func() bool {
+			stats := f.Stats()
+			return stats.Reconnects == int64(i) && stats.UpdatesReceived == int64(i+1)
+		}, time.Second*5, time.Millisecond*10)
+	}
+
+	stats := f.Stats()
+	assert.GreaterOrEqual(t, stats.ConnectAttempts, int64(len(servers)))
+	assert.Equal(t, int64(len(servers)+1), stats.CurrentMembers)
+}
+
+func TestFuddle_ConnectConfirmRegister(t *testing.T) {
+	server := newTestServer(t)
+	// Drop the first register so the client must retry.
+	server.DropRegisters(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f, err := Connect(
+		ctx,
+		fromRPC(randomMember("local")),
+		[]string{server.addr},
+		WithConfirmRegister(true),
+		WithReconnectBackoff(Backoff{Initial: time.Millisecond}),
+	)
+	require.NoError(t, err)
+	defer f.Close()
+
+	// Once Connect returns the member must be registered. The member may be
+	// registered multiple times if the lookup races with the register.
+	assert.Contains(t, receivedIDs(server, rpc.ClientUpdateType_CLIENT_REGISTER), "local")
+}
+
+func TestFuddle_ConnectConfirmRegisterTimeout(t *testing.T) {
+	server := newTestServer(t)
+	server.DropRegisters(math.MaxInt)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+
+	_, err := Connect(
+		ctx,
+		fromRPC(randomMember("local")),
+		[]string{server.addr},
+		WithConfirmRegister(true),
+		WithReconnectBackoff(Backoff{Initial: time.Millisecond}),
+	)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestFuddle_RegisterConfirmRegister(t *testing.T) {
+	server := newTestServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f, err := Connect(
+		ctx,
+		fromRPC(randomMember("local-1")),
+		[]string{server.addr},
+		WithConfirmRegister(true),
+		WithReconnectBackoff(Backoff{Initial: time.Millisecond}),
+	)
+	require.NoError(t, err)
+	defer f.Close()
+
+	server.DropRegisters(2)
+
+	_, err = f.Register(ctx, fromRPC(randomMember("local-2")))
+	require.NoError(t, err)
+
+	// Once Register returns the member must be registered.
+	assert.Contains(t, receivedIDs(server, rpc.ClientUpdateType_CLIENT_REGISTER), "local-2")
+}
+
+func TestFuddle_RegisterConfirmRegisterTimeout(t *testing.T) {
+	server := newTestServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f, err := Connect(
+		ctx,
+		fromRPC(randomMember("local-1")),
+		[]string{server.addr},
+		WithConfirmRegister(true),
+		WithReconnectBackoff(Backoff{Initial: time.Millisecond}),
+	)
+	require.NoError(t, err)
+	defer f.Close()
+
+	server.DropRegisters(math.MaxInt)
+
+	registerCtx, registerCancel := context.WithTimeout(ctx, time.Millisecond*200)
+	defer registerCancel()
+	_, err = f.Register(registerCtx, fromRPC(randomMember("local-2")))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// The member must be removed if it couldn't be registered.
+	_, ok := f.Member("local-2")
+	assert.False(t, ok)
+}
+
+func TestFuddle_MaxRecvMsgSize(t *testing.T) {
+	server := newTestServer(t)
+	// Stream a member larger than 1KB.
+	large := randomMember("large")
+	large.Metadata = map[string]string{"foo": strings.Repeat("x", 2048)}
+	server.AddMember(&rpc.Member2{
+		State:    large,
+		Liveness: rpc.Liveness_UP,
+		Version: &rpc.Version2{
+			OwnerId:   "remote",
+			Timestamp: &rpc.MonotonicTimestamp{Timestamp: 10},
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f1, err := Connect(
+		ctx,
+		fromRPC(randomMember("local-1")),
+		[]string{server.addr},
+		WithMaxRecvMsgSize(1024),
+	)
+	require.NoError(t, err)
+	defer f1.Close()
+
+	f2, err := Connect(
+		ctx,
+		fromRPC(randomMember("local-2")),
+		[]string{server.addr},
+		WithMaxRecvMsgSize(4096),
+	)
+	require.NoError(t, err)
+	defer f2.Close()
+
+	// The member is within the limit of f2 so must be received.
+	assert.Eventually(t, func() bool {
+		_, ok := f2.Member("large")
+		return ok
+	}, time.Second, time.Millisecond)
+	// Though exceeds the limit of f1 so must not be received.
+	assert.Never(t, func() bool {
+		_, ok := f1.Member("large")
+		return ok
+	}, time.Millisecond*100, time.Millisecond)
+}
+
+func TestFuddle_MaxSendMsgSize(t *testing.T) {
+	server := newTestServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f, err := Connect(
+		ctx,
+		fromRPC(randomMember("local")),
+		[]string{server.addr},
+		WithMaxSendMsgSize(1024),
+	)
+	require.NoError(t, err)
+	defer f.Close()
+
+	require.NoError(t, f.WaitForReady(ctx))
+
+	large := fromRPC(randomMember("large"))
+	large.Metadata = map[string]string{"foo": strings.Repeat("x", 2048)}
+	_, err = f.Register(ctx, large)
+	assert.ErrorIs(t, err, ErrNotConnected)
+	assert.ErrorContains(t, err, "larger than max")
+}
+
+func TestFuddle_Compression(t *testing.T) {
+	server := newTestServer(t)
+	server.AddMember(&rpc.Member2{
+		State:    randomMember("remote"),
+		Liveness: rpc.Liveness_UP,
+		Version: &rpc.Version2{
+			OwnerId:   "remote",
+			Timestamp: &rpc.MonotonicTimestamp{Timestamp: 10},
+		},
+	})
+
+	// Record the call options of the update stream.
+	var mu sync.Mutex
+	var updatesOpts []grpc.CallOption
+	interceptor := func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		if strings.HasSuffix(method, "/Updates") {
+			mu.Lock()
+			updatesOpts = opts
+			mu.Unlock()
+		}
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f, err := Connect(
+		ctx,
+		fromRPC(randomMember("local")),
+		[]string{server.addr},
+		WithCompression("gzip"),
+		WithDialOptions(grpc.WithChainStreamInterceptor(interceptor)),
+	)
+	require.NoError(t, err)
+	defer f.Close()
+
+	// The client must still receive updates.
+	assert.Eventually(t, func() bool {
+		_, ok := f.Member("remote")
+		return ok
+	}, time.Second, time.Millisecond)
+
+	mu.Lock()
+	assert.Contains(t, updatesOpts, grpc.UseCompressor("gzip"))
+	mu.Unlock()
+}
+
+func TestFuddle_ClientID(t *testing.T) {
+	server1 := newTestServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f, err := Connect(
+		ctx,
+		fromRPC(randomMember("local")),
+		[]string{server1.addr},
+		WithClientID("my-client"),
+	)
+	require.NoError(t, err)
+	defer f.Close()
+
+	assert.Equal(t, "my-client", f.ClientID())
+
+	// Wait for both the update and register streams.
+	require.Eventually(t, func() bool {
+		return len(server1.ClientIDs()) == 2
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, []string{"my-client", "my-client"}, server1.ClientIDs())
+
+	// The client ID must be the same after reconnecting.
+	server1.Stop()
+	server2 := newTestServer(t)
+	require.NoError(t, f.UpdateSeeds([]string{server2.addr}))
+
+	require.Eventually(t, func() bool {
+		return len(server2.ClientIDs()) == 2
+	}, time.Second*5, time.Millisecond)
+	assert.Equal(t, []string{"my-client", "my-client"}, server2.ClientIDs())
+}
+
+func TestFuddle_ClientIDDefault(t *testing.T) {
+	server := newTestServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f, err := Connect(ctx, fromRPC(randomMember("local")), []string{server.addr})
+	require.NoError(t, err)
+	defer f.Close()
+
+	// Defaults to a random ID.
+	_, err = uuid.Parse(f.ClientID())
+	assert.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return len(server.ClientIDs()) == 2
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, []string{f.ClientID(), f.ClientID()}, server.ClientIDs())
+
+	// Each client must have its own ID.
+	assert.NotEqual(t, f.ClientID(), NewOffline(nil).ClientID())
+}
+
+func TestFuddle_UpdateStreamClosedReconnects(t *testing.T) {
+	server1 := newTestServer(t)
+	server2 := newTestServer(t)
+
+	var mu sync.Mutex
+	var reasons []DisconnectReason
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f, err := Connect(
+		ctx,
+		fromRPC(randomMember("local")),
+		[]string{server1.addr},
+		WithOnDisconnect(func(reason DisconnectReason, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			reasons = append(reasons, reason)
+		}),
+	)
+	require.NoError(t, err)
+	defer f.Close()
+
+	// Add server2 to the seeds, which keeps the connection to server1.
+	require.NoError(t, f.WaitForReady(ctx))
+	require.NoError(t, f.UpdateSeeds([]string{server1.addr, server2.addr}))
+	require.Equal(t, server1.addr, f.ConnectedAddr())
+
+	// Closing the update stream must reconnect to the other node.
+	server1.CloseUpdates()
+
+	assert.Eventually(t, func() bool {
+		return f.ConnectedAddr() == server2.addr
+	}, time.Second*5, time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return len(server2.SubscribeRequests()) == 1
+	}, time.Second, time.Millisecond)
+
+	mu.Lock()
+	assert.Equal(t, []DisconnectReason{DisconnectReasonStreamClosed}, reasons)
+	mu.Unlock()
+}
+
+func TestFuddle_UpdateStreamClosedReconnectsHostname(t *testing.T) {
+	server1 := newTestServer(t)
+	server2 := newTestServer(t)
+
+	// Use hostname seeds, which differ from the resolved IP addresses, to
+	// check the node is excluded by its seed address.
+	addr1 := strings.Replace(server1.addr, "127.0.0.1", "localhost", 1)
+	addr2 := strings.Replace(server2.addr, "127.0.0.1", "localhost", 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f, err := Connect(
+		ctx,
+		fromRPC(randomMember("local")),
+		[]string{addr1},
+	)
+	require.NoError(t, err)
+	defer f.Close()
+
+	require.NoError(t, f.WaitForReady(ctx))
+	require.NoError(t, f.UpdateSeeds([]string{addr1, addr2}))
+	require.Equal(t, addr1, f.ConnectedAddr())
+
+	server1.CloseUpdates()
+
+	assert.Eventually(t, func() bool {
+		return f.ConnectedAddr() == addr2
+	}, time.Second*5, time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return len(server2.SubscribeRequests()) == 1
+	}, time.Second, time.Millisecond)
+}
+
+func TestFuddle_UpdateStreamClosedResubscribes(t *testing.T) {
+	server := newTestServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f, err := Connect(
+		ctx,
+		fromRPC(randomMember("local")),
+		[]string{server.addr},
+		WithReconnectBackoff(Backoff{Initial: time.Millisecond}),
+	)
+	require.NoError(t, err)
+	defer f.Close()
+
+	require.Eventually(t, func() bool {
+		return len(server.SubscribeRequests()) == 1
+	}, time.Second, time.Millisecond)
+
+	// With no other nodes, the client must subscribe again on the same
+	// connection.
+	server.CloseUpdates()
+	assert.Eventually(t, func() bool {
+		return len(server.SubscribeRequests()) >= 3
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, StateConnected, f.ConnState())
+}
+
+func TestFuddle_OnDisconnectConnectionLost(t *testing.T) {
+	server := newTestServer(t)
+
+	reasons := make(chan DisconnectReason, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f, err := Connect(
+		ctx,
+		fromRPC(randomMember("local")),
+		[]string{server.addr},
+		WithOnDisconnect(func(reason DisconnectReason, err error) {
+			reasons <- reason
+		}),
+	)
+	require.NoError(t, err)
+	defer f.Close()
+
+	server.Stop()
+
+	select {
+	case reason := <-reasons:
+		// The stream may be closed before the client detects the
+		// connection was lost.
+		assert.Contains(t, []DisconnectReason{
+			DisconnectReasonConnectionLost, DisconnectReasonStreamClosed,
+		}, reason)
+	case <-time.After(time.Second * 5):
+		t.Fatal("timeout")
+	}
+}
+
+func TestFuddle_ConnectedAddr(t *testing.T) {
+	server1 := newTestServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f, err := Connect(ctx, fromRPC(randomMember("local")), []string{server1.addr})
+	require.NoError(t, err)
+	defer f.Close()
+
+	assert.Equal(t, server1.addr, f.ConnectedAddr())
+
+	server1.Stop()
+	assert.Eventually(t, func() bool {
+		return f.ConnectedAddr() == ""
+	}, time.Second, time.Millisecond)
+
+	// Once reconnected must report the address of the new node.
+	server2 := newTestServer(t)
+	require.NoError(t, f.UpdateSeeds([]string{server2.addr}))
+	assert.Eventually(t, func() bool {
+		return f.ConnectedAddr() == server2.addr
+	}, time.Second*5, time.Millisecond)
+
+	f.Close()
+	assert.Equal(t, "", f.ConnectedAddr())
+
+	assert.Equal(t, "", NewOffline(nil).ConnectedAddr())
+}
+
+func TestFuddle_ReconnectFlappingDoesNotLeakGoroutines(t *testing.T) {
+	server1 := newTestServer(t)
+	server2 := newTestServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f, err := Connect(
+		ctx,
+		fromRPC(randomMember("local")),
+		[]string{server1.addr},
+		WithReconnectBackoff(Backoff{
+			Initial: time.Millisecond * 10,
+			Max:     time.Millisecond * 10,
+		}),
+	)
+	require.NoError(t, err)
+	defer f.Close()
+
+	require.Eventually(t, func() bool {
+		return len(receivedIDs(server1, rpc.ClientUpdateType_CLIENT_REGISTER)) == 1 &&
+			len(server1.SubscribeRequests()) == 1
+	}, time.Second, time.Millisecond)
+
+	baseline := runtime.NumGoroutine()
+
+	// Switching the seeds closes the connection gracefully and connects to
+	// the other server, so the previous connections streams would stay
+	// open unless the client closes them.
+	addrs := []string{server2.addr, server1.addr}
+	for i := 0; i != 10; i++ {
+		f.mu.Lock()
+		connects := f.connects
+		f.mu.Unlock()
+
+		require.NoError(t, f.UpdateSeeds([]string{addrs[i%2]}))
+		require.Eventually(t, func() bool {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			return f.connects > connects && f.connected
+		}, time.Second, time.Millisecond)
+	}
+
+	// Note Eventually runs the condition in its own goroutine.
+	assert.Eventually(t, func() bool {
+		return runtime.NumGoroutine() <= baseline+1
+	}, time.Second*5, time.Millisecond*10)
+}
+
+func TestFuddle_LogsReconnect(t *testing.T) {
+	server1 := newTestServer(t)
+	server2 := newTestServer(t)
+
+	core, logs := observer.New(zapcore.InfoLevel)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f, err := Connect(
+		ctx,
+		fromRPC(randomMember("local")),
+		[]string{server1.addr},
+		WithLogger(zap.New(core)),
+		WithReconnectBackoff(Backoff{
+			Initial: time.Millisecond * 10,
+			Max:     time.Millisecond * 10,
+		}),
+	)
+	require.NoError(t, err)
+	defer f.Close()
+
+	// Wait for the initial connection to be logged before reconnecting.
+	require.Eventually(t, func() bool {
+		return logs.FilterMessage("connected").Len() == 1
+	}, time.Second*5, time.Millisecond*10)
+
+	// Stopping the server should reconnect to the other server.
+	require.NoError(t, f.UpdateSeeds([]string{server1.addr, server2.addr}))
+	server1.Stop()
+
+	var connected []observer.LoggedEntry
+	require.Eventually(t, func() bool {
+		connected = logs.FilterMessage("connected").All()
+		return len(connected) == 2
+	}, time.Second*5, time.Millisecond*10)
+
+	initial := connected[0].ContextMap()
+	assert.Equal(t, server1.addr, initial["addr"])
+	assert.Equal(t, int64(0), initial["reconnect"])
+	assert.Equal(t, int64(1), initial["attempts"])
+
+	reconnect := connected[1].ContextMap()
+	assert.Equal(t, server2.addr, reconnect["addr"])
+	assert.Equal(t, int64(1), reconnect["reconnect"])
+	assert.GreaterOrEqual(t, reconnect["attempts"], int64(1))
+
+	disconnected := logs.FilterMessage("disconnected").All()
+	require.Len(t, disconnected, 1)
+	assert.Equal(t, server1.addr, disconnected[0].ContextMap()["addr"])
+}
+
+func TestFuddle_HealthyStalledStream(t *testing.T) {
+	server, err := fuddletest.NewServer()
+	require.NoError(t, err)
+	defer server.Close()
+
+	clock := newFakeClock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f, err := Connect(
+		ctx,
+		fromRPC(randomMember("local")),
+		[]string{server.Addr()},
+		WithStalenessThreshold(time.Second*10),
+		withClock(clock),
+	)
+	require.NoError(t, err)
+	defer f.Close()
+
+	// Wait to receive the local member from the server.
+	<-f.SyncedChan()
+	assert.True(t, f.Healthy())
+
+	// With no updates the stream is stale once the threshold is crossed.
+	clock.Advance(time.Second * 10)
+	assert.True(t, f.Healthy())
+	clock.Advance(time.Second)
+	assert.False(t, f.Healthy())
+
+	// Once an update is received the client is healthy again.
+	other, err := Connect(ctx, fromRPC(randomMember("other")), []string{server.Addr()})
+	require.NoError(t, err)
+	defer other.Close()
+
+	assert.Eventually(t, func() bool {
+		return f.Healthy()
+	}, time.Second, time.Millisecond)
+}
+
+func TestFuddle_HealthyDisconnected(t *testing.T) {
+	server := newTestServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f, err := Connect(ctx, fromRPC(randomMember("local")), []string{server.addr})
+	require.NoError(t, err)
+	defer f.Close()
+
+	// Without a staleness threshold the client is healthy while connected.
+	assert.True(t, f.Healthy())
+
+	server.Stop()
+	assert.Eventually(t, func() bool {
+		return !f.Healthy()
+	}, time.Second, time.Millisecond)
+
+	assert.False(t, NewOffline(nil).Healthy())
+}
+
+func TestFuddle_WaitForReady(t *testing.T) {
+	server1 := newTestServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f, err := Connect(
+		ctx,
+		fromRPC(randomMember("local")),
+		[]string{server1.addr},
+		WithReconnectBackoff(Backoff{
+			Initial: time.Millisecond * 10,
+			Max:     time.Millisecond * 10,
+		}),
+	)
+	require.NoError(t, err)
+	defer f.Close()
+
+	require.NoError(t, f.WaitForReady(ctx))
+
+	server1.Stop()
+	require.Eventually(t, func() bool {
+		return f.ConnState() == StateDisconnected
+	}, time.Second, time.Millisecond)
+
+	// Waiting while disconnected should block until the context is
+	// cancelled.
+	timeoutCtx, timeoutCancel := context.WithTimeout(ctx, time.Millisecond*50)
+	defer timeoutCancel()
+	assert.ErrorIs(t, f.WaitForReady(timeoutCtx), context.DeadlineExceeded)
+
+	// Wait from multiple goroutines, which should all unblock once
+	// reconnected.
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for i := 0; i != 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- f.WaitForReady(ctx)
+		}()
+	}
+
+	server2 := newTestServer(t)
+	require.NoError(t, f.UpdateSeeds([]string{server2.addr}))
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, StateConnected, f.ConnState())
+}
+
+func TestFuddle_WaitForReadyClosed(t *testing.T) {
+	f := newFuddle(fromRPC(randomMember("local")), defaultOptions())
+	f.cancel()
+
+	assert.Error(t, f.WaitForReady(context.Background()))
+}
+
+func TestFuddle_SubscribeFilterInvalid(t *testing.T) {
+	f := newFuddle(fromRPC(randomMember("local")), defaultOptions())
+
+	_, err := f.SubscribeFilter(&Filter{"": {}}, func() {})
+	assert.Error(t, err)
+	assert.Equal(t, 0, numSubscribers(f.registry))
+
+	unsub, err := f.SubscribeFilter(&Filter{"*": {}}, func() {})
+	require.NoError(t, err)
+	assert.Equal(t, 1, numSubscribers(f.registry))
+	unsub()
+}
+
+func TestFuddle_ConnectInvalidMember(t *testing.T) {
+	server := newTestServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	member := fromRPC(randomMember(""))
+	member.ID = ""
+	_, err := Connect(ctx, member, []string{server.addr})
+	assert.Error(t, err)
+}
+
+func TestFuddle_RegisterInvalidMember(t *testing.T) {
+	server := newTestServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f, err := Connect(ctx, fromRPC(randomMember("local-1")), []string{server.addr})
+	require.NoError(t, err)
+	defer f.Close()
+
+	member := fromRPC(randomMember("local-2"))
+	member.Service = ""
+	_, err = f.Register(ctx, member)
+	assert.Error(t, err)
+
+	_, ok := f.Member("local-2")
+	assert.False(t, ok)
+}
+
+func TestFuddle_Snapshot(t *testing.T) {
+	localMember := randomMember("local")
+	f := newFuddle(fromRPC(localMember), defaultOptions())
+	defer f.cancel()
+
+	member1 := randomMember("member-1")
+	member2 := randomMember("member-2")
+	for _, m := range []*rpc.MemberState{member1, member2, randomMember("member-3")} {
+		f.registry.RemoteUpdate(&rpc.Member2{
+			State:    m,
+			Liveness: rpc.Liveness_UP,
+		})
+	}
+	updatedMember1 := randomMember("member-1")
+	f.registry.RemoteUpdate(&rpc.Member2{
+		State:    updatedMember1,
+		Liveness: rpc.Liveness_UP,
+	})
+	f.registry.RemoteUpdate(&rpc.Member2{
+		State:    randomMember("member-3"),
+		Liveness: rpc.Liveness_LEFT,
+	})
+
+	snapshot := f.Snapshot()
+	assert.Equal(t, []Member{
+		fromRPC(localMember), fromRPC(updatedMember1), fromRPC(member2),
+	}, snapshot)
+
+	// The snapshot must be a copy.
+	snapshot[0].Metadata["foo"] = "bar"
+	m, _ := f.Member("local")
+	assert.NotContains(t, m.Metadata, "foo")
+}
+
+func TestFuddle_NewOffline(t *testing.T) {
+	orders := fromRPC(randomMember("orders-1"))
+	orders.Service = "orders"
+	payments := fromRPC(randomMember("payments-1"))
+	payments.Service = "payments"
+
+	f := NewOffline([]Member{payments, orders})
+	defer f.Close()
+
+	assert.Equal(t, []Member{orders, payments}, f.Snapshot())
+	assert.Equal(t, []Member{orders}, f.Members(WithFilter(&Filter{
+		"orders": {},
+	})))
+
+	// Replaying the snapshot must give the same registry.
+	assert.Equal(t, f.Snapshot(), NewOffline(f.Snapshot()).Snapshot())
+}
+
+func TestFuddle_SyncedChan(t *testing.T) {
+	server := newTestServer(t)
+	remote := randomMember("remote")
+	server.AddMember(&rpc.Member2{
+		State:    remote,
+		Liveness: rpc.Liveness_UP,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f, err := Connect(ctx, fromRPC(randomMember("local")), []string{server.addr})
+	require.NoError(t, err)
+	defer f.Close()
+
+	select {
+	case <-f.SyncedChan():
+	case <-time.After(time.Second):
+		t.Fatal("client not synced")
+	}
+
+	// Once synced the first member streamed must be in the registry.
+	m, ok := f.Member("remote")
+	require.True(t, ok)
+	assert.Equal(t, fromRPC(remote), m)
+}
+
+func TestFuddle_SyncedChanNoUpdates(t *testing.T) {
+	server := newTestServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f, err := Connect(ctx, fromRPC(randomMember("local")), []string{server.addr})
+	require.NoError(t, err)
+	defer f.Close()
+
+	// The server never sends an update so the client must not be synced.
+	select {
+	case <-f.SyncedChan():
+		t.Fatal("client synced without receiving an update")
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+func TestFuddle_SyncedChanOffline(t *testing.T) {
+	f := NewOffline(nil)
+	defer f.Close()
+
+	select {
+	case <-f.SyncedChan():
+	default:
+		t.Fatal("offline client not synced")
+	}
+}
+
+func TestFuddle_WaitForInitialSync(t *testing.T) {
+	server, err := fuddletest.NewServer()
+	require.NoError(t, err)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	remote, err := Connect(ctx, fromRPC(randomMember("remote")), []string{server.Addr()})
+	require.NoError(t, err)
+	defer remote.Close()
+
+	// Connect as an observer so the remote member is the only member
+	// streamed, since members streamed after the first may be missing
+	// once synced.
+	f, err := Connect(ctx, Member{}, []string{server.Addr()})
+	require.NoError(t, err)
+	defer f.Close()
+
+	require.NoError(t, f.WaitForInitialSync(ctx))
+
+	// Once synced the client must know about the members registered
+	// before it connected.
+	_, ok := f.Member("remote")
+	assert.True(t, ok)
+
+	// Returns immediately once synced, even if the context is cancelled.
+	cancelledCtx, cancelCtx := context.WithCancel(context.Background())
+	cancelCtx()
+	assert.NoError(t, f.WaitForInitialSync(cancelledCtx))
+}
+
+func TestFuddle_WaitForInitialSyncDeadlineExceeded(t *testing.T) {
+	// The test server never sends an update, so the client never syncs.
+	server := newTestServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f, err := Connect(ctx, fromRPC(randomMember("local")), []string{server.addr})
+	require.NoError(t, err)
+	defer f.Close()
+
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer waitCancel()
+
+	err = f.WaitForInitialSync(waitCtx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestFuddle_WaitForInitialSyncClosed(t *testing.T) {
+	f := newFuddle(fromRPC(randomMember("local")), defaultOptions())
+	f.cancel()
+
+	assert.ErrorIs(t, f.WaitForInitialSync(context.Background()), ErrNotConnected)
+}
+
+func TestFuddle_CloseWithContext(t *testing.T) {
+	server := newTestServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f, err := Connect(ctx, fromRPC(randomMember("local")), []string{server.addr})
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return len(receivedIDs(server, rpc.ClientUpdateType_CLIENT_REGISTER)) == 1
+	}, time.Second, time.Millisecond)
+
+	require.NoError(t, f.CloseWithContext(ctx))
+
+	assert.Eventually(t, func() bool {
+		return len(receivedIDs(server, rpc.ClientUpdateType_CLIENT_UNREGISTER)) == 1
+	}, time.Second, time.Millisecond)
+}
+
+func TestFuddle_CloseWithContextUnresponsive(t *testing.T) {
+	f := NewOffline(nil)
+
+	// Register a member whose stream blocks sending the unregister, as if
+	// the connection were unresponsive.
+	stream := &blockingRegisterStream{
+		unblock: make(chan struct{}),
+	}
+	defer close(stream.unblock)
+	startHeartbeats(f, stream)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+
+	start := time.Now()
+	err := f.CloseWithContext(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestFuddle_CloseConcurrent(t *testing.T) {
+	server := newTestServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f, err := Connect(ctx, fromRPC(randomMember("local")), []string{server.addr})
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i != 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f.Close()
+		}()
+	}
+	wg.Wait()
+
+	// Closing again once closed must return immediately.
+	assert.NoError(t, f.CloseWithContext(ctx))
+}
+
+func TestFuddle_CloseReconnecting(t *testing.T) {
+	server := newTestServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f, err := Connect(
+		ctx,
+		fromRPC(randomMember("local")),
+		[]string{server.addr},
+		WithReconnectBackoff(Backoff{
+			Initial:    time.Millisecond,
+			Max:        time.Millisecond,
+			Multiplier: 1,
+		}),
+	)
+	require.NoError(t, err)
+
+	// Close while the client is trying to reconnect.
+	server.Stop()
+	require.Eventually(t, func() bool {
+		return f.ConnState() == StateDisconnected
+	}, time.Second, time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		f.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("close blocked")
+	}
+}
+
+// blockingRegisterStream is a register stream whose Send blocks until
+// unblock is closed.
+type blockingRegisterStream struct {
+	rpc.ClientWriteRegistry_RegisterClient
+
+	unblock chan struct{}
+}
+
+func (s *blockingRegisterStream) Send(*rpc.ClientUpdate) error {
+	<-s.unblock
+	return nil
+}
+
+func numSubscribers(r *registry) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.subscribers.len()
+}
+
+func selfSignedCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "fuddle"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, pool
+}
+
+// receivedIDs returns the member IDs of the client updates with the given
+// type received by the server.
+func receivedIDs(s *testServer, updateType rpc.ClientUpdateType) []string {
+	var ids []string
+	for _, update := range s.Received() {
+		if update.UpdateType == updateType {
+			ids = append(ids, update.Member.Id)
+		}
+	}
+	return ids
+}
+
+type testMetrics struct {
+	reconnects      *atomic.Int64
+	heartbeatErrors *atomic.Int64
+	updatesReceived *atomic.Int64
+	members         *atomic.Int64
+	connState       *atomic.String
+}
+
+func newTestMetrics() *testMetrics {
+	return &testMetrics{
+		reconnects:      atomic.NewInt64(0),
+		heartbeatErrors: atomic.NewInt64(0),
+		updatesReceived: atomic.NewInt64(0),
+		members:         atomic.NewInt64(0),
+		connState:       atomic.NewString(""),
+	}
+}
+
+func (m *testMetrics) Reconnect() {
+	m.reconnects.Inc()
+}
+
+func (m *testMetrics) HeartbeatError() {
+	m.heartbeatErrors.Inc()
+}
+
+func (m *testMetrics) UpdateReceived() {
+	m.updatesReceived.Inc()
+}
+
+func (m *testMetrics) Members(n int) {
+	m.members.Store(int64(n))
+}
+
+func (m *testMetrics) ConnState(state ConnState) {
+	m.connState.Store(string(state))
+}
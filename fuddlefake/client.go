@@ -0,0 +1,121 @@
+// Package fuddlefake provides a fake fuddle.Client for testing code that
+// depends on fuddle.Client, without running a Fuddle server.
+//
+// Use fuddletest instead to test against an in-memory Fuddle server.
+package fuddlefake
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	fuddle "github.com/fuddle-io/fuddle-go"
+)
+
+// Client is a fake fuddle.Client whose registry is populated by the test
+// using AddMember and RemoveMember, which notify subscribers the same as the
+// real client.
+//
+// The client is backed by an offline client (see fuddle.NewOffline), so
+// members registered with Register are added to the registry and the
+// returned LocalNode can be updated as normal.
+type Client struct {
+	client *fuddle.Fuddle
+
+	// nodes contains the members added with AddMember, keyed by member ID.
+	nodes map[string]*fuddle.LocalNode
+	// connState is the connection state returned by ConnState.
+	connState fuddle.ConnState
+
+	// mu protects the above fields.
+	mu sync.Mutex
+}
+
+// New returns a fake client whose registry contains the given members. The
+// client starts in fuddle.StateConnected.
+func New(members ...fuddle.Member) (*Client, error) {
+	c := &Client{
+		client:    fuddle.NewOffline(nil),
+		nodes:     make(map[string]*fuddle.LocalNode),
+		connState: fuddle.StateConnected,
+	}
+	for _, m := range members {
+		if err := c.AddMember(m); err != nil {
+			c.client.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Members returns the members in the registry sorted by ID.
+func (c *Client) Members(opts ...fuddle.MembersOption) []fuddle.Member {
+	return c.client.Members(opts...)
+}
+
+// Subscribe subscribes to updates when the registry changes, such as when
+// a member is added with AddMember.
+func (c *Client) Subscribe(cb func(), opts ...fuddle.SubscribeOption) func() {
+	return c.client.Subscribe(cb, opts...)
+}
+
+// Register adds the member to the registry.
+func (c *Client) Register(ctx context.Context, member fuddle.Member) (*fuddle.LocalNode, error) {
+	return c.client.Register(ctx, member)
+}
+
+// ConnState returns the connection state set with SetConnState.
+func (c *Client) ConnState() fuddle.ConnState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.connState
+}
+
+// SetConnState sets the connection state returned by ConnState.
+func (c *Client) SetConnState(state fuddle.ConnState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.connState = state
+}
+
+// AddMember adds the member to the registry, or replaces the member if a
+// member with the same ID was already added.
+func (c *Client) AddMember(member fuddle.Member) error {
+	c.RemoveMember(member.ID)
+
+	node, err := c.client.Register(context.Background(), member)
+	if err != nil {
+		return fmt.Errorf("fuddlefake: add member: %w", err)
+	}
+
+	c.mu.Lock()
+	c.nodes[member.ID] = node
+	c.mu.Unlock()
+
+	return nil
+}
+
+// RemoveMember removes the member with the given ID from the registry.
+// Members that don't exist are ignored.
+func (c *Client) RemoveMember(id string) {
+	c.mu.Lock()
+	node, ok := c.nodes[id]
+	delete(c.nodes, id)
+	c.mu.Unlock()
+
+	if ok {
+		//nolint
+		node.Unregister()
+	}
+}
+
+// Close closes the client, and sets the connection state to
+// fuddle.StateDisconnected.
+func (c *Client) Close() {
+	c.client.Close()
+	c.SetConnState(fuddle.StateDisconnected)
+}
+
+var _ fuddle.Client = (*Client)(nil)
@@ -0,0 +1,74 @@
+package fuddlefake
+
+import (
+	"context"
+	"testing"
+
+	fuddle "github.com/fuddle-io/fuddle-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_Members(t *testing.T) {
+	orders := fuddle.Member{ID: "orders-1", Service: "orders"}
+	c, err := New(orders)
+	require.NoError(t, err)
+	defer c.Close()
+
+	assert.Equal(t, []fuddle.Member{orders}, c.Members())
+
+	var notifications int
+	unsub := c.Subscribe(func() {
+		notifications++
+	})
+	defer unsub()
+	// Subscribers are notified on bootstrap.
+	assert.Equal(t, 1, notifications)
+
+	payments := fuddle.Member{ID: "payments-1", Service: "payments"}
+	require.NoError(t, c.AddMember(payments))
+	assert.Equal(t, []fuddle.Member{orders, payments}, c.Members())
+	assert.Equal(t, 2, notifications)
+
+	c.RemoveMember("orders-1")
+	assert.Equal(t, []fuddle.Member{payments}, c.Members())
+	assert.Equal(t, 3, notifications)
+
+	// Members must be filtered the same as the real client.
+	assert.Equal(t, []fuddle.Member{payments}, c.Members(fuddle.WithFilter(&fuddle.Filter{
+		"payments": {},
+	})))
+}
+
+func TestClient_Register(t *testing.T) {
+	c, err := New()
+	require.NoError(t, err)
+	defer c.Close()
+
+	node, err := c.Register(context.Background(), fuddle.Member{
+		ID:      "orders-1",
+		Service: "orders",
+	})
+	require.NoError(t, err)
+	require.NoError(t, node.UpdateStatus(context.Background(), "active"))
+
+	members := c.Members()
+	require.Equal(t, 1, len(members))
+	assert.Equal(t, "active", members[0].Status)
+}
+
+func TestClient_ConnState(t *testing.T) {
+	c, err := New()
+	require.NoError(t, err)
+
+	// Code under test depends on the interface rather than *fuddle.Fuddle.
+	var client fuddle.Client = c
+	assert.Equal(t, fuddle.StateConnected, client.ConnState())
+
+	c.SetConnState(fuddle.StateDisconnected)
+	assert.Equal(t, fuddle.StateDisconnected, client.ConnState())
+
+	c.SetConnState(fuddle.StateConnected)
+	client.Close()
+	assert.Equal(t, fuddle.StateDisconnected, client.ConnState())
+}
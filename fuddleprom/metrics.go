@@ -0,0 +1,86 @@
+// Package fuddleprom records Prometheus metrics for the Fuddle client.
+//
+// This is a separate package so users who don't record metrics don't depend
+// on Prometheus.
+package fuddleprom
+
+import (
+	fuddle "github.com/fuddle-io/fuddle-go"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics records Prometheus metrics for the Fuddle client. Pass to the
+// client using fuddle.WithMetrics.
+type Metrics struct {
+	reconnects      prometheus.Counter
+	heartbeatErrors prometheus.Counter
+	updatesReceived prometheus.Counter
+	members         prometheus.Gauge
+	connState       prometheus.Gauge
+}
+
+// NewMetrics creates the client metrics and registers them with the given
+// registerer.
+func NewMetrics(registerer prometheus.Registerer) (*Metrics, error) {
+	m := &Metrics{
+		reconnects: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "fuddle_reconnects_total",
+			Help: "Number of times the client reconnected.",
+		}),
+		heartbeatErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "fuddle_heartbeat_errors_total",
+			Help: "Number of heartbeats the client failed to send.",
+		}),
+		updatesReceived: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "fuddle_updates_received_total",
+			Help: "Number of registry updates received.",
+		}),
+		members: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "fuddle_members",
+			Help: "Number of members in the registry.",
+		}),
+		connState: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "fuddle_connection_state",
+			Help: "Connection state, where 1 is connected and 0 is disconnected.",
+		}),
+	}
+
+	for _, c := range []prometheus.Collector{
+		m.reconnects,
+		m.heartbeatErrors,
+		m.updatesReceived,
+		m.members,
+		m.connState,
+	} {
+		if err := registerer.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *Metrics) Reconnect() {
+	m.reconnects.Inc()
+}
+
+func (m *Metrics) HeartbeatError() {
+	m.heartbeatErrors.Inc()
+}
+
+func (m *Metrics) UpdateReceived() {
+	m.updatesReceived.Inc()
+}
+
+func (m *Metrics) Members(n int) {
+	m.members.Set(float64(n))
+}
+
+func (m *Metrics) ConnState(state fuddle.ConnState) {
+	if state == fuddle.StateConnected {
+		m.connState.Set(1)
+	} else {
+		m.connState.Set(0)
+	}
+}
+
+var _ fuddle.Metrics = &Metrics{}
@@ -0,0 +1,47 @@
+package fuddleprom
+
+import (
+	"testing"
+
+	fuddle "github.com/fuddle-io/fuddle-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	m, err := NewMetrics(prometheus.NewRegistry())
+	require.NoError(t, err)
+
+	m.Reconnect()
+	m.Reconnect()
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.reconnects))
+
+	m.HeartbeatError()
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.heartbeatErrors))
+
+	m.UpdateReceived()
+	m.UpdateReceived()
+	m.UpdateReceived()
+	assert.Equal(t, 3.0, testutil.ToFloat64(m.updatesReceived))
+
+	m.Members(5)
+	assert.Equal(t, 5.0, testutil.ToFloat64(m.members))
+	m.Members(3)
+	assert.Equal(t, 3.0, testutil.ToFloat64(m.members))
+
+	m.ConnState(fuddle.StateConnected)
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.connState))
+	m.ConnState(fuddle.StateDisconnected)
+	assert.Equal(t, 0.0, testutil.ToFloat64(m.connState))
+}
+
+func TestMetrics_RegisterTwice(t *testing.T) {
+	registry := prometheus.NewRegistry()
+
+	_, err := NewMetrics(registry)
+	require.NoError(t, err)
+	_, err = NewMetrics(registry)
+	assert.Error(t, err)
+}
@@ -0,0 +1,254 @@
+// Package fuddletest provides an in-memory Fuddle server for testing clients
+// without running a Fuddle cluster.
+package fuddletest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	rpc "github.com/fuddle-io/fuddle-rpc/go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// ownerID is the owner of the member versions created by the server.
+const ownerID = "fuddletest"
+
+// Server is an in-process Fuddle server backed by an in-memory registry.
+//
+// Members registered by a client are streamed to all subscribed clients.
+// Members are marked as left when they are unregistered or their register
+// stream closes. Heartbeats are accepted but members never expire.
+type Server struct {
+	rpc.UnimplementedClientReadRegistryServer
+	rpc.UnimplementedClientWriteRegistryServer
+
+	grpcServer *grpc.Server
+	addr       string
+
+	// members contains the members in the registry, keyed by member ID.
+	members map[string]*rpc.Member2
+	// subscribers contains the subscribers to updates in the registry.
+	subscribers map[*subscriber]struct{}
+	// counter is incremented for each member version.
+	counter uint64
+
+	// mu protects the above fields.
+	mu sync.Mutex
+}
+
+// NewServer starts a server listening on a random local port. Connect to
+// the server using Server.Addr, and close it with Server.Close.
+func NewServer(opts ...grpc.ServerOption) (*Server, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("fuddletest: listen: %w", err)
+	}
+
+	s := &Server{
+		grpcServer:  grpc.NewServer(opts...),
+		addr:        ln.Addr().String(),
+		members:     make(map[string]*rpc.Member2),
+		subscribers: make(map[*subscriber]struct{}),
+	}
+	rpc.RegisterClientReadRegistryServer(s.grpcServer, s)
+	rpc.RegisterClientWriteRegistryServer(s.grpcServer, s)
+
+	go func() {
+		//nolint
+		s.grpcServer.Serve(ln)
+	}()
+
+	return s, nil
+}
+
+// Addr returns the address the server is listening on.
+func (s *Server) Addr() string {
+	return s.addr
+}
+
+// Snapshot returns a copy of the members in the registry, including members
+// that have left.
+func (s *Server) Snapshot() []*rpc.Member2 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	members := make([]*rpc.Member2, 0, len(s.members))
+	for _, m := range s.members {
+		members = append(members, proto.Clone(m).(*rpc.Member2))
+	}
+	return members
+}
+
+// Close stops the server and closes all open streams.
+func (s *Server) Close() {
+	s.grpcServer.Stop()
+}
+
+func (s *Server) Updates(req *rpc.SubscribeRequest, stream rpc.ClientReadRegistry_UpdatesServer) error {
+	sub := newSubscriber()
+
+	// Queue the members the client doesn't know about and add the subscriber
+	// under the same lock, so no updates are missed.
+	s.mu.Lock()
+	for id, m := range s.members {
+		if known, ok := req.KnownMembers[id]; ok && proto.Equal(known, m.Version) {
+			continue
+		}
+		sub.push(m)
+	}
+	s.subscribers[sub] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.subscribers, sub)
+		s.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-sub.notify:
+		}
+
+		for _, m := range sub.pop() {
+			if err := stream.Send(m); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *Server) Member(ctx context.Context, req *rpc.MemberRequest) (*rpc.MemberResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.members[req.Id]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "member not found: %s", req.Id)
+	}
+	return &rpc.MemberResponse{
+		Member: proto.Clone(m).(*rpc.Member2),
+	}, nil
+}
+
+func (s *Server) Members(ctx context.Context, req *rpc.MembersRequest) (*rpc.MembersResponse, error) {
+	return &rpc.MembersResponse{
+		Members: s.Snapshot(),
+	}, nil
+}
+
+func (s *Server) Register(stream rpc.ClientWriteRegistry_RegisterServer) error {
+	// registered contains the IDs of the members registered on the stream.
+	registered := make(map[string]struct{})
+	defer func() {
+		// Members registered on the stream leave once it closes.
+		for id := range registered {
+			s.leave(id)
+		}
+	}()
+
+	for {
+		update, err := stream.Recv()
+		if err != nil {
+			return nil
+		}
+
+		switch update.UpdateType {
+		case rpc.ClientUpdateType_CLIENT_REGISTER:
+			if update.Member == nil {
+				continue
+			}
+			registered[update.Member.Id] = struct{}{}
+			s.update(update.Member, rpc.Liveness_UP)
+		case rpc.ClientUpdateType_CLIENT_UNREGISTER:
+			if update.Member == nil {
+				continue
+			}
+			delete(registered, update.Member.Id)
+			s.leave(update.Member.Id)
+		}
+	}
+}
+
+// leave marks the member with the given ID as left.
+func (s *Server) leave(id string) {
+	s.mu.Lock()
+	m, ok := s.members[id]
+	s.mu.Unlock()
+
+	if !ok || m.Liveness == rpc.Liveness_LEFT {
+		return
+	}
+	s.update(m.State, rpc.Liveness_LEFT)
+}
+
+// update sets the members state and liveness with a new version, and
+// streams the member to the subscribers.
+func (s *Server) update(state *rpc.MemberState, liveness rpc.Liveness) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.counter++
+	m := &rpc.Member2{
+		State:    proto.Clone(state).(*rpc.MemberState),
+		Liveness: liveness,
+		Version: &rpc.Version2{
+			OwnerId: ownerID,
+			Timestamp: &rpc.MonotonicTimestamp{
+				Timestamp: time.Now().UnixMilli(),
+				Counter:   s.counter,
+			},
+		},
+	}
+	s.members[state.Id] = m
+
+	for sub := range s.subscribers {
+		sub.push(m)
+	}
+}
+
+// subscriber queues the updates to stream to a subscribed client, so
+// updating the registry doesn't block on a slow client.
+type subscriber struct {
+	queue []*rpc.Member2
+	// notify is signalled when updates are queued.
+	notify chan struct{}
+
+	// mu protects the above fields.
+	mu sync.Mutex
+}
+
+func newSubscriber() *subscriber {
+	return &subscriber{
+		notify: make(chan struct{}, 1),
+	}
+}
+
+func (s *subscriber) push(m *rpc.Member2) {
+	s.mu.Lock()
+	s.queue = append(s.queue, m)
+	s.mu.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+		// A notification is already pending.
+	}
+}
+
+func (s *subscriber) pop() []*rpc.Member2 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queue := s.queue
+	s.queue = nil
+	return queue
+}
@@ -0,0 +1,98 @@
+package fuddletest_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	fuddle "github.com/fuddle-io/fuddle-go"
+	"github.com/fuddle-io/fuddle-go/fuddletest"
+	rpc "github.com/fuddle-io/fuddle-rpc/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_RegisterAndSubscribe(t *testing.T) {
+	server, err := fuddletest.NewServer()
+	require.NoError(t, err)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	orders := fuddle.Member{
+		ID:       "orders-1",
+		Service:  "orders",
+		Locality: fuddle.Locality{Region: "eu-west-2"},
+		Started:  time.Now().UnixMilli(),
+		Revision: "v0.1.0",
+		Metadata: map[string]string{"addr.http": "10.0.0.1:8080"},
+	}
+	ordersClient, err := fuddle.Connect(ctx, orders, []string{server.Addr()})
+	require.NoError(t, err)
+
+	payments := fuddle.Member{
+		ID:       "payments-1",
+		Service:  "payments",
+		Locality: fuddle.Locality{Region: "eu-west-2"},
+		Started:  time.Now().UnixMilli(),
+		Revision: "v0.1.0",
+	}
+	paymentsClient, err := fuddle.Connect(ctx, payments, []string{server.Addr()})
+	require.NoError(t, err)
+	defer paymentsClient.Close()
+
+	// Each client must observe the member registered by the other.
+	assert.Eventually(t, func() bool {
+		m, ok := paymentsClient.Member("orders-1")
+		return ok && m.Equal(orders)
+	}, time.Second, time.Millisecond)
+	assert.Eventually(t, func() bool {
+		m, ok := ordersClient.Member("payments-1")
+		return ok && m.Equal(payments)
+	}, time.Second, time.Millisecond)
+
+	// Once the client closes, the member must leave.
+	ordersClient.Close()
+	assert.Eventually(t, func() bool {
+		_, ok := paymentsClient.Member("orders-1")
+		return !ok
+	}, time.Second, time.Millisecond)
+}
+
+func TestServer_Unregister(t *testing.T) {
+	server, err := fuddletest.NewServer()
+	require.NoError(t, err)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f, err := fuddle.Connect(ctx, fuddle.Member{
+		ID:      "orders-1",
+		Service: "orders",
+	}, []string{server.Addr()})
+	require.NoError(t, err)
+	defer f.Close()
+
+	node, err := f.Register(ctx, fuddle.Member{
+		ID:      "orders-2",
+		Service: "orders",
+	})
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return len(server.Snapshot()) == 2
+	}, time.Second, time.Millisecond)
+
+	require.NoError(t, node.Unregister())
+
+	assert.Eventually(t, func() bool {
+		for _, m := range server.Snapshot() {
+			if m.State.Id == "orders-2" {
+				return m.Liveness == rpc.Liveness_LEFT
+			}
+		}
+		return false
+	}, time.Second, time.Millisecond)
+}
@@ -0,0 +1,173 @@
+package resolvers
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc/resolver"
+)
+
+// LookupSRVFunc looks up the SRV records for the given name, with the same
+// signature as net.Resolver.LookupSRV.
+type LookupSRVFunc func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+
+// SRVResolverBuilder builds a resolver that discovers the Fuddle servers by
+// looking up the SRV records of a name, such as '_fuddle._tcp.example.com'.
+type SRVResolverBuilder struct {
+	name   string
+	lookup LookupSRVFunc
+
+	// resolvers contains the resolvers built by the builder, which look up
+	// the SRV records again when ResolveNow is called.
+	resolvers map[*SRVResolver]interface{}
+
+	// mu protects the above fields.
+	mu sync.Mutex
+}
+
+// NewSRVResolverBuilder returns a builder that resolves the SRV records for
+// the given name. If lookup is nil it defaults to net.DefaultResolver.
+func NewSRVResolverBuilder(name string, lookup LookupSRVFunc) *SRVResolverBuilder {
+	if lookup == nil {
+		lookup = net.DefaultResolver.LookupSRV
+	}
+	return &SRVResolverBuilder{
+		name:      name,
+		lookup:    lookup,
+		resolvers: make(map[*SRVResolver]interface{}),
+	}
+}
+
+func (s *SRVResolverBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &SRVResolver{
+		name:       s.name,
+		lookup:     s.lookup,
+		cc:         cc,
+		resolveNow: make(chan struct{}, 1),
+		ctx:        ctx,
+		cancel:     cancel,
+		builder:    s,
+	}
+
+	s.mu.Lock()
+	s.resolvers[r] = struct{}{}
+	s.mu.Unlock()
+
+	r.start()
+	return r, nil
+}
+
+func (s *SRVResolverBuilder) Scheme() string {
+	return "srv"
+}
+
+// ResolveNow looks up the SRV records again in all resolvers built by the
+// builder, such as to discover a different node when the connected node is
+// shutting down.
+func (s *SRVResolverBuilder) ResolveNow() {
+	s.mu.Lock()
+	var resolvers []*SRVResolver
+	for r := range s.resolvers {
+		resolvers = append(resolvers, r)
+	}
+	s.mu.Unlock()
+
+	for _, r := range resolvers {
+		r.ResolveNow(resolver.ResolveNowOptions{})
+	}
+}
+
+func (s *SRVResolverBuilder) remove(r *SRVResolver) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.resolvers, r)
+}
+
+type SRVResolver struct {
+	name   string
+	lookup LookupSRVFunc
+	cc     resolver.ClientConn
+
+	// resolveNow is used to trigger a new lookup.
+	resolveNow chan struct{}
+
+	ctx    context.Context
+	cancel func()
+	wg     sync.WaitGroup
+
+	builder *SRVResolverBuilder
+}
+
+func (s *SRVResolver) start() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.watch()
+	}()
+}
+
+func (s *SRVResolver) ResolveNow(resolver.ResolveNowOptions) {
+	select {
+	case s.resolveNow <- struct{}{}:
+	default:
+		// A lookup is already pending.
+	}
+}
+
+func (s *SRVResolver) Close() {
+	s.builder.remove(s)
+	s.cancel()
+	s.wg.Wait()
+}
+
+// watch looks up the SRV records when started and each time ResolveNow is
+// called, until the resolver is closed.
+func (s *SRVResolver) watch() {
+	for {
+		s.resolve()
+
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-s.resolveNow:
+		}
+	}
+}
+
+func (s *SRVResolver) resolve() {
+	_, records, err := s.lookup(s.ctx, "", "", s.name)
+	if err != nil {
+		s.cc.ReportError(fmt.Errorf("lookup srv: %s: %w", s.name, err))
+		return
+	}
+	if len(records) == 0 {
+		s.cc.ReportError(fmt.Errorf("lookup srv: %s: no records", s.name))
+		return
+	}
+
+	var addrs []resolver.Address
+	for _, record := range records {
+		host := strings.TrimSuffix(record.Target, ".")
+		addrs = append(addrs, resolver.Address{
+			Addr:       net.JoinHostPort(host, strconv.Itoa(int(record.Port))),
+			ServerName: host,
+		})
+	}
+	// Since we use a 'first pick' load balancer, shuffle the addrs so
+	// multiple clients don't all try the same node.
+	rand.Shuffle(len(addrs), func(i, j int) {
+		addrs[i], addrs[j] = addrs[j], addrs[i]
+	})
+
+	//nolint
+	s.cc.UpdateState(resolver.State{Addresses: addrs})
+}
+
+var _ resolver.Builder = &SRVResolverBuilder{}
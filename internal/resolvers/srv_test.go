@@ -0,0 +1,170 @@
+package resolvers
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/resolver"
+)
+
+func TestSRVResolver_Resolve(t *testing.T) {
+	lookup := func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		assert.Equal(t, "_fuddle._tcp.example.com", name)
+		return "", []*net.SRV{
+			{Target: "node-1.example.com.", Port: 8220},
+			{Target: "node-2.example.com.", Port: 8221},
+		}, nil
+	}
+
+	cc := &fakeClientConn{}
+	r, err := NewSRVResolverBuilder("_fuddle._tcp.example.com", lookup).Build(
+		resolver.Target{}, cc, resolver.BuildOptions{},
+	)
+	require.NoError(t, err)
+	defer r.Close()
+
+	require.Eventually(t, func() bool {
+		return len(cc.Addresses()) > 0
+	}, time.Second, time.Millisecond)
+	assert.ElementsMatch(t, []resolver.Address{
+		{Addr: "node-1.example.com:8220", ServerName: "node-1.example.com"},
+		{Addr: "node-2.example.com:8221", ServerName: "node-2.example.com"},
+	}, cc.Addresses())
+}
+
+func TestSRVResolver_ResolveNow(t *testing.T) {
+	var mu sync.Mutex
+	port := uint16(8220)
+	lookup := func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return "", []*net.SRV{
+			{Target: "node-1.example.com.", Port: port},
+		}, nil
+	}
+
+	cc := &fakeClientConn{}
+	r, err := NewSRVResolverBuilder("_fuddle._tcp.example.com", lookup).Build(
+		resolver.Target{}, cc, resolver.BuildOptions{},
+	)
+	require.NoError(t, err)
+	defer r.Close()
+
+	require.Eventually(t, func() bool {
+		return len(cc.Addresses()) > 0
+	}, time.Second, time.Millisecond)
+
+	mu.Lock()
+	port = 8221
+	mu.Unlock()
+
+	r.ResolveNow(resolver.ResolveNowOptions{})
+
+	assert.Eventually(t, func() bool {
+		addrs := cc.Addresses()
+		return len(addrs) == 1 && addrs[0].Addr == "node-1.example.com:8221"
+	}, time.Second, time.Millisecond)
+}
+
+func TestSRVResolverBuilder_ResolveNow(t *testing.T) {
+	var mu sync.Mutex
+	lookups := 0
+	lookup := func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		lookups++
+		return "", []*net.SRV{
+			{Target: "node-1.example.com.", Port: 8220},
+		}, nil
+	}
+	numLookups := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return lookups
+	}
+
+	builder := NewSRVResolverBuilder("_fuddle._tcp.example.com", lookup)
+	r, err := builder.Build(resolver.Target{}, &fakeClientConn{}, resolver.BuildOptions{})
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return numLookups() == 1
+	}, time.Second, time.Millisecond)
+
+	// ResolveNow on the builder must look up the records again.
+	builder.ResolveNow()
+	require.Eventually(t, func() bool {
+		return numLookups() == 2
+	}, time.Second, time.Millisecond)
+
+	// Once closed the resolver must be removed from the builder.
+	r.Close()
+	builder.ResolveNow()
+	time.Sleep(time.Millisecond * 10)
+	assert.Equal(t, 2, numLookups())
+}
+
+func TestSRVResolver_LookupError(t *testing.T) {
+	lookup := func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		return "", nil, errors.New("not found")
+	}
+
+	cc := &fakeClientConn{}
+	r, err := NewSRVResolverBuilder("_fuddle._tcp.example.com", lookup).Build(
+		resolver.Target{}, cc, resolver.BuildOptions{},
+	)
+	require.NoError(t, err)
+	defer r.Close()
+
+	assert.Eventually(t, func() bool {
+		return cc.Err() != nil
+	}, time.Second, time.Millisecond)
+	assert.Empty(t, cc.Addresses())
+}
+
+// fakeClientConn records the state reported by a resolver.
+type fakeClientConn struct {
+	resolver.ClientConn
+
+	addrs []resolver.Address
+	err   error
+
+	mu sync.Mutex
+}
+
+func (c *fakeClientConn) UpdateState(state resolver.State) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.addrs = state.Addresses
+	return nil
+}
+
+func (c *fakeClientConn) ReportError(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.err = err
+}
+
+func (c *fakeClientConn) Addresses() []resolver.Address {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.addrs
+}
+
+func (c *fakeClientConn) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.err
+}
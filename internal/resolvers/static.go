@@ -1,31 +1,43 @@
 package resolvers
 
 import (
+	"net"
+	"sync"
+
 	"google.golang.org/grpc/resolver"
 )
 
 type StaticResolverBuilder struct {
-	addrs []string
+	addrs []resolver.Address
+
+	// resolvers contains the resolvers built by the builder which are
+	// updated when the addresses change.
+	resolvers map[*StaticResolver]interface{}
+
+	// mu protects the above fields.
+	mu sync.Mutex
 }
 
 func NewStaticResolverBuilder(addrs []string) *StaticResolverBuilder {
 	return &StaticResolverBuilder{
-		addrs: addrs,
+		addrs:     toAddresses(addrs),
+		resolvers: make(map[*StaticResolver]interface{}),
 	}
 }
 
 func (s *StaticResolverBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
-	var addrs []resolver.Address
-	for _, addr := range s.addrs {
-		addrs = append(addrs, resolver.Address{Addr: addr})
-	}
-
 	r := &StaticResolver{
-		target: target,
-		cc:     cc,
-		addrs:  addrs,
+		target:  target,
+		cc:      cc,
+		builder: s,
 	}
-	r.start()
+
+	s.mu.Lock()
+	s.resolvers[r] = struct{}{}
+	addrs := s.addrs
+	s.mu.Unlock()
+
+	r.updateAddresses(addrs)
 	return r, nil
 }
 
@@ -33,21 +45,52 @@ func (s *StaticResolverBuilder) Scheme() string {
 	return "static"
 }
 
-type StaticResolver struct {
-	target resolver.Target
-	cc     resolver.ClientConn
-	addrs  []resolver.Address
+// UpdateAddrs replaces the addresses and updates the resolvers with the new
+// addresses.
+func (s *StaticResolverBuilder) UpdateAddrs(addrs []string) {
+	s.mu.Lock()
+	s.addrs = toAddresses(addrs)
+	var resolvers []*StaticResolver
+	for r := range s.resolvers {
+		resolvers = append(resolvers, r)
+	}
+	addresses := s.addrs
+	s.mu.Unlock()
+
+	// Update the resolvers without holding the mutex since gRPC may call
+	// back into the resolver.
+	for _, r := range resolvers {
+		r.updateAddresses(addresses)
+	}
+}
+
+func (s *StaticResolverBuilder) resolveNow(r *StaticResolver) {
+	s.mu.Lock()
+	addrs := s.addrs
+	s.mu.Unlock()
+
+	r.updateAddresses(addrs)
+}
+
+func (s *StaticResolverBuilder) remove(r *StaticResolver) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.resolvers, r)
 }
 
-func (s *StaticResolver) start() {
-	s.updateAddresses(s.addrs)
+type StaticResolver struct {
+	target  resolver.Target
+	cc      resolver.ClientConn
+	builder *StaticResolverBuilder
 }
 
 func (s *StaticResolver) ResolveNow(resolver.ResolveNowOptions) {
-	s.updateAddresses(s.addrs)
+	s.builder.resolveNow(s)
 }
 
 func (s *StaticResolver) Close() {
+	s.builder.remove(s)
 }
 
 func (s *StaticResolver) updateAddresses(addrs []resolver.Address) {
@@ -55,4 +98,27 @@ func (s *StaticResolver) updateAddresses(addrs []resolver.Address) {
 	s.cc.UpdateState(resolver.State{Addresses: addrs})
 }
 
+func toAddresses(addrs []string) []resolver.Address {
+	var addresses []resolver.Address
+	for _, addr := range addrs {
+		addresses = append(addresses, resolver.Address{
+			Addr: addr,
+			// Set the server name to the seed host so TLS verifies the
+			// server certificate against the seed rather than the dial
+			// target.
+			ServerName: serverName(addr),
+		})
+	}
+	return addresses
+}
+
+// serverName returns the host of the given address.
+func serverName(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
 var _ resolver.Builder = &StaticResolverBuilder{}
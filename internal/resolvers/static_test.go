@@ -0,0 +1,48 @@
+package resolvers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/resolver"
+)
+
+func TestStaticResolver_Build(t *testing.T) {
+	cc := &fakeClientConn{}
+	r, err := NewStaticResolverBuilder([]string{"10.26.104.1:8220"}).Build(
+		resolver.Target{}, cc, resolver.BuildOptions{},
+	)
+	require.NoError(t, err)
+	defer r.Close()
+
+	assert.Equal(t, []resolver.Address{
+		{Addr: "10.26.104.1:8220", ServerName: "10.26.104.1"},
+	}, cc.Addresses())
+}
+
+func TestStaticResolver_UpdateAddrs(t *testing.T) {
+	builder := NewStaticResolverBuilder([]string{"10.26.104.1:8220"})
+
+	cc := &fakeClientConn{}
+	r, err := builder.Build(resolver.Target{}, cc, resolver.BuildOptions{})
+	require.NoError(t, err)
+
+	builder.UpdateAddrs([]string{"10.26.104.2:8220", "10.26.104.3:8220"})
+
+	expected := []resolver.Address{
+		{Addr: "10.26.104.2:8220", ServerName: "10.26.104.2"},
+		{Addr: "10.26.104.3:8220", ServerName: "10.26.104.3"},
+	}
+	assert.Equal(t, expected, cc.Addresses())
+
+	// ResolveNow should push the updated addresses.
+	cc.UpdateState(resolver.State{})
+	r.ResolveNow(resolver.ResolveNowOptions{})
+	assert.Equal(t, expected, cc.Addresses())
+
+	// Closed resolvers should no longer be updated.
+	r.Close()
+	builder.UpdateAddrs([]string{"10.26.104.4:8220"})
+	assert.Equal(t, expected, cc.Addresses())
+}
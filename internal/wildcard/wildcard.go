@@ -0,0 +1,214 @@
+package wildcard
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Match returns true if the given string matches the pattern.
+//
+// The pattern may contain '*' wildcards that match zero or more characters,
+// and '?' wildcards that match exactly one character. A backslash escapes the
+// following character so it matches literally, such as '\*' matching a '*'
+// and '\\' matching a '\'. Escapes take precedence over wildcards, so an
+// escaped '*' or '?' is never a wildcard. A trailing backslash matches a
+// literal backslash.
+//
+// The pattern is anchored, so must match the entire string rather than a
+// substring. Such as 'a*' matches 'abc' but not 'cab', and the empty pattern
+// only matches the empty string. Consecutive '*' wildcards are equivalent to
+// a single '*'.
+//
+// Matching never backtracks. The parts of the pattern between '*' wildcards
+// are matched in order, each at its leftmost position after the previous
+// part, which is always a match if one exists. So matching is linear in the
+// length of the string for parts without '?' wildcards, and at worst takes
+// the length of the string multiplied by the length of the pattern.
+func Match(pattern string, s string) bool {
+	return Compile(pattern).Match(s)
+}
+
+// Pattern is a pattern that has been split on its wildcards, so matching
+// many strings against the same pattern doesn't parse the pattern each time.
+type Pattern struct {
+	// parts contains the parts of the pattern between the '*' wildcards, so
+	// a pattern without '*' wildcards has a single part.
+	parts []part
+}
+
+// Compile returns the compiled pattern.
+func Compile(pattern string) Pattern {
+	var parts []part
+	var tokens []token
+	for i := 0; i < len(pattern); {
+		c := pattern[i]
+		switch {
+		case c == '\\' && i+1 < len(pattern):
+			_, size := utf8.DecodeRuneInString(pattern[i+1:])
+			tokens = append(tokens, token{lit: pattern[i+1 : i+1+size]})
+			i += 1 + size
+		case c == '*':
+			// Consecutive wildcards are equivalent to a single wildcard, so
+			// skip the empty part between them.
+			if tokens != nil || len(parts) == 0 {
+				parts = append(parts, newPart(tokens))
+			}
+			tokens = nil
+			i++
+		case c == '?':
+			tokens = append(tokens, token{any: true})
+			i++
+		default:
+			_, size := utf8.DecodeRuneInString(pattern[i:])
+			tokens = append(tokens, token{lit: pattern[i : i+size]})
+			i += size
+		}
+	}
+	parts = append(parts, newPart(tokens))
+	return Pattern{parts: parts}
+}
+
+// Literal returns the unescaped pattern if the pattern has no wildcards, so
+// only matches the returned string. Returns false if the pattern has
+// wildcards.
+func (p Pattern) Literal() (string, bool) {
+	if len(p.parts) != 1 || p.parts[0].tokens != nil {
+		return "", false
+	}
+	return p.parts[0].s, true
+}
+
+// Match returns true if the given string matches the pattern.
+func (p Pattern) Match(s string) bool {
+	parts := p.parts
+
+	if len(parts) == 1 {
+		// No '*' wildcards so the part must match the whole string.
+		n, ok := parts[0].prefix(s)
+		return ok && n == len(s)
+	}
+
+	// The first part must be a prefix and the last part must be a suffix,
+	// with all other parts appearing in order between them.
+	n, ok := parts[0].prefix(s)
+	if !ok {
+		return false
+	}
+	s = s[n:]
+
+	n, ok = parts[len(parts)-1].suffix(s)
+	if !ok {
+		return false
+	}
+	s = s[:len(s)-n]
+
+	for _, part := range parts[1 : len(parts)-1] {
+		i, n := part.index(s)
+		if i < 0 {
+			return false
+		}
+		s = s[i+n:]
+	}
+	return true
+}
+
+// token is a single character of a pattern part, which is either a literal
+// character or a '?' wildcard.
+type token struct {
+	// lit contains the bytes of the literal character.
+	lit string
+	// any is true if the token is a '?' wildcard that matches any single
+	// character.
+	any bool
+}
+
+// part is a part of the pattern between '*' wildcards.
+type part struct {
+	// s is the unescaped part if it has no '?' wildcards.
+	s string
+	// tokens contains the characters of the part, or nil if the part has no
+	// '?' wildcards so can be matched using s.
+	tokens []token
+}
+
+func newPart(tokens []token) part {
+	var b strings.Builder
+	for _, t := range tokens {
+		if t.any {
+			return part{tokens: tokens}
+		}
+		b.WriteString(t.lit)
+	}
+	return part{s: b.String()}
+}
+
+// prefix returns the number of bytes of s the part matches if s has the part
+// as a prefix.
+func (p part) prefix(s string) (int, bool) {
+	if p.tokens == nil {
+		return len(p.s), strings.HasPrefix(s, p.s)
+	}
+
+	n := 0
+	for _, t := range p.tokens {
+		if t.any {
+			if n == len(s) {
+				return 0, false
+			}
+			_, size := utf8.DecodeRuneInString(s[n:])
+			n += size
+			continue
+		}
+		if !strings.HasPrefix(s[n:], t.lit) {
+			return 0, false
+		}
+		n += len(t.lit)
+	}
+	return n, true
+}
+
+// suffix returns the number of bytes of s the part matches if s has the part
+// as a suffix.
+func (p part) suffix(s string) (int, bool) {
+	if p.tokens == nil {
+		return len(p.s), strings.HasSuffix(s, p.s)
+	}
+
+	end := len(s)
+	for i := len(p.tokens) - 1; i >= 0; i-- {
+		t := p.tokens[i]
+		if t.any {
+			if end == 0 {
+				return 0, false
+			}
+			_, size := utf8.DecodeLastRuneInString(s[:end])
+			end -= size
+			continue
+		}
+		if !strings.HasSuffix(s[:end], t.lit) {
+			return 0, false
+		}
+		end -= len(t.lit)
+	}
+	return len(s) - end, true
+}
+
+// index returns the index of the first match of the part in s and the number
+// of bytes matched, or -1 if there is no match.
+func (p part) index(s string) (int, int) {
+	if p.tokens == nil {
+		return strings.Index(s, p.s), len(p.s)
+	}
+
+	for i := 0; i <= len(s); {
+		if n, ok := p.prefix(s[i:]); ok {
+			return i, n
+		}
+		if i == len(s) {
+			break
+		}
+		_, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+	}
+	return -1, 0
+}
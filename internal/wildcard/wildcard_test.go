@@ -0,0 +1,327 @@
+package wildcard
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		match   bool
+	}{
+		{pattern: "", s: "", match: true},
+		{pattern: "", s: "a", match: false},
+		{pattern: "abc", s: "abc", match: true},
+		{pattern: "abc", s: "abcd", match: false},
+		{pattern: "*", s: "", match: true},
+		{pattern: "*", s: "abc", match: true},
+		{pattern: "**", s: "", match: true},
+		{pattern: "**", s: "abc", match: true},
+		{pattern: "a**c", s: "abc", match: true},
+		{pattern: "a**c", s: "ab", match: false},
+		{pattern: "a*", s: "abc", match: true},
+		{pattern: "a*", s: "bc", match: false},
+		{pattern: "*c", s: "abc", match: true},
+		{pattern: "*c", s: "ab", match: false},
+		// The prefix and suffix must not overlap.
+		{pattern: "a*a", s: "a", match: false},
+		{pattern: "a*a", s: "aa", match: true},
+		{pattern: "ab*ba", s: "aba", match: false},
+		{pattern: "a*b*c", s: "axbxc", match: true},
+		{pattern: "a*b*c", s: "axcxb", match: false},
+		{pattern: "us-*-1", s: "us-east-1", match: true},
+		{pattern: "us-*-1", s: "eu-west-1", match: false},
+		// Patterns are anchored so must match the whole string.
+		{pattern: "b", s: "abc", match: false},
+		{pattern: "b*", s: "abc", match: false},
+		{pattern: "*b", s: "abc", match: false},
+		{pattern: "*b*", s: "abc", match: true},
+		{pattern: "", s: "*", match: false},
+		// Consecutive wildcards are equivalent to a single wildcard.
+		{pattern: "***", s: "", match: true},
+		{pattern: "***", s: "abc", match: true},
+		{pattern: "a***", s: "a", match: true},
+		{pattern: "***c", s: "c", match: true},
+		{pattern: "a***b***c", s: "abc", match: true},
+		{pattern: "a***b***c", s: "ac", match: false},
+		{pattern: "*a*", s: "", match: false},
+		{pattern: "*a*", s: "a", match: true},
+		// Later parts must match after earlier parts.
+		{pattern: "*ab*ab*", s: "ab", match: false},
+		{pattern: "*ab*ab*", s: "abab", match: true},
+		{pattern: "*aba*", s: "abba", match: false},
+	}
+	for _, tt := range tests {
+		assert.Equal(
+			t, tt.match, Match(tt.pattern, tt.s),
+			"pattern %q, s %q", tt.pattern, tt.s,
+		)
+		assert.Equal(
+			t, tt.match, Compile(tt.pattern).Match(tt.s),
+			"compiled pattern %q, s %q", tt.pattern, tt.s,
+		)
+	}
+}
+
+func TestMatchSingleCharacter(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		match   bool
+	}{
+		{pattern: "?", s: "a", match: true},
+		{pattern: "?", s: "", match: false},
+		{pattern: "?", s: "ab", match: false},
+		{pattern: "??", s: "ab", match: true},
+		{pattern: "??", s: "a", match: false},
+		{pattern: "host-?", s: "host-1", match: true},
+		{pattern: "host-?", s: "host-", match: false},
+		{pattern: "host-?", s: "host-12", match: false},
+		{pattern: "?ost-1", s: "host-1", match: true},
+		{pattern: "h?st-?", s: "host-1", match: true},
+		{pattern: "h?st-?", s: "hst-1", match: false},
+		// '?' matches a single character rather than a single byte.
+		{pattern: "?", s: "é", match: true},
+		{pattern: "a?c", s: "a世c", match: true},
+		{pattern: "a??c", s: "a世c", match: false},
+	}
+	for _, tt := range tests {
+		assertMatch(t, tt.match, tt.pattern, tt.s)
+	}
+}
+
+func TestMatchSingleCharacterWithWildcard(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		match   bool
+	}{
+		{pattern: "?*", s: "", match: false},
+		{pattern: "?*", s: "a", match: true},
+		{pattern: "?*", s: "abc", match: true},
+		{pattern: "*?", s: "", match: false},
+		{pattern: "*?", s: "abc", match: true},
+		{pattern: "*?*", s: "a", match: true},
+		{pattern: "a*?", s: "a", match: false},
+		{pattern: "a*?", s: "ab", match: true},
+		{pattern: "a?*c", s: "abc", match: true},
+		{pattern: "a?*c", s: "ac", match: false},
+		{pattern: "a*?c", s: "abxc", match: true},
+		{pattern: "*-?-*", s: "us-1-east", match: true},
+		{pattern: "*-?-*", s: "us-12-east", match: false},
+		{pattern: "*-?-*", s: "a-12-b-3-c", match: true},
+		{pattern: "shard.?.weight", s: "shard.1.weight", match: true},
+		{pattern: "shard.*.weight", s: "shard.12.weight", match: true},
+		{pattern: "shard.?.weight", s: "shard.12.weight", match: false},
+		// The prefix and suffix must not overlap.
+		{pattern: "a?*?a", s: "aba", match: false},
+		{pattern: "a?*?a", s: "abba", match: true},
+		{pattern: "*世?", s: "x世界", match: true},
+	}
+	for _, tt := range tests {
+		assertMatch(t, tt.match, tt.pattern, tt.s)
+	}
+}
+
+func TestMatchEscape(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		match   bool
+	}{
+		{pattern: `\*`, s: "*", match: true},
+		{pattern: `\*`, s: "", match: false},
+		{pattern: `\*`, s: "a", match: false},
+		{pattern: `\*`, s: `\abc`, match: false},
+		{pattern: `build\*`, s: "build*", match: true},
+		{pattern: `build\*`, s: "builds", match: false},
+		{pattern: `\?`, s: "?", match: true},
+		{pattern: `\?`, s: "a", match: false},
+		{pattern: `\\`, s: `\`, match: true},
+		{pattern: `\\`, s: `\\`, match: false},
+		// Escaping other characters matches them literally.
+		{pattern: `\a\b`, s: "ab", match: true},
+		{pattern: `\世`, s: "世", match: true},
+		// A trailing backslash matches a literal backslash.
+		{pattern: `a\`, s: `a\`, match: true},
+		{pattern: `a\`, s: "a", match: false},
+		// An escaped backslash followed by a wildcard is still a wildcard.
+		{pattern: `a\\*`, s: `a\bc`, match: true},
+		{pattern: `a\\*`, s: "a*", match: false},
+		{pattern: `a\\?`, s: `a\b`, match: true},
+	}
+	for _, tt := range tests {
+		assertMatch(t, tt.match, tt.pattern, tt.s)
+	}
+}
+
+func TestMatchEscapeWithWildcard(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		match   bool
+	}{
+		{pattern: `*\**`, s: "a*b", match: true},
+		{pattern: `*\**`, s: "*", match: true},
+		{pattern: `*\**`, s: "ab", match: false},
+		{pattern: `\**`, s: "*abc", match: true},
+		{pattern: `\**`, s: "abc", match: false},
+		{pattern: `*\*`, s: "abc*", match: true},
+		{pattern: `*\*`, s: "abc", match: false},
+		{pattern: `\*?`, s: "*a", match: true},
+		{pattern: `\*?`, s: "*", match: false},
+		{pattern: `?\?`, s: "a?", match: true},
+		{pattern: `?\?`, s: "ab", match: false},
+		{pattern: `a*\?*b`, s: "ax?yb", match: true},
+		{pattern: `a*\?*b`, s: "axyb", match: false},
+	}
+	for _, tt := range tests {
+		assertMatch(t, tt.match, tt.pattern, tt.s)
+	}
+}
+
+func TestCompileLiteral(t *testing.T) {
+	tests := []struct {
+		pattern string
+		literal string
+		ok      bool
+	}{
+		{pattern: "", literal: "", ok: true},
+		{pattern: "abc", literal: "abc", ok: true},
+		{pattern: `a\*c`, literal: "a*c", ok: true},
+		{pattern: `a\?c`, literal: "a?c", ok: true},
+		{pattern: `a\\`, literal: `a\`, ok: true},
+		{pattern: "a*", ok: false},
+		{pattern: "a?", ok: false},
+		{pattern: `a\\*`, ok: false},
+	}
+	for _, tt := range tests {
+		literal, ok := Compile(tt.pattern).Literal()
+		assert.Equal(t, tt.ok, ok, "pattern %q", tt.pattern)
+		assert.Equal(t, tt.literal, literal, "pattern %q", tt.pattern)
+	}
+}
+
+func assertMatch(t *testing.T, match bool, pattern string, s string) {
+	t.Helper()
+
+	assert.Equal(
+		t, match, Match(pattern, s),
+		"pattern %q, s %q", pattern, s,
+	)
+	assert.Equal(
+		t, match, Compile(pattern).Match(s),
+		"compiled pattern %q, s %q", pattern, s,
+	)
+}
+
+func TestCompileCollapsesConsecutiveWildcards(t *testing.T) {
+	assert.Equal(t, Compile("*"), Compile("***"))
+	assert.Equal(t, Compile("a*b"), Compile("a**b"))
+	assert.Equal(t, Compile("*a*b*"), Compile("**a***b**"))
+	// Escaped wildcards aren't collapsed.
+	assert.NotEqual(t, Compile(`*\**`), Compile("*"))
+}
+
+// TestMatchAdversarial checks inputs that take exponential time with a
+// backtracking matcher complete quickly.
+func TestMatchAdversarial(t *testing.T) {
+	long := strings.Repeat("a", 100000)
+
+	tests := []struct {
+		pattern string
+		s       string
+		match   bool
+	}{
+		{pattern: strings.Repeat("a*", 50) + "b", s: long, match: false},
+		{pattern: strings.Repeat("*a", 50) + "*b", s: long, match: false},
+		{pattern: strings.Repeat("*a", 50) + "*", s: long, match: true},
+		{pattern: strings.Repeat("*?", 50) + "b", s: long, match: false},
+		{pattern: "*" + strings.Repeat("a?", 50) + "b*", s: long, match: false},
+		{pattern: "*" + strings.Repeat("a", 1000) + "b*", s: long, match: false},
+		{pattern: strings.Repeat("*", 100000), s: long, match: true},
+		{pattern: long, s: long, match: true},
+		{pattern: long + "*", s: long, match: true},
+		{pattern: long + "?", s: long, match: false},
+	}
+	for _, tt := range tests {
+		start := time.Now()
+		assert.Equal(t, tt.match, Match(tt.pattern, tt.s))
+		assert.Less(t, time.Since(start), time.Second)
+	}
+}
+
+func FuzzMatch(f *testing.F) {
+	seeds := []struct {
+		pattern string
+		s       string
+	}{
+		{pattern: "", s: ""},
+		{pattern: "*", s: "abc"},
+		{pattern: "**", s: ""},
+		{pattern: "a*b*c", s: "axbxc"},
+		{pattern: "a*a", s: "a"},
+		{pattern: "h?st-*", s: "host-1"},
+		{pattern: `\**\?`, s: "*x?"},
+		{pattern: `a\`, s: `a\`},
+		{pattern: "*?世", s: "x世界世"},
+	}
+	for _, seed := range seeds {
+		f.Add(seed.pattern, seed.s)
+	}
+
+	f.Fuzz(func(t *testing.T, pattern string, s string) {
+		// The regular expression reference doesn't match invalid UTF-8
+		// byte for byte, so only compare valid UTF-8.
+		if !utf8.ValidString(pattern) || !utf8.ValidString(s) {
+			return
+		}
+
+		re := regexp.MustCompile(patternToRegexp(pattern))
+		if re.MatchString(s) != Match(pattern, s) {
+			t.Fatalf(
+				"pattern %q, s %q: expected match %v",
+				pattern, s, re.MatchString(s),
+			)
+		}
+	})
+}
+
+// patternToRegexp returns an anchored regular expression equivalent to the
+// wildcard pattern, used as a reference implementation.
+func patternToRegexp(pattern string) string {
+	var b strings.Builder
+	b.WriteString("^(?s:")
+	for i := 0; i < len(pattern); {
+		r, size := utf8.DecodeRuneInString(pattern[i:])
+		switch {
+		case r == '\\' && i+1 < len(pattern):
+			escaped, escapedSize := utf8.DecodeRuneInString(pattern[i+1:])
+			b.WriteString(regexp.QuoteMeta(string(escaped)))
+			size += escapedSize
+		case r == '*':
+			b.WriteString(".*")
+		case r == '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+		i += size
+	}
+	b.WriteString(")$")
+	return b.String()
+}
+
+func BenchmarkMatch(b *testing.B) {
+	pattern := Compile("shard.*.weight.*")
+	for i := 0; i != b.N; i++ {
+		pattern.Match("shard.12.weight.primary")
+	}
+}
@@ -0,0 +1,804 @@
+package fuddle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	rpc "github.com/fuddle-io/fuddle-rpc/go"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var errStreamClosed = errors.New("stream closed")
+
+// maxUpdateAttempts is the maximum number of attempts to send an update to
+// the connected node when sending fails with a transient error.
+const maxUpdateAttempts = 3
+
+// errorsBufferSize is the number of errors buffered by LocalNode.Errors before
+// new errors are dropped.
+const errorsBufferSize = 16
+
+// LocalNode is a member registered by the client.
+//
+// Each local node has its own register stream, though all local nodes share
+// the clients connection. The member is registered again whenever the client
+// reconnects, and is unregistered when either LocalNode.Unregister is called
+// or the client is closed.
+type LocalNode struct {
+	id string
+
+	// stream is the register stream for the current connection, or nil if
+	// the member hasn't been registered since the client connected.
+	stream rpc.ClientWriteRegistry_RegisterClient
+	// cancelStream cancels the context of the last register stream, or is
+	// nil if the member hasn't been registered.
+	cancelStream func()
+	// unregistered is closed once the member is unregistered.
+	unregistered chan struct{}
+	// batchPending is true if metadata updates from UpdateMetadataBatch are
+	// waiting to be sent.
+	batchPending bool
+	// errs delivers heartbeat and update errors for the member. It is closed
+	// once the member is unregistered.
+	errs chan error
+
+	// mu protects the above fields, and ensures only one goroutine sends to
+	// the stream at a time.
+	mu sync.Mutex
+
+	f *Fuddle
+}
+
+func newLocalNode(id string, f *Fuddle) *LocalNode {
+	return &LocalNode{
+		id:           id,
+		unregistered: make(chan struct{}),
+		errs:         make(chan error, errorsBufferSize),
+		f:            f,
+	}
+}
+
+// ID returns the ID of the registered member.
+func (n *LocalNode) ID() string {
+	return n.id
+}
+
+// Errors returns a channel that receives heartbeat and update errors relating
+// to this member, such as failing to send a heartbeat or a metadata update to
+// the connected node. This lets callers supervise each member separately
+// rather than using the client wide WithOnHeartbeatError callback.
+//
+// The channel is buffered, and errors are dropped rather than blocking when the
+// buffer is full. The channel is closed once the member is unregistered.
+func (n *LocalNode) Errors() <-chan error {
+	return n.errs
+}
+
+// Member returns a copy of the members current state, including any updates
+// such as UpdateMetadata and UpdateStatus. Returns an empty member once the
+// member is unregistered.
+func (n *LocalNode) Member() Member {
+	member, _ := n.f.registry.LocalMember(n.id)
+	return member
+}
+
+// UpdateMetadata merges the given metadata into the members metadata, so
+// existing keys that aren't in the given metadata are kept.
+//
+// All keys are applied atomically and sent to the connected node in a single
+// update, so subscribers never see a subset of the given metadata. To
+// combine multiple calls into a single update, use UpdateMetadataBatch.
+//
+// If sending the update fails with a transient error, such as the
+// connection being briefly unavailable, the member is registered again with
+// the update on a new register stream, retrying with the reconnect backoff
+// (see WithReconnectBackoff) up to 3 attempts before returning an error
+// wrapping ErrNotConnected. Non-retryable errors, such as the node rejecting
+// the member as invalid, return an error wrapping ErrUpdateRejected without
+// retrying.
+func (n *LocalNode) UpdateMetadata(metadata map[string]string) error {
+	return n.updateMetadata("fuddle.LocalNode.UpdateMetadata", func(m map[string]string) {
+		for k, v := range metadata {
+			m[k] = v
+		}
+	})
+}
+
+// UpdateMetadataBatch merges the given metadata into the members metadata,
+// like UpdateMetadata, though rather than sending the update to the connected
+// node immediately, waits for the batch window (see WithMetadataBatchWindow)
+// to collect further updates, then sends them all in a single update. Such
+// as a node publishing many metadata fields at boot can call
+// UpdateMetadataBatch for each field without a round trip per field.
+//
+// The registry is updated immediately, so Member and subscribers see the
+// update before it is sent. Since the update is sent asynchronously, errors
+// sending the update are logged rather than returned, though if the client
+// is disconnected the updated member is registered once the client
+// reconnects.
+func (n *LocalNode) UpdateMetadataBatch(metadata map[string]string) error {
+	if err := n.f.registry.UpdateLocalMetadata(n.id, func(m map[string]string) {
+		for k, v := range metadata {
+			m[k] = v
+		}
+	}); err != nil {
+		return fmt.Errorf("fuddle: update member: %w", err)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.batchPending {
+		// The update will be included in the pending batch.
+		return nil
+	}
+	n.batchPending = true
+
+	n.f.wg.Add(1)
+	go func() {
+		defer n.f.wg.Done()
+		n.flushMetadataBatch()
+	}()
+	return nil
+}
+
+// SetMetadata replaces the members metadata with the given metadata, so
+// existing keys that aren't in the given metadata are removed.
+func (n *LocalNode) SetMetadata(metadata map[string]string) error {
+	return n.updateMetadata("fuddle.LocalNode.SetMetadata", func(m map[string]string) {
+		for k := range m {
+			if _, ok := metadata[k]; !ok {
+				delete(m, k)
+			}
+		}
+		for k, v := range metadata {
+			m[k] = v
+		}
+	})
+}
+
+// RemoveMetadata removes the given keys from the members metadata. Keys that
+// don't exist are ignored.
+func (n *LocalNode) RemoveMetadata(keys ...string) error {
+	return n.updateMetadata("fuddle.LocalNode.RemoveMetadata", func(m map[string]string) {
+		for _, k := range keys {
+			delete(m, k)
+		}
+	})
+}
+
+// UpdateStatus updates the members status, such as from 'booting' to
+// 'active'.
+//
+// If the client is disconnected, the update succeeds and the status is sent
+// when the member is registered again once the client reconnects. If the
+// client is connected but the update can't be sent to the connected node,
+// returns an error wrapping ErrNotConnected, though the status is still
+// updated in the registry and is included when the member is registered
+// again. Transient errors are retried like UpdateMetadata, stopping early if
+// ctx is cancelled.
+func (n *LocalNode) UpdateStatus(ctx context.Context, status string) error {
+	return n.update(ctx, "fuddle.LocalNode.UpdateStatus", func() error {
+		return n.f.registry.UpdateLocalStatus(n.id, status)
+	})
+}
+
+// Relocate moves the member to the given locality, such as when a node fails
+// over to another availability zone, without reconnecting the client.
+//
+// Since the locality is part of the members registration, the member is
+// unregistered then registered again with the new locality under the same
+// ID on a new register stream. So there is a brief gap where other clients
+// may see the member leave before it rejoins. Relocate waits for the server to
+// close the old stream before registering again, so the member can't be left
+// unregistered by the server processing the unregister last.
+//
+// If the client is disconnected, the locality is still updated in the
+// registry and the member is registered with the new locality once the
+// client reconnects. If registering with the new locality fails, returns an
+// error wrapping ErrNotConnected, and the member is registered again once the
+// client reconnects. If ctx is cancelled while waiting for the old stream to
+// close, the old stream is cancelled and the member is still registered with
+// the new locality, though returns the context error.
+func (n *LocalNode) Relocate(ctx context.Context, locality Locality) (err error) {
+	ctx, span := n.f.startSpan(ctx, "fuddle.LocalNode.Relocate", n.id)
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("fuddle: relocate: %w", err)
+	}
+
+	// Update the registry before locking, since updating the registry
+	// notifies subscribers which may call back into the client.
+	if err := n.f.registry.UpdateLocalMember(n.id, func(state *rpc.MemberState) {
+		state.Locality = &rpc.Locality{
+			Region:           locality.Region,
+			AvailabilityZone: locality.AvailabilityZone,
+		}
+	}); err != nil {
+		return fmt.Errorf("fuddle: relocate: %w", err)
+	}
+
+	n.mu.Lock()
+	stream, cancelStream := n.stream, n.cancelStream
+	n.stream = nil
+	if stream != nil {
+		if err := stream.Send(&rpc.ClientUpdate{
+			UpdateType: rpc.ClientUpdateType_CLIENT_UNREGISTER,
+			Member:     n.f.registry.LocalRPCMember(n.id),
+		}); err != nil {
+			n.f.logger.Warn(
+				"relocate unregister error",
+				zap.String("id", n.id),
+				zap.Error(err),
+			)
+		}
+	}
+	n.mu.Unlock()
+
+	var ctxErr error
+	if stream != nil {
+		// Wait for the server to close the old stream before registering
+		// again, otherwise the server may process the unregister after the
+		// new registration.
+		closed := make(chan struct{})
+		go func() {
+			//nolint
+			stream.CloseAndRecv()
+			close(closed)
+		}()
+		select {
+		case <-closed:
+		case <-ctx.Done():
+			// Cancel the old stream so CloseAndRecv returns, then still
+			// register with the new locality below rather than leaving
+			// the member unregistered until the client reconnects.
+			if cancelStream != nil {
+				cancelStream()
+			}
+			<-closed
+			ctxErr = fmt.Errorf("fuddle: relocate: %w", ctx.Err())
+		}
+	}
+
+	// Lock the client to avoid racing with registering the local nodes when
+	// the client reconnects.
+	n.f.mu.Lock()
+	defer n.f.mu.Unlock()
+
+	n.mu.Lock()
+	registered := n.stream != nil
+	n.mu.Unlock()
+
+	// If the client is disconnected, or the member was already registered
+	// again after the client reconnected, there is nothing to do.
+	if !n.f.connected || registered {
+		return ctxErr
+	}
+	if err := n.register(ctx); err != nil {
+		return fmt.Errorf("fuddle: relocate: %w", err)
+	}
+	return ctxErr
+}
+
+// Unregister unregisters the member and removes it from the registry.
+// Unregister is safe to call multiple times.
+//
+// Returns an error wrapping ErrUnregisterFailed if the unregister couldn't be
+// sent to the connected node, though the member is still removed from the
+// registry.
+func (n *LocalNode) Unregister() error {
+	n.f.mu.Lock()
+	delete(n.f.localNodes, n.id)
+	n.f.mu.Unlock()
+
+	n.mu.Lock()
+
+	select {
+	case <-n.unregistered:
+		// Already unregistered.
+		n.mu.Unlock()
+		return nil
+	default:
+	}
+	close(n.unregistered)
+
+	_, span := n.f.startSpan(
+		context.Background(), "fuddle.LocalNode.Unregister", n.id,
+	)
+
+	var err error
+	// If the client is closed the member has already been unregistered.
+	if n.stream != nil && !n.f.closed.Load() {
+		if err = n.stream.Send(&rpc.ClientUpdate{
+			UpdateType: rpc.ClientUpdateType_CLIENT_UNREGISTER,
+			Member:     n.f.registry.LocalRPCMember(n.id),
+		}); err != nil {
+			n.f.logger.Warn(
+				"unregister error",
+				zap.String("id", n.id),
+				zap.Error(err),
+			)
+		}
+		//nolint
+		n.stream.CloseSend()
+	}
+	n.stream = nil
+
+	if err != nil {
+		err = fmt.Errorf("fuddle: unregister: %w: %w", ErrUnregisterFailed, err)
+		n.errorLocked(err)
+	}
+	close(n.errs)
+
+	n.mu.Unlock()
+
+	endSpan(span, err)
+
+	n.f.registry.RemoveLocalMember(n.id)
+
+	if err != nil {
+		if n.f.onHeartbeatError != nil {
+			n.f.onHeartbeatError(err)
+		}
+		return err
+	}
+	return nil
+}
+
+// flushMetadataBatch waits for the batch window then sends the member,
+// including all metadata updates since the batch started, to the connected
+// node.
+func (n *LocalNode) flushMetadataBatch() {
+	select {
+	case <-n.f.clock.After(n.f.metadataBatchWindow):
+	case <-n.unregistered:
+		return
+	case <-n.f.ctx.Done():
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.batchPending = false
+
+	// If the client is disconnected, the updated member is registered once
+	// the client reconnects.
+	if n.stream == nil {
+		return
+	}
+	if err := n.stream.Send(&rpc.ClientUpdate{
+		UpdateType: rpc.ClientUpdateType_CLIENT_REGISTER,
+		Member:     n.f.registry.LocalRPCMember(n.id),
+	}); err != nil {
+		n.f.logger.Warn(
+			"metadata batch update error",
+			zap.String("id", n.id),
+			zap.Error(err),
+		)
+		n.errorLocked(fmt.Errorf("fuddle: update member: %w", err))
+	}
+}
+
+// updateMetadata updates the members metadata, where update is passed a copy
+// of the members metadata to modify.
+func (n *LocalNode) updateMetadata(spanName string, update func(metadata map[string]string)) error {
+	return n.update(context.Background(), spanName, func() error {
+		return n.f.registry.UpdateLocalMetadata(n.id, update)
+	})
+}
+
+// update updates the member in the local registry using updateRegistry, then
+// sends the updated member to the connected node.
+//
+// Since the register stream doesn't support partial updates, the full member
+// state is registered again. If the client is disconnected, the updated member
+// is registered once the client reconnects. If sending fails with a transient
+// error, the member is registered again on a new stream with backoff.
+func (n *LocalNode) update(ctx context.Context, spanName string, updateRegistry func() error) (err error) {
+	ctx, span := n.f.startSpan(ctx, spanName, n.id)
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("fuddle: update member: %w", err)
+	}
+
+	// Update the registry before locking, since updating the registry
+	// notifies subscribers which may call back into the node.
+	if err := updateRegistry(); err != nil {
+		return fmt.Errorf("fuddle: update member: %w", err)
+	}
+
+	n.mu.Lock()
+	// Note the member state is loaded when sending, so if there are
+	// concurrent updates the last update sent includes all of them.
+	stream := n.stream
+	if stream == nil {
+		n.mu.Unlock()
+		return nil
+	}
+	err = stream.Send(&rpc.ClientUpdate{
+		UpdateType: rpc.ClientUpdateType_CLIENT_REGISTER,
+		Member:     n.f.registry.LocalRPCMember(n.id),
+	})
+	n.mu.Unlock()
+
+	// If sending fails with a transient error, such as the connection
+	// being briefly unavailable, retry with backoff.
+	for attempt := 1; err != nil; attempt++ {
+		if stream != nil {
+			err = streamStatus(stream, err)
+		}
+		if !isTransient(err) {
+			err = fmt.Errorf("fuddle: update member: %w: %w", ErrUpdateRejected, err)
+			n.reportError(err)
+			return err
+		}
+		if attempt >= maxUpdateAttempts {
+			break
+		}
+
+		n.f.logger.Debug(
+			"update member failed; retrying",
+			zap.String("id", n.id),
+			zap.Int("attempt", attempt),
+			zap.Error(err),
+		)
+
+		select {
+		case <-n.f.clock.After(n.f.reconnectBackoff.delay(attempt - 1)):
+		case <-ctx.Done():
+			err = fmt.Errorf("fuddle: update member: %w: %w", ErrNotConnected, ctx.Err())
+			n.reportError(err)
+			return err
+		}
+
+		err = n.resend(ctx, stream)
+		stream = nil
+	}
+	if err != nil {
+		// Errors from resend already wrap ErrNotConnected.
+		if !errors.Is(err, ErrNotConnected) {
+			err = fmt.Errorf("%w: %w", ErrNotConnected, err)
+		}
+		err = fmt.Errorf("fuddle: update member: %w", err)
+		n.reportError(err)
+		return err
+	}
+	return nil
+}
+
+// resend registers the member on a new register stream after sending to the
+// failed stream failed, which sends the latest member state including any
+// updates. failed is nil if the previous attempt failed to register, in which
+// case the member has no stream.
+func (n *LocalNode) resend(ctx context.Context, failed rpc.ClientWriteRegistry_RegisterClient) error {
+	// Lock the client to avoid racing with registering the local nodes when
+	// the client reconnects.
+	n.f.mu.Lock()
+	defer n.f.mu.Unlock()
+
+	// If the client is disconnected, the member is registered with the
+	// latest state once the client reconnects.
+	if !n.f.connected {
+		return nil
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	// If the stream was replaced, such as the client reconnected, the latest
+	// state has already been registered on the new stream.
+	if n.stream != failed {
+		return nil
+	}
+	return n.registerLocked(ctx)
+}
+
+// register opens a register stream and registers the member on the current
+// connection. The stream outlives ctx, so ctx is only used to propagate the
+// trace context.
+func (n *LocalNode) register(ctx context.Context) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.registerLocked(ctx)
+}
+
+// registerLocked registers the member like register. n.mu must be held.
+func (n *LocalNode) registerLocked(ctx context.Context) error {
+	select {
+	case <-n.unregistered:
+		return nil
+	default:
+	}
+
+	// Close the stream from the previous connection, which also stops its
+	// heartbeats. The previous connection may be closed gracefully, so the
+	// stream would otherwise stay open.
+	if n.stream != nil {
+		//nolint
+		n.stream.CloseSend()
+		n.stream = nil
+	}
+	if n.cancelStream != nil {
+		n.cancelStream()
+		n.cancelStream = nil
+	}
+
+	// Use background since f.ctx will be cancelled before we've sent
+	// unregister.
+	streamCtx, cancel := context.WithCancel(trace.ContextWithSpanContext(
+		context.Background(), trace.SpanContextFromContext(ctx),
+	))
+	stream, err := n.f.writeClient.Register(streamCtx)
+	if err != nil {
+		cancel()
+		return fmt.Errorf("stream register: %w: %w", ErrNotConnected, err)
+	}
+
+	if err := stream.Send(&rpc.ClientUpdate{
+		UpdateType: rpc.ClientUpdateType_CLIENT_REGISTER,
+		Member:     n.f.registry.LocalRPCMember(n.id),
+	}); err != nil {
+		err = streamStatus(stream, err)
+		cancel()
+		return fmt.Errorf("send register: %w: %w", ErrNotConnected, err)
+	}
+
+	n.stream = stream
+	n.cancelStream = cancel
+
+	n.f.wg.Add(1)
+	go func() {
+		defer n.f.wg.Done()
+		n.streamHeartbeats(streamCtx, stream)
+	}()
+
+	return nil
+}
+
+// confirmRegistered blocks until the connected node confirms the member is
+// registered, by looking up the member on the node. If the member isn't
+// registered, registers the member again and retries with backoff until
+// ctx is cancelled.
+func (n *LocalNode) confirmRegistered(ctx context.Context) error {
+	for attempts := 0; ; attempts++ {
+		resp, err := n.f.readClient.Member(ctx, &rpc.MemberRequest{Id: n.id})
+		if err == nil && resp.Member != nil && resp.Member.Liveness == rpc.Liveness_UP {
+			return nil
+		}
+		if err != nil && status.Code(err) != codes.NotFound {
+			n.f.logger.Warn(
+				"failed to confirm register",
+				zap.String("id", n.id),
+				zap.Error(err),
+			)
+		}
+
+		select {
+		case <-n.f.clock.After(n.f.reconnectBackoff.delay(attempts)):
+		case <-ctx.Done():
+			return fmt.Errorf("confirm register: %w", ctx.Err())
+		case <-n.unregistered:
+			return fmt.Errorf("confirm register: %w: member unregistered", ErrNotConnected)
+		case <-n.f.ctx.Done():
+			return fmt.Errorf("confirm register: %w: client closed", ErrNotConnected)
+		}
+
+		n.mu.Lock()
+		// If the client is disconnected, the member is registered once the
+		// client reconnects.
+		if n.stream != nil {
+			if err := n.stream.Send(&rpc.ClientUpdate{
+				UpdateType: rpc.ClientUpdateType_CLIENT_REGISTER,
+				Member:     n.f.registry.LocalRPCMember(n.id),
+			}); err != nil {
+				n.f.logger.Warn(
+					"failed to register",
+					zap.String("id", n.id),
+					zap.Error(err),
+				)
+			}
+		}
+		n.mu.Unlock()
+	}
+}
+
+// streamHeartbeats sends heartbeats to the given stream until the stream is
+// replaced, where ctx is cancelled once the stream is closed.
+func (n *LocalNode) streamHeartbeats(ctx context.Context, stream rpc.ClientWriteRegistry_RegisterClient) {
+	ticker := n.f.clock.NewTicker(n.f.heartbeatInterval)
+	defer ticker.Stop()
+
+	// failures is the number of consecutive heartbeat failures.
+	failures := 0
+	for {
+		select {
+		case <-n.unregistered:
+			return
+		case <-ctx.Done():
+			return
+		case <-n.f.ctx.Done():
+			if err := n.send(stream, &rpc.ClientUpdate{
+				UpdateType: rpc.ClientUpdateType_CLIENT_UNREGISTER,
+				Member:     n.f.registry.LocalRPCMember(n.id),
+			}); err != nil {
+				n.f.logger.Warn(
+					"unregister error",
+					zap.String("id", n.id),
+					zap.Error(err),
+				)
+				if !errors.Is(err, errStreamClosed) {
+					n.heartbeatError(fmt.Errorf(
+						"fuddle: unregister: %w: %w", ErrUnregisterFailed, err,
+					))
+				}
+			}
+			return
+		case <-ticker.C():
+			if err := n.send(stream, &rpc.ClientUpdate{
+				UpdateType: rpc.ClientUpdateType_CLIENT_HEARTBEAT,
+			}); err != nil {
+				// Ignore the error if the stream was replaced.
+				if errors.Is(err, errStreamClosed) {
+					return
+				}
+				n.f.stats.heartbeatErrors.Inc()
+				n.f.metrics.HeartbeatError()
+				n.heartbeatError(fmt.Errorf("fuddle: heartbeat: %w", err))
+
+				if n.f.heartbeatMaxFailures == 0 {
+					return
+				}
+				failures++
+				if failures >= n.f.heartbeatMaxFailures {
+					n.reregister(stream)
+					return
+				}
+				continue
+			}
+			n.f.stats.heartbeatsSent.Inc()
+			failures = 0
+		}
+	}
+}
+
+// reregister registers the member on a new register stream, replacing the
+// given stream if it is still the current stream. If the client is
+// disconnected, the member is registered once the client reconnects instead.
+func (n *LocalNode) reregister(stream rpc.ClientWriteRegistry_RegisterClient) {
+	n.f.logger.Warn(
+		"heartbeat failure threshold reached; re-registering",
+		zap.String("id", n.id),
+	)
+
+	// Lock the client to avoid racing with registering the local nodes when
+	// the client reconnects.
+	n.f.mu.Lock()
+	defer n.f.mu.Unlock()
+
+	if !n.f.connected {
+		return
+	}
+
+	n.mu.Lock()
+	if n.stream != stream {
+		// The stream has already been replaced.
+		n.mu.Unlock()
+		return
+	}
+	//nolint
+	stream.CloseSend()
+	n.stream = nil
+	if n.cancelStream != nil {
+		n.cancelStream()
+		n.cancelStream = nil
+	}
+	n.mu.Unlock()
+
+	if err := n.register(context.Background()); err != nil {
+		// If we can't register, this will typically mean we've
+		// disconnected so will retry once reconnected.
+		n.f.logger.Warn(
+			"failed to register",
+			zap.String("id", n.id),
+			zap.Error(err),
+		)
+	}
+}
+
+// heartbeatError delivers the error to the Errors channel and calls the
+// heartbeat error callback if configured. Must not be called while holding a
+// lock.
+func (n *LocalNode) heartbeatError(err error) {
+	n.reportError(err)
+
+	if n.f.onHeartbeatError != nil {
+		n.f.onHeartbeatError(err)
+	}
+}
+
+// reportError delivers the error to the Errors channel unless the member is
+// unregistered. Must not be called while holding n.mu.
+func (n *LocalNode) reportError(err error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	select {
+	case <-n.unregistered:
+		// The errors channel is closed once unregistered.
+	default:
+		n.errorLocked(err)
+	}
+}
+
+// errorLocked delivers the error to the Errors channel, dropping the error if
+// the channel is full so the caller never blocks. n.mu must be held, and the
+// channel must not be closed.
+func (n *LocalNode) errorLocked(err error) {
+	select {
+	case n.errs <- err:
+	default:
+		n.f.logger.Debug(
+			"errors channel full; dropping error",
+			zap.String("id", n.id),
+			zap.Error(err),
+		)
+	}
+}
+
+// send sends the update to the given stream. Returns an error if the stream
+// has been replaced or the member unregistered.
+func (n *LocalNode) send(stream rpc.ClientWriteRegistry_RegisterClient, update *rpc.ClientUpdate) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.stream != stream {
+		return errStreamClosed
+	}
+	return stream.Send(update)
+}
+
+// streamStatus returns the status of the stream after sending failed with the
+// given error. Once a stream is broken, Send returns io.EOF and the status is
+// only returned when receiving from the stream.
+func streamStatus(stream rpc.ClientWriteRegistry_RegisterClient, err error) error {
+	if !errors.Is(err, io.EOF) {
+		return err
+	}
+	if _, recvErr := stream.CloseAndRecv(); recvErr != nil && !errors.Is(recvErr, io.EOF) {
+		return recvErr
+	}
+	return err
+}
+
+// isTransient returns whether the error is a transient error, where sending
+// the update again may succeed, such as the connection being unavailable.
+func isTransient(err error) bool {
+	// The stream closed without a status.
+	if errors.Is(err, io.EOF) {
+		return true
+	}
+	var se interface{ GRPCStatus() *status.Status }
+	if !errors.As(err, &se) {
+		return false
+	}
+	switch se.GRPCStatus().Code() {
+	case codes.Unavailable, codes.Aborted:
+		return true
+	default:
+		return false
+	}
+}
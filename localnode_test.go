@@ -0,0 +1,894 @@
+package fuddle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fuddle-io/fuddle-go/fuddletest"
+	rpc "github.com/fuddle-io/fuddle-rpc/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestLocalNode_UpdateMetadata(t *testing.T) {
+	server := newTestServer(t)
+	f, node := connectWithLocalNode(t, server, map[string]string{
+		"foo": "1",
+		"bar": "2",
+	})
+	defer f.Close()
+
+	require.NoError(t, node.UpdateMetadata(map[string]string{
+		"bar": "3",
+		"car": "4",
+	}))
+
+	expected := map[string]string{
+		"foo": "1",
+		"bar": "3",
+		"car": "4",
+	}
+	assert.Equal(t, expected, f.registry.LocalRPCMember("local-2").Metadata)
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual(expected, lastRegistered(server, "local-2"))
+	}, time.Second, time.Millisecond)
+}
+
+func TestLocalNode_UpdateMetadataBatch(t *testing.T) {
+	server := newTestServer(t)
+	clock := newFakeClock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f, err := Connect(
+		ctx,
+		fromRPC(randomMember("local-1")),
+		[]string{server.addr},
+		WithMetadataBatchWindow(time.Millisecond*10),
+		withClock(clock),
+	)
+	require.NoError(t, err)
+	defer f.Close()
+
+	member := fromRPC(randomMember("local-2"))
+	member.Metadata = nil
+	node, err := f.Register(ctx, member)
+	require.NoError(t, err)
+
+	registers := func() int {
+		n := 0
+		for _, id := range receivedIDs(server, rpc.ClientUpdateType_CLIENT_REGISTER) {
+			if id == "local-2" {
+				n++
+			}
+		}
+		return n
+	}
+	require.Eventually(t, func() bool {
+		return registers() == 1
+	}, time.Second, time.Millisecond)
+
+	expected := make(map[string]string)
+	for i := 0; i != 20; i++ {
+		k := fmt.Sprintf("key-%d", i)
+		expected[k] = fmt.Sprintf("value-%d", i)
+		require.NoError(t, node.UpdateMetadataBatch(map[string]string{
+			k: expected[k],
+		}))
+	}
+
+	// The registry must be updated immediately.
+	assert.Equal(t, expected, node.Member().Metadata)
+
+	// Wait for the batch to start waiting on the window.
+	require.Eventually(t, func() bool {
+		return clock.NumTimers() == 1
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, 1, registers())
+
+	clock.Advance(time.Millisecond * 10)
+
+	// All updates must be sent in a single update.
+	require.Eventually(t, func() bool {
+		return registers() == 2
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, expected, lastRegistered(server, "local-2"))
+
+	// Updates after the batch was sent start a new batch.
+	require.NoError(t, node.UpdateMetadataBatch(map[string]string{"foo": "bar"}))
+	require.Eventually(t, func() bool {
+		return clock.NumTimers() == 1
+	}, time.Second, time.Millisecond)
+	clock.Advance(time.Millisecond * 10)
+	require.Eventually(t, func() bool {
+		return registers() == 3
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, "bar", lastRegistered(server, "local-2")["foo"])
+}
+
+func TestLocalNode_UpdateMetadataBatchUnregistered(t *testing.T) {
+	f := NewOffline(nil)
+	defer f.Close()
+
+	node, err := f.Register(context.Background(), fromRPC(randomMember("local")))
+	require.NoError(t, err)
+	require.NoError(t, node.Unregister())
+
+	assert.Error(t, node.UpdateMetadataBatch(map[string]string{"foo": "1"}))
+}
+
+func TestLocalNode_SetMetadata(t *testing.T) {
+	server := newTestServer(t)
+	f, node := connectWithLocalNode(t, server, map[string]string{
+		"foo": "1",
+		"bar": "2",
+	})
+	defer f.Close()
+
+	require.NoError(t, node.SetMetadata(map[string]string{
+		"bar": "3",
+		"car": "4",
+	}))
+
+	expected := map[string]string{
+		"bar": "3",
+		"car": "4",
+	}
+	assert.Equal(t, expected, f.registry.LocalRPCMember("local-2").Metadata)
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual(expected, lastRegistered(server, "local-2"))
+	}, time.Second, time.Millisecond)
+}
+
+func TestLocalNode_RemoveMetadata(t *testing.T) {
+	server := newTestServer(t)
+	f, node := connectWithLocalNode(t, server, map[string]string{
+		"foo": "1",
+		"bar": "2",
+	})
+	defer f.Close()
+
+	// Removing a key that doesn't exist should be ignored.
+	require.NoError(t, node.RemoveMetadata("bar", "unknown"))
+
+	expected := map[string]string{
+		"foo": "1",
+	}
+	assert.Equal(t, expected, f.registry.LocalRPCMember("local-2").Metadata)
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual(expected, lastRegistered(server, "local-2"))
+	}, time.Second, time.Millisecond)
+}
+
+func TestLocalNode_UpdateStatus(t *testing.T) {
+	server := newTestServer(t)
+	f, node := connectWithLocalNode(t, server, nil)
+	defer f.Close()
+
+	var mu sync.Mutex
+	var updates []MemberUpdate
+	unsub := f.SubscribeDelta(func(delta Delta) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		updates = append(updates, delta.Updated...)
+	})
+	defer unsub()
+
+	require.NoError(t, node.UpdateStatus(context.Background(), "booting"))
+	require.NoError(t, node.UpdateStatus(context.Background(), "active"))
+	// Setting the same status again must not notify subscribers.
+	require.NoError(t, node.UpdateStatus(context.Background(), "active"))
+
+	m, ok := f.Member("local-2")
+	require.True(t, ok)
+	assert.Equal(t, "active", m.Status)
+
+	mu.Lock()
+	require.Equal(t, 2, len(updates))
+	assert.Equal(t, "", updates[0].Old.Status)
+	assert.Equal(t, "booting", updates[0].New.Status)
+	assert.Equal(t, "booting", updates[1].Old.Status)
+	assert.Equal(t, "active", updates[1].New.Status)
+	mu.Unlock()
+
+	assert.Eventually(t, func() bool {
+		return lastRegisteredStatus(server, "local-2") == "active"
+	}, time.Second, time.Millisecond)
+}
+
+func TestLocalNode_UpdateStatusReconnect(t *testing.T) {
+	server1 := newTestServer(t)
+	f, node := connectWithLocalNode(t, server1, nil)
+	defer f.Close()
+
+	server1.Stop()
+	require.Eventually(t, func() bool {
+		return f.ConnState() == StateDisconnected
+	}, time.Second, time.Millisecond)
+
+	// Update the status while disconnected, which must be registered once
+	// the client reconnects. Sending the update may fail since the register
+	// stream is closed.
+	if err := node.UpdateStatus(context.Background(), "active"); err != nil {
+		assert.ErrorIs(t, err, ErrNotConnected)
+	}
+
+	server2 := newTestServer(t)
+	require.NoError(t, f.UpdateSeeds([]string{server2.addr}))
+
+	assert.Eventually(t, func() bool {
+		return lastRegisteredStatus(server2, "local-2") == "active"
+	}, time.Second*5, time.Millisecond)
+}
+
+func TestLocalNode_UpdateStatusObservedByOtherClient(t *testing.T) {
+	server, err := fuddletest.NewServer()
+	require.NoError(t, err)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f1, err := Connect(ctx, fromRPC(randomMember("member-1")), []string{server.Addr()})
+	require.NoError(t, err)
+	defer f1.Close()
+	f2, err := Connect(ctx, fromRPC(randomMember("member-2")), []string{server.Addr()})
+	require.NoError(t, err)
+	defer f2.Close()
+
+	node, err := f1.Register(ctx, fromRPC(randomMember("member-3")))
+	require.NoError(t, err)
+	require.NoError(t, node.UpdateStatus(ctx, "active"))
+
+	assert.Eventually(t, func() bool {
+		m, ok := f2.Member("member-3")
+		return ok && m.Status == "active"
+	}, time.Second, time.Millisecond)
+}
+
+func TestLocalNode_Relocate(t *testing.T) {
+	server := newTestServer(t)
+	f, node := connectWithLocalNode(t, server, nil)
+	defer f.Close()
+
+	locality := Locality{Region: "eu-west-2", AvailabilityZone: "eu-west-2b"}
+	require.NoError(t, node.Relocate(context.Background(), locality))
+
+	assert.Equal(t, locality, node.Member().Locality)
+	m, ok := f.Member("local-2")
+	require.True(t, ok)
+	assert.Equal(t, locality, m.Locality)
+
+	// The member must be unregistered then registered with the new
+	// locality. Since each registration uses its own stream, the server
+	// may receive the unregister after the register.
+	require.Eventually(t, func() bool {
+		return len(receivedIDs(server, rpc.ClientUpdateType_CLIENT_REGISTER)) == 3 &&
+			len(receivedIDs(server, rpc.ClientUpdateType_CLIENT_UNREGISTER)) == 1
+	}, time.Second, time.Millisecond)
+	var registered *rpc.MemberState
+	for _, update := range server.Received() {
+		if update.UpdateType == rpc.ClientUpdateType_CLIENT_REGISTER && update.Member.Id == "local-2" {
+			registered = update.Member
+		}
+	}
+	require.NotNil(t, registered)
+	assert.Equal(t, locality, fromRPC(registered).Locality)
+}
+
+func TestLocalNode_RelocateObservedByOtherClient(t *testing.T) {
+	server, err := fuddletest.NewServer()
+	require.NoError(t, err)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f1, err := Connect(ctx, fromRPC(randomMember("member-1")), []string{server.Addr()})
+	require.NoError(t, err)
+	defer f1.Close()
+	f2, err := Connect(ctx, fromRPC(randomMember("member-2")), []string{server.Addr()})
+	require.NoError(t, err)
+	defer f2.Close()
+
+	node, err := f1.Register(ctx, fromRPC(randomMember("member-3")))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		_, ok := f2.Member("member-3")
+		return ok
+	}, time.Second, time.Millisecond)
+
+	locality := Locality{Region: "eu-west-2", AvailabilityZone: "eu-west-2c"}
+	require.NoError(t, node.Relocate(ctx, locality))
+
+	assert.Eventually(t, func() bool {
+		m, ok := f2.Member("member-3")
+		return ok && m.Locality == locality
+	}, time.Second, time.Millisecond)
+}
+
+func TestLocalNode_RelocateOffline(t *testing.T) {
+	f := NewOffline(nil)
+	defer f.Close()
+
+	node, err := f.Register(context.Background(), fromRPC(randomMember("local")))
+	require.NoError(t, err)
+
+	locality := Locality{Region: "us-east-1", AvailabilityZone: "us-east-1a"}
+	require.NoError(t, node.Relocate(context.Background(), locality))
+	assert.Equal(t, locality, node.Member().Locality)
+
+	require.NoError(t, node.Unregister())
+	assert.Error(t, node.Relocate(context.Background(), locality))
+}
+
+func TestLocalNode_RelocateCancelled(t *testing.T) {
+	client := &fakeWriteClient{streams: []*fakeRegisterStream{
+		// The server never closes the first stream.
+		{failAfter: -1, blockClose: true},
+		{failAfter: -1},
+	}}
+	_, node := registerWithWriteClient(t, client)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+
+	locality := Locality{Region: "eu-west-2", AvailabilityZone: "eu-west-2b"}
+	err := node.Relocate(ctx, locality)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// The old stream must be cancelled, and the member registered with the
+	// new locality on a new stream.
+	assert.Error(t, client.streams[0].ctx.Err())
+	assert.Equal(t, 2, client.Registers())
+	sent := client.streams[1].Sent()
+	require.Equal(t, 1, len(sent))
+	assert.Equal(t, rpc.ClientUpdateType_CLIENT_REGISTER, sent[0].UpdateType)
+	assert.Equal(t, locality, fromRPC(sent[0].Member).Locality)
+}
+
+func TestLocalNode_UpdateStatusCancelled(t *testing.T) {
+	f := NewOffline(nil)
+	defer f.Close()
+
+	node, err := f.Register(context.Background(), fromRPC(randomMember("local")))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, node.UpdateStatus(ctx, "active"), context.Canceled)
+
+	m, ok := f.Member("local")
+	require.True(t, ok)
+	assert.Equal(t, "", m.Status)
+}
+
+func TestLocalNode_UpdateMetadataRetriesTransientError(t *testing.T) {
+	client := &fakeWriteClient{streams: []*fakeRegisterStream{
+		// The first stream registers the member then fails.
+		{failAfter: 1, err: status.Error(codes.Unavailable, "unavailable")},
+		{failAfter: -1},
+	}}
+	_, node := registerWithWriteClient(t, client)
+
+	require.NoError(t, node.UpdateMetadata(map[string]string{"foo": "bar"}))
+
+	// The member is registered again with the update on a new stream.
+	assert.Equal(t, 2, client.Registers())
+	sent := client.streams[1].Sent()
+	require.Equal(t, 1, len(sent))
+	assert.Equal(t, rpc.ClientUpdateType_CLIENT_REGISTER, sent[0].UpdateType)
+	assert.Equal(t, "bar", sent[0].Member.Metadata["foo"])
+}
+
+func TestLocalNode_UpdateMetadataRetryLimit(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "unavailable")
+	client := &fakeWriteClient{streams: []*fakeRegisterStream{
+		{failAfter: 1, err: unavailable},
+		{failAfter: 0, err: unavailable},
+		{failAfter: 0, err: unavailable},
+		{failAfter: -1},
+	}}
+	_, node := registerWithWriteClient(t, client)
+
+	err := node.UpdateMetadata(map[string]string{"foo": "bar"})
+	assert.ErrorIs(t, err, ErrNotConnected)
+	assert.Equal(t, 1, strings.Count(err.Error(), ErrNotConnected.Error()))
+	assert.Equal(t, codes.Unavailable, statusCode(err))
+	// The initial register plus one stream for each retry.
+	assert.Equal(t, maxUpdateAttempts, client.Registers())
+}
+
+func TestLocalNode_UpdateMetadataNonRetryableError(t *testing.T) {
+	client := &fakeWriteClient{streams: []*fakeRegisterStream{
+		{failAfter: 1, err: status.Error(codes.InvalidArgument, "invalid member")},
+		{failAfter: -1},
+	}}
+	_, node := registerWithWriteClient(t, client)
+
+	err := node.UpdateMetadata(map[string]string{"foo": "bar"})
+	assert.ErrorIs(t, err, ErrUpdateRejected)
+	assert.Equal(t, codes.InvalidArgument, statusCode(err))
+	// Non-retryable errors fail without registering again.
+	assert.Equal(t, 1, client.Registers())
+}
+
+func TestLocalNode_UpdateStatusRetryCancelled(t *testing.T) {
+	client := &fakeWriteClient{streams: []*fakeRegisterStream{
+		{failAfter: 1, err: status.Error(codes.Unavailable, "unavailable")},
+		{failAfter: -1},
+	}}
+	_, node := registerWithWriteClient(t, client, WithReconnectBackoff(Backoff{
+		Initial: time.Hour,
+		Max:     time.Hour,
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+
+	// The retry backoff is cancelled by the context.
+	err := node.UpdateStatus(ctx, "active")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 1, client.Registers())
+}
+
+func TestLocalNode_Member(t *testing.T) {
+	f := NewOffline(nil)
+	defer f.Close()
+
+	member := fromRPC(randomMember("local"))
+	member.Status = "booting"
+	node, err := f.Register(context.Background(), member)
+	require.NoError(t, err)
+	assert.Equal(t, member, node.Member())
+
+	require.NoError(t, node.UpdateMetadata(map[string]string{"foo": "bar"}))
+	require.NoError(t, node.UpdateStatus(context.Background(), "active"))
+
+	expected := member.Copy()
+	expected.Metadata["foo"] = "bar"
+	expected.Status = "active"
+	assert.Equal(t, expected, node.Member())
+
+	// Modifying the returned member must not affect the registry.
+	node.Member().Metadata["foo"] = "car"
+	assert.Equal(t, expected, node.Member())
+
+	require.NoError(t, node.Unregister())
+	assert.Equal(t, Member{}, node.Member())
+}
+
+func TestLocalNode_UpdateMetadataUnregistered(t *testing.T) {
+	server := newTestServer(t)
+	f, node := connectWithLocalNode(t, server, nil)
+	defer f.Close()
+
+	node.Unregister()
+
+	assert.Error(t, node.UpdateMetadata(map[string]string{"foo": "1"}))
+	assert.Error(t, node.SetMetadata(map[string]string{"foo": "1"}))
+	assert.Error(t, node.RemoveMetadata("foo"))
+}
+
+func TestLocalNode_OnHeartbeatError(t *testing.T) {
+	errs := make(chan error, 1)
+	f := NewOffline(
+		nil,
+		WithHeartbeatInterval(time.Millisecond),
+		WithOnHeartbeatError(func(err error) {
+			errs <- err
+		}),
+	)
+	defer f.Close()
+
+	stream := &failingRegisterStream{err: errors.New("broken stream")}
+	startHeartbeats(f, stream)
+
+	select {
+	case err := <-errs:
+		assert.ErrorIs(t, err, stream.err)
+	case <-time.After(time.Second):
+		t.Fatal("heartbeat error callback not called")
+	}
+}
+
+func TestLocalNode_StatsHeartbeatErrors(t *testing.T) {
+	f := NewOffline(
+		nil,
+		WithHeartbeatInterval(time.Millisecond),
+		WithHeartbeatFailureThreshold(3),
+	)
+	defer f.Close()
+
+	startHeartbeats(f, &failingRegisterStream{err: errors.New("broken stream")})
+
+	// The offline client is never connected so doesn't re-register once the
+	// threshold is reached.
+	assert.Eventually(t, func() bool {
+		return f.Stats().HeartbeatErrors == 3
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, int64(0), f.Stats().HeartbeatsSent)
+}
+
+func TestLocalNode_OnHeartbeatErrorUnregister(t *testing.T) {
+	errs := make(chan error, 1)
+	f := NewOffline(
+		nil,
+		WithHeartbeatInterval(time.Hour),
+		WithOnHeartbeatError(func(err error) {
+			errs <- err
+		}),
+	)
+
+	stream := &failingRegisterStream{err: errors.New("broken stream")}
+	startHeartbeats(f, stream)
+
+	// Closing the client sends an unregister, which fails.
+	f.Close()
+
+	select {
+	case err := <-errs:
+		assert.ErrorIs(t, err, ErrUnregisterFailed)
+		assert.ErrorIs(t, err, stream.err)
+	case <-time.After(time.Second):
+		t.Fatal("heartbeat error callback not called")
+	}
+}
+
+func TestLocalNode_Errors(t *testing.T) {
+	f := NewOffline(nil, WithHeartbeatInterval(time.Millisecond))
+	defer f.Close()
+
+	stream := &failingRegisterStream{err: errors.New("broken stream")}
+	node := startHeartbeats(f, stream)
+
+	select {
+	case err := <-node.Errors():
+		assert.ErrorIs(t, err, stream.err)
+	case <-time.After(time.Second):
+		t.Fatal("heartbeat error not delivered")
+	}
+}
+
+func TestLocalNode_ErrorsDropsWhenFull(t *testing.T) {
+	f := NewOffline(
+		nil,
+		WithHeartbeatInterval(time.Millisecond),
+		WithHeartbeatFailureThreshold(errorsBufferSize*2),
+	)
+	defer f.Close()
+
+	node := startHeartbeats(f, &failingRegisterStream{err: errors.New("broken stream")})
+
+	// Nothing reads the errors channel, so the heartbeat loop must keep
+	// running and drop the errors once the buffer is full.
+	assert.Eventually(t, func() bool {
+		return f.Stats().HeartbeatErrors == errorsBufferSize*2
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, errorsBufferSize, len(node.Errors()))
+}
+
+func TestLocalNode_ErrorsClosedOnUnregister(t *testing.T) {
+	f := NewOffline(nil, WithHeartbeatInterval(time.Hour))
+	defer f.Close()
+
+	stream := &failingRegisterStream{err: errors.New("broken stream")}
+	node := startHeartbeats(f, stream)
+
+	// The unregister fails, so the error is delivered before the channel is
+	// closed.
+	assert.ErrorIs(t, node.Unregister(), ErrUnregisterFailed)
+
+	err, ok := <-node.Errors()
+	assert.True(t, ok)
+	assert.ErrorIs(t, err, ErrUnregisterFailed)
+	assert.ErrorIs(t, err, stream.err)
+
+	_, ok = <-node.Errors()
+	assert.False(t, ok)
+
+	// Unregistering again must not close the channel twice.
+	assert.NoError(t, node.Unregister())
+}
+
+func TestLocalNode_HeartbeatFailureThreshold(t *testing.T) {
+	server := newTestServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	var failures atomic.Int64
+	f, err := Connect(
+		ctx,
+		fromRPC(randomMember("local")),
+		[]string{server.addr},
+		WithHeartbeatInterval(time.Millisecond),
+		WithHeartbeatFailureThreshold(3),
+		WithOnHeartbeatError(func(err error) {
+			failures.Inc()
+		}),
+	)
+	require.NoError(t, err)
+	defer f.Close()
+
+	require.Eventually(t, func() bool {
+		return len(receivedIDs(server, rpc.ClientUpdateType_CLIENT_REGISTER)) == 1
+	}, time.Second, time.Millisecond)
+
+	// Replace the members stream with a stream that always fails, as if the
+	// register stream were broken.
+	f.mu.Lock()
+	node := f.localNodes["local"]
+	f.mu.Unlock()
+
+	stream := &failingRegisterStream{err: errors.New("broken stream")}
+	node.mu.Lock()
+	node.stream = stream
+	node.mu.Unlock()
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		node.streamHeartbeats(context.Background(), stream)
+	}()
+
+	// After 3 failures the member must be registered on a new stream.
+	assert.Eventually(t, func() bool {
+		return len(receivedIDs(server, rpc.ClientUpdateType_CLIENT_REGISTER)) == 2
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, int64(3), failures.Load())
+}
+
+func TestLocalNode_HeartbeatPerTick(t *testing.T) {
+	clock := newFakeClock()
+	f := NewOffline(nil, WithHeartbeatInterval(time.Second), withClock(clock))
+	defer f.Close()
+
+	stream := &recordingRegisterStream{}
+	startHeartbeats(f, stream)
+
+	// Wait for the heartbeat ticker to be created before advancing.
+	require.Eventually(t, func() bool {
+		return clock.NumTickers() == 1
+	}, time.Second, time.Millisecond)
+
+	assert.Equal(t, 0, stream.NumSent())
+	for i := 1; i <= 3; i++ {
+		clock.Advance(time.Second)
+		require.Eventually(t, func() bool {
+			return stream.NumSent() == i
+		}, time.Second, time.Millisecond)
+	}
+
+	// Advancing less than the interval must not send a heartbeat.
+	clock.Advance(time.Millisecond * 500)
+	time.Sleep(time.Millisecond * 10)
+	assert.Equal(t, 3, stream.NumSent())
+
+	for _, update := range stream.Sent() {
+		assert.Equal(t, rpc.ClientUpdateType_CLIENT_HEARTBEAT, update.UpdateType)
+	}
+}
+
+// startHeartbeats adds a local node to the client using the given stream and
+// starts sending heartbeats.
+func startHeartbeats(f *Fuddle, stream rpc.ClientWriteRegistry_RegisterClient) *LocalNode {
+	node := newLocalNode("local", f)
+	node.stream = stream
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		node.streamHeartbeats(context.Background(), stream)
+	}()
+	return node
+}
+
+// failingRegisterStream is a register stream whose Send always fails.
+type failingRegisterStream struct {
+	rpc.ClientWriteRegistry_RegisterClient
+
+	err error
+}
+
+func (s *failingRegisterStream) Send(*rpc.ClientUpdate) error {
+	return s.err
+}
+
+func (s *failingRegisterStream) CloseSend() error {
+	return nil
+}
+
+// registerWithWriteClient registers a local member with ID 'local' on a client
+// using the given write client, as if the client were connected.
+func registerWithWriteClient(t *testing.T, client rpc.ClientWriteRegistryClient, opts ...Option) (*Fuddle, *LocalNode) {
+	opts = append([]Option{WithReconnectBackoff(Backoff{
+		Initial: time.Millisecond,
+		Max:     time.Millisecond,
+	})}, opts...)
+	f := NewOffline(nil, opts...)
+	t.Cleanup(f.Close)
+
+	f.writeClient = client
+	f.mu.Lock()
+	f.connected = true
+	f.mu.Unlock()
+
+	node, err := f.Register(context.Background(), fromRPC(randomMember("local")))
+	require.NoError(t, err)
+	return f, node
+}
+
+// statusCode returns the gRPC status code of the error, which may be
+// wrapped.
+func statusCode(err error) codes.Code {
+	var se interface{ GRPCStatus() *status.Status }
+	if !errors.As(err, &se) {
+		return codes.Unknown
+	}
+	return se.GRPCStatus().Code()
+}
+
+// fakeWriteClient is a write client whose Register returns the given
+// streams in order.
+type fakeWriteClient struct {
+	rpc.ClientWriteRegistryClient
+
+	streams   []*fakeRegisterStream
+	registers int
+	mu        sync.Mutex
+}
+
+func (c *fakeWriteClient) Register(ctx context.Context, _ ...grpc.CallOption) (rpc.ClientWriteRegistry_RegisterClient, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.registers >= len(c.streams) {
+		return nil, status.Error(codes.Unavailable, "no streams")
+	}
+	stream := c.streams[c.registers]
+	c.registers++
+	stream.mu.Lock()
+	stream.ctx = ctx
+	stream.mu.Unlock()
+	return stream, nil
+}
+
+// Registers returns the number of register streams opened.
+func (c *fakeWriteClient) Registers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.registers
+}
+
+// fakeRegisterStream is a register stream that fails with err once
+// failAfter updates have been sent, or never fails if failAfter is negative.
+// If blockClose is true, CloseAndRecv blocks until the stream context is
+// cancelled, as if the server never closed the stream.
+type fakeRegisterStream struct {
+	rpc.ClientWriteRegistry_RegisterClient
+
+	failAfter  int
+	err        error
+	blockClose bool
+	ctx        context.Context
+	sent       []*rpc.ClientUpdate
+	mu         sync.Mutex
+}
+
+func (s *fakeRegisterStream) Send(update *rpc.ClientUpdate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.failAfter >= 0 && len(s.sent) >= s.failAfter {
+		return s.err
+	}
+	s.sent = append(s.sent, update)
+	return nil
+}
+
+func (s *fakeRegisterStream) CloseSend() error {
+	return nil
+}
+
+func (s *fakeRegisterStream) CloseAndRecv() (*rpc.ClientAck, error) {
+	s.mu.Lock()
+	ctx := s.ctx
+	s.mu.Unlock()
+
+	if s.blockClose {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return nil, io.EOF
+}
+
+// Sent returns the updates sent to the stream.
+func (s *fakeRegisterStream) Sent() []*rpc.ClientUpdate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*rpc.ClientUpdate(nil), s.sent...)
+}
+
+// connectWithLocalNode connects to the server and registers an additional
+// local member with ID 'local-2' and the given metadata, waiting for the
+// member to be registered.
+func connectWithLocalNode(t *testing.T, server *testServer, metadata map[string]string) (*Fuddle, *LocalNode) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f, err := Connect(ctx, fromRPC(randomMember("local-1")), []string{server.addr})
+	require.NoError(t, err)
+
+	member := fromRPC(randomMember("local-2"))
+	member.Metadata = metadata
+	node, err := f.Register(ctx, member)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return len(receivedIDs(server, rpc.ClientUpdateType_CLIENT_REGISTER)) == 2
+	}, time.Second, time.Millisecond)
+
+	return f, node
+}
+
+// lastRegisteredStatus returns the status of the last register update
+// received by the server for the member with the given ID.
+func lastRegisteredStatus(s *testServer, id string) string {
+	var status string
+	for _, update := range s.Received() {
+		if update.UpdateType == rpc.ClientUpdateType_CLIENT_REGISTER && update.Member.Id == id {
+			status = update.Member.Status
+		}
+	}
+	return status
+}
+
+// lastRegistered returns the metadata of the last register update received by
+// the server for the member with the given ID.
+func lastRegistered(s *testServer, id string) map[string]string {
+	var metadata map[string]string
+	for _, update := range s.Received() {
+		if update.UpdateType == rpc.ClientUpdateType_CLIENT_REGISTER && update.Member.Id == id {
+			metadata = update.Member.Metadata
+		}
+	}
+	return metadata
+}
+
+// recordingRegisterStream is a register stream that records the updates sent.
+type recordingRegisterStream struct {
+	rpc.ClientWriteRegistry_RegisterClient
+
+	sent []*rpc.ClientUpdate
+	mu   sync.Mutex
+}
+
+func (s *recordingRegisterStream) Send(update *rpc.ClientUpdate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sent = append(s.sent, update)
+	return nil
+}
+
+func (s *recordingRegisterStream) Sent() []*rpc.ClientUpdate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]*rpc.ClientUpdate{}, s.sent...)
+}
+
+func (s *recordingRegisterStream) NumSent() int {
+	return len(s.Sent())
+}
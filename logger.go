@@ -25,14 +25,24 @@ func (l memberLogger) MarshalLogObject(e zapcore.ObjectEncoder) error {
 		e.AddString("state.locality.region", l.member.State.Locality.Region)
 		e.AddString("state.locality.az", l.member.State.Locality.AvailabilityZone)
 	}
-	e.AddString("state.started", l.member.State.Service)
+	e.AddInt64("state.started", l.member.State.Started)
 	e.AddString("state.revision", l.member.State.Revision)
 
 	e.AddString("liveness", strings.ToLower(l.member.Liveness.String()))
 
-	e.AddString("version.owner", l.member.Version.OwnerId)
-	e.AddInt64("version.timestamp", l.member.Version.Timestamp.Timestamp)
-	e.AddUint64("version.counter", l.member.Version.Timestamp.Counter)
+	// A malformed update may be missing its version, so log it as unknown
+	// rather than panicking.
+	if l.member.Version == nil {
+		e.AddString("version", "unknown")
+	} else {
+		e.AddString("version.owner", l.member.Version.OwnerId)
+		if l.member.Version.Timestamp == nil {
+			e.AddString("version.timestamp", "unknown")
+		} else {
+			e.AddInt64("version.timestamp", l.member.Version.Timestamp.Timestamp)
+			e.AddUint64("version.counter", l.member.Version.Timestamp.Counter)
+		}
+	}
 
 	e.AddInt64("expiry", l.member.Expiry)
 
@@ -0,0 +1,56 @@
+package fuddle
+
+import (
+	"testing"
+
+	rpc "github.com/fuddle-io/fuddle-rpc/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestMemberLogger(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	logger := zap.New(core)
+
+	logger.Debug("member", zap.Object("member", newMemberLogger(&rpc.Member2{
+		State: &rpc.MemberState{
+			Id:      "member-1",
+			Status:  "booting",
+			Service: "frontend",
+			Locality: &rpc.Locality{
+				Region:           "eu-west-2",
+				AvailabilityZone: "eu-west-2a",
+			},
+			Started:  1234,
+			Revision: "v0.1.0",
+		},
+		Liveness: rpc.Liveness_UP,
+		Version: &rpc.Version2{
+			OwnerId: "owner-1",
+			Timestamp: &rpc.MonotonicTimestamp{
+				Timestamp: 5678,
+				Counter:   3,
+			},
+		},
+		Expiry: 9012,
+	})))
+
+	require.Equal(t, 1, logs.Len())
+	assert.Equal(t, map[string]interface{}{
+		"state.id":              "member-1",
+		"state.status":          "booting",
+		"state.service":         "frontend",
+		"state.locality.region": "eu-west-2",
+		"state.locality.az":     "eu-west-2a",
+		"state.started":         int64(1234),
+		"state.revision":        "v0.1.0",
+		"liveness":              "up",
+		"version.owner":         "owner-1",
+		"version.timestamp":     int64(5678),
+		"version.counter":       uint64(3),
+		"expiry":                int64(9012),
+	}, logs.All()[0].ContextMap()["member"])
+}
@@ -1,22 +1,39 @@
 package fuddle
 
 import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
 	rpc "github.com/fuddle-io/fuddle-rpc/go"
 )
 
 type Locality struct {
-	Region           string
-	AvailabilityZone string
+	Region           string `json:"region"`
+	AvailabilityZone string `json:"availability_zone"`
 }
 
+// attributeKeyPrefix is the prefix of the metadata keys containing the members
+// attributes. Since the registry protocol has no attributes field, attributes
+// are sent to the registry as metadata with this prefix.
+const attributeKeyPrefix = "fuddle.attr."
+
 type Member struct {
-	ID       string
-	Status   string
-	Service  string
-	Locality Locality
-	Started  int64
-	Revision string
-	Metadata map[string]string
+	ID       string            `json:"id"`
+	Status   string            `json:"status"`
+	Service  string            `json:"service"`
+	Locality Locality          `json:"locality"`
+	Started  int64             `json:"started"`
+	Revision string            `json:"revision"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Attributes contains application defined key-value pairs that are fixed
+	// when the member registers, such as the members rack or hardware class.
+	// Unlike Metadata, attributes can't be updated once registered.
+	//
+	// Attributes are stored in the registry as metadata with the
+	// 'fuddle.attr.' prefix, so metadata keys with that prefix are reserved.
+	Attributes map[string]string `json:"attributes,omitempty"`
 }
 
 func (m *Member) toRPC() *rpc.MemberState {
@@ -30,17 +47,22 @@ func (m *Member) toRPC() *rpc.MemberState {
 		},
 		Started:  m.Started,
 		Revision: m.Revision,
-		Metadata: m.Metadata,
+		// Copy the metadata so modifying the members metadata doesn't
+		// affect the registry.
+		Metadata: mergeAttributes(m.Metadata, m.Attributes),
 	}
 }
 
 func fromRPC(m *rpc.MemberState) Member {
+	metadata, attributes := splitAttributes(m.Metadata)
 	member := Member{
-		ID:       m.Id,
-		Service:  m.Service,
-		Started:  m.Started,
-		Revision: m.Revision,
-		Metadata: m.Metadata,
+		ID:         m.Id,
+		Status:     m.Status,
+		Service:    m.Service,
+		Started:    m.Started,
+		Revision:   m.Revision,
+		Metadata:   metadata,
+		Attributes: attributes,
 	}
 	if m.Locality != nil {
 		member.Locality = Locality{
@@ -50,3 +72,245 @@ func fromRPC(m *rpc.MemberState) Member {
 	}
 	return member
 }
+
+// Validate returns an error if the member is invalid, which is when it has
+// an empty ID, an empty service, metadata or attributes with an empty key or
+// value, or metadata with the reserved 'fuddle.attr.' key prefix.
+func (m *Member) Validate() error {
+	if m.ID == "" {
+		return fmt.Errorf("member: empty id")
+	}
+	if m.Service == "" {
+		return fmt.Errorf("member: %s: empty service", m.ID)
+	}
+	for k, v := range m.Metadata {
+		if k == "" {
+			return fmt.Errorf("member: %s: empty metadata key", m.ID)
+		}
+		if v == "" {
+			return fmt.Errorf("member: %s: empty metadata value: %s", m.ID, k)
+		}
+		if strings.HasPrefix(k, attributeKeyPrefix) {
+			return fmt.Errorf("member: %s: reserved metadata key: %s", m.ID, k)
+		}
+	}
+	for k, v := range m.Attributes {
+		if k == "" {
+			return fmt.Errorf("member: %s: empty attribute key", m.ID)
+		}
+		if v == "" {
+			return fmt.Errorf("member: %s: empty attribute value: %s", m.ID, k)
+		}
+	}
+	return nil
+}
+
+// Equal returns true if the member is equal to the given member. A nil and
+// empty metadata or attributes map are considered equal.
+func (m *Member) Equal(o Member) bool {
+	if m.ID != o.ID {
+		return false
+	}
+	if m.Status != o.Status {
+		return false
+	}
+	if m.Service != o.Service {
+		return false
+	}
+	if m.Locality.Region != o.Locality.Region {
+		return false
+	}
+	if m.Locality.AvailabilityZone != o.Locality.AvailabilityZone {
+		return false
+	}
+	if m.Started != o.Started {
+		return false
+	}
+	if m.Revision != o.Revision {
+		return false
+	}
+	return mapsEqual(m.Metadata, o.Metadata) &&
+		mapsEqual(m.Attributes, o.Attributes)
+}
+
+// Address returns the address in the members metadata with the given key
+// prefix, where the host is in '<prefix>.ip' and the port in '<prefix>.port'.
+// Such as Address("addr.rpc") returns '192.168.2.1:5562' given metadata
+// 'addr.rpc.ip' of '192.168.2.1' and 'addr.rpc.port' of '5562'.
+//
+// Returns false if either key is missing.
+func (m *Member) Address(prefix string) (string, bool) {
+	ip, ok := m.Metadata[prefix+".ip"]
+	if !ok {
+		return "", false
+	}
+	port, ok := m.Metadata[prefix+".port"]
+	if !ok {
+		return "", false
+	}
+	return net.JoinHostPort(ip, port), true
+}
+
+// MetadataString returns the metadata value with the given key. Returns false
+// if the key is missing.
+func (m *Member) MetadataString(key string) (string, bool) {
+	v, ok := m.Metadata[key]
+	return v, ok
+}
+
+// MetadataInt returns the metadata value with the given key parsed as an
+// integer, ignoring leading and trailing whitespace. Returns false if the key
+// is missing or the value isn't an integer.
+func (m *Member) MetadataInt(key string) (int, bool) {
+	v, ok := m.Metadata[key]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// MetadataBool returns the metadata value with the given key parsed as a
+// boolean, ignoring leading and trailing whitespace. Accepts the same values
+// as strconv.ParseBool, such as 'true', 'false', '1' and '0'. Returns false
+// if the key is missing or the value isn't a boolean.
+func (m *Member) MetadataBool(key string) (bool, bool) {
+	v, ok := m.Metadata[key]
+	if !ok {
+		return false, false
+	}
+	b, err := strconv.ParseBool(strings.TrimSpace(v))
+	if err != nil {
+		return false, false
+	}
+	return b, true
+}
+
+// Attribute returns the attribute with the given key. Returns false if the
+// key is missing.
+func (m *Member) Attribute(key string) (string, bool) {
+	v, ok := m.Attributes[key]
+	return v, ok
+}
+
+// Copy returns a deep copy of the member, so modifying the copies metadata or
+// attributes doesn't affect the original member.
+func (m *Member) Copy() Member {
+	cp := *m
+	cp.Metadata = copyMetadata(m.Metadata)
+	cp.Attributes = copyMetadata(m.Attributes)
+	return cp
+}
+
+// DiffMembers compares two sets of members, such as two registry snapshots
+// (see Fuddle.Snapshot), and returns the members in b that aren't in a
+// (added), the members in a that aren't in b (removed), and the members in
+// both whose state differs (changed), using Member.Equal. Members are matched
+// by ID, and changed contains the members state in b. Each result is sorted
+// by ID. So the sets are equal if all three results are empty.
+//
+// This is mainly useful for tests, to assert how the registry changes.
+func DiffMembers(a []Member, b []Member) (added []Member, removed []Member, changed []Member) {
+	before := make(map[string]Member, len(a))
+	for _, m := range a {
+		before[m.ID] = m
+	}
+	after := make(map[string]Member, len(b))
+	for _, m := range b {
+		after[m.ID] = m
+
+		old, ok := before[m.ID]
+		if !ok {
+			added = append(added, m.Copy())
+			continue
+		}
+		if !old.Equal(m) {
+			changed = append(changed, m.Copy())
+		}
+	}
+	for _, m := range a {
+		if _, ok := after[m.ID]; !ok {
+			removed = append(removed, m.Copy())
+		}
+	}
+
+	sortMembers(added)
+	sortMembers(removed)
+	sortMembers(changed)
+	return added, removed, changed
+}
+
+func copyMetadata(metadata map[string]string) map[string]string {
+	if metadata == nil {
+		return nil
+	}
+	cp := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		cp[k] = v
+	}
+	return cp
+}
+
+func mapsEqual(a map[string]string, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		bv, ok := b[k]
+		if !ok || v != bv {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeAttributes returns a copy of the metadata including the attributes,
+// where each attribute key has the attribute key prefix.
+func mergeAttributes(metadata map[string]string, attributes map[string]string) map[string]string {
+	if len(attributes) == 0 {
+		return copyMetadata(metadata)
+	}
+	merged := make(map[string]string, len(metadata)+len(attributes))
+	for k, v := range metadata {
+		merged[k] = v
+	}
+	for k, v := range attributes {
+		merged[attributeKeyPrefix+k] = v
+	}
+	return merged
+}
+
+// splitAttributes splits the registry metadata into the members metadata and
+// attributes. If the metadata has no attributes, it is returned unchanged
+// with nil attributes.
+func splitAttributes(metadata map[string]string) (map[string]string, map[string]string) {
+	if !hasAttributes(metadata) {
+		return metadata, nil
+	}
+
+	var plain map[string]string
+	attributes := make(map[string]string)
+	for k, v := range metadata {
+		if strings.HasPrefix(k, attributeKeyPrefix) {
+			attributes[strings.TrimPrefix(k, attributeKeyPrefix)] = v
+			continue
+		}
+		if plain == nil {
+			plain = make(map[string]string)
+		}
+		plain[k] = v
+	}
+	return plain, attributes
+}
+
+func hasAttributes(metadata map[string]string) bool {
+	for k := range metadata {
+		if strings.HasPrefix(k, attributeKeyPrefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,508 @@
+package fuddle
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMember_Equal(t *testing.T) {
+	member := Member{
+		ID:      "member-1",
+		Status:  "active",
+		Service: "orders",
+		Locality: Locality{
+			Region:           "us-east-1",
+			AvailabilityZone: "us-east-1-a",
+		},
+		Started:  123,
+		Revision: "v1.0.0",
+		Metadata: map[string]string{
+			"foo": "bar",
+		},
+	}
+
+	tests := []struct {
+		name   string
+		update func(m *Member)
+		equal  bool
+	}{
+		{
+			name:   "equal",
+			update: func(m *Member) {},
+			equal:  true,
+		},
+		{
+			name:   "id",
+			update: func(m *Member) { m.ID = "member-2" },
+		},
+		{
+			name:   "status",
+			update: func(m *Member) { m.Status = "down" },
+		},
+		{
+			name:   "service",
+			update: func(m *Member) { m.Service = "frontend" },
+		},
+		{
+			name:   "region",
+			update: func(m *Member) { m.Locality.Region = "us-west-1" },
+		},
+		{
+			name:   "availability zone",
+			update: func(m *Member) { m.Locality.AvailabilityZone = "us-east-1-b" },
+		},
+		{
+			name:   "started",
+			update: func(m *Member) { m.Started = 456 },
+		},
+		{
+			name:   "revision",
+			update: func(m *Member) { m.Revision = "v2.0.0" },
+		},
+		{
+			name:   "metadata value",
+			update: func(m *Member) { m.Metadata["foo"] = "car" },
+		},
+		{
+			name:   "metadata key",
+			update: func(m *Member) { m.Metadata = map[string]string{"car": "bar"} },
+		},
+		{
+			name:   "metadata extra key",
+			update: func(m *Member) { m.Metadata["car"] = "bar" },
+		},
+		{
+			name:   "metadata nil",
+			update: func(m *Member) { m.Metadata = nil },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := member.Copy()
+			tt.update(&o)
+			assert.Equal(t, tt.equal, member.Equal(o))
+			assert.Equal(t, tt.equal, o.Equal(member))
+		})
+	}
+}
+
+func TestMember_EqualNilAndEmptyMetadata(t *testing.T) {
+	a := Member{ID: "member-1"}
+	b := Member{ID: "member-1", Metadata: map[string]string{}}
+	assert.True(t, a.Equal(b))
+	assert.True(t, b.Equal(a))
+}
+
+func TestMember_Copy(t *testing.T) {
+	member := Member{
+		ID: "member-1",
+		Metadata: map[string]string{
+			"foo": "bar",
+		},
+	}
+
+	cp := member.Copy()
+	assert.True(t, member.Equal(cp))
+
+	cp.Metadata["foo"] = "car"
+	assert.Equal(t, "bar", member.Metadata["foo"])
+}
+
+func TestDiffMembers(t *testing.T) {
+	member := func(id string, status string) Member {
+		return Member{
+			ID:      id,
+			Service: "orders",
+			Status:  status,
+			Metadata: map[string]string{
+				"foo": "bar",
+			},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		a       []Member
+		b       []Member
+		added   []Member
+		removed []Member
+		changed []Member
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "equal",
+			a:    []Member{member("a", "up"), member("b", "up")},
+			// Order doesn't matter.
+			b: []Member{member("b", "up"), member("a", "up")},
+		},
+		{
+			name:  "added",
+			a:     []Member{member("a", "up")},
+			b:     []Member{member("c", "up"), member("a", "up"), member("b", "up")},
+			added: []Member{member("b", "up"), member("c", "up")},
+		},
+		{
+			name:    "removed",
+			a:       []Member{member("c", "up"), member("a", "up"), member("b", "up")},
+			b:       []Member{member("b", "up")},
+			removed: []Member{member("a", "up"), member("c", "up")},
+		},
+		{
+			name:    "changed",
+			a:       []Member{member("a", "up"), member("b", "up")},
+			b:       []Member{member("a", "draining"), member("b", "up")},
+			changed: []Member{member("a", "draining")},
+		},
+		{
+			name:    "added removed and changed",
+			a:       []Member{member("a", "up"), member("b", "up")},
+			b:       []Member{member("b", "draining"), member("c", "up")},
+			added:   []Member{member("c", "up")},
+			removed: []Member{member("a", "up")},
+			changed: []Member{member("b", "draining")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			added, removed, changed := DiffMembers(tt.a, tt.b)
+			assert.Equal(t, tt.added, added)
+			assert.Equal(t, tt.removed, removed)
+			assert.Equal(t, tt.changed, changed)
+		})
+	}
+}
+
+func TestDiffMembersMetadata(t *testing.T) {
+	a := Member{
+		ID:       "a",
+		Metadata: map[string]string{"foo": "bar"},
+	}
+	b := a.Copy()
+	b.Metadata["foo"] = "car"
+
+	_, _, changed := DiffMembers([]Member{a}, []Member{b})
+	assert.Equal(t, []Member{b}, changed)
+
+	// The results are copies, so can't modify the given members.
+	changed[0].Metadata["foo"] = "baz"
+	assert.Equal(t, "car", b.Metadata["foo"])
+
+	// A nil and empty metadata map are equal.
+	a.Metadata = nil
+	b.Metadata = map[string]string{}
+	added, removed, changed := DiffMembers([]Member{a}, []Member{b})
+	assert.Empty(t, added)
+	assert.Empty(t, removed)
+	assert.Empty(t, changed)
+}
+
+func TestMember_Validate(t *testing.T) {
+	valid := Member{
+		ID:      "member-1",
+		Service: "orders",
+		Metadata: map[string]string{
+			"foo": "bar",
+		},
+	}
+	assert.NoError(t, valid.Validate())
+
+	tests := []struct {
+		name   string
+		update func(m *Member)
+	}{
+		{
+			name:   "empty id",
+			update: func(m *Member) { m.ID = "" },
+		},
+		{
+			name:   "empty service",
+			update: func(m *Member) { m.Service = "" },
+		},
+		{
+			name:   "empty metadata key",
+			update: func(m *Member) { m.Metadata[""] = "bar" },
+		},
+		{
+			name:   "empty metadata value",
+			update: func(m *Member) { m.Metadata["foo"] = "" },
+		},
+		{
+			name:   "reserved metadata key",
+			update: func(m *Member) { m.Metadata["fuddle.attr.rack"] = "r1" },
+		},
+		{
+			name:   "empty attribute key",
+			update: func(m *Member) { m.Attributes = map[string]string{"": "r1"} },
+		},
+		{
+			name:   "empty attribute value",
+			update: func(m *Member) { m.Attributes = map[string]string{"rack": ""} },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := valid.Copy()
+			tt.update(&m)
+			assert.Error(t, m.Validate())
+		})
+	}
+}
+
+func TestMember_RPCRoundTrip(t *testing.T) {
+	m := Member{
+		ID:      "member-1",
+		Status:  "active",
+		Service: "orders",
+		Locality: Locality{
+			Region:           "eu-west-2",
+			AvailabilityZone: "eu-west-2a",
+		},
+		Started:  1234,
+		Revision: "v0.1.0",
+		Metadata: map[string]string{"foo": "bar"},
+	}
+	assert.Equal(t, m, fromRPC(m.toRPC()))
+}
+
+func TestMember_RPCRoundTripAttributes(t *testing.T) {
+	m := Member{
+		ID:       "member-1",
+		Service:  "orders",
+		Metadata: map[string]string{"foo": "bar"},
+		Attributes: map[string]string{
+			"rack":           "r12",
+			"hardware-class": "c5",
+		},
+	}
+
+	state := m.toRPC()
+	// The registry protocol has no attributes field, so attributes are
+	// sent as prefixed metadata.
+	assert.Equal(t, map[string]string{
+		"foo":                        "bar",
+		"fuddle.attr.rack":           "r12",
+		"fuddle.attr.hardware-class": "c5",
+	}, state.Metadata)
+	assert.Equal(t, m, fromRPC(state))
+
+	// Members with attributes but no metadata have nil metadata.
+	m.Metadata = nil
+	assert.Equal(t, m, fromRPC(m.toRPC()))
+}
+
+func TestMember_EqualAttributes(t *testing.T) {
+	a := Member{ID: "member-1", Attributes: map[string]string{"rack": "r1"}}
+	b := Member{ID: "member-1", Attributes: map[string]string{"rack": "r2"}}
+	assert.False(t, a.Equal(b))
+	assert.True(t, a.Equal(a.Copy()))
+	assert.True(t, (&Member{ID: "member-1"}).Equal(Member{
+		ID:         "member-1",
+		Attributes: map[string]string{},
+	}))
+}
+
+func TestMember_CopyAttributes(t *testing.T) {
+	member := Member{
+		ID:         "member-1",
+		Attributes: map[string]string{"rack": "r1"},
+	}
+
+	cp := member.Copy()
+	cp.Attributes["rack"] = "r2"
+	assert.Equal(t, "r1", member.Attributes["rack"])
+
+	v, ok := member.Attribute("rack")
+	assert.True(t, ok)
+	assert.Equal(t, "r1", v)
+	_, ok = member.Attribute("missing")
+	assert.False(t, ok)
+}
+
+func TestMember_Address(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata map[string]string
+		addr     string
+		ok       bool
+	}{
+		{
+			name: "present",
+			metadata: map[string]string{
+				"addr.rpc.ip":   "192.168.2.1",
+				"addr.rpc.port": "5562",
+			},
+			addr: "192.168.2.1:5562",
+			ok:   true,
+		},
+		{
+			name: "ipv6",
+			metadata: map[string]string{
+				"addr.rpc.ip":   "fd00::1",
+				"addr.rpc.port": "5562",
+			},
+			addr: "[fd00::1]:5562",
+			ok:   true,
+		},
+		{
+			name: "missing port",
+			metadata: map[string]string{
+				"addr.rpc.ip": "192.168.2.1",
+			},
+			ok: false,
+		},
+		{
+			name: "missing ip",
+			metadata: map[string]string{
+				"addr.rpc.port": "5562",
+			},
+			ok: false,
+		},
+		{
+			name: "different prefix",
+			metadata: map[string]string{
+				"addr.http.ip":   "192.168.2.1",
+				"addr.http.port": "8080",
+			},
+			ok: false,
+		},
+		{
+			name:     "no metadata",
+			metadata: nil,
+			ok:       false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := Member{ID: "member-1", Metadata: tt.metadata}
+			addr, ok := m.Address("addr.rpc")
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.addr, addr)
+		})
+	}
+}
+
+func TestMember_MetadataString(t *testing.T) {
+	m := Member{Metadata: map[string]string{"protocol.name": "grpc"}}
+
+	v, ok := m.MetadataString("protocol.name")
+	assert.True(t, ok)
+	assert.Equal(t, "grpc", v)
+
+	_, ok = m.MetadataString("unknown")
+	assert.False(t, ok)
+}
+
+func TestMember_MetadataInt(t *testing.T) {
+	m := Member{Metadata: map[string]string{
+		"protocol.version": "3",
+		"weight":           " 10 ",
+		"negative":         "-2",
+		"malformed":        "3.5",
+		"empty":            "",
+	}}
+
+	v, ok := m.MetadataInt("protocol.version")
+	assert.True(t, ok)
+	assert.Equal(t, 3, v)
+
+	v, ok = m.MetadataInt("weight")
+	assert.True(t, ok)
+	assert.Equal(t, 10, v)
+
+	v, ok = m.MetadataInt("negative")
+	assert.True(t, ok)
+	assert.Equal(t, -2, v)
+
+	for _, key := range []string{"malformed", "empty", "unknown"} {
+		v, ok = m.MetadataInt(key)
+		assert.False(t, ok, key)
+		assert.Equal(t, 0, v, key)
+	}
+}
+
+func TestMember_MetadataBool(t *testing.T) {
+	m := Member{Metadata: map[string]string{
+		"canary":    "true",
+		"draining":  " false ",
+		"enabled":   "1",
+		"malformed": "yes",
+	}}
+
+	v, ok := m.MetadataBool("canary")
+	assert.True(t, ok)
+	assert.True(t, v)
+
+	v, ok = m.MetadataBool("draining")
+	assert.True(t, ok)
+	assert.False(t, v)
+
+	v, ok = m.MetadataBool("enabled")
+	assert.True(t, ok)
+	assert.True(t, v)
+
+	for _, key := range []string{"malformed", "unknown"} {
+		v, ok = m.MetadataBool(key)
+		assert.False(t, ok, key)
+		assert.False(t, v, key)
+	}
+}
+
+func TestMember_JSON(t *testing.T) {
+	member := Member{
+		ID:      "member-1",
+		Status:  "active",
+		Service: "orders",
+		Locality: Locality{
+			Region:           "us-east-1",
+			AvailabilityZone: "us-east-1-a",
+		},
+		Started:  123,
+		Revision: "v1.0.0",
+		Metadata: map[string]string{
+			"foo": "bar",
+		},
+	}
+
+	b, err := json.Marshal(member)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"id": "member-1",
+		"status": "active",
+		"service": "orders",
+		"locality": {
+			"region": "us-east-1",
+			"availability_zone": "us-east-1-a"
+		},
+		"started": 123,
+		"revision": "v1.0.0",
+		"metadata": {
+			"foo": "bar"
+		}
+	}`, string(b))
+
+	var decoded Member
+	require.NoError(t, json.Unmarshal(b, &decoded))
+	assert.True(t, member.Equal(decoded))
+}
+
+func TestMember_JSONEmptyMetadata(t *testing.T) {
+	for _, metadata := range []map[string]string{nil, {}} {
+		member := Member{
+			ID:       "member-1",
+			Service:  "orders",
+			Metadata: metadata,
+		}
+
+		b, err := json.Marshal(member)
+		require.NoError(t, err)
+
+		var decoded Member
+		require.NoError(t, json.Unmarshal(b, &decoded))
+		assert.True(t, member.Equal(decoded))
+	}
+}
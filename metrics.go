@@ -0,0 +1,30 @@
+package fuddle
+
+// Metrics records metrics about the client, such as reconnects and the
+// number of members in the registry.
+//
+// Use the fuddleprom package to record Prometheus metrics.
+type Metrics interface {
+	// Reconnect is called when the client reconnects after the connection
+	// was dropped.
+	Reconnect()
+	// HeartbeatError is called when the client fails to send a heartbeat.
+	HeartbeatError()
+	// UpdateReceived is called when the client receives a registry update.
+	UpdateReceived()
+	// Members is called with the number of members in the registry
+	// whenever the registry changes.
+	Members(n int)
+	// ConnState is called when the connection state changes.
+	ConnState(state ConnState)
+}
+
+type nopMetrics struct{}
+
+func (m nopMetrics) Reconnect()                {}
+func (m nopMetrics) HeartbeatError()           {}
+func (m nopMetrics) UpdateReceived()           {}
+func (m nopMetrics) Members(n int)             {}
+func (m nopMetrics) ConnState(state ConnState) {}
+
+var _ Metrics = nopMetrics{}
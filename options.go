@@ -1,19 +1,86 @@
 package fuddle
 
 import (
+	"crypto/tls"
+	"fmt"
 	"time"
 
+	"github.com/fuddle-io/fuddle-go/internal/resolvers"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+	"golang.org/x/net/proxy"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	// Register the gzip compressor for WithCompression.
+	_ "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/keepalive"
 )
 
 type options struct {
+	connectTimeout        time.Duration
 	connectAttemptTimeout time.Duration
 	keepAlivePingInterval time.Duration
 	keepAlivePingTimeout  time.Duration
+	keepAliveParams       *keepalive.ClientParameters
 	heartbeatInterval     time.Duration
+	heartbeatMaxFailures  int
+	reconnectBackoff      Backoff
+
+	tls       bool
+	tlsConfig *tls.Config
+
+	dialOptions []grpc.DialOption
+
+	proxyDialer proxy.Dialer
+
+	maxRecvMsgSize int
+	maxSendMsgSize int
+
+	compression string
+
+	loadBalancingPolicy string
+
+	srvName string
+	// srvLookup overrides the SRV lookup for testing.
+	srvLookup resolvers.LookupSRVFunc
+	// clock overrides the system clock for testing.
+	clock clock
+
+	fuddleServiceName string
+
+	backupSeeds      []string
+	backupSeedsAfter time.Duration
+
+	ownerOnly bool
+
+	notifyCoalesce time.Duration
+
+	slowSubscriberThreshold time.Duration
+
+	asyncNotify bool
+
+	stalenessThreshold time.Duration
+
+	metadataBatchWindow time.Duration
+
+	confirmRegister bool
+
+	autoTimestamp bool
+
+	readinessProbe bool
+
+	clientID string
 
 	onConnectionStateChange func(state ConnState)
+	onDisconnected          func(reason DisconnectReason, err error)
+	onHeartbeatError        func(err error)
+	onIDConflict            func(local Member, remote Member)
+	onAllSeedsFailed        func()
+
+	tracerProvider trace.TracerProvider
 
+	metrics             Metrics
 	logger              *zap.Logger
 	grpcLoggerVerbosity int
 }
@@ -23,17 +90,110 @@ func defaultOptions() *options {
 		connectAttemptTimeout:   time.Second * 4,
 		keepAlivePingInterval:   time.Second * 10,
 		keepAlivePingTimeout:    time.Second * 5,
+		keepAliveParams:         nil,
 		heartbeatInterval:       time.Second * 5,
 		onConnectionStateChange: nil,
+		onDisconnected:          nil,
 		logger:                  zap.NewNop(),
 		grpcLoggerVerbosity:     0,
+		reconnectBackoff:        defaultBackoff(),
+		tls:                     false,
+		tlsConfig:               nil,
+		dialOptions:             nil,
+		proxyDialer:             nil,
+		maxRecvMsgSize:          0,
+		maxSendMsgSize:          0,
+		compression:             "",
+		connectTimeout:          0,
+		srvName:                 "",
+		srvLookup:               nil,
+		fuddleServiceName:       "fuddle",
+		metrics:                 nopMetrics{},
+		tracerProvider:          trace.NewNoopTracerProvider(),
+		onHeartbeatError:        nil,
+		onAllSeedsFailed:        nil,
+		heartbeatMaxFailures:    0,
+		clock:                   realClock{},
+		loadBalancingPolicy:     "",
+		ownerOnly:               false,
+		onIDConflict:            nil,
+		backupSeeds:             nil,
+		backupSeedsAfter:        time.Second * 30,
+		notifyCoalesce:          0,
+		slowSubscriberThreshold: 0,
+		asyncNotify:             false,
+		stalenessThreshold:      0,
+		metadataBatchWindow:     time.Millisecond * 10,
+		confirmRegister:         false,
+		autoTimestamp:           false,
+		readinessProbe:          false,
+		clientID:                uuid.New().String(),
 	}
 }
 
+func (o *options) validate() error {
+	if o.heartbeatInterval <= 0 {
+		return fmt.Errorf("heartbeat interval must be positive: %s", o.heartbeatInterval)
+	}
+	switch o.loadBalancingPolicy {
+	case "", loadBalancingPickFirst, loadBalancingRoundRobin:
+	default:
+		return fmt.Errorf("unknown load balancing policy: %s", o.loadBalancingPolicy)
+	}
+	if o.heartbeatMaxFailures < 0 {
+		return fmt.Errorf("heartbeat failure threshold must not be negative: %d", o.heartbeatMaxFailures)
+	}
+	if o.stalenessThreshold < 0 {
+		return fmt.Errorf("staleness threshold must not be negative: %s", o.stalenessThreshold)
+	}
+	if o.maxRecvMsgSize < 0 {
+		return fmt.Errorf("max receive message size must not be negative: %d", o.maxRecvMsgSize)
+	}
+	if o.maxSendMsgSize < 0 {
+		return fmt.Errorf("max send message size must not be negative: %d", o.maxSendMsgSize)
+	}
+	if o.compression != "" && encoding.GetCompressor(o.compression) == nil {
+		return fmt.Errorf("compressor not registered: %s", o.compression)
+	}
+	if o.clientID == "" {
+		return fmt.Errorf("client id must not be empty")
+	}
+	if o.metadataBatchWindow <= 0 {
+		return fmt.Errorf("metadata batch window must be positive: %s", o.metadataBatchWindow)
+	}
+	if o.notifyCoalesce < 0 {
+		return fmt.Errorf("notify coalesce window must not be negative: %s", o.notifyCoalesce)
+	}
+	if o.slowSubscriberThreshold < 0 {
+		return fmt.Errorf("slow subscriber threshold must not be negative: %s", o.slowSubscriberThreshold)
+	}
+	if o.backupSeedsAfter <= 0 {
+		return fmt.Errorf("backup seeds threshold must be positive: %s", o.backupSeedsAfter)
+	}
+	return nil
+}
+
 type Option interface {
 	apply(*options)
 }
 
+type connectTimeoutOption struct {
+	timeout time.Duration
+}
+
+func (o connectTimeoutOption) apply(opts *options) {
+	opts.connectTimeout = o.timeout
+}
+
+// WithConnectTimeout is the overall timeout for Connect, which may attempt
+// multiple seed addresses. Connect returns an error if it can't connect within
+// the timeout, or when the context passed to Connect is cancelled.
+//
+// Defaults to 0, meaning no timeout other than the Connect context.
+func WithConnectTimeout(timeout time.Duration) Option {
+	return connectTimeoutOption{timeout: timeout}
+}
+
 type connectAttemptTimeoutOption struct {
 	timeout time.Duration
 }
@@ -63,6 +223,8 @@ func (o keepAlivePingIntervalOption) apply(opts *options) {
 // connected Fuddle node, which is used to detect an unresponsive connection
 // and trigger a reconnection attempt.
 //
+// Ignored if WithKeepAliveParameters is used.
+//
 // Defaults to 10 seconds.
 func WithKeepAlivePingInterval(interval time.Duration) Option {
 	return keepAlivePingIntervalOption{interval: interval}
@@ -79,11 +241,38 @@ func (o keepAlivePingTimeoutOption) apply(opts *options) {
 // WithKeepAlivePingTimeout is the time to wait for a keepalive ping response
 // before timing out and considering the connection as failed.
 //
+// Ignored if WithKeepAliveParameters is used.
+//
 // Defaults to 4 seconds.
 func WithKeepAlivePingTimeout(timeout time.Duration) Option {
 	return keepAlivePingTimeoutOption{timeout: timeout}
 }
 
+type keepAliveParametersOption struct {
+	params keepalive.ClientParameters
+}
+
+func (o keepAliveParametersOption) apply(opts *options) {
+	opts.keepAliveParams = &o.params
+}
+
+// WithKeepAliveParameters sets the gRPC keepalive parameters, overriding
+// WithKeepAlivePingInterval and WithKeepAlivePingTimeout.
+//
+// By default the client sends keepalive pings even when there are no active
+// streams (PermitWithoutStream), such as before the client subscribes. If the
+// connected node enforces a keepalive policy that doesn't permit pings
+// without streams, or permits pings less frequently than the configured
+// interval, the node will close the connection with a GOAWAY
+// ('too_many_pings') and the client will reconnect. So the parameters must be
+// compatible with the nodes keepalive enforcement policy.
+//
+// Defaults to using the ping interval and timeout with PermitWithoutStream
+// enabled.
+func WithKeepAliveParameters(params keepalive.ClientParameters) Option {
+	return keepAliveParametersOption{params: params}
+}
+
 type heartbeatIntervalOption struct {
 	interval time.Duration
 }
@@ -92,10 +281,543 @@ func (o heartbeatIntervalOption) apply(opts *options) {
 	opts.heartbeatInterval = o.interval
 }
 
+// WithHeartbeatInterval is the interval to send heartbeats to the connected
+// Fuddle node for the registered member. The interval must be positive.
+//
+// Defaults to 5 seconds.
 func WithHeartbeatInterval(interval time.Duration) Option {
 	return heartbeatIntervalOption{interval: interval}
 }
 
+type heartbeatFailureThresholdOption struct {
+	threshold int
+}
+
+func (o heartbeatFailureThresholdOption) apply(opts *options) {
+	opts.heartbeatMaxFailures = o.threshold
+}
+
+// WithHeartbeatFailureThreshold re-registers a member on a new register
+// stream after the given number of consecutive heartbeat failures. This
+// detects a broken register stream sooner than waiting for the keepalive
+// pings to fail, such as when the connection is half-open.
+//
+// Defaults to 0, meaning the member stops sending heartbeats after the first
+// failure until the client reconnects.
+func WithHeartbeatFailureThreshold(n int) Option {
+	return heartbeatFailureThresholdOption{threshold: n}
+}
+
+type reconnectBackoffOption struct {
+	backoff Backoff
+}
+
+func (o reconnectBackoffOption) apply(opts *options) {
+	opts.reconnectBackoff = o.backoff
+}
+
+// WithReconnectBackoff configures the delay between reconnect attempts
+// while disconnected. The delay is reset once the client reconnects. Any zero
+// fields in the backoff use the default.
+//
+// Defaults to an initial delay of 100ms, a max delay of 30 seconds, a
+// multiplier of 1.6 and a jitter of 0.2.
+func WithReconnectBackoff(backoff Backoff) Option {
+	return reconnectBackoffOption{backoff: backoff}
+}
+
+type tlsOption struct {
+	config *tls.Config
+}
+
+func (o tlsOption) apply(opts *options) {
+	opts.tls = true
+	opts.tlsConfig = o.config
+}
+
+// WithTLS connects to the Fuddle nodes using TLS with the given config. If
+// the config is nil, the default config is used, which verifies the server
+// certificate using the system root CAs.
+//
+// Unless the config sets ServerName, the server certificate is verified
+// against the host of the seed address being connected to.
+//
+// Defaults to connecting without TLS.
+func WithTLS(config *tls.Config) Option {
+	return tlsOption{config: config}
+}
+
+type dialOptionsOption struct {
+	opts []grpc.DialOption
+}
+
+func (o dialOptionsOption) apply(opts *options) {
+	opts.dialOptions = append(opts.dialOptions, o.opts...)
+}
+
+// WithDialOptions adds custom gRPC dial options, such as interceptors or
+// compression, used when connecting to Fuddle nodes.
+//
+// Note the client relies on the following dial options, which override any
+// conflicting user options:
+//   - Transport credentials (use WithTLS instead)
+//   - Resolvers
+//   - Context dialer
+//   - Block
+//   - Return connection error
+//   - Keepalive parameters (use WithKeepAliveParameters,
+//     WithKeepAlivePingInterval and WithKeepAlivePingTimeout instead)
+//   - Connect parameters (use WithReconnectBackoff and
+//     WithConnectAttemptTimeout instead)
+//   - Default service config, if WithLoadBalancingPolicy is used
+//   - Default call options, if WithMaxRecvMsgSize, WithMaxSendMsgSize or
+//     WithCompression is used
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return dialOptionsOption{opts: opts}
+}
+
+type proxyDialerOption struct {
+	dialer proxy.Dialer
+}
+
+func (o proxyDialerOption) apply(opts *options) {
+	opts.proxyDialer = o.dialer
+}
+
+// WithProxyDialer connects to Fuddle nodes through a proxy using the given
+// dialer, such as a SOCKS5 dialer from golang.org/x/net/proxy. To use the
+// proxy configured in the ALL_PROXY and NO_PROXY environment variables, pass
+// proxy.FromEnvironment().
+//
+// The connect attempt timeout (see WithConnectAttemptTimeout) still applies
+// to each connection through the proxy, though only if the dialer implements
+// proxy.ContextDialer, which the dialers in golang.org/x/net/proxy do.
+//
+// Defaults to nil, which connects to Fuddle nodes directly.
+func WithProxyDialer(dialer proxy.Dialer) Option {
+	return proxyDialerOption{dialer: dialer}
+}
+
+type compressionOption struct {
+	name string
+}
+
+func (o compressionOption) apply(opts *options) {
+	opts.compression = o.name
+}
+
+// WithCompression sets the compressor used to compress requests, such as
+// 'gzip'. The connected node compresses the update stream using the same
+// compressor, which can significantly reduce bandwidth since members often
+// have repetitive metadata, such as for clients connecting over a wide area
+// network.
+//
+// Compression uses more CPU on both the client and node, so is typically
+// only worthwhile when bandwidth is limited.
+//
+// The compressor must be registered with gRPC (see
+// google.golang.org/grpc/encoding.RegisterCompressor). The 'gzip' compressor
+// is always registered.
+//
+// Defaults to no compression.
+func WithCompression(name string) Option {
+	return compressionOption{name: name}
+}
+
+type maxRecvMsgSizeOption struct {
+	size int
+}
+
+func (o maxRecvMsgSizeOption) apply(opts *options) {
+	opts.maxRecvMsgSize = o.size
+}
+
+// WithMaxRecvMsgSize sets the maximum size in bytes of a message the client
+// can receive from the connected node.
+//
+// In large clusters, the initial registry snapshot may exceed the limit, in
+// which case the update stream fails with a RESOURCE_EXHAUSTED error ('grpc:
+// received message larger than max') immediately after connecting, so the
+// client never syncs.
+//
+// Defaults to 0, which uses the gRPC default of 4MB.
+func WithMaxRecvMsgSize(size int) Option {
+	return maxRecvMsgSizeOption{size: size}
+}
+
+type maxSendMsgSizeOption struct {
+	size int
+}
+
+func (o maxSendMsgSizeOption) apply(opts *options) {
+	opts.maxSendMsgSize = o.size
+}
+
+// WithMaxSendMsgSize sets the maximum size in bytes of a message the client
+// can send to the connected node, such as a member with a lot of metadata.
+// Sending a larger message fails with a RESOURCE_EXHAUSTED error.
+//
+// Note the connected node also limits the size of messages it receives.
+//
+// Defaults to 0, which uses the gRPC default of no limit.
+func WithMaxSendMsgSize(size int) Option {
+	return maxSendMsgSizeOption{size: size}
+}
+
+const (
+	loadBalancingPickFirst  = "pick_first"
+	loadBalancingRoundRobin = "round_robin"
+)
+
+type loadBalancingPolicyOption struct {
+	policy string
+}
+
+func (o loadBalancingPolicyOption) apply(opts *options) {
+	opts.loadBalancingPolicy = o.policy
+}
+
+// WithLoadBalancingPolicy sets the gRPC load balancing policy used to pick
+// which Fuddle node each stream is sent to, either 'pick_first' or
+// 'round_robin'.
+//
+// With 'pick_first' the client connects to a single Fuddle node, and
+// reconnects to another node if the connection fails. With 'round_robin' the
+// client connects to all known Fuddle nodes and spreads the update and
+// register streams across them. Keepalive pings are sent on each connection.
+//
+// Note with 'round_robin' the client is only considered disconnected once
+// it can't connect to any node, so if a single node fails the streams sent to
+// that node aren't re-established until the client reconnects. Use
+// WithHeartbeatFailureThreshold to re-register members sooner.
+//
+// Defaults to gRPC's default policy, 'pick_first', unless overridden with a
+// service config in WithDialOptions.
+func WithLoadBalancingPolicy(policy string) Option {
+	return loadBalancingPolicyOption{policy: policy}
+}
+
+type srvResolverOption struct {
+	name string
+}
+
+func (o srvResolverOption) apply(opts *options) {
+	opts.srvName = o.name
+}
+
+// WithSRVResolver discovers the Fuddle nodes to connect to by looking up the
+// SRV records for the given name, such as '_fuddle._tcp.example.com', instead
+// of using the seed addresses passed to Connect. The records are looked up
+// again whenever the client reconnects.
+func WithSRVResolver(name string) Option {
+	return srvResolverOption{name: name}
+}
+
+type clockOption struct {
+	clock clock
+}
+
+func (o clockOption) apply(opts *options) {
+	opts.clock = o.clock
+}
+
+// withClock overrides the system clock for testing.
+func withClock(c clock) Option {
+	return clockOption{clock: c}
+}
+
+type backupSeedsOption struct {
+	addrs []string
+}
+
+func (o backupSeedsOption) apply(opts *options) {
+	opts.backupSeeds = o.addrs
+}
+
+// WithBackupSeeds adds seed addresses of Fuddle nodes to connect to if the
+// primary seeds passed to Connect are unreachable, such as the nodes in
+// another region.
+//
+// Once the client has been unable to connect for longer than the threshold
+// set with WithBackupSeedsThreshold, the backup addresses are added to the
+// addresses the client tries. The backup addresses are then kept for the
+// life of the client, so the client doesn't drop a connection to a backup
+// node when the primary seeds recover.
+//
+// Backup seeds are ignored if WithSRVResolver is used.
+func WithBackupSeeds(addrs []string) Option {
+	return backupSeedsOption{addrs: addrs}
+}
+
+type backupSeedsThresholdOption struct {
+	threshold time.Duration
+}
+
+func (o backupSeedsThresholdOption) apply(opts *options) {
+	opts.backupSeedsAfter = o.threshold
+}
+
+// WithBackupSeedsThreshold is the time the client must be unable to connect
+// to the primary seeds before trying the backup seeds (see WithBackupSeeds).
+//
+// Defaults to 30 seconds.
+func WithBackupSeedsThreshold(threshold time.Duration) Option {
+	return backupSeedsThresholdOption{threshold: threshold}
+}
+
+type fuddleServiceNameOption struct {
+	name string
+}
+
+func (o fuddleServiceNameOption) apply(opts *options) {
+	opts.fuddleServiceName = o.name
+}
+
+// WithFuddleServiceName is the service name of the Fuddle node members in
+// the registry. The client discovers Fuddle nodes with this service name and
+// adds them to the seed addresses, so it can fail over to nodes that weren't
+// in the original seeds. Fuddle nodes are expected to include their address
+// in the 'addr.rpc.ip' and 'addr.rpc.port' metadata.
+//
+// Discovery is disabled if the name is empty or WithSRVResolver is used.
+//
+// Defaults to 'fuddle'.
+func WithFuddleServiceName(name string) Option {
+	return fuddleServiceNameOption{name: name}
+}
+
+type ownerOnlyOption struct {
+	ownerOnly bool
+}
+
+func (o ownerOnlyOption) apply(opts *options) {
+	opts.ownerOnly = o.ownerOnly
+}
+
+// WithOwnerOnly only receives updates for the members owned by the connected
+// Fuddle node, such as the members registered with that node, rather than
+// all members in the cluster.
+//
+// Note the registry then only contains a subset of the cluster, which changes
+// if the client reconnects to another node, so Members and subscribers won't
+// see members owned by other nodes. This should only be used by clients that
+// only care about the members of the node they're connected to, such as a
+// local agent.
+//
+// Defaults to false, to receive updates for all members.
+func WithOwnerOnly(ownerOnly bool) Option {
+	return ownerOnlyOption{ownerOnly: ownerOnly}
+}
+
+type stalenessThresholdOption struct {
+	threshold time.Duration
+}
+
+func (o stalenessThresholdOption) apply(opts *options) {
+	opts.stalenessThreshold = o.threshold
+}
+
+// WithStalenessThreshold configures Fuddle.Healthy to report the client as
+// unhealthy if it hasn't received an update from the connected node within
+// the threshold, such as if the update stream has stalled even though the
+// connection is still ready.
+//
+// The connected node only streams updates when the registry changes, so the
+// threshold must be longer than the expected time between updates, such as
+// the member heartbeat updates in the cluster, otherwise a quiet cluster is
+// reported as unhealthy.
+//
+// Defaults to 0, where the staleness of the stream isn't checked.
+func WithStalenessThreshold(threshold time.Duration) Option {
+	return stalenessThresholdOption{threshold: threshold}
+}
+
+type clientIDOption struct {
+	id string
+}
+
+func (o clientIDOption) apply(opts *options) {
+	opts.clientID = o.id
+}
+
+// WithClientID sets the ID of the client instance, which identifies the
+// connection separately from the members registered by the client, since
+// multiple members may share the connection.
+//
+// The ID is sent to the connected node in the 'fuddle-client-id' gRPC
+// metadata of every request, including the update and register streams, so
+// the node can correlate the streams of the client, including across
+// reconnects.
+//
+// Defaults to a random UUID generated when the client is created.
+func WithClientID(id string) Option {
+	return clientIDOption{id: id}
+}
+
+type confirmRegisterOption struct {
+	confirm bool
+}
+
+func (o confirmRegisterOption) apply(opts *options) {
+	opts.confirmRegister = o.confirm
+}
+
+// WithConfirmRegister configures Connect and Fuddle.Register to wait until
+// the connected node confirms the member is registered before returning, so
+// the member can be queried from other clients as soon as they return.
+//
+// The register stream doesn't acknowledge updates, so the member is
+// confirmed by looking it up on the connected node. If the member isn't
+// found, such as the register update was dropped, the member is registered
+// again using the reconnect backoff (see WithReconnectBackoff) until either
+// the member is found or the context is cancelled.
+//
+// Defaults to false, where Connect and Fuddle.Register return once the
+// register update is sent.
+func WithConfirmRegister(confirm bool) Option {
+	return confirmRegisterOption{confirm: confirm}
+}
+
+type autoTimestampOption struct{}
+
+func (o autoTimestampOption) apply(opts *options) {
+	opts.autoTimestamp = true
+}
+
+// WithAutoTimestamp sets the Started timestamp of the members passed to
+// Connect and Fuddle.Register to the current time in milliseconds if the
+// timestamp is zero.
+//
+// Note an explicit zero timestamp can't be distinguished from an unset
+// timestamp, so is also replaced.
+//
+// Defaults to off, where members are registered with the given timestamp.
+func WithAutoTimestamp() Option {
+	return autoTimestampOption{}
+}
+
+type readinessProbeOption struct{}
+
+func (o readinessProbeOption) apply(opts *options) {
+	opts.readinessProbe = true
+}
+
+// WithReadinessProbe sends a lightweight request to the connected node before
+// Connect returns, to check the node is serving the Fuddle registry rather
+// than only accepting connections, such as a node that is still starting.
+//
+// If the probe fails, the node is treated as unreachable and Connect tries
+// another seed address, until a node passes the probe or the addresses are
+// exhausted, in which case Connect returns an error. Each probe uses the
+// connect attempt timeout (see WithConnectAttemptTimeout). Addresses from
+// WithSRVResolver can't be excluded, so Connect returns an error if the probe
+// fails.
+//
+// Defaults to off, where the client is connected once the connection is
+// ready.
+func WithReadinessProbe() Option {
+	return readinessProbeOption{}
+}
+
+type metadataBatchWindowOption struct {
+	window time.Duration
+}
+
+func (o metadataBatchWindowOption) apply(opts *options) {
+	opts.metadataBatchWindow = o.window
+}
+
+// WithMetadataBatchWindow sets how long LocalNode.UpdateMetadataBatch waits
+// to collect further metadata updates before sending them to the connected
+// node in a single update.
+//
+// Defaults to 10ms.
+func WithMetadataBatchWindow(window time.Duration) Option {
+	return metadataBatchWindowOption{window: window}
+}
+
+type notifyCoalesceOption struct {
+	window time.Duration
+}
+
+func (o notifyCoalesceOption) apply(opts *options) {
+	opts.notifyCoalesce = o.window
+}
+
+// WithNotifyCoalesce coalesces the notifications to subscribers, to avoid
+// calling subscribers for every update in a burst, such as when the client
+// resyncs thousands of members after reconnecting.
+//
+// Once a subscriber is notified, any further changes within the window are
+// collapsed into a single notification at the end of the window, which is
+// passed the latest state of the registry. This trades notification latency
+// for fewer callbacks.
+//
+// Applies to Subscribe, SubscribeFilter, SubscribeMembers and
+// SubscribeMember. SubscribeDelta subscribers are still notified of every
+// change, since deltas can't be dropped.
+//
+// Defaults to 0, which notifies subscribers of every change.
+func WithNotifyCoalesce(window time.Duration) Option {
+	return notifyCoalesceOption{window: window}
+}
+
+type slowSubscriberThresholdOption struct {
+	threshold time.Duration
+}
+
+func (o slowSubscriberThresholdOption) apply(opts *options) {
+	opts.slowSubscriberThreshold = o.threshold
+}
+
+// WithSlowSubscriberThreshold logs a warning when notifying subscribers of an
+// update takes longer than the given threshold, including the number of
+// subscribers notified and the type of the slowest subscriber.
+//
+// Subscribers are notified one at a time, and the update stream waits for
+// the subscribers to be notified before processing the next update, so a
+// single blocking callback stalls all updates. This helps identify slow
+// subscribers causing the client to fall behind.
+//
+// Defaults to 0, which doesn't measure notifications.
+func WithSlowSubscriberThreshold(threshold time.Duration) Option {
+	return slowSubscriberThresholdOption{threshold: threshold}
+}
+
+type asyncNotifyOption struct{}
+
+func (o asyncNotifyOption) apply(opts *options) {
+	opts.asyncNotify = true
+}
+
+// WithAsyncNotify notifies each subscriber on its own goroutine, so a slow
+// subscriber doesn't delay processing updates or notifying other
+// subscribers.
+//
+// Each subscriber has a buffered queue of pending notifications, which are
+// still delivered one at a time in the order they were queued. If a
+// subscriber falls behind and its queue is full, further notifications are
+// coalesced into the last pending notification instead of blocking. Since
+// Subscribe, SubscribeFilter, SubscribeMembers and SubscribeMember callbacks
+// are passed the latest state, the superseded notifications are dropped, and
+// the pending SubscribeDelta deltas are merged into the net changes. So a
+// slow subscriber may skip intermediate states, but is always eventually
+// notified of the latest state of the registry.
+//
+// Notifications are no longer ordered across subscribers, such as a
+// subscriber may be notified of an update before an earlier subscriber, and
+// a callback may run after the client has moved on to later updates. Once the
+// client is closed, subscribers are no longer notified.
+//
+// WithSlowSubscriberThreshold has no effect, since subscribers can't delay
+// each other.
+//
+// Defaults to off, where subscribers are notified one at a time in the order
+// they subscribed.
+func WithAsyncNotify() Option {
+	return asyncNotifyOption{}
+}
+
 type onConnectionStateChangeOption struct {
 	cb func(state ConnState)
 }
@@ -112,6 +834,136 @@ func WithOnConnectionStateChange(cb func(state ConnState)) Option {
 	}
 }
 
+type onDisconnectOption struct {
+	cb func(reason DisconnectReason, err error)
+}
+
+func (o onDisconnectOption) apply(opts *options) {
+	opts.onDisconnected = o.cb
+}
+
+// WithOnDisconnect adds an optional callback that is called when the client
+// disconnects from a Fuddle node, with the reason for disconnecting where it
+// can be detected, and the error that closed the update stream if any.
+//
+// The reason is detected from the update stream, so if the connection is
+// closed before the node closes the stream, such as the node being
+// unreachable, the reason is DisconnectReasonConnectionLost.
+//
+// The callback is called after the connection state change callback (see
+// WithOnConnectionStateChange).
+func WithOnDisconnect(cb func(reason DisconnectReason, err error)) Option {
+	return onDisconnectOption{cb: cb}
+}
+
+type onHeartbeatErrorOption struct {
+	cb func(err error)
+}
+
+func (o onHeartbeatErrorOption) apply(opts *options) {
+	opts.onHeartbeatError = o.cb
+}
+
+// WithOnHeartbeatError adds an optional callback that is called when sending
+// a heartbeat or unregister for a registered member fails, such as to alert
+// that the member may expire before the client detects the connection has
+// failed.
+//
+// The callback is called from the members heartbeat goroutine, so should not
+// block.
+func WithOnHeartbeatError(cb func(err error)) Option {
+	return onHeartbeatErrorOption{cb: cb}
+}
+
+type onAllSeedsFailedOption struct {
+	cb func()
+}
+
+func (o onAllSeedsFailedOption) apply(opts *options) {
+	opts.onAllSeedsFailed = o.cb
+}
+
+// WithOnAllSeedsFailed adds an optional callback that is called when the
+// client is disconnected and has failed to connect to every known address,
+// including the seed, backup seed (if in use) and discovered addresses, such
+// as to trigger fallback behaviour when the cluster is unreachable. Unlike
+// WithOnConnectionStateChange, this distinguishes a failed node, which the
+// client recovers from by connecting to another node, from an unreachable
+// cluster.
+//
+// The callback is called at most once each time the client disconnects, and
+// the client keeps trying to reconnect. It isn't called if the initial
+// connection fails, since Connect returns an error, or if WithSRVResolver is
+// used, since the resolved addresses aren't known.
+//
+// The callback is called from the goroutine connecting to the node, so
+// should not block.
+func WithOnAllSeedsFailed(cb func()) Option {
+	return onAllSeedsFailedOption{cb: cb}
+}
+
+type onIDConflictOption struct {
+	cb func(local Member, remote Member)
+}
+
+func (o onIDConflictOption) apply(opts *options) {
+	opts.onIDConflict = o.cb
+}
+
+// WithOnIDConflict adds an optional callback that is called when the client
+// receives an update for a member with the same ID as one of its local
+// members, but the member was registered by another client. This typically
+// means two processes are misconfigured with the same member ID, so the
+// client won't see the other members updates.
+//
+// A conflict is detected when the members service, locality, started time or
+// revision differ, and the callback is called with the state of the local
+// member and the conflicting remote member. The callback is called for each
+// conflicting update, from the clients update goroutine, so should not block.
+func WithOnIDConflict(cb func(local Member, remote Member)) Option {
+	return onIDConflictOption{cb: cb}
+}
+
+type metricsOption struct {
+	metrics Metrics
+}
+
+func (o metricsOption) apply(opts *options) {
+	if o.metrics == nil {
+		opts.metrics = nopMetrics{}
+		return
+	}
+	opts.metrics = o.metrics
+}
+
+// WithMetrics records metrics about the client, such as reconnects and the
+// number of members in the registry. See the fuddleprom package to record
+// Prometheus metrics.
+func WithMetrics(metrics Metrics) Option {
+	return metricsOption{metrics: metrics}
+}
+
+type tracerProviderOption struct {
+	tp trace.TracerProvider
+}
+
+func (o tracerProviderOption) apply(opts *options) {
+	if o.tp == nil {
+		opts.tracerProvider = trace.NewNoopTracerProvider()
+		return
+	}
+	opts.tracerProvider = o.tp
+}
+
+// WithTracerProvider adds OpenTelemetry tracing using the given provider.
+// Spans are created when registering, updating and unregistering members,
+// and the trace context is propagated to the connected Fuddle node.
+//
+// Defaults to a no-op provider.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return tracerProviderOption{tp: tp}
+}
+
 type loggerOption struct {
 	logger *zap.Logger
 }
@@ -140,3 +992,67 @@ func (o grpcLoggerVerbosityOption) apply(opts *options) {
 func WithGRPCLoggerVerbosity(v int) Option {
 	return grpcLoggerVerbosityOption{v: v}
 }
+
+type membersOptions struct {
+	filter MemberFilter
+}
+
+func defaultMembersOptions() *membersOptions {
+	return &membersOptions{
+		filter: nil,
+	}
+}
+
+// MembersOption configures a members query.
+type MembersOption interface {
+	apply(*membersOptions)
+}
+
+type filterOption struct {
+	filter MemberFilter
+}
+
+func (o filterOption) apply(opts *membersOptions) {
+	opts.filter = o.filter
+}
+
+// WithFilter filters the returned members to only include those matching
+// the filter, which is either a *Filter or AnyFilter. A nil filter, or
+// MatchAll, includes all members, whereas an empty filter, or MatchNone,
+// includes no members.
+//
+// Defaults to no filter, which includes all members.
+func WithFilter(f MemberFilter) MembersOption {
+	return filterOption{filter: f}
+}
+
+type subscribeOptions struct {
+	bootstrap bool
+}
+
+func defaultSubscribeOptions() *subscribeOptions {
+	return &subscribeOptions{
+		bootstrap: true,
+	}
+}
+
+// SubscribeOption configures a subscription.
+type SubscribeOption interface {
+	apply(*subscribeOptions)
+}
+
+type withoutBootstrapOption struct{}
+
+func (o withoutBootstrapOption) apply(opts *subscribeOptions) {
+	opts.bootstrap = false
+}
+
+// WithoutBootstrap skips notifying the subscriber immediately after
+// subscribing, so the subscriber is only notified of later changes. This
+// avoids scanning the registry when subscribing if the caller only cares
+// about future changes.
+//
+// Defaults to notifying the subscriber immediately to bootstrap.
+func WithoutBootstrap() SubscribeOption {
+	return withoutBootstrapOption{}
+}
@@ -0,0 +1,196 @@
+package fuddle
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+)
+
+func TestOptions_HeartbeatInterval(t *testing.T) {
+	options := defaultOptions()
+	assert.Equal(t, time.Second*5, options.heartbeatInterval)
+	assert.NoError(t, options.validate())
+
+	WithHeartbeatInterval(time.Second).apply(options)
+	assert.Equal(t, time.Second, options.heartbeatInterval)
+	assert.NoError(t, options.validate())
+
+	f := newFuddle(fromRPC(randomMember("local")), options)
+	assert.Equal(t, time.Second, f.heartbeatInterval)
+}
+
+func TestOptions_HeartbeatFailureThreshold(t *testing.T) {
+	options := defaultOptions()
+	assert.Equal(t, 0, options.heartbeatMaxFailures)
+
+	WithHeartbeatFailureThreshold(3).apply(options)
+	assert.NoError(t, options.validate())
+	f := newFuddle(fromRPC(randomMember("local")), options)
+	assert.Equal(t, 3, f.heartbeatMaxFailures)
+
+	WithHeartbeatFailureThreshold(-1).apply(options)
+	assert.Error(t, options.validate())
+}
+
+func TestOptions_HeartbeatIntervalInvalid(t *testing.T) {
+	for _, interval := range []time.Duration{0, -time.Second} {
+		options := defaultOptions()
+		WithHeartbeatInterval(interval).apply(options)
+		assert.Error(t, options.validate())
+
+		_, err := Connect(
+			context.Background(),
+			fromRPC(randomMember("local")),
+			[]string{"127.0.0.1:1"},
+			WithHeartbeatInterval(interval),
+		)
+		require.Error(t, err)
+	}
+}
+
+func TestOptions_LoadBalancingPolicy(t *testing.T) {
+	options := defaultOptions()
+	assert.Equal(t, "", options.loadBalancingPolicy)
+
+	for _, policy := range []string{"pick_first", "round_robin"} {
+		WithLoadBalancingPolicy(policy).apply(options)
+		assert.NoError(t, options.validate())
+	}
+
+	f := newFuddle(fromRPC(randomMember("local")), options)
+	assert.Equal(t, `{"loadBalancingConfig": [{"round_robin": {}}]}`, f.serviceConfig())
+
+	WithLoadBalancingPolicy("unknown").apply(options)
+	assert.Error(t, options.validate())
+}
+
+func TestOptions_BackupSeedsThreshold(t *testing.T) {
+	options := defaultOptions()
+	assert.Equal(t, time.Second*30, options.backupSeedsAfter)
+
+	for _, threshold := range []time.Duration{0, -time.Second} {
+		WithBackupSeedsThreshold(threshold).apply(options)
+		assert.Error(t, options.validate())
+	}
+}
+
+func TestOptions_NotifyCoalesce(t *testing.T) {
+	options := defaultOptions()
+	assert.Equal(t, time.Duration(0), options.notifyCoalesce)
+
+	WithNotifyCoalesce(time.Millisecond * 100).apply(options)
+	assert.NoError(t, options.validate())
+	f := newFuddle(fromRPC(randomMember("local")), options)
+	assert.Equal(t, time.Millisecond*100, f.registry.coalesce)
+
+	WithNotifyCoalesce(-time.Second).apply(options)
+	assert.Error(t, options.validate())
+}
+
+func TestOptions_SlowSubscriberThreshold(t *testing.T) {
+	options := defaultOptions()
+	assert.Equal(t, time.Duration(0), options.slowSubscriberThreshold)
+
+	WithSlowSubscriberThreshold(time.Millisecond * 100).apply(options)
+	assert.NoError(t, options.validate())
+	f := newFuddle(fromRPC(randomMember("local")), options)
+	assert.Equal(t, time.Millisecond*100, f.registry.slowThreshold)
+
+	WithSlowSubscriberThreshold(-time.Second).apply(options)
+	assert.Error(t, options.validate())
+}
+
+func TestOptions_AsyncNotify(t *testing.T) {
+	options := defaultOptions()
+	assert.False(t, options.asyncNotify)
+
+	WithAsyncNotify().apply(options)
+	f := newFuddle(fromRPC(randomMember("local")), options)
+	defer f.Close()
+	assert.True(t, f.registry.async)
+}
+
+func TestOptions_MetadataBatchWindow(t *testing.T) {
+	options := defaultOptions()
+	assert.Equal(t, time.Millisecond*10, options.metadataBatchWindow)
+
+	WithMetadataBatchWindow(time.Millisecond * 50).apply(options)
+	assert.NoError(t, options.validate())
+	f := newFuddle(fromRPC(randomMember("local")), options)
+	assert.Equal(t, time.Millisecond*50, f.metadataBatchWindow)
+
+	WithMetadataBatchWindow(0).apply(options)
+	assert.Error(t, options.validate())
+}
+
+func TestOptions_ClientIDEmpty(t *testing.T) {
+	options := defaultOptions()
+	assert.NotEqual(t, "", options.clientID)
+	assert.NoError(t, options.validate())
+
+	WithClientID("").apply(options)
+	assert.Error(t, options.validate())
+}
+
+func TestOptions_KeepAliveParameters(t *testing.T) {
+	options := defaultOptions()
+	WithKeepAlivePingInterval(time.Second * 20).apply(options)
+	WithKeepAlivePingTimeout(time.Second * 2).apply(options)
+
+	// Defaults to the ping interval and timeout.
+	f := newFuddle(fromRPC(randomMember("local")), options)
+	assert.Equal(t, keepalive.ClientParameters{
+		Time:                time.Second * 20,
+		Timeout:             time.Second * 2,
+		PermitWithoutStream: true,
+	}, f.keepAliveClientParams())
+
+	// The parameters must override the ping interval and timeout.
+	params := keepalive.ClientParameters{
+		Time:                time.Minute,
+		Timeout:             time.Second * 10,
+		PermitWithoutStream: false,
+	}
+	WithKeepAliveParameters(params).apply(options)
+	assert.NoError(t, options.validate())
+	f = newFuddle(fromRPC(randomMember("local")), options)
+	assert.Equal(t, params, f.keepAliveClientParams())
+}
+
+func TestOptions_MaxMsgSize(t *testing.T) {
+	options := defaultOptions()
+	f := newFuddle(fromRPC(randomMember("local")), options)
+	assert.Empty(t, f.callOptions())
+
+	WithMaxRecvMsgSize(8 << 20).apply(options)
+	WithMaxSendMsgSize(1 << 20).apply(options)
+	assert.NoError(t, options.validate())
+
+	f = newFuddle(fromRPC(randomMember("local")), options)
+	assert.Equal(t, []grpc.CallOption{
+		grpc.MaxCallRecvMsgSize(8 << 20),
+		grpc.MaxCallSendMsgSize(1 << 20),
+	}, f.callOptions())
+
+	WithMaxRecvMsgSize(-1).apply(options)
+	assert.Error(t, options.validate())
+	WithMaxRecvMsgSize(0).apply(options)
+	WithMaxSendMsgSize(-1).apply(options)
+	assert.Error(t, options.validate())
+}
+
+func TestOptions_Compression(t *testing.T) {
+	options := defaultOptions()
+	assert.Equal(t, "", options.compression)
+
+	WithCompression("gzip").apply(options)
+	assert.NoError(t, options.validate())
+
+	WithCompression("unknown").apply(options)
+	assert.Error(t, options.validate())
+}
@@ -1,70 +1,659 @@
 package fuddle
 
 import (
+	"fmt"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	rpc "github.com/fuddle-io/fuddle-rpc/go"
+	"go.uber.org/atomic"
 	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
 )
 
 type subscriber struct {
+	// Callback is called when the registry changes.
 	Callback func()
+	// MembersCallback is called when the registry changes with a snapshot
+	// of the members at the time of the notification. Only one of Callback,
+	// MembersCallback and DeltaCallback is set.
+	MembersCallback func(members []Member)
+	// DeltaCallback is called when the registry changes with the changes
+	// since the last notification.
+	DeltaCallback func(delta Delta)
+	// MemberCallback is called when the member with ID MemberID changes,
+	// with present false if the member isn't in the registry. Member
+	// subscribers are only notified of changes to that member.
+	MemberCallback func(member Member, present bool)
+	MemberID       string
+
+	// Filter is an optional filter where the subscriber is only notified
+	// when the set of members matching the filter changes.
+	Filter MemberFilter
+	// matched contains the state of the members that matched the filter
+	// when the subscriber was last notified.
+	matched map[string]*rpc.MemberState
+
+	// seq is the order the subscriber subscribed, used to notify
+	// subscribers in registration order.
+	seq uint64
+
+	// queue delivers the subscribers notifications on its own goroutine if
+	// the registry notifies subscribers asynchronously, otherwise nil.
+	queue *asyncQueue
+
+	// unsubscribed is set once the subscriber is removed to discard any
+	// pending notifications.
+	unsubscribed *atomic.Bool
+
+	// notified is when the subscriber was last notified, and coalescing is
+	// true while a deferred notification is pending. Only used when the
+	// registry coalesces notifications.
+	notified   time.Time
+	coalescing bool
+}
+
+// kind returns the type of subscriber for logging.
+func (s *subscriber) kind() string {
+	switch {
+	case s.MembersCallback != nil:
+		return "members"
+	case s.DeltaCallback != nil:
+		return "delta"
+	case s.MemberCallback != nil:
+		return "member"
+	case s.Filter != nil:
+		return "filter"
+	default:
+		return "callback"
+	}
+}
+
+// updateMatched updates the matched members given the new state of the
+// member with the given ID, where a nil state means the member was removed.
+// Returns true if the set of matched members changed.
+func (s *subscriber) updateMatched(id string, state *rpc.MemberState) bool {
+	prev, wasMatched := s.matched[id]
+	isMatched := state != nil && s.Filter.Match(fromRPC(state))
+
+	if !isMatched {
+		if !wasMatched {
+			return false
+		}
+		delete(s.matched, id)
+		return true
+	}
+
+	s.matched[id] = state
+	// Compare the member state content rather than pointers, since each
+	// remote update contains a new state even if it is unchanged.
+	return !wasMatched || !proto.Equal(prev, state)
+}
+
+// subscriberList contains subscribers in the order they subscribed.
+//
+// Removing a subscriber leaves a nil entry rather than shifting the later
+// subscribers, and the list is compacted once at least half the entries are
+// nil, so removal is amortized constant time.
+type subscriberList struct {
+	// subs contains the subscribers in registration order, where removed
+	// subscribers are nil until the list is compacted.
+	subs []*subscriber
+	// index contains the index of each subscriber in subs.
+	index map[*subscriber]int
+}
+
+func newSubscriberList() *subscriberList {
+	return &subscriberList{
+		index: make(map[*subscriber]int),
+	}
+}
+
+func (l *subscriberList) add(sub *subscriber) {
+	l.index[sub] = len(l.subs)
+	l.subs = append(l.subs, sub)
+}
+
+func (l *subscriberList) remove(sub *subscriber) {
+	i, ok := l.index[sub]
+	if !ok {
+		return
+	}
+	l.subs[i] = nil
+	delete(l.index, sub)
+
+	if len(l.index) <= len(l.subs)/2 {
+		l.compact()
+	}
+}
+
+// len returns the number of subscribers in the list.
+func (l *subscriberList) len() int {
+	return len(l.index)
+}
+
+// compact removes the nil entries of removed subscribers.
+func (l *subscriberList) compact() {
+	subs := make([]*subscriber, 0, len(l.index))
+	for _, sub := range l.subs {
+		if sub == nil {
+			continue
+		}
+		l.index[sub] = len(subs)
+		subs = append(subs, sub)
+	}
+	l.subs = subs
+}
+
+// mergeSubscribers returns the subscribers in a and b in registration order,
+// where both a and b must already be in registration order. The result may
+// still contain nil entries, which the caller must skip.
+func mergeSubscribers(a []*subscriber, b []*subscriber) []*subscriber {
+	merged := make([]*subscriber, 0, len(a)+len(b))
+	for {
+		for len(a) > 0 && a[0] == nil {
+			a = a[1:]
+		}
+		for len(b) > 0 && b[0] == nil {
+			b = b[1:]
+		}
+
+		switch {
+		case len(a) == 0:
+			return append(merged, b...)
+		case len(b) == 0:
+			return append(merged, a...)
+		case a[0].seq < b[0].seq:
+			merged = append(merged, a[0])
+			a = a[1:]
+		default:
+			merged = append(merged, b[0])
+			b = b[1:]
+		}
+	}
 }
 
 type registry struct {
 	// members contains the members in the registry known by the client.
 	members map[string]*rpc.Member2
-	localID string
+	// services indexes the IDs of the members in each service.
+	services map[string]map[string]interface{}
+	// localIDs contains the IDs of the members registered by the client.
+	localIDs map[string]interface{}
+	// tombstones contains the versions of recently removed members, keyed
+	// by member ID, so delayed updates older than the removal don't add the
+	// member back.
+	tombstones map[string]tombstone
+	// pruneTombstones is when expired tombstones are next removed.
+	pruneTombstones time.Time
 
-	subscribers map[*subscriber]interface{}
+	// epoch is incremented whenever the members change.
+	epoch uint64
+	// sorted caches copies of the members sorted by ID as of sortedEpoch,
+	// or nil if not yet built. The cached members must be copied before
+	// being returned.
+	sorted      []Member
+	sortedEpoch uint64
+
+	// subscribers contains the subscribers to all members in the order
+	// they subscribed.
+	subscribers *subscriberList
+	// memberSubscribers contains the subscribers to a single member, keyed
+	// by member ID.
+	memberSubscribers map[string]*subscriberList
+	// subscriberSeq is the sequence number of the last subscriber, used to
+	// order subscribers across subscribers and memberSubscribers.
+	subscriberSeq uint64
+
+	// notifications contains the pending subscriber notifications, which
+	// are delivered in the order they are queued.
+	notifications []notification
+	// notifying is true while a goroutine is delivering notifications.
+	notifying bool
+	// closed is true once the registry is closed, after which asynchronous
+	// subscribers are no longer notified.
+	closed bool
 
 	// mu protects the above fields.
 	mu sync.Mutex
 
-	logger *zap.Logger
+	// onIDConflict is an optional callback called when a remote update has
+	// the same ID as a local member but was registered by another client.
+	onIDConflict func(local Member, remote Member)
+
+	// coalesce is the window to coalesce subscriber notifications, or zero
+	// to notify subscribers of every change.
+	coalesce time.Duration
+	// slowThreshold is the duration after which delivering a batch of
+	// notifications logs a warning, or zero to not measure notifications.
+	slowThreshold time.Duration
+	clock         clock
+
+	// async is true if each subscriber is notified on its own goroutine.
+	async bool
+
+	// done is closed once the registry is closed, to stop goroutines
+	// waiting to deliver coalesced notifications.
+	done chan struct{}
+	// wg tracks the goroutines delivering coalesced notifications, which
+	// Close waits for.
+	wg sync.WaitGroup
+
+	metrics Metrics
+	logger  *zap.Logger
+}
+
+// tombstoneTTL is how long the version of a removed member is kept to
+// ignore delayed updates older than the removal.
+const tombstoneTTL = time.Minute
+
+// tombstone records the version of a removed member.
+type tombstone struct {
+	version *rpc.Version2
+	expiry  time.Time
 }
 
-func newRegistry(member Member, logger *zap.Logger) *registry {
-	members := make(map[string]*rpc.Member2)
-	members[member.ID] = &rpc.Member2{
+type notification struct {
+	sub    *subscriber
+	notify func()
+	// delta contains the delta passed to delta subscribers, so pending
+	// deltas can be merged by asyncQueue.
+	delta *Delta
+}
+
+func newRegistry(member Member, metrics Metrics, logger *zap.Logger) *registry {
+	r := newSnapshotRegistry([]Member{member}, metrics, logger)
+	r.localIDs[member.ID] = struct{}{}
+	return r
+}
+
+// newSnapshotRegistry returns a registry containing the given members, with
+// no local members.
+func newSnapshotRegistry(members []Member, metrics Metrics, logger *zap.Logger) *registry {
+	r := &registry{
+		members:     make(map[string]*rpc.Member2),
+		services:    make(map[string]map[string]interface{}),
+		localIDs:    make(map[string]interface{}),
+		tombstones:  make(map[string]tombstone),
+		subscribers: newSubscriberList(),
+		done:        make(chan struct{}),
+		clock:       realClock{},
+		metrics:     metrics,
+		logger:      logger,
+
+		memberSubscribers: make(map[string]*subscriberList),
+	}
+	for _, m := range members {
+		r.updateMemberLocked(&rpc.Member2{
+			State:    m.toRPC(),
+			Liveness: rpc.Liveness_UP,
+		})
+	}
+	return r
+}
+
+// LocalRPCMember returns the state of the local member with the given ID, or
+// nil if there is no local member with that ID.
+func (r *registry) LocalRPCMember(id string) *rpc.MemberState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.localIDs[id]; !ok {
+		return nil
+	}
+	return r.members[id].State
+}
+
+// LocalMember returns a copy of the local member with the given ID. Returns
+// false if there is no local member with that ID.
+func (r *registry) LocalMember(id string) (Member, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.localIDs[id]; !ok {
+		return Member{}, false
+	}
+	member := fromRPC(r.members[id].State)
+	return member.Copy(), true
+}
+
+// AddLocalMember adds a member registered by the client. Returns an error if
+// a local member with the same ID already exists.
+func (r *registry) AddLocalMember(member Member) error {
+	r.mu.Lock()
+
+	if _, ok := r.localIDs[member.ID]; ok {
+		r.mu.Unlock()
+		return fmt.Errorf("%w: member already registered: %s", ErrUpdateRejected, member.ID)
+	}
+	r.localIDs[member.ID] = struct{}{}
+
+	m := &rpc.Member2{
 		State:    member.toRPC(),
 		Liveness: rpc.Liveness_UP,
 	}
+	var delta Delta
+	if old, ok := r.updateMemberLocked(m); ok {
+		delta.Updated = append(delta.Updated, MemberUpdate{
+			Old: fromRPC(old.State),
+			New: fromRPC(m.State),
+		})
+	} else {
+		delta.Joined = append(delta.Joined, fromRPC(m.State))
+	}
+	r.queueSubscribersLocked(member.ID, m.State, delta)
 
-	return &registry{
-		members:     members,
-		localID:     member.ID,
-		subscribers: make(map[*subscriber]interface{}),
-		logger:      logger,
+	r.mu.Unlock()
+
+	r.deliverNotifications()
+
+	return nil
+}
+
+// UpdateLocalMetadata updates the metadata of the local member with the given
+// ID, where update is passed a copy of the members metadata to modify.
+// Returns an error if there is no local member with that ID.
+//
+// The members attributes are kept unchanged, so update is only passed the
+// metadata excluding attributes, and any keys added with the attribute key
+// prefix are ignored.
+func (r *registry) UpdateLocalMetadata(id string, update func(metadata map[string]string)) error {
+	return r.UpdateLocalMember(id, func(state *rpc.MemberState) {
+		metadata, attributes := splitAttributes(state.Metadata)
+		metadata = copyMetadata(metadata)
+		if metadata == nil {
+			metadata = make(map[string]string)
+		}
+		update(metadata)
+		for k := range metadata {
+			if strings.HasPrefix(k, attributeKeyPrefix) {
+				delete(metadata, k)
+			}
+		}
+		state.Metadata = mergeAttributes(metadata, attributes)
+	})
+}
+
+// UpdateLocalStatus updates the status of the local member with the given ID.
+// Returns an error if there is no local member with that ID.
+func (r *registry) UpdateLocalStatus(id string, status string) error {
+	return r.UpdateLocalMember(id, func(state *rpc.MemberState) {
+		state.Status = status
+	})
+}
+
+// UpdateLocalMember updates the local member with the given ID, where update
+// is passed a copy of the members state to modify. Subscribers are only
+// notified if the state changed. Returns an error if there is no local member
+// with that ID.
+func (r *registry) UpdateLocalMember(id string, update func(state *rpc.MemberState)) error {
+	r.mu.Lock()
+
+	if _, ok := r.localIDs[id]; !ok {
+		r.mu.Unlock()
+		return fmt.Errorf("%w: member not registered: %s", ErrUpdateRejected, id)
+	}
+
+	old := r.members[id]
+	state := proto.Clone(old.State).(*rpc.MemberState)
+	update(state)
+
+	if !proto.Equal(old.State, state) {
+		m := &rpc.Member2{
+			State:    state,
+			Liveness: rpc.Liveness_UP,
+		}
+		r.updateMemberLocked(m)
+		r.queueSubscribersLocked(id, state, Delta{
+			Updated: []MemberUpdate{{
+				Old: fromRPC(old.State),
+				New: fromRPC(state),
+			}},
+		})
 	}
+
+	r.mu.Unlock()
+
+	r.deliverNotifications()
+
+	return nil
 }
 
-func (r *registry) LocalRPCMember() *rpc.MemberState {
+// RemoveLocalMember removes the local member with the given ID. Does nothing
+// if there is no local member with that ID.
+func (r *registry) RemoveLocalMember(id string) {
+	r.mu.Lock()
+
+	if _, ok := r.localIDs[id]; !ok {
+		r.mu.Unlock()
+		return
+	}
+	delete(r.localIDs, id)
+
+	if old, ok := r.removeMemberLocked(id); ok {
+		r.queueSubscribersLocked(id, nil, Delta{
+			Left: []Member{fromRPC(old.State)},
+		})
+	}
+
+	r.mu.Unlock()
+
+	r.deliverNotifications()
+}
+
+func (r *registry) Members(opts ...MembersOption) []Member {
+	options := defaultMembersOptions()
+	for _, o := range opts {
+		o.apply(options)
+	}
+
+	filter := r.compileFilter(options.filter)
+
+	r.mu.Lock()
+	members := r.membersLocked(filter)
+	cached := r.sortedLocked()
+	epoch := r.epoch
+	r.mu.Unlock()
+
+	if cached {
+		return members
+	}
+	// Sort once the mutex is released, then cache the sorted members so
+	// later calls don't need to sort until the registry changes.
+	sortMembers(members)
+	if isMatchAll(filter) {
+		r.cacheSorted(members, epoch)
+	}
+	return members
+}
+
+// compileFilter compiles the filter so its patterns are compiled once rather
+// than for each member. If the filter is invalid, logs a warning and returns
+// the filter unchanged, where invalid patterns never match.
+func (r *registry) compileFilter(filter MemberFilter) MemberFilter {
+	compiled, err := compileMemberFilter(filter)
+	if err != nil {
+		r.logger.Warn("invalid members filter", zap.Error(err))
+		return filter
+	}
+	return compiled
+}
+
+// Count returns the number of members matching the filter, without copying
+// the members.
+func (r *registry) Count(opts ...MembersOption) int {
+	options := defaultMembersOptions()
+	for _, o := range opts {
+		o.apply(options)
+	}
+
+	filter := r.compileFilter(options.filter)
+
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	return r.members[r.localID].State
+	if isMatchAll(filter) {
+		return len(r.members)
+	}
+
+	count := 0
+	for _, m := range r.members {
+		// Note fromRPC shares the metadata with the registry state, which
+		// is safe since the filter doesn't modify the member.
+		if filter.Match(fromRPC(m.State)) {
+			count++
+		}
+	}
+	return count
 }
 
-func (r *registry) Members() []Member {
+// Member returns the member with the given ID, including local members.
+// Returns false if the member isn't in the registry.
+func (r *registry) Member(id string) (Member, bool) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	var members []Member
+	m, ok := r.members[id]
+	if !ok {
+		return Member{}, false
+	}
+	member := fromRPC(m.State)
+	return member.Copy(), true
+}
+
+// Query returns the members matching the filter sorted by ID, along with the
+// total number of members in the services the filter selects. Both are
+// computed under the same lock so are consistent with each other.
+func (r *registry) Query(filter *Filter) ([]Member, int) {
+	matched := r.compileFilter(filter)
+	services := filter.services()
+	matchAll := services.IsMatchAll()
+
+	r.mu.Lock()
+	total := 0
 	for _, m := range r.members {
-		members = append(members, fromRPC(m.State))
+		// Note fromRPC shares the metadata with the registry state, which
+		// is safe since the filter doesn't modify the member.
+		if matchAll || services.Match(fromRPC(m.State)) {
+			total++
+		}
+	}
+	members := r.membersLocked(matched)
+	r.mu.Unlock()
+
+	sortMembers(members)
+	return members, total
+}
+
+// MembersByService returns the known members in the given service. This only
+// iterates the members in the service, so is faster than filtering all
+// members by service.
+func (r *registry) MembersByService(service string) []Member {
+	r.mu.Lock()
+	var members []Member
+	for id := range r.services[service] {
+		member := fromRPC(r.members[id].State)
+		members = append(members, member.Copy())
 	}
+	r.mu.Unlock()
+
+	sortMembers(members)
 	return members
 }
 
+// Services returns the sorted names of the services with at least one member.
+// This uses the service index, so doesn't copy the members.
+func (r *registry) Services() []string {
+	r.mu.Lock()
+	services := make([]string, 0, len(r.services))
+	for service := range r.services {
+		services = append(services, service)
+	}
+	r.mu.Unlock()
+
+	sort.Strings(services)
+	return services
+}
+
+// Localities returns the distinct localities of the members, sorted by region
+// then availability zone.
+func (r *registry) Localities() []Locality {
+	r.mu.Lock()
+	seen := make(map[Locality]struct{})
+	for _, m := range r.members {
+		// Use the getters since the locality may be nil.
+		seen[Locality{
+			Region:           m.State.Locality.GetRegion(),
+			AvailabilityZone: m.State.Locality.GetAvailabilityZone(),
+		}] = struct{}{}
+	}
+	r.mu.Unlock()
+
+	localities := make([]Locality, 0, len(seen))
+	for locality := range seen {
+		localities = append(localities, locality)
+	}
+	sort.Slice(localities, func(i, j int) bool {
+		if localities[i].Region != localities[j].Region {
+			return localities[i].Region < localities[j].Region
+		}
+		return localities[i].AvailabilityZone < localities[j].AvailabilityZone
+	})
+	return localities
+}
+
+// GroupByService returns the known members grouped by service, with the
+// members in each service sorted by ID.
+func (r *registry) GroupByService() map[string][]Member {
+	r.mu.Lock()
+	members := r.membersLocked(nil)
+	r.mu.Unlock()
+
+	groups := make(map[string][]Member)
+	for _, m := range members {
+		groups[m.Service] = append(groups[m.Service], m)
+	}
+	for _, group := range groups {
+		sortMembers(group)
+	}
+	return groups
+}
+
+// GroupByLocality returns the known members grouped by locality, with the
+// members in each locality sorted by ID.
+func (r *registry) GroupByLocality() map[Locality][]Member {
+	r.mu.Lock()
+	members := r.membersLocked(nil)
+	r.mu.Unlock()
+
+	groups := make(map[Locality][]Member)
+	for _, m := range members {
+		groups[m.Locality] = append(groups[m.Locality], m)
+	}
+	for _, group := range groups {
+		sortMembers(group)
+	}
+	return groups
+}
+
+// KnownVersions returns the versions of the known remote members keyed by
+// member ID, which is sent to the connected node when subscribing so it only
+// sends members that have changed.
 func (r *registry) KnownVersions() map[string]*rpc.Version2 {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
 	versions := make(map[string]*rpc.Version2)
 	for id, m := range r.members {
-		// Exclude the local member.
-		if id == r.localID {
+		// Exclude the local members.
+		if _, ok := r.localIDs[id]; ok {
+			continue
+		}
+		// Exclude members without a version, so the server treats them as
+		// unknown and sends their latest state.
+		if m.Version == nil {
 			continue
 		}
 		versions[id] = m.Version
@@ -72,24 +661,220 @@ func (r *registry) KnownVersions() map[string]*rpc.Version2 {
 	return versions
 }
 
-func (r *registry) Subscribe(cb func()) func() {
-	r.mu.Lock()
+// knownVersion is the version of a known remote member.
+type knownVersion struct {
+	ID      string
+	Version *rpc.Version2
+}
 
-	sub := &subscriber{
+// String returns the member ID and version, formatted as
+// '<id>@<owner>/<timestamp>.<counter>'.
+func (v knownVersion) String() string {
+	return fmt.Sprintf(
+		"%s@%s/%d.%d",
+		v.ID,
+		v.Version.GetOwnerId(),
+		v.Version.GetTimestamp().GetTimestamp(),
+		v.Version.GetTimestamp().GetCounter(),
+	)
+}
+
+// sortedKnownVersions returns the known versions (see
+// registry.KnownVersions) sorted by member ID, so logs of the known versions
+// are reproducible.
+func sortedKnownVersions(versions map[string]*rpc.Version2) []knownVersion {
+	sorted := make([]knownVersion, 0, len(versions))
+	for id, v := range versions {
+		sorted = append(sorted, knownVersion{ID: id, Version: v})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].ID < sorted[j].ID
+	})
+	return sorted
+}
+
+func (r *registry) Subscribe(cb func(), opts ...SubscribeOption) func() {
+	return r.SubscribeFilter(nil, cb, opts...)
+}
+
+// SubscribeFilter subscribes to changes in the set of members matching the
+// filter. If the filter is nil the subscriber is notified of all changes.
+func (r *registry) SubscribeFilter(filter MemberFilter, cb func(), opts ...SubscribeOption) func() {
+	return r.subscribe(&subscriber{
 		Callback: cb,
+		Filter:   filter,
+	}, opts...)
+}
+
+// SubscribeMembers subscribes to changes in the registry, where the callback
+// is passed a snapshot of the members at the time of the notification.
+func (r *registry) SubscribeMembers(cb func(members []Member), opts ...SubscribeOption) func() {
+	return r.subscribe(&subscriber{
+		MembersCallback: cb,
+	}, opts...)
+}
+
+// SubscribeDelta subscribes to changes in the registry, where the callback
+// is passed the members that joined, left or were updated. When bootstrapping
+// all existing members are included as joined.
+func (r *registry) SubscribeDelta(cb func(delta Delta), opts ...SubscribeOption) func() {
+	return r.subscribe(&subscriber{
+		DeltaCallback: cb,
+	}, opts...)
+}
+
+// SubscribeMember subscribes to changes to the member with the given ID,
+// where the callback is passed the member and whether it is in the registry.
+// The subscriber is notified immediately to bootstrap, even if the member
+// isn't in the registry, unless WithoutBootstrap is used.
+func (r *registry) SubscribeMember(id string, cb func(member Member, present bool), opts ...SubscribeOption) func() {
+	options := defaultSubscribeOptions()
+	for _, o := range opts {
+		o.apply(options)
+	}
+
+	sub := &subscriber{
+		MemberCallback: cb,
+		MemberID:       id,
+		unsubscribed:   atomic.NewBool(false),
+	}
+
+	r.mu.Lock()
+
+	subs, ok := r.memberSubscribers[id]
+	if !ok {
+		subs = newSubscriberList()
+		r.memberSubscribers[id] = subs
+	}
+	r.subscriberSeq++
+	sub.seq = r.subscriberSeq
+	subs.add(sub)
+	r.startQueueLocked(sub)
+
+	if options.bootstrap {
+		var state *rpc.MemberState
+		if m, ok := r.members[id]; ok {
+			state = m.State
+		}
+		r.queueMemberNotificationLocked(sub, state)
+		r.notifiedLocked(sub)
+	}
+
+	r.mu.Unlock()
+
+	r.deliverNotifications()
+
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		sub.unsubscribed.Store(true)
+		if sub.queue != nil {
+			sub.queue.Close()
+		}
+		if subs, ok := r.memberSubscribers[id]; ok {
+			subs.remove(sub)
+			if subs.len() == 0 {
+				delete(r.memberSubscribers, id)
+			}
+		}
+	}
+}
+
+// subscribe adds the subscriber and notifies it immediately to bootstrap,
+// unless WithoutBootstrap is used. Returns a function to unsubscribe.
+func (r *registry) subscribe(sub *subscriber, opts ...SubscribeOption) func() {
+	options := defaultSubscribeOptions()
+	for _, o := range opts {
+		o.apply(options)
+	}
+
+	sub.unsubscribed = atomic.NewBool(false)
+
+	// Skip evaluating the filter for every member if it matches all
+	// members.
+	if isMatchAll(sub.Filter) {
+		sub.Filter = nil
+	}
+
+	r.mu.Lock()
+
+	if sub.Filter != nil {
+		sub.matched = r.matchedLocked(sub.Filter)
+	}
+	r.subscriberSeq++
+	sub.seq = r.subscriberSeq
+	r.subscribers.add(sub)
+	r.startQueueLocked(sub)
+
+	if options.bootstrap {
+		var delta Delta
+		if sub.DeltaCallback != nil {
+			// Bootstrap with all existing members as joined.
+			delta.Joined = r.membersLocked(nil)
+		}
+		// Queue the bootstrap notification so it is ordered with respect
+		// to notifications from concurrent updates.
+		r.queueNotificationLocked(sub, delta)
+		r.notifiedLocked(sub)
 	}
-	r.subscribers[sub] = struct{}{}
 
 	r.mu.Unlock()
 
-	// Ensure calling outside of the mutex.
-	cb()
+	r.deliverNotifications()
 
 	return func() {
 		r.mu.Lock()
 		defer r.mu.Unlock()
 
-		delete(r.subscribers, sub)
+		sub.unsubscribed.Store(true)
+		if sub.queue != nil {
+			sub.queue.Close()
+		}
+		r.subscribers.remove(sub)
+	}
+}
+
+// startQueueLocked starts delivering the subscribers notifications on its own
+// goroutine if the registry notifies subscribers asynchronously.
+//
+// Assumes the mutex is locked.
+func (r *registry) startQueueLocked(sub *subscriber) {
+	if !r.async {
+		return
+	}
+	sub.queue = newAsyncQueue()
+	if r.closed {
+		sub.queue.Close()
+		return
+	}
+	go sub.queue.Run()
+}
+
+// Close stops the goroutines delivering asynchronous and coalesced
+// notifications, and waits for any coalesced notification being delivered to
+// return.
+func (r *registry) Close() {
+	r.mu.Lock()
+	defer r.wg.Wait()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return
+	}
+	r.closed = true
+	close(r.done)
+	for _, sub := range r.subscribers.subs {
+		if sub != nil && sub.queue != nil {
+			sub.queue.Close()
+		}
+	}
+	for _, subs := range r.memberSubscribers {
+		for _, sub := range subs.subs {
+			if sub != nil && sub.queue != nil {
+				sub.queue.Close()
+			}
+		}
 	}
 }
 
@@ -99,45 +884,546 @@ func (r *registry) RemoteUpdate(m *rpc.Member2) {
 		zap.Object("member", newMemberLogger(m)),
 	)
 
-	if m.State.Id == r.localID {
+	r.mu.Lock()
+
+	// Ignore updates to the local members, since the client owns their
+	// state.
+	if _, ok := r.localIDs[m.State.Id]; ok {
+		local := r.members[m.State.Id]
+		// The connected node streams back the updates for the local
+		// members, so only a member with a different registration was
+		// registered by another client.
+		conflict := m.Liveness == rpc.Liveness_UP && !sameRegistration(local.State, m.State)
+		r.mu.Unlock()
+
+		if conflict {
+			r.logger.Warn(
+				"member id conflict; another client registered the same id",
+				zap.Object("member", newMemberLogger(m)),
+			)
+			if r.onIDConflict != nil {
+				r.onIDConflict(fromRPC(local.State), fromRPC(m.State))
+			}
+		}
 		return
 	}
 
+	// Ignore updates older than the known member, such as an update
+	// delivered out of order when resyncing after reconnecting.
+	if old, ok := r.members[m.State.Id]; ok && compareVersions(m.Version, old.Version) < 0 {
+		r.mu.Unlock()
+		return
+	}
+	// Similarly ignore updates older than the removal of a member, which
+	// would otherwise add the member back.
+	now := r.clock.Now()
+	if t, ok := r.tombstones[m.State.Id]; ok {
+		if now.Before(t.expiry) && compareVersions(m.Version, t.version) < 0 {
+			r.mu.Unlock()
+			return
+		}
+		delete(r.tombstones, m.State.Id)
+	}
+
+	var delta Delta
+	var state *rpc.MemberState
 	if m.Liveness == rpc.Liveness_UP {
-		r.updateMember(m)
+		state = m.State
+		if old, ok := r.updateMemberLocked(m); ok {
+			// Skip updates where the state is unchanged, such as when
+			// the connected node resyncs members after reconnecting.
+			// The member is still updated to store the new version.
+			if !proto.Equal(old.State, m.State) {
+				delta.Updated = append(delta.Updated, MemberUpdate{
+					Old: fromRPC(old.State),
+					New: fromRPC(m.State),
+				})
+			}
+		} else {
+			delta.Joined = append(delta.Joined, fromRPC(m.State))
+		}
 	} else {
-		r.removeMember(m.State.Id)
+		if old, ok := r.removeMemberLocked(m.State.Id); ok {
+			delta.Left = append(delta.Left, fromRPC(old.State))
+		}
+		r.addTombstoneLocked(m.State.Id, m.Version, now)
 	}
 
-	r.notifySubscribers()
-}
+	// Queue notifications in the same critical section as the update so
+	// the state passed to subscribers reflects this update. If nothing
+	// changed, such as removing an unknown member or an update with an
+	// unchanged state, there is nothing to notify.
+	if !delta.empty() {
+		r.queueSubscribersLocked(m.State.Id, state, delta)
+	}
 
-func (r *registry) updateMember(m *rpc.Member2) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+	r.mu.Unlock()
 
+	r.deliverNotifications()
+}
+
+// updateMemberLocked adds or updates the given member. Returns the previous
+// member state if the member already existed.
+//
+// Assumes the mutex is locked.
+func (r *registry) updateMemberLocked(m *rpc.Member2) (*rpc.Member2, bool) {
+	old, ok := r.members[m.State.Id]
+	if ok && old.State.Service != m.State.Service {
+		r.removeServiceIndexLocked(old.State.Service, m.State.Id)
+	}
 	r.members[m.State.Id] = m
+	if old == nil || old.State != m.State {
+		r.epoch++
+	}
+
+	ids, ok := r.services[m.State.Service]
+	if !ok {
+		ids = make(map[string]interface{})
+		r.services[m.State.Service] = ids
+	}
+	ids[m.State.Id] = struct{}{}
+
+	r.metrics.Members(len(r.members))
+
+	return old, old != nil
+}
+
+// removeMemberLocked removes the member with the given ID. Returns the
+// removed member if it existed.
+//
+// Assumes the mutex is locked.
+func (r *registry) removeMemberLocked(id string) (*rpc.Member2, bool) {
+	old, ok := r.members[id]
+	if !ok {
+		return nil, false
+	}
+	delete(r.members, id)
+	r.epoch++
+	r.removeServiceIndexLocked(old.State.Service, id)
+
+	r.metrics.Members(len(r.members))
+
+	return old, true
+}
+
+// addTombstoneLocked records the version of a removed member, and removes
+// any expired tombstones at most once per tombstoneTTL.
+//
+// Assumes the mutex is locked.
+func (r *registry) addTombstoneLocked(id string, version *rpc.Version2, now time.Time) {
+	if !now.Before(r.pruneTombstones) {
+		for removed, t := range r.tombstones {
+			if !now.Before(t.expiry) {
+				delete(r.tombstones, removed)
+			}
+		}
+		r.pruneTombstones = now.Add(tombstoneTTL)
+	}
+
+	r.tombstones[id] = tombstone{
+		version: version,
+		expiry:  now.Add(tombstoneTTL),
+	}
+}
+
+// removeServiceIndexLocked removes the member with the given ID from the
+// service index.
+//
+// Assumes the mutex is locked.
+func (r *registry) removeServiceIndexLocked(service string, id string) {
+	ids := r.services[service]
+	delete(ids, id)
+	if len(ids) == 0 {
+		delete(r.services, service)
+	}
+}
+
+// queueSubscribersLocked queues notifications for the subscribers of a change
+// to the member with the given ID, where state is the new member state or nil
+// if the member was removed, and delta describes the change. Subscribers are
+// notified in the order they subscribed.
+//
+// Assumes the mutex is locked.
+func (r *registry) queueSubscribersLocked(id string, state *rpc.MemberState, delta Delta) {
+	subs := r.subscribers.subs
+	if memberSubs, ok := r.memberSubscribers[id]; ok {
+		subs = mergeSubscribers(subs, memberSubs.subs)
+	}
+
+	for _, sub := range subs {
+		if sub == nil {
+			continue
+		}
+
+		if sub.MemberCallback != nil {
+			r.queueUpdateLocked(sub, func() {
+				r.queueMemberNotificationLocked(sub, state)
+			})
+			continue
+		}
+
+		// Skip subscribers whose matching members haven't changed.
+		if sub.Filter != nil && !sub.updateMatched(id, state) {
+			continue
+		}
+		r.queueUpdateLocked(sub, func() {
+			r.queueNotificationLocked(sub, delta)
+		})
+	}
+}
+
+// queueUpdateLocked queues the notification of a change for the subscriber
+// using queue. If the registry coalesces notifications and the subscriber was
+// notified within the coalesce window, the notification is deferred until
+// the end of the window instead, where any further changes in the window are
+// collapsed into the deferred notification.
+//
+// Delta subscribers are always notified, since the deltas can't be dropped.
+//
+// Assumes the mutex is locked.
+func (r *registry) queueUpdateLocked(sub *subscriber, queue func()) {
+	if r.coalesce == 0 || sub.DeltaCallback != nil {
+		queue()
+		return
+	}
+	if sub.coalescing {
+		// The pending notification will include this change.
+		return
+	}
+
+	// Once closed, Close may be waiting for the coalescing goroutines, so
+	// notify immediately rather than starting another.
+	if wait := sub.notified.Add(r.coalesce).Sub(r.clock.Now()); wait > 0 && !r.closed {
+		sub.coalescing = true
+		r.wg.Add(1)
+		go r.notifyCoalesced(sub, wait)
+		return
+	}
+
+	queue()
+	r.notifiedLocked(sub)
 }
 
-func (r *registry) removeMember(id string) {
+// notifyCoalesced waits for the given duration then notifies the subscriber
+// with the latest state of the registry, unless the subscriber was removed or
+// the registry closed while waiting.
+func (r *registry) notifyCoalesced(sub *subscriber, wait time.Duration) {
+	defer r.wg.Done()
+
+	select {
+	case <-r.clock.After(wait):
+	case <-r.done:
+		return
+	}
+
 	r.mu.Lock()
-	defer r.mu.Unlock()
 
-	delete(r.members, id)
+	sub.coalescing = false
+	if sub.unsubscribed.Load() || r.closed {
+		r.mu.Unlock()
+		return
+	}
+	if sub.MemberCallback != nil {
+		var state *rpc.MemberState
+		if m, ok := r.members[sub.MemberID]; ok {
+			state = m.State
+		}
+		r.queueMemberNotificationLocked(sub, state)
+	} else {
+		r.queueNotificationLocked(sub, Delta{})
+	}
+	r.notifiedLocked(sub)
+
+	r.mu.Unlock()
+
+	r.deliverNotifications()
 }
 
-func (r *registry) notifySubscribers() {
+// notifiedLocked records when the subscriber was notified, if the registry
+// coalesces notifications.
+//
+// Assumes the mutex is locked.
+func (r *registry) notifiedLocked(sub *subscriber) {
+	if r.coalesce > 0 {
+		sub.notified = r.clock.Now()
+	}
+}
+
+// queueMemberNotificationLocked queues a notification for a member
+// subscriber with the given member state, where a nil state means the member
+// isn't in the registry.
+//
+// Assumes the mutex is locked.
+func (r *registry) queueMemberNotificationLocked(sub *subscriber, state *rpc.MemberState) {
+	var member Member
+	if state != nil {
+		// Copy the member since fromRPC shares the metadata with the
+		// registry state.
+		m := fromRPC(state)
+		member = m.Copy()
+	}
+	present := state != nil
+	r.notifications = append(r.notifications, notification{
+		sub: sub,
+		notify: func() {
+			sub.MemberCallback(member, present)
+		},
+	})
+}
+
+// queueNotificationLocked queues a notification for the subscriber. Any state
+// passed to the subscriber is captured when queued, so the notification may
+// be delivered once the mutex is released.
+//
+// Assumes the mutex is locked.
+func (r *registry) queueNotificationLocked(sub *subscriber, delta Delta) {
+	if sub.DeltaCallback != nil {
+		// Copy the delta so subscribers don't share the same members.
+		r.notifications = append(r.notifications, deltaNotification(sub, delta.copy()))
+		return
+	}
+	r.notifications = append(r.notifications, notification{
+		sub:    sub,
+		notify: r.notificationLocked(sub),
+	})
+}
+
+// deltaNotification returns a notification that passes the given delta to the
+// delta subscriber.
+func deltaNotification(sub *subscriber, delta Delta) notification {
+	return notification{
+		sub: sub,
+		notify: func() {
+			sortMembers(delta.Joined)
+			sub.DeltaCallback(delta)
+		},
+		delta: &delta,
+	}
+}
+
+// deliverNotifications delivers the queued notifications in order, calling
+// the subscribers outside of the mutex.
+//
+// Only one goroutine delivers notifications at a time. If another goroutine
+// is already delivering it will deliver any notifications queued by the
+// caller, which also means subscribers may subscribe or update the registry
+// from within a callback without deadlocking.
+func (r *registry) deliverNotifications() {
 	r.mu.Lock()
+	if r.notifying {
+		r.mu.Unlock()
+		return
+	}
+	r.notifying = true
+
+	for len(r.notifications) > 0 {
+		notifications := r.notifications
+		r.notifications = nil
 
-	// Copy the subscribers to avoid calling with the mutex locked.
-	subscribers := make([]*subscriber, 0, len(r.subscribers))
-	for sub := range r.subscribers {
-		subscribers = append(subscribers, sub)
+		r.mu.Unlock()
+		if r.async {
+			// Each subscriber is notified on its own goroutine, so pushing
+			// the notifications never blocks on a slow subscriber.
+			for _, n := range notifications {
+				n.sub.queue.Push(n)
+			}
+		} else if r.slowThreshold > 0 {
+			r.deliverMeasured(notifications)
+		} else {
+			for _, n := range notifications {
+				if n.sub.unsubscribed.Load() {
+					continue
+				}
+				n.notify()
+			}
+		}
+		r.mu.Lock()
 	}
 
+	r.notifying = false
 	r.mu.Unlock()
+}
+
+// deliverMeasured delivers the given notifications the same as
+// deliverNotifications, though measures how long the subscribers take and
+// logs a warning if the notifications take longer than the slow threshold.
+// Since only one goroutine delivers notifications at a time, a slow subscriber
+// delays notifying every other subscriber.
+func (r *registry) deliverMeasured(notifications []notification) {
+	start := r.clock.Now()
+
+	subscribers := make(map[*subscriber]struct{})
+	var slowest *subscriber
+	var slowestDuration time.Duration
+	for _, n := range notifications {
+		if n.sub.unsubscribed.Load() {
+			continue
+		}
 
-	for _, sub := range subscribers {
-		sub.Callback()
+		notifyStart := r.clock.Now()
+		n.notify()
+		if d := r.clock.Now().Sub(notifyStart); slowest == nil || d > slowestDuration {
+			slowest = n.sub
+			slowestDuration = d
+		}
+		subscribers[n.sub] = struct{}{}
+	}
+
+	elapsed := r.clock.Now().Sub(start)
+	if elapsed <= r.slowThreshold {
+		return
 	}
+	r.logger.Warn(
+		"slow subscribers; notifying subscribers exceeded threshold",
+		zap.Duration("duration", elapsed),
+		zap.Duration("threshold", r.slowThreshold),
+		zap.Int("notifications", len(notifications)),
+		zap.Int("subscribers", len(subscribers)),
+		zap.String("slowest-subscriber", slowest.kind()),
+		zap.Duration("slowest-duration", slowestDuration),
+	)
+}
+
+// notificationLocked returns a function that notifies the subscriber, which
+// must not be a delta subscriber (see deltaNotification). Any state passed to
+// the subscriber is captured when notificationLocked is called, so the
+// returned function may be called once the mutex is released.
+//
+// Assumes the mutex is locked.
+func (r *registry) notificationLocked(sub *subscriber) func() {
+	if sub.MembersCallback != nil {
+		members := r.membersLocked(sub.Filter)
+		return func() {
+			// Sort when notifying to avoid sorting with the mutex locked.
+			sortMembers(members)
+			sub.MembersCallback(members)
+		}
+	}
+	return sub.Callback
+}
+
+// membersLocked returns copies of the members matching the given filter. If
+// the cached sorted members are current (see sortedLocked) the members are
+// sorted by ID, otherwise they are in no particular order and the caller must
+// sort them with sortMembers once the mutex is released, to avoid sorting
+// with the mutex locked.
+//
+// Assumes the mutex is locked.
+func (r *registry) membersLocked(filter MemberFilter) []Member {
+	matchAll := isMatchAll(filter)
+
+	var members []Member
+	if r.sortedLocked() {
+		for _, member := range r.sorted {
+			if !matchAll && !filter.Match(member) {
+				continue
+			}
+			members = append(members, member.Copy())
+		}
+		return members
+	}
+
+	for _, m := range r.members {
+		// Note fromRPC shares the metadata with the registry state, so
+		// copy the member before returning it.
+		member := fromRPC(m.State)
+		if !matchAll && !filter.Match(member) {
+			continue
+		}
+		members = append(members, member.Copy())
+	}
+	return members
+}
+
+// sortedLocked returns whether the cached sorted members are current.
+//
+// Assumes the mutex is locked.
+func (r *registry) sortedLocked() bool {
+	return r.sorted != nil && r.sortedEpoch == r.epoch
+}
+
+// cacheSorted caches copies of the given members, which must be all members
+// in the registry as of epoch sorted by ID, unless the registry has since
+// changed. The members are copied before locking the mutex, since the caller
+// may modify the given members.
+func (r *registry) cacheSorted(members []Member, epoch uint64) {
+	sorted := make([]Member, 0, len(members))
+	for _, m := range members {
+		sorted = append(sorted, m.Copy())
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.epoch == epoch {
+		r.sorted = sorted
+		r.sortedEpoch = epoch
+	}
+}
+
+// matchedLocked returns the members that match the given filter. Note the
+// returned states must not be modified.
+//
+// Assumes the mutex is locked.
+func (r *registry) matchedLocked(filter MemberFilter) map[string]*rpc.MemberState {
+	matched := make(map[string]*rpc.MemberState)
+	for id, m := range r.members {
+		if filter.Match(fromRPC(m.State)) {
+			matched[id] = m.State
+		}
+	}
+	return matched
+}
+
+// sortMembers sorts the members by ID.
+func sortMembers(members []Member) {
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].ID < members[j].ID
+	})
+}
+
+// compareVersions returns -1 if version a is older than b, 1 if a is newer
+// than b, and 0 if they are equal. Versions are ordered by timestamp then
+// counter, where a nil version or timestamp is older than any other version.
+func compareVersions(a *rpc.Version2, b *rpc.Version2) int {
+	aTimestamp := a.GetTimestamp()
+	bTimestamp := b.GetTimestamp()
+	if aTimestamp == nil || bTimestamp == nil {
+		switch {
+		case aTimestamp == nil && bTimestamp == nil:
+			return 0
+		case aTimestamp == nil:
+			return -1
+		default:
+			return 1
+		}
+	}
+
+	if aTimestamp.Timestamp != bTimestamp.Timestamp {
+		if aTimestamp.Timestamp < bTimestamp.Timestamp {
+			return -1
+		}
+		return 1
+	}
+	if aTimestamp.Counter != bTimestamp.Counter {
+		if aTimestamp.Counter < bTimestamp.Counter {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+// sameRegistration returns true if the given member states have the same
+// registration, meaning the fields that are fixed when the member registers
+// are equal. Members with the same ID but different registrations were
+// registered by different clients.
+func sameRegistration(a *rpc.MemberState, b *rpc.MemberState) bool {
+	_, aAttributes := splitAttributes(a.Metadata)
+	_, bAttributes := splitAttributes(b.Metadata)
+	return a.Service == b.Service &&
+		a.Started == b.Started &&
+		a.Revision == b.Revision &&
+		proto.Equal(a.Locality, b.Locality) &&
+		mapsEqual(aAttributes, bAttributes)
 }
@@ -1,18 +1,26 @@
 package fuddle
 
 import (
+	"fmt"
 	"math/rand"
+	"sync"
 	"testing"
+	"time"
 
 	rpc "github.com/fuddle-io/fuddle-rpc/go"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"google.golang.org/protobuf/proto"
 )
 
 func TestRegistry_RemoteUpdateAddMember(t *testing.T) {
 	localMember := randomMember("local")
-	reg := newRegistry(fromRPC(localMember), zap.NewNop())
+	reg := newRegistry(fromRPC(localMember), nopMetrics{}, zap.NewNop())
 
 	addedMember := randomMember("member-1")
 	reg.RemoteUpdate(&rpc.Member2{
@@ -29,9 +37,43 @@ func TestRegistry_RemoteUpdateAddMember(t *testing.T) {
 	assert.Equal(t, []Member{fromRPC(localMember), fromRPC(addedMember)}, reg.Members())
 }
 
+func TestRegistry_MembersSorted(t *testing.T) {
+	reg := newRegistry(fromRPC(randomMember("member-5")), nopMetrics{}, zap.NewNop())
+
+	var members []*rpc.MemberState
+	for _, id := range []string{"member-3", "member-1", "member-4", "member-2"} {
+		m := randomMember(id)
+		m.Service = "orders"
+		members = append(members, m)
+		reg.RemoteUpdate(&rpc.Member2{
+			State:    m,
+			Liveness: rpc.Liveness_UP,
+		})
+	}
+
+	// Repeated calls with the same state must return the same order.
+	for i := 0; i != 10; i++ {
+		var ids []string
+		for _, m := range reg.Members() {
+			ids = append(ids, m.ID)
+		}
+		assert.Equal(t, []string{
+			"member-1", "member-2", "member-3", "member-4", "member-5",
+		}, ids)
+
+		ids = nil
+		for _, m := range reg.MembersByService("orders") {
+			ids = append(ids, m.ID)
+		}
+		assert.Equal(t, []string{
+			"member-1", "member-2", "member-3", "member-4",
+		}, ids)
+	}
+}
+
 func TestRegistry_RemoteIgnoreLocalMember(t *testing.T) {
 	localMember := randomMember("local")
-	reg := newRegistry(fromRPC(localMember), zap.NewNop())
+	reg := newRegistry(fromRPC(localMember), nopMetrics{}, zap.NewNop())
 
 	reg.RemoteUpdate(&rpc.Member2{
 		State:    randomMember("local"),
@@ -48,9 +90,50 @@ func TestRegistry_RemoteIgnoreLocalMember(t *testing.T) {
 	assert.Equal(t, []Member{fromRPC(localMember)}, reg.Members())
 }
 
+func TestRegistry_RemoteUpdateIDConflict(t *testing.T) {
+	localMember := randomMember("local")
+	reg := newRegistry(fromRPC(localMember), nopMetrics{}, zap.NewNop())
+
+	var conflicts [][2]Member
+	reg.onIDConflict = func(local Member, remote Member) {
+		conflicts = append(conflicts, [2]Member{local, remote})
+	}
+
+	// An update echoing the local member, even with different metadata,
+	// isn't a conflict.
+	echo := proto.Clone(localMember).(*rpc.MemberState)
+	echo.Metadata = map[string]string{"foo": "bar"}
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    echo,
+		Liveness: rpc.Liveness_UP,
+		Version: &rpc.Version2{
+			OwnerId:   "remote-1",
+			Timestamp: &rpc.MonotonicTimestamp{Timestamp: 123},
+		},
+	})
+	assert.Empty(t, conflicts)
+
+	// A member with the same ID registered by another client.
+	conflicting := randomMember("local")
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    conflicting,
+		Liveness: rpc.Liveness_UP,
+		Version: &rpc.Version2{
+			OwnerId:   "remote-2",
+			Timestamp: &rpc.MonotonicTimestamp{Timestamp: 456},
+		},
+	})
+	require.Len(t, conflicts, 1)
+	assert.Equal(t, fromRPC(localMember), conflicts[0][0])
+	assert.Equal(t, fromRPC(conflicting), conflicts[0][1])
+
+	// The local member should be unchanged.
+	assert.Equal(t, []Member{fromRPC(localMember)}, reg.Members())
+}
+
 func TestRegistry_RemoteUpdateRemoveMember(t *testing.T) {
 	localMember := randomMember("local")
-	reg := newRegistry(fromRPC(localMember), zap.NewNop())
+	reg := newRegistry(fromRPC(localMember), nopMetrics{}, zap.NewNop())
 
 	reg.RemoteUpdate(&rpc.Member2{
 		State:    randomMember("member-1"),
@@ -78,9 +161,476 @@ func TestRegistry_RemoteUpdateRemoveMember(t *testing.T) {
 	assert.Equal(t, []Member{fromRPC(localMember)}, reg.Members())
 }
 
+func TestRegistry_MembersIsCopy(t *testing.T) {
+	localMember := fromRPC(randomMember("local"))
+	reg := newRegistry(localMember, nopMetrics{}, zap.NewNop())
+
+	remoteMember := randomMember("remote")
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    remoteMember,
+		Liveness: rpc.Liveness_UP,
+	})
+
+	for _, m := range reg.Members() {
+		m.Metadata["foo"] = "bar"
+	}
+	// Modifying the registered members metadata must not affect the
+	// registry either.
+	localMember.Metadata["foo"] = "bar"
+
+	for _, m := range reg.Members() {
+		assert.NotContains(t, m.Metadata, "foo")
+	}
+}
+
+func TestRegistry_MembersCacheRebuiltAfterUpdate(t *testing.T) {
+	localMember := randomMember("local")
+	reg := newRegistry(fromRPC(localMember), nopMetrics{}, zap.NewNop())
+
+	assert.Equal(t, []Member{fromRPC(localMember)}, reg.Members())
+	// The cache is reused while the registry is unchanged.
+	epoch := reg.sortedEpoch
+	assert.Equal(t, []Member{fromRPC(localMember)}, reg.Members())
+	assert.Equal(t, epoch, reg.sortedEpoch)
+
+	remoteMember := randomMember("remote")
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    remoteMember,
+		Liveness: rpc.Liveness_UP,
+	})
+	assert.Equal(t, []Member{
+		fromRPC(localMember),
+		fromRPC(remoteMember),
+	}, reg.Members())
+
+	updatedMember := proto.Clone(remoteMember).(*rpc.MemberState)
+	updatedMember.Status = "active"
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    updatedMember,
+		Liveness: rpc.Liveness_UP,
+	})
+	assert.Equal(t, []Member{
+		fromRPC(localMember),
+		fromRPC(updatedMember),
+	}, reg.Members())
+
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    updatedMember,
+		Liveness: rpc.Liveness_LEFT,
+	})
+	assert.Equal(t, []Member{fromRPC(localMember)}, reg.Members())
+
+	// Modifying the returned members must not modify the cache.
+	members := reg.Members()
+	members[0].Metadata["foo"] = "bar"
+	members[0].Status = "modified"
+	assert.Equal(t, []Member{fromRPC(localMember)}, reg.Members())
+}
+
+func TestRegistry_Member(t *testing.T) {
+	localMember := randomMember("local")
+	reg := newRegistry(fromRPC(localMember), nopMetrics{}, zap.NewNop())
+
+	remoteMember := randomMember("remote")
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    remoteMember,
+		Liveness: rpc.Liveness_UP,
+	})
+
+	m, ok := reg.Member("remote")
+	assert.True(t, ok)
+	assert.Equal(t, fromRPC(remoteMember), m)
+
+	m, ok = reg.Member("local")
+	assert.True(t, ok)
+	assert.Equal(t, fromRPC(localMember), m)
+
+	_, ok = reg.Member("unknown")
+	assert.False(t, ok)
+
+	// The returned member must be a copy.
+	m.Metadata["foo"] = "bar"
+	m, _ = reg.Member("local")
+	assert.NotContains(t, m.Metadata, "foo")
+}
+
+func TestRegistry_Services(t *testing.T) {
+	localMember := randomMember("local")
+	localMember.Service = "orders"
+	reg := newRegistry(fromRPC(localMember), nopMetrics{}, zap.NewNop())
+
+	assert.Equal(t, []string{"orders"}, reg.Services())
+
+	for _, m := range []struct {
+		id      string
+		service string
+	}{
+		{"payments-1", "payments"},
+		{"orders-1", "orders"},
+		{"frontend-1", "frontend"},
+		{"frontend-2", "frontend"},
+	} {
+		state := randomMember(m.id)
+		state.Service = m.service
+		reg.RemoteUpdate(&rpc.Member2{
+			State:    state,
+			Liveness: rpc.Liveness_UP,
+		})
+	}
+	assert.Equal(t, []string{"frontend", "orders", "payments"}, reg.Services())
+
+	// Services are removed once they have no members.
+	payments := randomMember("payments-1")
+	payments.Service = "payments"
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    payments,
+		Liveness: rpc.Liveness_LEFT,
+	})
+	assert.Equal(t, []string{"frontend", "orders"}, reg.Services())
+}
+
+func TestRegistry_Localities(t *testing.T) {
+	localMember := randomMember("local")
+	localMember.Locality = &rpc.Locality{
+		Region:           "us-east-1",
+		AvailabilityZone: "us-east-1a",
+	}
+	reg := newRegistry(fromRPC(localMember), nopMetrics{}, zap.NewNop())
+
+	for _, m := range []struct {
+		id       string
+		locality *rpc.Locality
+	}{
+		{"member-1", &rpc.Locality{Region: "us-east-1", AvailabilityZone: "us-east-1b"}},
+		{"member-2", &rpc.Locality{Region: "us-east-1", AvailabilityZone: "us-east-1a"}},
+		{"member-3", &rpc.Locality{Region: "eu-west-1", AvailabilityZone: "eu-west-1a"}},
+		{"member-4", &rpc.Locality{Region: "eu-west-1", AvailabilityZone: "eu-west-1a"}},
+		// Members without a locality are included as the zero locality.
+		{"member-5", nil},
+		{"member-6", &rpc.Locality{}},
+	} {
+		state := randomMember(m.id)
+		state.Locality = m.locality
+		reg.RemoteUpdate(&rpc.Member2{
+			State:    state,
+			Liveness: rpc.Liveness_UP,
+		})
+	}
+
+	assert.Equal(t, []Locality{
+		{},
+		{Region: "eu-west-1", AvailabilityZone: "eu-west-1a"},
+		{Region: "us-east-1", AvailabilityZone: "us-east-1a"},
+		{Region: "us-east-1", AvailabilityZone: "us-east-1b"},
+	}, reg.Localities())
+}
+
+func TestRegistry_MembersByService(t *testing.T) {
+	localMember := randomMember("local")
+	localMember.Service = "orders"
+	reg := newRegistry(fromRPC(localMember), nopMetrics{}, zap.NewNop())
+
+	orders := randomMember("orders-1")
+	orders.Service = "orders"
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    orders,
+		Liveness: rpc.Liveness_UP,
+	})
+	frontend := randomMember("frontend-1")
+	frontend.Service = "frontend"
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    frontend,
+		Liveness: rpc.Liveness_UP,
+	})
+
+	assert.ElementsMatch(t, []Member{
+		fromRPC(localMember), fromRPC(orders),
+	}, reg.MembersByService("orders"))
+	assert.ElementsMatch(t, []Member{
+		fromRPC(frontend),
+	}, reg.MembersByService("frontend"))
+	assert.Empty(t, reg.MembersByService("unknown"))
+
+	// Moving a member to another service must update the index.
+	movedOrders := randomMember("orders-1")
+	movedOrders.Service = "frontend"
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    movedOrders,
+		Liveness: rpc.Liveness_UP,
+	})
+	assert.ElementsMatch(t, []Member{
+		fromRPC(localMember),
+	}, reg.MembersByService("orders"))
+	assert.ElementsMatch(t, []Member{
+		fromRPC(frontend), fromRPC(movedOrders),
+	}, reg.MembersByService("frontend"))
+
+	// Removing members must update the index.
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    frontend,
+		Liveness: rpc.Liveness_LEFT,
+	})
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    movedOrders,
+		Liveness: rpc.Liveness_LEFT,
+	})
+	assert.Empty(t, reg.MembersByService("frontend"))
+	assert.Equal(t, 1, len(reg.services))
+}
+
+func TestRegistry_MembersFilterStatus(t *testing.T) {
+	reg := newRegistry(fromRPC(randomMember("local")), nopMetrics{}, zap.NewNop())
+
+	active := randomMember("orders-1")
+	active.Service = "orders"
+	active.Status = "active"
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    active,
+		Liveness: rpc.Liveness_UP,
+	})
+	booting := randomMember("orders-2")
+	booting.Service = "orders"
+	booting.Status = "booting"
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    booting,
+		Liveness: rpc.Liveness_UP,
+	})
+
+	assert.Equal(t, []Member{fromRPC(active)}, reg.Members(WithFilter(&Filter{
+		"orders": {Status: []string{"active"}},
+	})))
+}
+
+func TestRegistry_Count(t *testing.T) {
+	local := randomMember("local")
+	local.Service = "orders"
+	reg := newRegistry(fromRPC(local), nopMetrics{}, zap.NewNop())
+
+	for i := 0; i != 3; i++ {
+		state := randomMember(fmt.Sprintf("orders-%d", i))
+		state.Service = "orders"
+		reg.RemoteUpdate(&rpc.Member2{
+			State:    state,
+			Liveness: rpc.Liveness_UP,
+		})
+	}
+	for i := 0; i != 2; i++ {
+		state := randomMember(fmt.Sprintf("frontend-%d", i))
+		state.Service = "frontend"
+		reg.RemoteUpdate(&rpc.Member2{
+			State:    state,
+			Liveness: rpc.Liveness_UP,
+		})
+	}
+
+	assert.Equal(t, 6, reg.Count())
+	assert.Equal(t, 6, reg.Count(WithFilter(MatchAll())))
+	assert.Equal(t, 4, reg.Count(WithFilter(&Filter{"orders": {}})))
+	assert.Equal(t, 2, reg.Count(WithFilter(&Filter{"frontend": {}})))
+	assert.Equal(t, 0, reg.Count(WithFilter(&Filter{"unknown": {}})))
+	assert.Equal(t, 0, reg.Count(WithFilter(MatchNone())))
+
+	for _, filter := range []MemberFilter{nil, &Filter{"orders": {}}} {
+		assert.Equal(t, len(reg.Members(WithFilter(filter))), reg.Count(WithFilter(filter)))
+	}
+}
+
+func TestRegistry_Query(t *testing.T) {
+	local := randomMember("local")
+	local.Service = "orders"
+	local.Locality.Region = "us-east-1"
+	reg := newRegistry(fromRPC(local), nopMetrics{}, zap.NewNop())
+
+	for i, region := range []string{"us-east-1", "eu-west-1", "eu-west-1"} {
+		state := randomMember(fmt.Sprintf("orders-%d", i))
+		state.Service = "orders"
+		state.Locality.Region = region
+		reg.RemoteUpdate(&rpc.Member2{
+			State:    state,
+			Liveness: rpc.Liveness_UP,
+		})
+	}
+	for i := 0; i != 2; i++ {
+		state := randomMember(fmt.Sprintf("frontend-%d", i))
+		state.Service = "frontend"
+		state.Locality.Region = "us-east-1"
+		reg.RemoteUpdate(&rpc.Member2{
+			State:    state,
+			Liveness: rpc.Liveness_UP,
+		})
+	}
+
+	matched, total := reg.Query(&Filter{
+		"orders": {
+			Locality: LocalityFilter{
+				Region: []string{"us-east-1"},
+			},
+		},
+	})
+	assert.Equal(t, 2, len(matched))
+	assert.Equal(t, "local", matched[0].ID)
+	assert.Equal(t, "orders-0", matched[1].ID)
+	assert.Equal(t, 4, total)
+
+	matched, total = reg.Query(&Filter{"orders": {}})
+	assert.Equal(t, 4, len(matched))
+	assert.Equal(t, 4, total)
+
+	matched, total = reg.Query(&Filter{
+		"*": {
+			Locality: LocalityFilter{
+				Region: []string{"us-east-1"},
+			},
+		},
+	})
+	assert.Equal(t, 4, len(matched))
+	assert.Equal(t, 6, total)
+
+	matched, total = reg.Query(nil)
+	assert.Equal(t, 6, len(matched))
+	assert.Equal(t, 6, total)
+
+	matched, total = reg.Query(MatchNone())
+	assert.Equal(t, 0, len(matched))
+	assert.Equal(t, 0, total)
+}
+
+// Tests the matched members and total are taken from the same snapshot when
+// members are updated concurrently.
+func TestRegistry_QueryConsistent(t *testing.T) {
+	local := randomMember("local")
+	local.Service = "frontend"
+	reg := newRegistry(fromRPC(local), nopMetrics{}, zap.NewNop())
+
+	// Add orders members that never match the filter, so the total is
+	// always the number of matched members plus the unmatched members.
+	unmatched := 2
+	for i := 0; i != unmatched; i++ {
+		state := randomMember(fmt.Sprintf("orders-eu-%d", i))
+		state.Service = "orders"
+		state.Locality.Region = "eu-west-1"
+		reg.RemoteUpdate(&rpc.Member2{
+			State:    state,
+			Liveness: rpc.Liveness_UP,
+		})
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			state := randomMember(fmt.Sprintf("orders-us-%d", i%10))
+			state.Service = "orders"
+			state.Locality.Region = "us-east-1"
+			liveness := rpc.Liveness_UP
+			if i%20 >= 10 {
+				liveness = rpc.Liveness_LEFT
+			}
+			reg.RemoteUpdate(&rpc.Member2{
+				State:    state,
+				Liveness: liveness,
+			})
+		}
+	}()
+
+	filter := &Filter{
+		"orders": {
+			Locality: LocalityFilter{
+				Region: []string{"us-east-1"},
+			},
+		},
+	}
+	for i := 0; i != 1000; i++ {
+		matched, total := reg.Query(filter)
+		if !assert.Equal(t, len(matched)+unmatched, total) {
+			break
+		}
+	}
+
+	close(done)
+	wg.Wait()
+}
+
+func TestRegistry_GroupByService(t *testing.T) {
+	local := randomMember("local")
+	local.Service = "orders"
+	reg := newRegistry(fromRPC(local), nopMetrics{}, zap.NewNop())
+
+	var remote []*rpc.MemberState
+	for _, m := range []struct {
+		id      string
+		service string
+	}{
+		{"orders-2", "orders"},
+		{"orders-1", "orders"},
+		{"frontend-1", "frontend"},
+		{"payments-1", "payments"},
+	} {
+		state := randomMember(m.id)
+		state.Service = m.service
+		reg.RemoteUpdate(&rpc.Member2{
+			State:    state,
+			Liveness: rpc.Liveness_UP,
+		})
+		remote = append(remote, state)
+	}
+
+	assert.Equal(t, map[string][]Member{
+		"orders": {
+			fromRPC(local), fromRPC(remote[1]), fromRPC(remote[0]),
+		},
+		"frontend": {fromRPC(remote[2])},
+		"payments": {fromRPC(remote[3])},
+	}, reg.GroupByService())
+}
+
+func TestRegistry_GroupByLocality(t *testing.T) {
+	euWest2a := Locality{Region: "eu-west-2", AvailabilityZone: "eu-west-2a"}
+	euWest2b := Locality{Region: "eu-west-2", AvailabilityZone: "eu-west-2b"}
+	usEast1a := Locality{Region: "us-east-1", AvailabilityZone: "us-east-1a"}
+
+	local := fromRPC(randomMember("local"))
+	local.Locality = euWest2a
+	reg := newRegistry(local, nopMetrics{}, zap.NewNop())
+
+	var remote []Member
+	for _, m := range []struct {
+		id       string
+		locality Locality
+	}{
+		{"member-1", euWest2a},
+		{"member-2", euWest2b},
+		{"member-3", usEast1a},
+		{"member-4", usEast1a},
+	} {
+		member := fromRPC(randomMember(m.id))
+		member.Locality = m.locality
+		reg.RemoteUpdate(&rpc.Member2{
+			State:    member.toRPC(),
+			Liveness: rpc.Liveness_UP,
+		})
+		remote = append(remote, member)
+	}
+
+	assert.Equal(t, map[Locality][]Member{
+		euWest2a: {local, remote[0]},
+		euWest2b: {remote[1]},
+		usEast1a: {remote[2], remote[3]},
+	}, reg.GroupByLocality())
+}
+
 func TestRegistry_KnownVersions(t *testing.T) {
 	localMember := randomMember("local")
-	reg := newRegistry(fromRPC(localMember), zap.NewNop())
+	reg := newRegistry(fromRPC(localMember), nopMetrics{}, zap.NewNop())
 
 	reg.RemoteUpdate(&rpc.Member2{
 		State:    randomMember("member-1"),
@@ -119,60 +669,1428 @@ func TestRegistry_KnownVersions(t *testing.T) {
 	}, reg.KnownVersions())
 }
 
-func TestRegistry_Subscribe(t *testing.T) {
-	localMember := randomMember("local")
-	reg := newRegistry(fromRPC(localMember), zap.NewNop())
+func TestRegistry_SortedKnownVersions(t *testing.T) {
+	reg := newRegistry(fromRPC(randomMember("local")), nopMetrics{}, zap.NewNop())
 
-	count := 0
-	reg.Subscribe(func() {
-		count++
+	// Add members in a random order.
+	var ids []string
+	for i := 0; i != 100; i++ {
+		ids = append(ids, fmt.Sprintf("member-%03d", i))
+	}
+	rand.Shuffle(len(ids), func(i, j int) {
+		ids[i], ids[j] = ids[j], ids[i]
 	})
+	for i, id := range ids {
+		reg.RemoteUpdate(&rpc.Member2{
+			State:    randomMember(id),
+			Liveness: rpc.Liveness_UP,
+			Version: &rpc.Version2{
+				OwnerId: "remote",
+				Timestamp: &rpc.MonotonicTimestamp{
+					Timestamp: int64(i),
+					Counter:   2,
+				},
+			},
+		})
+	}
+
+	sorted := sortedKnownVersions(reg.KnownVersions())
+	require.Equal(t, 100, len(sorted))
+	for i, v := range sorted {
+		assert.Equal(t, fmt.Sprintf("member-%03d", i), v.ID)
+	}
+
+	// The debug representation must be the same regardless of the map
+	// iteration order.
+	assert.Equal(
+		t,
+		fmt.Sprint(sorted),
+		fmt.Sprint(sortedKnownVersions(reg.KnownVersions())),
+	)
+}
+
+func TestKnownVersion_String(t *testing.T) {
+	assert.Equal(t, "member-1@remote/123.4", knownVersion{
+		ID: "member-1",
+		Version: &rpc.Version2{
+			OwnerId: "remote",
+			Timestamp: &rpc.MonotonicTimestamp{
+				Timestamp: 123,
+				Counter:   4,
+			},
+		},
+	}.String())
+}
+
+func TestRegistry_KnownVersionsExcludesLocalMembers(t *testing.T) {
+	reg := newRegistry(fromRPC(randomMember("local-1")), nopMetrics{}, zap.NewNop())
+	require.NoError(t, reg.AddLocalMember(fromRPC(randomMember("local-2"))))
 
 	reg.RemoteUpdate(&rpc.Member2{
 		State:    randomMember("member-1"),
 		Liveness: rpc.Liveness_UP,
 		Version: &rpc.Version2{
 			OwnerId: "remote-1",
-			Timestamp: &rpc.MonotonicTimestamp{
-				Timestamp: 123,
-			},
 		},
 	})
+
+	assert.Equal(t, map[string]*rpc.Version2{
+		"member-1": &rpc.Version2{
+			OwnerId: "remote-1",
+		},
+	}, reg.KnownVersions())
+}
+
+func TestRegistry_RemoteUpdateNilVersion(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	reg := newRegistry(fromRPC(randomMember("local")), nopMetrics{}, zap.New(core))
+
+	// Members without a version, or with a version without a timestamp,
+	// must be added without panicking.
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    randomMember("member-1"),
+		Liveness: rpc.Liveness_UP,
+	})
 	reg.RemoteUpdate(&rpc.Member2{
-		State: &rpc.MemberState{
-			Id: "member-1",
+		State:    randomMember("member-2"),
+		Liveness: rpc.Liveness_UP,
+		Version: &rpc.Version2{
+			OwnerId: "remote-1",
 		},
-		Liveness: rpc.Liveness_LEFT,
+	})
+
+	_, ok := reg.Member("member-1")
+	assert.True(t, ok)
+	_, ok = reg.Member("member-2")
+	assert.True(t, ok)
+
+	require.Equal(t, 2, logs.Len())
+	assert.Equal(t, "unknown", logs.All()[0].ContextMap()["member"].(map[string]interface{})["version"])
+	assert.Equal(t, "unknown", logs.All()[1].ContextMap()["member"].(map[string]interface{})["version.timestamp"])
+
+	// A versioned update is newer than a member without a version.
+	updated := randomMember("member-1")
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    updated,
+		Liveness: rpc.Liveness_UP,
 		Version: &rpc.Version2{
 			OwnerId: "remote-1",
 			Timestamp: &rpc.MonotonicTimestamp{
-				Timestamp: 123,
+				Timestamp: 1,
 			},
 		},
 	})
-
-	assert.Equal(t, 3, count)
+	m, ok := reg.Member("member-1")
+	require.True(t, ok)
+	assert.Equal(t, fromRPC(updated), m)
 }
 
-func randomMember(id string) *rpc.MemberState {
-	if id == "" {
-		id = uuid.New().String()
-	}
-	return &rpc.MemberState{
-		Id:      id,
-		Service: uuid.New().String(),
-		Locality: &rpc.Locality{
-			Region:           uuid.New().String(),
-			AvailabilityZone: uuid.New().String(),
+func TestRegistry_KnownVersionsExcludesNilVersions(t *testing.T) {
+	reg := newRegistry(fromRPC(randomMember("local")), nopMetrics{}, zap.NewNop())
+
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    randomMember("member-1"),
+		Liveness: rpc.Liveness_UP,
+	})
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    randomMember("member-2"),
+		Liveness: rpc.Liveness_UP,
+		Version: &rpc.Version2{
+			OwnerId: "remote-1",
 		},
-		Started:  rand.Int63(),
-		Revision: uuid.New().String(),
-		Metadata: map[string]string{
-			uuid.New().String(): uuid.New().String(),
-			uuid.New().String(): uuid.New().String(),
-			uuid.New().String(): uuid.New().String(),
-			uuid.New().String(): uuid.New().String(),
-			uuid.New().String(): uuid.New().String(),
+	})
+
+	assert.Equal(t, map[string]*rpc.Version2{
+		"member-2": &rpc.Version2{
+			OwnerId: "remote-1",
 		},
+	}, reg.KnownVersions())
+}
+
+func TestRegistry_AddLocalMember(t *testing.T) {
+	reg := newRegistry(fromRPC(randomMember("local-1")), nopMetrics{}, zap.NewNop())
+
+	var deltas []Delta
+	reg.SubscribeDelta(func(delta Delta) {
+		deltas = append(deltas, delta)
+	})
+
+	localMember := randomMember("local-2")
+	require.NoError(t, reg.AddLocalMember(fromRPC(localMember)))
+	assert.Error(t, reg.AddLocalMember(fromRPC(localMember)))
+	assert.True(t, proto.Equal(localMember, reg.LocalRPCMember("local-2")))
+
+	// Remote updates to the local member are ignored.
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    randomMember("local-2"),
+		Liveness: rpc.Liveness_DOWN,
+	})
+	assert.True(t, proto.Equal(localMember, reg.LocalRPCMember("local-2")))
+
+	reg.RemoveLocalMember("local-2")
+	assert.Nil(t, reg.LocalRPCMember("local-2"))
+	assert.Equal(t, 1, len(reg.Members()))
+
+	require.Equal(t, 3, len(deltas))
+	assert.Equal(t, []Member{fromRPC(localMember)}, deltas[1].Joined)
+	assert.Equal(t, []Member{fromRPC(localMember)}, deltas[2].Left)
+}
+
+func TestRegistry_UpdateLocalMetadataKeepsAttributes(t *testing.T) {
+	local := Member{
+		ID:         "local",
+		Service:    "orders",
+		Metadata:   map[string]string{"foo": "bar"},
+		Attributes: map[string]string{"rack": "r12"},
+	}
+	reg := newRegistry(local, nopMetrics{}, zap.NewNop())
+
+	// Replacing the metadata, including attempting to set an attribute
+	// key, must not modify the attributes.
+	require.NoError(t, reg.UpdateLocalMetadata("local", func(metadata map[string]string) {
+		assert.Equal(t, map[string]string{"foo": "bar"}, metadata)
+		for k := range metadata {
+			delete(metadata, k)
+		}
+		metadata["car"] = "baz"
+		metadata["fuddle.attr.rack"] = "r13"
+	}))
+
+	m, ok := reg.LocalMember("local")
+	require.True(t, ok)
+	assert.Equal(t, map[string]string{"car": "baz"}, m.Metadata)
+	assert.Equal(t, map[string]string{"rack": "r12"}, m.Attributes)
+}
+
+func TestRegistry_Subscribe(t *testing.T) {
+	localMember := randomMember("local")
+	reg := newRegistry(fromRPC(localMember), nopMetrics{}, zap.NewNop())
+
+	count := 0
+	reg.Subscribe(func() {
+		count++
+	})
+
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    randomMember("member-1"),
+		Liveness: rpc.Liveness_UP,
+		Version: &rpc.Version2{
+			OwnerId: "remote-1",
+			Timestamp: &rpc.MonotonicTimestamp{
+				Timestamp: 123,
+			},
+		},
+	})
+	reg.RemoteUpdate(&rpc.Member2{
+		State: &rpc.MemberState{
+			Id: "member-1",
+		},
+		Liveness: rpc.Liveness_LEFT,
+		Version: &rpc.Version2{
+			OwnerId: "remote-1",
+			Timestamp: &rpc.MonotonicTimestamp{
+				Timestamp: 123,
+			},
+		},
+	})
+
+	assert.Equal(t, 3, count)
+}
+
+func TestRegistry_SubscribeWithoutBootstrap(t *testing.T) {
+	reg := newRegistry(fromRPC(randomMember("local")), nopMetrics{}, zap.NewNop())
+
+	count := 0
+	reg.Subscribe(func() {
+		count++
+	}, WithoutBootstrap())
+	var deltas []Delta
+	reg.SubscribeDelta(func(delta Delta) {
+		deltas = append(deltas, delta)
+	}, WithoutBootstrap())
+	memberCount := 0
+	reg.SubscribeMember("member-1", func(member Member, present bool) {
+		memberCount++
+	}, WithoutBootstrap())
+
+	// The callbacks must not be called until the first update.
+	assert.Equal(t, 0, count)
+	assert.Empty(t, deltas)
+	assert.Equal(t, 0, memberCount)
+
+	member := randomMember("member-1")
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    member,
+		Liveness: rpc.Liveness_UP,
+	})
+
+	assert.Equal(t, 1, count)
+	assert.Equal(t, []Delta{{Joined: []Member{fromRPC(member)}}}, deltas)
+	assert.Equal(t, 1, memberCount)
+}
+
+func TestRegistry_SubscribeOrder(t *testing.T) {
+	reg := newRegistry(fromRPC(randomMember("local")), nopMetrics{}, zap.NewNop())
+
+	var order []string
+	reg.SubscribeMember("member-1", func(member Member, present bool) {
+		order = append(order, "member-1")
+	}, WithoutBootstrap())
+	reg.Subscribe(func() {
+		order = append(order, "primary")
+	}, WithoutBootstrap())
+	unsubscribe := reg.SubscribeDelta(func(delta Delta) {
+		order = append(order, "removed")
+	}, WithoutBootstrap())
+	reg.SubscribeMembers(func(members []Member) {
+		order = append(order, "secondary")
+	}, WithoutBootstrap())
+	reg.SubscribeMember("member-1", func(member Member, present bool) {
+		order = append(order, "member-2")
+	}, WithoutBootstrap())
+	reg.SubscribeFilter(&Filter{"*": {Status: []string{"*"}}}, func() {
+		order = append(order, "filter")
+	}, WithoutBootstrap())
+
+	unsubscribe()
+
+	// Subscribing after unsubscribing must still be notified last.
+	reg.SubscribeDelta(func(delta Delta) {
+		order = append(order, "last")
+	}, WithoutBootstrap())
+
+	for i := 0; i != 3; i++ {
+		order = nil
+		reg.RemoteUpdate(&rpc.Member2{
+			State:    randomMember("member-1"),
+			Liveness: rpc.Liveness_UP,
+		})
+		assert.Equal(t, []string{
+			"member-1", "primary", "secondary", "member-2", "filter", "last",
+		}, order)
+	}
+}
+
+func TestSubscriberList(t *testing.T) {
+	l := newSubscriberList()
+
+	var subs []*subscriber
+	for i := 0; i != 10; i++ {
+		sub := &subscriber{seq: uint64(i)}
+		subs = append(subs, sub)
+		l.add(sub)
+	}
+
+	// Remove most subscribers so the list is compacted.
+	for i := 0; i != 10; i++ {
+		if i%4 != 0 {
+			l.remove(subs[i])
+		}
+	}
+	// Removing a subscriber that isn't in the list is ignored.
+	l.remove(subs[1])
+	l.remove(&subscriber{})
+
+	assert.Equal(t, 3, l.len())
+
+	var remaining []*subscriber
+	for _, sub := range l.subs {
+		if sub != nil {
+			remaining = append(remaining, sub)
+		}
+	}
+	assert.Equal(t, []*subscriber{subs[0], subs[4], subs[8]}, remaining)
+	assert.LessOrEqual(t, len(l.subs), 2*l.len())
+	for sub, i := range l.index {
+		assert.Equal(t, sub, l.subs[i])
+	}
+}
+
+func TestMergeSubscribers(t *testing.T) {
+	var subs []*subscriber
+	for i := 0; i != 6; i++ {
+		subs = append(subs, &subscriber{seq: uint64(i)})
+	}
+
+	merged := mergeSubscribers(
+		[]*subscriber{subs[0], nil, subs[3], subs[4]},
+		[]*subscriber{nil, subs[1], subs[2], nil, subs[5]},
+	)
+	var seqs []uint64
+	for _, sub := range merged {
+		if sub != nil {
+			seqs = append(seqs, sub.seq)
+		}
+	}
+	assert.Equal(t, []uint64{0, 1, 2, 3, 4, 5}, seqs)
+}
+
+func TestRegistry_SubscribeFilterWithoutBootstrap(t *testing.T) {
+	reg := newRegistry(fromRPC(randomMember("local")), nopMetrics{}, zap.NewNop())
+
+	orders := randomMember("orders-1")
+	orders.Service = "orders"
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    orders,
+		Liveness: rpc.Liveness_UP,
+	})
+
+	count := 0
+	reg.SubscribeFilter(&Filter{"orders": {}}, func() {
+		count++
+	}, WithoutBootstrap())
+	assert.Equal(t, 0, count)
+
+	// Members matching the filter when subscribing are still tracked, so
+	// an unchanged update doesn't notify.
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    orders,
+		Liveness: rpc.Liveness_UP,
+	})
+	assert.Equal(t, 0, count)
+
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    orders,
+		Liveness: rpc.Liveness_LEFT,
+	})
+	assert.Equal(t, 1, count)
+}
+
+func TestRegistry_RemoteUpdateIgnoresUnchanged(t *testing.T) {
+	reg := newRegistry(fromRPC(randomMember("local")), nopMetrics{}, zap.NewNop())
+
+	count := 0
+	reg.Subscribe(func() {
+		count++
+	})
+	assert.Equal(t, 1, count)
+
+	member := randomMember("remote")
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    member,
+		Liveness: rpc.Liveness_UP,
+		Version: &rpc.Version2{
+			OwnerId: "remote",
+			Timestamp: &rpc.MonotonicTimestamp{
+				Timestamp: 1,
+			},
+		},
+	})
+	assert.Equal(t, 2, count)
+
+	// Replaying an identical update, even with a new version, must not
+	// notify.
+	for i := 0; i != 3; i++ {
+		reg.RemoteUpdate(&rpc.Member2{
+			State:    proto.Clone(member).(*rpc.MemberState),
+			Liveness: rpc.Liveness_UP,
+			Version: &rpc.Version2{
+				OwnerId: "remote",
+				Timestamp: &rpc.MonotonicTimestamp{
+					Timestamp: int64(i + 2),
+				},
+			},
+		})
+	}
+	assert.Equal(t, 2, count)
+
+	// Though a metadata change must notify.
+	updated := proto.Clone(member).(*rpc.MemberState)
+	updated.Metadata["foo"] = "bar"
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    updated,
+		Liveness: rpc.Liveness_UP,
+		Version: &rpc.Version2{
+			OwnerId: "remote",
+			Timestamp: &rpc.MonotonicTimestamp{
+				Timestamp: 10,
+			},
+		},
+	})
+	assert.Equal(t, 3, count)
+}
+
+func TestRegistry_RemoteUpdateOutOfOrder(t *testing.T) {
+	reg := newRegistry(fromRPC(randomMember("local")), nopMetrics{}, zap.NewNop())
+
+	version := func(timestamp int64, counter uint64) *rpc.Version2 {
+		return &rpc.Version2{
+			OwnerId: "remote",
+			Timestamp: &rpc.MonotonicTimestamp{
+				Timestamp: timestamp,
+				Counter:   counter,
+			},
+		}
+	}
+
+	newest := randomMember("remote")
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    newest,
+		Liveness: rpc.Liveness_UP,
+		Version:  version(10, 2),
+	})
+
+	// Older updates, by either timestamp or counter, must be ignored.
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    randomMember("remote"),
+		Liveness: rpc.Liveness_UP,
+		Version:  version(9, 5),
+	})
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    randomMember("remote"),
+		Liveness: rpc.Liveness_UP,
+		Version:  version(10, 1),
+	})
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    randomMember("remote"),
+		Liveness: rpc.Liveness_UP,
+	})
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    randomMember("remote"),
+		Liveness: rpc.Liveness_LEFT,
+		Version:  version(9, 0),
+	})
+
+	m, ok := reg.Member("remote")
+	require.True(t, ok)
+	assert.Equal(t, fromRPC(newest), m)
+
+	// Newer updates must be applied.
+	newer := randomMember("remote")
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    newer,
+		Liveness: rpc.Liveness_UP,
+		Version:  version(10, 3),
+	})
+	m, ok = reg.Member("remote")
+	require.True(t, ok)
+	assert.Equal(t, fromRPC(newer), m)
+}
+
+func TestRegistry_RemoteUpdateAfterRemoved(t *testing.T) {
+	clock := newFakeClock()
+	reg := newRegistry(fromRPC(randomMember("local")), nopMetrics{}, zap.NewNop())
+	reg.clock = clock
+
+	version := func(counter uint64) *rpc.Version2 {
+		return &rpc.Version2{
+			OwnerId: "remote",
+			Timestamp: &rpc.MonotonicTimestamp{
+				Timestamp: 10,
+				Counter:   counter,
+			},
+		}
+	}
+
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    randomMember("remote"),
+		Liveness: rpc.Liveness_UP,
+		Version:  version(1),
+	})
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    randomMember("remote"),
+		Liveness: rpc.Liveness_LEFT,
+		Version:  version(2),
+	})
+
+	// A delayed update older than the removal must not add the member back.
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    randomMember("remote"),
+		Liveness: rpc.Liveness_UP,
+		Version:  version(1),
+	})
+	_, ok := reg.Member("remote")
+	assert.False(t, ok)
+
+	// A newer update adds the member back.
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    randomMember("remote"),
+		Liveness: rpc.Liveness_UP,
+		Version:  version(3),
+	})
+	_, ok = reg.Member("remote")
+	assert.True(t, ok)
+
+	// Once the tombstone expires, older updates are applied again.
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    randomMember("remote"),
+		Liveness: rpc.Liveness_LEFT,
+		Version:  version(4),
+	})
+	clock.Advance(tombstoneTTL)
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    randomMember("remote"),
+		Liveness: rpc.Liveness_UP,
+		Version:  version(1),
+	})
+	_, ok = reg.Member("remote")
+	assert.True(t, ok)
+	assert.Empty(t, reg.tombstones)
+}
+
+func TestRegistry_CompareVersions(t *testing.T) {
+	v := func(timestamp int64, counter uint64) *rpc.Version2 {
+		return &rpc.Version2{
+			Timestamp: &rpc.MonotonicTimestamp{
+				Timestamp: timestamp,
+				Counter:   counter,
+			},
+		}
+	}
+
+	assert.Equal(t, 0, compareVersions(v(1, 1), v(1, 1)))
+	assert.Equal(t, -1, compareVersions(v(1, 1), v(2, 0)))
+	assert.Equal(t, 1, compareVersions(v(2, 0), v(1, 1)))
+	assert.Equal(t, -1, compareVersions(v(1, 1), v(1, 2)))
+	assert.Equal(t, 1, compareVersions(v(1, 2), v(1, 1)))
+
+	assert.Equal(t, 0, compareVersions(nil, nil))
+	assert.Equal(t, -1, compareVersions(nil, v(0, 0)))
+	assert.Equal(t, 1, compareVersions(v(0, 0), nil))
+	assert.Equal(t, -1, compareVersions(&rpc.Version2{}, v(0, 0)))
+}
+
+func TestRegistry_MembersWithFilter(t *testing.T) {
+	localMember := randomMember("local")
+	reg := newRegistry(fromRPC(localMember), nopMetrics{}, zap.NewNop())
+
+	ordersEast := randomMember("orders-east")
+	ordersEast.Service = "orders"
+	ordersEast.Locality.Region = "us-east-1"
+	ordersWest := randomMember("orders-west")
+	ordersWest.Service = "orders"
+	ordersWest.Locality.Region = "us-west-1"
+	payments := randomMember("payments")
+	payments.Service = "payments"
+	payments.Locality.Region = "us-east-1"
+
+	for _, m := range []*rpc.MemberState{ordersEast, ordersWest, payments} {
+		reg.RemoteUpdate(&rpc.Member2{
+			State:    m,
+			Liveness: rpc.Liveness_UP,
+			Version: &rpc.Version2{
+				OwnerId: "remote-1",
+				Timestamp: &rpc.MonotonicTimestamp{
+					Timestamp: 123,
+				},
+			},
+		})
+	}
+
+	assert.ElementsMatch(t, []Member{
+		fromRPC(localMember),
+		fromRPC(ordersEast),
+		fromRPC(ordersWest),
+		fromRPC(payments),
+	}, reg.Members())
+
+	assert.ElementsMatch(t, []Member{
+		fromRPC(ordersEast),
+		fromRPC(ordersWest),
+	}, reg.Members(WithFilter(&Filter{
+		"orders": {},
+	})))
+
+	assert.ElementsMatch(t, []Member{
+		fromRPC(ordersEast),
+	}, reg.Members(WithFilter(&Filter{
+		"orders": {
+			Locality: LocalityFilter{
+				Region: []string{"us-east-*"},
+			},
+		},
+	})))
+
+	assert.ElementsMatch(t, []Member{
+		fromRPC(ordersEast),
+		fromRPC(payments),
+	}, reg.Members(WithFilter(&Filter{
+		"*": {
+			Locality: LocalityFilter{
+				Region: []string{"us-east-1"},
+			},
+		},
+	})))
+
+	assert.Empty(t, reg.Members(WithFilter(&Filter{})))
+	assert.Len(t, reg.Members(WithFilter(nil)), 4)
+
+	var nilFilter Filter
+	assert.Len(t, reg.Members(WithFilter(&nilFilter)), 4)
+
+	assert.ElementsMatch(t, []Member{
+		fromRPC(ordersWest),
+		fromRPC(payments),
+	}, reg.Members(WithFilter(AnyFilter{
+		{"orders": {
+			Locality: LocalityFilter{
+				Region: []string{"us-west-1"},
+			},
+		}},
+		{"payments": {}},
+	})))
+}
+
+func TestRegistry_SubscribeFilter(t *testing.T) {
+	localMember := randomMember("local")
+	reg := newRegistry(fromRPC(localMember), nopMetrics{}, zap.NewNop())
+
+	count := 0
+	reg.SubscribeFilter(&Filter{
+		"orders": {},
+	}, func() {
+		count++
+	})
+	// Bootstrap.
+	assert.Equal(t, 1, count)
+
+	payments := randomMember("payments")
+	payments.Service = "payments"
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    payments,
+		Liveness: rpc.Liveness_UP,
+	})
+	// Non-matching member should not notify.
+	assert.Equal(t, 1, count)
+
+	orders := randomMember("orders")
+	orders.Service = "orders"
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    orders,
+		Liveness: rpc.Liveness_UP,
+	})
+	assert.Equal(t, 2, count)
+
+	// Resending an identical matching member state should not notify.
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    proto.Clone(orders).(*rpc.MemberState),
+		Liveness: rpc.Liveness_UP,
+	})
+	assert.Equal(t, 2, count)
+
+	// Updating the matching member should notify.
+	updatedOrders := proto.Clone(orders).(*rpc.MemberState)
+	updatedOrders.Metadata = map[string]string{"status": "active"}
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    updatedOrders,
+		Liveness: rpc.Liveness_UP,
+	})
+	assert.Equal(t, 3, count)
+
+	reg.RemoteUpdate(&rpc.Member2{
+		State: &rpc.MemberState{
+			Id: "payments",
+		},
+		Liveness: rpc.Liveness_LEFT,
+	})
+	assert.Equal(t, 3, count)
+
+	reg.RemoteUpdate(&rpc.Member2{
+		State: &rpc.MemberState{
+			Id: "orders",
+		},
+		Liveness: rpc.Liveness_LEFT,
+	})
+	assert.Equal(t, 4, count)
+}
+
+func TestRegistry_SubscribeMembers(t *testing.T) {
+	localMember := randomMember("local")
+	reg := newRegistry(fromRPC(localMember), nopMetrics{}, zap.NewNop())
+
+	var snapshots [][]Member
+	reg.SubscribeMembers(func(members []Member) {
+		snapshots = append(snapshots, members)
+	})
+
+	addedMember := randomMember("member-1")
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    addedMember,
+		Liveness: rpc.Liveness_UP,
+	})
+	reg.RemoteUpdate(&rpc.Member2{
+		State: &rpc.MemberState{
+			Id: "member-1",
+		},
+		Liveness: rpc.Liveness_LEFT,
+	})
+
+	assert.Equal(t, 3, len(snapshots))
+	assert.ElementsMatch(t, []Member{fromRPC(localMember)}, snapshots[0])
+	assert.ElementsMatch(t, []Member{
+		fromRPC(localMember), fromRPC(addedMember),
+	}, snapshots[1])
+	assert.ElementsMatch(t, []Member{fromRPC(localMember)}, snapshots[2])
+}
+
+func TestRegistry_SubscribeDelta(t *testing.T) {
+	localMember := randomMember("local")
+	reg := newRegistry(fromRPC(localMember), nopMetrics{}, zap.NewNop())
+
+	var deltas []Delta
+	reg.SubscribeDelta(func(delta Delta) {
+		deltas = append(deltas, delta)
+	})
+
+	addedMember := randomMember("member-1")
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    addedMember,
+		Liveness: rpc.Liveness_UP,
+	})
+
+	updatedMember := fromRPC(addedMember)
+	updatedMember.Metadata = map[string]string{"status": "active"}
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    updatedMember.toRPC(),
+		Liveness: rpc.Liveness_UP,
+	})
+
+	reg.RemoteUpdate(&rpc.Member2{
+		State: &rpc.MemberState{
+			Id: "member-1",
+		},
+		Liveness: rpc.Liveness_LEFT,
+	})
+
+	assert.Equal(t, []Delta{
+		{Joined: []Member{fromRPC(localMember)}},
+		{Joined: []Member{fromRPC(addedMember)}},
+		{Updated: []MemberUpdate{{
+			Old: fromRPC(addedMember),
+			New: updatedMember,
+		}}},
+		{Left: []Member{updatedMember}},
+	}, deltas)
+}
+
+func TestRegistry_SubscribeMember(t *testing.T) {
+	reg := newRegistry(fromRPC(randomMember("local")), nopMetrics{}, zap.NewNop())
+
+	type memberUpdate struct {
+		member  Member
+		present bool
+	}
+	var updates []memberUpdate
+	unsubscribe := reg.SubscribeMember("member-1", func(member Member, present bool) {
+		updates = append(updates, memberUpdate{member, present})
+	})
+
+	// Join.
+	addedMember := randomMember("member-1")
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    addedMember,
+		Liveness: rpc.Liveness_UP,
+	})
+
+	// Changes to other members must be ignored.
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    randomMember("member-2"),
+		Liveness: rpc.Liveness_UP,
+	})
+
+	// Metadata update.
+	updatedMember := fromRPC(addedMember)
+	updatedMember.Metadata = map[string]string{"status": "active"}
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    updatedMember.toRPC(),
+		Liveness: rpc.Liveness_UP,
+	})
+	// An unchanged update must be ignored.
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    updatedMember.toRPC(),
+		Liveness: rpc.Liveness_UP,
+	})
+
+	// Leave.
+	reg.RemoteUpdate(&rpc.Member2{
+		State: &rpc.MemberState{
+			Id: "member-1",
+		},
+		Liveness: rpc.Liveness_LEFT,
+	})
+
+	assert.Equal(t, []memberUpdate{
+		// Bootstrap before the member joined.
+		{Member{}, false},
+		{fromRPC(addedMember), true},
+		{updatedMember, true},
+		{Member{}, false},
+	}, updates)
+
+	unsubscribe()
+	assert.Empty(t, reg.memberSubscribers)
+
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    addedMember,
+		Liveness: rpc.Liveness_UP,
+	})
+	assert.Len(t, updates, 4)
+}
+
+func TestRegistry_SubscribeMemberBootstrapPresent(t *testing.T) {
+	localMember := randomMember("local")
+	reg := newRegistry(fromRPC(localMember), nopMetrics{}, zap.NewNop())
+
+	var member Member
+	var present bool
+	reg.SubscribeMember("local", func(m Member, p bool) {
+		member, present = m, p
+	})
+	assert.True(t, present)
+	assert.Equal(t, fromRPC(localMember), member)
+
+	// Modifying the member passed to the callback must not modify the
+	// registry.
+	member.Metadata["foo"] = "bar"
+	m, _ := reg.Member("local")
+	assert.Equal(t, fromRPC(localMember), m)
+}
+
+func TestRegistry_SlowSubscriberWarning(t *testing.T) {
+	core, logs := observer.New(zapcore.WarnLevel)
+	clock := newFakeClock()
+	reg := newRegistry(fromRPC(randomMember("local")), nopMetrics{}, zap.New(core))
+	reg.slowThreshold = time.Millisecond * 100
+	reg.clock = clock
+
+	reg.Subscribe(func() {}, WithoutBootstrap())
+	slow := false
+	reg.SubscribeDelta(func(delta Delta) {
+		if slow {
+			clock.Advance(time.Millisecond * 250)
+		}
+	}, WithoutBootstrap())
+	reg.SubscribeMembers(func(members []Member) {}, WithoutBootstrap())
+
+	// Notifying fast subscribers must not log a warning.
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    randomMember("member-1"),
+		Liveness: rpc.Liveness_UP,
+	})
+	assert.Equal(t, 0, logs.Len())
+
+	slow = true
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    randomMember("member-2"),
+		Liveness: rpc.Liveness_UP,
+	})
+
+	require.Equal(t, 1, logs.Len())
+	fields := logs.All()[0].ContextMap()
+	assert.Equal(t, time.Millisecond*250, fields["duration"])
+	assert.Equal(t, int64(3), fields["subscribers"])
+	assert.Equal(t, "delta", fields["slowest-subscriber"])
+	assert.Equal(t, time.Millisecond*250, fields["slowest-duration"])
+}
+
+// Tests a slow subscriber doesn't block processing updates or notifying
+// other subscribers when notifying asynchronously.
+func TestRegistry_AsyncNotifySlowSubscriber(t *testing.T) {
+	reg := newRegistry(fromRPC(randomMember("local")), nopMetrics{}, zap.NewNop())
+	reg.async = true
+	defer reg.Close()
+
+	unblock := make(chan struct{})
+	slowCalls := atomic.NewInt64(0)
+	slowLen := atomic.NewInt64(0)
+	reg.SubscribeMembers(func(members []Member) {
+		<-unblock
+		slowCalls.Inc()
+		slowLen.Store(int64(len(members)))
+	})
+	// The slow delta subscriber must still receive every change once
+	// unblocked, though the deltas may be merged.
+	var deltaMembers map[string]Member
+	var mu sync.Mutex
+	reg.SubscribeDelta(func(delta Delta) {
+		<-unblock
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if deltaMembers == nil {
+			deltaMembers = make(map[string]Member)
+		}
+		for _, m := range delta.Joined {
+			deltaMembers[m.ID] = m
+		}
+		for _, m := range delta.Left {
+			delete(deltaMembers, m.ID)
+		}
+		for _, u := range delta.Updated {
+			deltaMembers[u.New.ID] = u.New
+		}
+	})
+	fastLen := atomic.NewInt64(0)
+	reg.SubscribeMembers(func(members []Member) {
+		fastLen.Store(int64(len(members)))
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		for i := 0; i != 100; i++ {
+			reg.RemoteUpdate(&rpc.Member2{
+				State:    randomMember(fmt.Sprintf("member-%d", i)),
+				Liveness: rpc.Liveness_UP,
+			})
+		}
+		for i := 0; i != 100; i += 2 {
+			reg.RemoteUpdate(&rpc.Member2{
+				State:    randomMember(fmt.Sprintf("member-%d", i)),
+				Liveness: rpc.Liveness_LEFT,
+			})
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatal("updates blocked by slow subscriber")
+	}
+
+	// The fast subscriber is notified while the slow subscribers are
+	// blocked.
+	assert.Eventually(t, func() bool {
+		return fastLen.Load() == 51
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, int64(0), slowCalls.Load())
+
+	close(unblock)
+
+	// Once unblocked, the slow subscribers are eventually notified of the
+	// latest state, skipping coalesced notifications.
+	assert.Eventually(t, func() bool {
+		return slowLen.Load() == 51
+	}, time.Second, time.Millisecond)
+	// The first notification was already being delivered when the
+	// subscriber blocked, so isn't counted in the queue.
+	assert.LessOrEqual(t, slowCalls.Load(), int64(asyncQueueSize+1))
+
+	expected := make(map[string]Member)
+	for _, m := range reg.Members() {
+		expected[m.ID] = m
+	}
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(deltaMembers) == len(expected)
+	}, time.Second, time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, expected, deltaMembers)
+	mu.Unlock()
+}
+
+func TestRegistry_AsyncNotifyClose(t *testing.T) {
+	reg := newRegistry(fromRPC(randomMember("local")), nopMetrics{}, zap.NewNop())
+	reg.async = true
+
+	notified := make(chan struct{}, 10)
+	reg.Subscribe(func() {
+		notified <- struct{}{}
+	})
+	<-notified
+
+	reg.Close()
+
+	// Subscribers are no longer notified once closed.
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    randomMember("member-1"),
+		Liveness: rpc.Liveness_UP,
+	})
+	reg.Subscribe(func() {
+		notified <- struct{}{}
+	})
+	select {
+	case <-notified:
+		t.Fatal("subscriber notified after close")
+	case <-time.After(time.Millisecond * 50):
+	}
+}
+
+func TestRegistry_SubscribeCoalesce(t *testing.T) {
+	clock := newFakeClock()
+	reg := newRegistry(fromRPC(randomMember("local")), nopMetrics{}, zap.NewNop())
+	reg.coalesce = time.Second
+	reg.clock = clock
+
+	calls := atomic.NewInt64(0)
+	reg.Subscribe(func() {
+		calls.Inc()
+	})
+	var snapshots [][]Member
+	var mu sync.Mutex
+	reg.SubscribeMembers(func(members []Member) {
+		mu.Lock()
+		defer mu.Unlock()
+		snapshots = append(snapshots, members)
+	})
+	deltas := atomic.NewInt64(0)
+	reg.SubscribeDelta(func(delta Delta) {
+		deltas.Inc()
+	})
+
+	// Inject a burst of updates within the window.
+	for i := 0; i != 1000; i++ {
+		reg.RemoteUpdate(&rpc.Member2{
+			State:    randomMember(fmt.Sprintf("member-%d", i)),
+			Liveness: rpc.Liveness_UP,
+		})
+	}
+
+	// Only the bootstrap notifications are delivered during the window,
+	// except for delta subscribers which receive every update.
+	assert.Equal(t, int64(1), calls.Load())
+	assert.Equal(t, int64(1001), deltas.Load())
+
+	// Once the window ends the burst is delivered in a single notification.
+	require.Eventually(t, func() bool {
+		return clock.NumTimers() == 2
+	}, time.Second, time.Millisecond)
+	clock.Advance(time.Second)
+	// Each subscriber's deferred notification is delivered by its own
+	// goroutine, so wait for both.
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return calls.Load() == 2 && len(snapshots) == 2
+	}, time.Second, time.Millisecond)
+
+	mu.Lock()
+	require.Len(t, snapshots, 2)
+	assert.Len(t, snapshots[1], 1001)
+	mu.Unlock()
+
+	// An update after the window is delivered immediately.
+	clock.Advance(time.Second)
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    randomMember("member-1000"),
+		Liveness: rpc.Liveness_UP,
+	})
+	assert.Equal(t, int64(3), calls.Load())
+	assert.Equal(t, 0, clock.NumTimers())
+}
+
+func TestRegistry_SubscribeCoalesceUnsubscribed(t *testing.T) {
+	clock := newFakeClock()
+	reg := newRegistry(fromRPC(randomMember("local")), nopMetrics{}, zap.NewNop())
+	reg.coalesce = time.Second
+	reg.clock = clock
+
+	calls := atomic.NewInt64(0)
+	unsubscribe := reg.Subscribe(func() {
+		calls.Inc()
+	})
+
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    randomMember("member-1"),
+		Liveness: rpc.Liveness_UP,
+	})
+	require.Eventually(t, func() bool {
+		return clock.NumTimers() == 1
+	}, time.Second, time.Millisecond)
+
+	// Unsubscribing during the window discards the pending notification.
+	unsubscribe()
+	clock.Advance(time.Second)
+
+	// Close waits for the coalescing goroutine to return.
+	reg.Close()
+	assert.Equal(t, int64(1), calls.Load())
+}
+
+func TestRegistry_SubscribeCoalesceClose(t *testing.T) {
+	clock := newFakeClock()
+	reg := newRegistry(fromRPC(randomMember("local")), nopMetrics{}, zap.NewNop())
+	reg.coalesce = time.Second
+	reg.clock = clock
+
+	calls := atomic.NewInt64(0)
+	reg.Subscribe(func() {
+		calls.Inc()
+	})
+
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    randomMember("member-1"),
+		Liveness: rpc.Liveness_UP,
+	})
+	require.Eventually(t, func() bool {
+		return clock.NumTimers() == 1
+	}, time.Second, time.Millisecond)
+
+	// Close must stop the coalescing goroutine without waiting for the
+	// window to end.
+	reg.Close()
+	clock.Advance(time.Second)
+	assert.Equal(t, int64(1), calls.Load())
+}
+
+func TestRegistry_SubscribeMemberCoalesce(t *testing.T) {
+	clock := newFakeClock()
+	reg := newRegistry(fromRPC(randomMember("local")), nopMetrics{}, zap.NewNop())
+	reg.coalesce = time.Second
+	reg.clock = clock
+
+	var presents []bool
+	var mu sync.Mutex
+	reg.SubscribeMember("member-1", func(member Member, present bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		presents = append(presents, present)
+	})
+
+	// The member joins and leaves within the window, so the final state is
+	// that the member isn't present.
+	m := randomMember("member-1")
+	reg.RemoteUpdate(&rpc.Member2{State: m, Liveness: rpc.Liveness_UP})
+	reg.RemoteUpdate(&rpc.Member2{State: m, Liveness: rpc.Liveness_LEFT})
+
+	require.Eventually(t, func() bool {
+		return clock.NumTimers() == 1
+	}, time.Second, time.Millisecond)
+	clock.Advance(time.Second)
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(presents) == 2
+	}, time.Second, time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, []bool{false, false}, presents)
+	mu.Unlock()
+}
+
+func TestRegistry_SubscribeMembersSnapshotIsCopy(t *testing.T) {
+	localMember := randomMember("local")
+	reg := newRegistry(fromRPC(localMember), nopMetrics{}, zap.NewNop())
+
+	reg.SubscribeMembers(func(members []Member) {
+		for _, m := range members {
+			m.Metadata["foo"] = "bar"
+		}
+	})
+
+	assert.Equal(t, []Member{fromRPC(localMember)}, reg.Members())
+}
+
+func TestRegistry_SubscribeMembersOrderedWithConcurrentUpdates(t *testing.T) {
+	localMember := randomMember("local")
+	reg := newRegistry(fromRPC(localMember), nopMetrics{}, zap.NewNop())
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i != 100; i++ {
+			reg.RemoteUpdate(&rpc.Member2{
+				State:    randomMember(""),
+				Liveness: rpc.Liveness_UP,
+			})
+		}
+	}()
+
+	var sizes []int
+	var mu sync.Mutex
+	reg.SubscribeMembers(func(members []Member) {
+		mu.Lock()
+		defer mu.Unlock()
+		sizes = append(sizes, len(members))
+	})
+
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	// Members are only added, so if the snapshots are delivered in order
+	// they must never shrink.
+	assert.NotEmpty(t, sizes)
+	assert.IsNonDecreasing(t, sizes)
+	assert.Equal(t, 101, sizes[len(sizes)-1])
+}
+
+func TestRegistry_SubscribeFromCallback(t *testing.T) {
+	localMember := randomMember("local")
+	reg := newRegistry(fromRPC(localMember), nopMetrics{}, zap.NewNop())
+
+	count := 0
+	reg.Subscribe(func() {
+		if count == 0 {
+			// Subscribing from within a callback must not deadlock.
+			reg.Subscribe(func() {})
+		}
+		count++
+	})
+
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    randomMember("member-1"),
+		Liveness: rpc.Liveness_UP,
+	})
+	assert.Equal(t, 2, count)
+}
+
+func TestRegistry_SubscribeDeltaIgnoresUnknownMemberLeft(t *testing.T) {
+	localMember := randomMember("local")
+	reg := newRegistry(fromRPC(localMember), nopMetrics{}, zap.NewNop())
+
+	var deltas []Delta
+	reg.SubscribeDelta(func(delta Delta) {
+		deltas = append(deltas, delta)
+	})
+
+	reg.RemoteUpdate(&rpc.Member2{
+		State: &rpc.MemberState{
+			Id: "unknown",
+		},
+		Liveness: rpc.Liveness_LEFT,
+	})
+
+	// Only the bootstrap.
+	assert.Equal(t, 1, len(deltas))
+}
+
+func TestRegistry_SubscribeDeltaNotShared(t *testing.T) {
+	localMember := randomMember("local")
+	reg := newRegistry(fromRPC(localMember), nopMetrics{}, zap.NewNop())
+
+	reg.SubscribeDelta(func(delta Delta) {
+		for i := range delta.Joined {
+			delta.Joined[i].ID = "modified"
+			delta.Joined[i].Metadata["foo"] = "bar"
+		}
+	})
+	var deltas []Delta
+	reg.SubscribeDelta(func(delta Delta) {
+		deltas = append(deltas, delta)
+	})
+
+	addedMember := randomMember("member-1")
+	reg.RemoteUpdate(&rpc.Member2{
+		State:    addedMember,
+		Liveness: rpc.Liveness_UP,
+	})
+
+	assert.Equal(t, []Delta{
+		{Joined: []Member{fromRPC(localMember)}},
+		{Joined: []Member{fromRPC(addedMember)}},
+	}, deltas)
+}
+
+func TestRegistry_SubscribeDeltaBootstrapOrderedWithConcurrentUpdates(t *testing.T) {
+	localMember := randomMember("local")
+	reg := newRegistry(fromRPC(localMember), nopMetrics{}, zap.NewNop())
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i != 100; i++ {
+			reg.RemoteUpdate(&rpc.Member2{
+				State:    randomMember("member-1"),
+				Liveness: rpc.Liveness_UP,
+			})
+		}
+	}()
+
+	joined := false
+	var mu sync.Mutex
+	reg.SubscribeDelta(func(delta Delta) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		for _, m := range delta.Joined {
+			if m.ID == "member-1" {
+				joined = true
+			}
+		}
+		// Must never see an update before the member joined.
+		if len(delta.Updated) > 0 {
+			assert.True(t, joined)
+		}
+	})
+
+	<-done
+}
+
+func randomMember(id string) *rpc.MemberState {
+	if id == "" {
+		id = uuid.New().String()
+	}
+	return &rpc.MemberState{
+		Id:      id,
+		Service: uuid.New().String(),
+		Locality: &rpc.Locality{
+			Region:           uuid.New().String(),
+			AvailabilityZone: uuid.New().String(),
+		},
+		Started:  rand.Int63(),
+		Revision: uuid.New().String(),
+		Metadata: map[string]string{
+			uuid.New().String(): uuid.New().String(),
+			uuid.New().String(): uuid.New().String(),
+			uuid.New().String(): uuid.New().String(),
+			uuid.New().String(): uuid.New().String(),
+			uuid.New().String(): uuid.New().String(),
+		},
+	}
+}
+
+func BenchmarkRegistry_MembersByService(b *testing.B) {
+	reg := benchmarkRegistry(b)
+
+	b.ResetTimer()
+	for i := 0; i != b.N; i++ {
+		reg.MembersByService("service-0")
+	}
+}
+
+func BenchmarkRegistry_MembersFilterByService(b *testing.B) {
+	reg := benchmarkRegistry(b)
+	filter := Filter{
+		"service-0": {},
+	}
+
+	b.ResetTimer()
+	for i := 0; i != b.N; i++ {
+		reg.Members(WithFilter(&filter))
+	}
+}
+
+func BenchmarkRegistry_MembersUnchanged(b *testing.B) {
+	reg := benchmarkRegistry(b)
+
+	b.ResetTimer()
+	for i := 0; i != b.N; i++ {
+		reg.Members()
+	}
+}
+
+func BenchmarkRegistry_MembersChanged(b *testing.B) {
+	reg := benchmarkRegistry(b)
+	m := randomMember("")
+
+	b.ResetTimer()
+	for i := 0; i != b.N; i++ {
+		// Update a member before each call so the cache is rebuilt.
+		m.Status = fmt.Sprint(i)
+		reg.RemoteUpdate(&rpc.Member2{
+			State:    proto.Clone(m).(*rpc.MemberState),
+			Liveness: rpc.Liveness_UP,
+		})
+		reg.Members()
+	}
+}
+
+// benchmarkRegistry returns a registry containing 10,000 members across 100
+// services.
+func benchmarkRegistry(b *testing.B) *registry {
+	reg := newRegistry(fromRPC(randomMember("local")), nopMetrics{}, zap.NewNop())
+	for i := 0; i != 10000; i++ {
+		m := randomMember("")
+		m.Service = fmt.Sprintf("service-%d", i%100)
+		reg.RemoteUpdate(&rpc.Member2{
+			State:    m,
+			Liveness: rpc.Liveness_UP,
+		})
 	}
+	return reg
 }
@@ -0,0 +1,341 @@
+package fuddle
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+
+	rpc "github.com/fuddle-io/fuddle-rpc/go"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// testServer is a fake Fuddle server that records the updates it receives
+// from clients.
+type testServer struct {
+	rpc.UnimplementedClientReadRegistryServer
+	rpc.UnimplementedClientWriteRegistryServer
+
+	grpcServer *grpc.Server
+	addr       string
+
+	// closeUpdates is closed to close the update streams.
+	closeUpdates     chan struct{}
+	closeUpdatesOnce sync.Once
+
+	// received contains the client updates received by the server.
+	received []*rpc.ClientUpdate
+	// members contains the members streamed to clients when they subscribe.
+	members []*rpc.Member2
+	// subscribeRequests contains the subscribe requests received by the
+	// server.
+	subscribeRequests []*rpc.SubscribeRequest
+	// registered contains the members registered by clients, which are
+	// returned by Member.
+	registered map[string]*rpc.MemberState
+	// dropRegisters is the number of register updates to drop.
+	dropRegisters int
+	// clientIDs contains the client ID metadata of each stream opened by
+	// clients.
+	clientIDs []string
+
+	// mu protects the above fields.
+	mu sync.Mutex
+}
+
+func newTestServer(t *testing.T, opts ...grpc.ServerOption) *testServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := &testServer{
+		grpcServer:   grpc.NewServer(opts...),
+		addr:         ln.Addr().String(),
+		registered:   make(map[string]*rpc.MemberState),
+		closeUpdates: make(chan struct{}),
+	}
+	rpc.RegisterClientReadRegistryServer(s.grpcServer, s)
+	rpc.RegisterClientWriteRegistryServer(s.grpcServer, s)
+
+	go func() {
+		//nolint
+		s.grpcServer.Serve(ln)
+	}()
+	t.Cleanup(s.grpcServer.Stop)
+
+	return s
+}
+
+func (s *testServer) Updates(req *rpc.SubscribeRequest, stream rpc.ClientReadRegistry_UpdatesServer) error {
+	s.mu.Lock()
+	s.subscribeRequests = append(s.subscribeRequests, req)
+	s.clientIDs = append(s.clientIDs, clientID(stream.Context()))
+	members := append([]*rpc.Member2{}, s.members...)
+	s.mu.Unlock()
+
+	for _, m := range members {
+		if err := stream.Send(m); err != nil {
+			return err
+		}
+	}
+
+	select {
+	case <-stream.Context().Done():
+		return nil
+	case <-s.closeUpdates:
+		return status.Error(codes.Unavailable, "server shutting down")
+	}
+}
+
+// CloseUpdates closes the update streams, including streams opened later,
+// while keeping the connections open.
+func (s *testServer) CloseUpdates() {
+	s.closeUpdatesOnce.Do(func() {
+		close(s.closeUpdates)
+	})
+}
+
+// AddMember adds a member to stream to clients when they subscribe.
+func (s *testServer) AddMember(m *rpc.Member2) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.members = append(s.members, m)
+}
+
+func (s *testServer) Member(ctx context.Context, req *rpc.MemberRequest) (*rpc.MemberResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, m := range s.members {
+		if m.State.Id == req.Id {
+			return &rpc.MemberResponse{Member: m}, nil
+		}
+	}
+	if state, ok := s.registered[req.Id]; ok {
+		return &rpc.MemberResponse{Member: &rpc.Member2{
+			State:    state,
+			Liveness: rpc.Liveness_UP,
+		}}, nil
+	}
+	return nil, status.Errorf(codes.NotFound, "member not found: %s", req.Id)
+}
+
+func (s *testServer) Register(stream rpc.ClientWriteRegistry_RegisterServer) error {
+	s.mu.Lock()
+	s.clientIDs = append(s.clientIDs, clientID(stream.Context()))
+	s.mu.Unlock()
+
+	for {
+		update, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+
+		s.mu.Lock()
+		switch update.UpdateType {
+		case rpc.ClientUpdateType_CLIENT_REGISTER:
+			if s.dropRegisters > 0 {
+				s.dropRegisters--
+				s.mu.Unlock()
+				continue
+			}
+			s.registered[update.Member.Id] = update.Member
+		case rpc.ClientUpdateType_CLIENT_UNREGISTER:
+			delete(s.registered, update.Member.Id)
+		}
+		s.received = append(s.received, update)
+		s.mu.Unlock()
+	}
+}
+
+// DropRegisters drops the next n register updates received, as if they were
+// lost.
+func (s *testServer) DropRegisters(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.dropRegisters = n
+}
+
+// Received returns the client updates received by the server.
+func (s *testServer) Received() []*rpc.ClientUpdate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	received := make([]*rpc.ClientUpdate, len(s.received))
+	copy(received, s.received)
+	return received
+}
+
+// SubscribeRequests returns the subscribe requests received by the server.
+func (s *testServer) SubscribeRequests() []*rpc.SubscribeRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]*rpc.SubscribeRequest{}, s.subscribeRequests...)
+}
+
+// ClientIDs returns the client ID metadata of each stream opened by clients.
+func (s *testServer) ClientIDs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string{}, s.clientIDs...)
+}
+
+func (s *testServer) Stop() {
+	s.grpcServer.Stop()
+}
+
+// clientID returns the client ID in the incoming metadata, or an empty string
+// if the client ID is missing.
+func clientID(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	ids := md.Get(clientIDMetadataKey)
+	if len(ids) == 0 {
+		return ""
+	}
+	return ids[0]
+}
+
+// socksProxy is a minimal SOCKS5 proxy that supports unauthenticated CONNECT
+// requests, and records the addresses it connects to.
+type socksProxy struct {
+	addr string
+
+	// targets contains the addresses of the connections proxied.
+	targets []string
+
+	// mu protects the above fields.
+	mu sync.Mutex
+}
+
+func newSOCKSProxy(t *testing.T) *socksProxy {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		ln.Close()
+	})
+
+	p := &socksProxy{
+		addr: ln.Addr().String(),
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go p.serve(conn)
+		}
+	}()
+	return p
+}
+
+// Targets returns the addresses of the connections proxied.
+func (p *socksProxy) Targets() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]string{}, p.targets...)
+}
+
+func (p *socksProxy) serve(conn net.Conn) {
+	defer conn.Close()
+
+	target, err := p.handshake(conn)
+	if err != nil {
+		return
+	}
+
+	upstream, err := net.Dial("tcp", target)
+	if err != nil {
+		// General SOCKS server failure.
+		//nolint
+		conn.Write([]byte{5, 1, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer upstream.Close()
+
+	p.mu.Lock()
+	p.targets = append(p.targets, target)
+	p.mu.Unlock()
+
+	// Succeeded, with a zero bound address.
+	if _, err := conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0}); err != nil {
+		return
+	}
+
+	go func() {
+		//nolint
+		io.Copy(upstream, conn)
+		upstream.Close()
+	}()
+	//nolint
+	io.Copy(conn, upstream)
+}
+
+// handshake negotiates no authentication and reads the CONNECT request,
+// returning the target address.
+func (p *socksProxy) handshake(conn net.Conn) (string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", err
+	}
+	if _, err := io.ReadFull(conn, make([]byte, header[1])); err != nil {
+		return "", err
+	}
+	if _, err := conn.Write([]byte{5, 0}); err != nil {
+		return "", err
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return "", err
+	}
+	if request[1] != 1 {
+		return "", fmt.Errorf("unsupported command: %d", request[1])
+	}
+
+	var host string
+	switch request[3] {
+	case 1:
+		ip := make([]byte, net.IPv4len)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case 3:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(conn, n); err != nil {
+			return "", err
+		}
+		name := make([]byte, n[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	case 4:
+		ip := make([]byte, net.IPv6len)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	default:
+		return "", fmt.Errorf("unsupported address type: %d", request[3])
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
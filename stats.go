@@ -0,0 +1,48 @@
+package fuddle
+
+import (
+	"go.uber.org/atomic"
+)
+
+// Stats contains counters about the client, such as reconnects and updates
+// received.
+//
+// Unlike Metrics, Stats has no dependencies, so can be exposed using any
+// metrics framework by polling Fuddle.Stats.
+type Stats struct {
+	// ConnectAttempts is the number of attempts to open a connection to a
+	// Fuddle node, including attempts that failed.
+	ConnectAttempts int64
+	// Reconnects is the number of times the client reconnected after the
+	// connection was dropped.
+	Reconnects int64
+	// UpdatesReceived is the number of registry updates received.
+	UpdatesReceived int64
+	// HeartbeatsSent is the number of heartbeats sent by the local members.
+	HeartbeatsSent int64
+	// HeartbeatErrors is the number of heartbeats the local members failed
+	// to send.
+	HeartbeatErrors int64
+	// CurrentMembers is the number of members in the registry, including the
+	// local members.
+	CurrentMembers int64
+}
+
+// stats contains the counters returned by Fuddle.Stats.
+type stats struct {
+	connectAttempts *atomic.Int64
+	reconnects      *atomic.Int64
+	updatesReceived *atomic.Int64
+	heartbeatsSent  *atomic.Int64
+	heartbeatErrors *atomic.Int64
+}
+
+func newStats() *stats {
+	return &stats{
+		connectAttempts: atomic.NewInt64(0),
+		reconnects:      atomic.NewInt64(0),
+		updatesReceived: atomic.NewInt64(0),
+		heartbeatsSent:  atomic.NewInt64(0),
+		heartbeatErrors: atomic.NewInt64(0),
+	}
+}
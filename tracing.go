@@ -0,0 +1,27 @@
+package fuddle
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/fuddle-io/fuddle-go"
+
+// startSpan starts a span for an operation on the member with the given ID.
+func (f *Fuddle) startSpan(ctx context.Context, name string, id string) (context.Context, trace.Span) {
+	return f.tracer.Start(
+		ctx, name, trace.WithAttributes(attribute.String("fuddle.member.id", id)),
+	)
+}
+
+// endSpan ends the span, recording the error status if err is not nil.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,107 @@
+package fuddle
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracing_LocalNodeSpans(t *testing.T) {
+	server := newTestServer(t)
+
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	f, err := Connect(
+		ctx,
+		fromRPC(randomMember("local-1")),
+		[]string{server.addr},
+		WithTracerProvider(tp),
+	)
+	require.NoError(t, err)
+	defer f.Close()
+
+	parentCtx, parent := tp.Tracer("test").Start(ctx, "parent")
+	node, err := f.Register(parentCtx, fromRPC(randomMember("local-2")))
+	require.NoError(t, err)
+	parent.End()
+
+	// Registering the same member again should record the error.
+	_, err = f.Register(ctx, fromRPC(randomMember("local-2")))
+	require.Error(t, err)
+
+	require.NoError(t, node.UpdateMetadata(map[string]string{"foo": "bar"}))
+	node.Unregister()
+
+	spans := make(map[string][]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = append(spans[span.Name()], span)
+	}
+
+	require.Equal(t, 2, len(spans["fuddle.Register"]))
+
+	register := spans["fuddle.Register"][0]
+	assert.Equal(t, parent.SpanContext().SpanID(), register.Parent().SpanID())
+	assert.Contains(
+		t,
+		register.Attributes(),
+		attribute.String("fuddle.member.id", "local-2"),
+	)
+	assert.Equal(t, codes.Unset, register.Status().Code)
+
+	assert.Equal(t, codes.Error, spans["fuddle.Register"][1].Status().Code)
+
+	require.Equal(t, 1, len(spans["fuddle.LocalNode.UpdateMetadata"]))
+	assert.Contains(
+		t,
+		spans["fuddle.LocalNode.UpdateMetadata"][0].Attributes(),
+		attribute.String("fuddle.member.id", "local-2"),
+	)
+	require.Equal(t, 1, len(spans["fuddle.LocalNode.Unregister"]))
+}
+
+func TestTracing_MembersAndSubscribeSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	f := NewOffline(
+		[]Member{fromRPC(randomMember("member-1"))},
+		WithTracerProvider(tp),
+	)
+	defer f.Close()
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+	members, err := f.MembersContext(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(members))
+
+	unsub := f.SubscribeContext(ctx, func() {})
+	unsub()
+	parent.End()
+
+	spans := make(map[string][]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = append(spans[span.Name()], span)
+	}
+
+	require.Equal(t, 1, len(spans["fuddle.Members"]))
+	assert.Equal(t, parent.SpanContext().SpanID(), spans["fuddle.Members"][0].Parent().SpanID())
+	assert.Contains(
+		t,
+		spans["fuddle.Members"][0].Attributes(),
+		attribute.Int("fuddle.members.count", 1),
+	)
+
+	require.Equal(t, 1, len(spans["fuddle.Subscribe"]))
+	assert.Equal(t, parent.SpanContext().SpanID(), spans["fuddle.Subscribe"][0].Parent().SpanID())
+}